@@ -0,0 +1,76 @@
+var tag = {
+    selStatus:'#status',
+    btnQuery: '#btn-query',
+    tableBody: '#alertsBody',
+}
+
+var param = {
+    status:-1,
+    page:1
+}
+
+var severityNames = ['提示', '警告', '错误', '严重'];
+var severityClasses = ['table-info', 'table-warning', 'table-danger', 'table-danger'];
+var statusNames = ['待处理', '已确认', '已解决'];
+
+$(function(){
+    query();
+    $(tag.btnQuery).click(function(){
+        param.status = getParseInt($(tag.selStatus).val(), -1);
+        param.page = 1;
+        query();
+    })
+    $(tag.tableBody).on('click', 'a.btn-ack', function(){
+        changeStatus('/admin/alert/ack', $(this).data('id'));
+    })
+    $(tag.tableBody).on('click', 'a.btn-resolve', function(){
+        changeStatus('/admin/alert/resolve', $(this).data('id'));
+    })
+})
+
+function query(){
+    $.ajax({
+        url:'/admin/alerts/query',
+        type:'GET',
+        data:{status:param.status, page: param.page},
+        dataType:'json',
+        success: function(result){
+            $(tag.tableBody).empty();
+            for(var i=0; result != null && i<result.alerts.length; i++){
+                var a = result.alerts[i];
+                var ops = '';
+                if (a.status == 0){
+                    ops += '<a href="javascript:;" class="btn-ack" data-id="' + a.id + '" title="确认"><i class="fa fa-check"></i></a> ';
+                }
+                if (a.status != 2){
+                    ops += '<a href="javascript:;" class="btn-resolve" data-id="' + a.id + '" title="解决"><i class="fa fa-check-circle"></i></a>';
+                }
+                var tr = '<tr class="' + severityClasses[a.severity] + '"><td>'+ops+'</td><td>'+severityNames[a.severity]+'</td><td>'+a.date
+                    +'</td><td>'+a.tranNum+'</td><td>'+a.carNum+'</td><td>'+a.seatNum+'</td><td>'+a.depStation+' - '+a.arrStation
+                    +'</td><td>'+a.message+'</td><td>'+a.repeatCount+'</td><td>'+getStrDate(a.lastTime)+'</td><td>'+statusNames[a.status]+'</td></tr>';
+                $(tag.tableBody).append(tr);
+            }
+            setPage(result.count, result.ps, param.page, function(page){
+                param.page = page;
+                query();
+            })
+        }
+    })
+}
+
+function changeStatus(url, id){
+    $.ajax({
+        url:url,
+        type:'POST',
+        data:{alertID:id},
+        dataType:'json',
+        success: function(result){
+            if (result.success){
+                toastr.success('操作成功');
+                query();
+            } else {
+                toastr.error(result.msg);
+            }
+        }
+    })
+}
\ No newline at end of file
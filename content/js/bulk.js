@@ -0,0 +1,98 @@
+var tag = {
+    selKind:'#kind',
+    fileBulk:'#bulkFile',
+    btnPreview:'#btn-preview',
+    btnCommit:'#btn-commit',
+    btnExportCsv:'#btn-export-csv',
+    btnExportXlsx:'#btn-export-xlsx',
+    summary:'#summary',
+    tableBody:'#previewBody',
+}
+
+$(function(){
+    $(tag.btnPreview).click(function(){ importBulk(false); });
+    $(tag.btnCommit).click(function(){ importBulk(true); });
+    $(tag.selKind).change(function(){
+        setExportUrl();
+        resetPreview();
+    });
+    $(tag.fileBulk).change(resetPreview);
+    setExportUrl();
+})
+
+function setExportUrl(){
+    var kind = $(tag.selKind).val();
+    $(tag.btnExportCsv).attr('href', '/admin/bulk/export?format=csv&kind=' + kind);
+    $(tag.btnExportXlsx).attr('href', '/admin/bulk/export?format=xlsx&kind=' + kind);
+}
+
+// 更换文件或数据类型后需重新预览才能提交
+function resetPreview(){
+    $(tag.btnCommit).attr('disabled', true);
+    $(tag.summary).empty();
+    $(tag.tableBody).empty();
+}
+
+// 上传文件，commit为false时只预览，预览没有错误后才能提交
+function importBulk(commit){
+    var file = $(tag.fileBulk)[0].files[0];
+    if (file == undefined){
+        toastr.error('请选择csv或xlsx文件');
+        return;
+    }
+    var data = new FormData();
+    data.append('file', file);
+    data.append('kind', $(tag.selKind).val());
+    data.append('commit', commit);
+    $.ajax({
+        url:'/admin/bulk/import',
+        type:'POST',
+        data:data,
+        processData:false,
+        contentType:false,
+        dataType:'json',
+        success:function(result){
+            if (!result.success){
+                toastr.error(result.msg);
+                return;
+            }
+            var p = result.preview;
+            showPreview(p);
+            var hasError = p.errors != null && p.errors.length > 0;
+            if (p.committed){
+                toastr.success('导入完成');
+                $(tag.btnCommit).attr('disabled', true);
+            } else if (hasError){
+                toastr.error('文件有错误，请修改后重新预览');
+                $(tag.btnCommit).attr('disabled', true);
+            } else {
+                $(tag.btnCommit).attr('disabled', false);
+            }
+        }
+    })
+}
+
+function showPreview(p){
+    var count = function(list){ return list == null ? 0 : list.length; };
+    $(tag.summary).html('<p>新增' + count(p.creates) + '条，修改' + count(p.updates) + '条，删除' + count(p.deletes)
+        + '条，未修改' + p.unchanged + '条</p>');
+    $(tag.tableBody).empty();
+    appendRows(p.errors, '错误', 'table-danger');
+    appendRows(p.warnings, '警告', 'table-warning');
+    appendKeys(p.creates, '新增');
+    appendKeys(p.updates, '修改');
+    appendKeys(p.deletes, '删除');
+}
+
+function appendRows(list, type, cls){
+    for(var i=0; list != null && i<list.length; i++){
+        $(tag.tableBody).append('<tr class="' + cls + '"><td>' + type + '</td><td>' + list[i].row + '</td><td>'
+            + list[i].column + '</td><td>' + list[i].msg + '</td></tr>');
+    }
+}
+
+function appendKeys(list, type){
+    for(var i=0; list != null && i<list.length; i++){
+        $(tag.tableBody).append('<tr><td>' + type + '</td><td></td><td></td><td>' + list[i] + '</td></tr>');
+    }
+}
@@ -0,0 +1,74 @@
+var tag = {
+    fileGtfs:'#gtfsFile',
+    chkDryRun:'#dryRun',
+    btnImport:'#btn-import',
+    summary:'#summary',
+    tableBody:'#reportBody',
+}
+
+$(function(){
+    $(tag.btnImport).click(importGtfs);
+})
+
+// 上传GTFS压缩包，试运行时只显示校验报告
+function importGtfs(){
+    var file = $(tag.fileGtfs)[0].files[0];
+    if (file == undefined){
+        toastr.error('请选择GTFS压缩包');
+        return;
+    }
+    var data = new FormData();
+    data.append('file', file);
+    data.append('dryRun', $(tag.chkDryRun).is(':checked'));
+    $.ajax({
+        url:'/admin/gtfs/import',
+        type:'POST',
+        data:data,
+        processData:false,
+        contentType:false,
+        dataType:'json',
+        success:function(result){
+            if (result.success){
+                showReport(result.report);
+                if (result.report.dryRun){
+                    toastr.success('试运行完成');
+                } else if (result.report.committed){
+                    toastr.success('导入完成');
+                } else {
+                    toastr.error('有行程导入失败，未保存任何数据');
+                }
+            } else {
+                toastr.error(result.msg);
+            }
+        }
+    })
+}
+
+function showReport(report){
+    var summary = '';
+    if (report.stations != null && report.stations.length > 0){
+        summary += '<p>新增车站：' + report.stations.join('、') + '</p>';
+    }
+    if (report.calendars != null && report.calendars.length > 0){
+        summary += '<p>开行日历：' + report.calendars.join('、') + '</p>';
+    }
+    for(var i=0; report.warnings != null && i<report.warnings.length; i++){
+        summary += '<p class="text-warning">' + report.warnings[i] + '</p>';
+    }
+    $(tag.summary).html(summary);
+    $(tag.tableBody).empty();
+    for(var i=0; report.trans != null && i<report.trans.length; i++){
+        var t = report.trans[i];
+        var issues = '';
+        for(var j=0; t.issues != null && j<t.issues.length; j++){
+            var cls = t.issues[j].level == 'error' ? 'text-danger' : 'text-warning';
+            issues += '<div class="' + cls + '">' + t.issues[j].msg + '</div>';
+        }
+        if (issues == '' && t.msg != ''){
+            issues = '<div class="text-danger">' + t.msg + '</div>';
+        }
+        var tr = '<tr class="' + (t.success ? '' : 'table-danger') + '"><td>' + t.tripID + '</td><td>' + t.tranNum
+            + '</td><td>' + t.action + '</td><td>' + (t.success ? '成功' : '失败') + '</td><td>' + issues + '</td></tr>';
+        $(tag.tableBody).append(tr);
+    }
+}
@@ -3,6 +3,11 @@ var tag = {
     txtTranNum:'#tranNum',
     btnQuery: '#btn-query',
     btnAdd: '#btn-add',
+    btnRunJob: '#btn-run-job',
+    divJobReport: '#jobReport',
+    btnCheckTicket: '#btn-check-ticket',
+    btnRepairTicket: '#btn-repair-ticket',
+    divCheckReport: '#checkReport',
     tableBody: '#schedulesBody',
 }
 
@@ -25,8 +30,100 @@ $(function(){
         query();
     })
     $(tag.btnQuery).click();
+    $(tag.tableBody).on('click', 'a.btn-suspend', function(){
+        var remark = prompt('停运说明', '列车停运');
+        if (remark == null){
+            return;
+        }
+        changeSuspension('/admin/schedules/suspend', {tranNum:$(this).data('tran'), depDate:$(this).data('date'), remark:remark});
+    })
+    $(tag.tableBody).on('click', 'a.btn-resume', function(){
+        if (confirm('确定恢复该排班的售票？')){
+            changeSuspension('/admin/schedules/resume', {tranNum:$(this).data('tran'), depDate:$(this).data('date')});
+        }
+    })
+    $(tag.btnRunJob).click(function(){
+        if (confirm('确定立即生成可订票天数内的排班？')){
+            runScheduleJob();
+        }
+    })
+    $.getJSON('/admin/schedules/job', function(result){ showJobReport(result.report); });
+    $(tag.btnCheckTicket).click(function(){ checkTicket('report'); });
+    $(tag.btnRepairTicket).click(function(){
+        if (confirm('确定以车票为准修复排班？')){
+            checkTicket('repair');
+        }
+    })
 })
 
+// 核验车票与排班，填写车次时只核验所选日期的该车次，否则核验可订票天数内的全部排班
+function checkTicket(mode){
+    var tranNum = $(tag.txtTranNum).val();
+    $.ajax({
+        url:'/admin/schedules/checkTicket',
+        type:'POST',
+        data:{tranNum:tranNum, depDate:tranNum == '' ? '' : $(tag.txtDepartureDate).val(), mode:mode},
+        dataType:'json',
+        success: function(result){
+            if (!result.success){
+                toastr.error(result.msg);
+                return;
+            }
+            showCheckReport(result.reports);
+        }
+    })
+}
+
+function showCheckReport(reports){
+    var count = function(list){ return list == null ? 0 : list.length; };
+    var html = '';
+    for(var i=0; reports != null && i<reports.length; i++){
+        var r = reports[i];
+        html += '<p>' + r.tranNum + ' ' + r.date + '：车票' + r.ticketCount + '张，冲突' + count(r.conflicts) + '个，无效车票'
+            + count(r.invalidTicketIDs) + '张，座位不一致' + count(r.seatMismatches) + '个，人数不一致' + count(r.countMismatches)
+            + '个' + (r.repaired ? '，已修复' : '') + '</p>';
+        for(var j=0; j<count(r.conflicts); j++){
+            var c = r.conflicts[j];
+            html += '<div class="text-danger">' + c.carNum + '车' + c.seatNum + ' 路段' + c.segments.join(',') + ' 车票' + c.ticketIDs.join(',') + '</div>';
+        }
+    }
+    $(tag.divCheckReport).html(html == '' ? '<p>核验完成，未发现问题</p>' : html);
+}
+
+function runScheduleJob(){
+    $(tag.btnRunJob).attr('disabled', true);
+    $.ajax({
+        url:'/admin/schedules/job/run',
+        type:'POST',
+        dataType:'json',
+        success: function(result){
+            $(tag.btnRunJob).attr('disabled', false);
+            if (result.success){
+                toastr.success('排班生成完成');
+                showJobReport(result.report);
+                query();
+            } else {
+                toastr.error(result.msg);
+            }
+        }
+    })
+}
+
+// 显示排班任务的结果，列出跳过的排班及原因
+function showJobReport(r){
+    $(tag.divJobReport).empty();
+    if (r == null){
+        return;
+    }
+    var count = function(list){ return list == null ? 0 : list.length; };
+    var html = '<p>最近一次排班：' + r.startTime + '，新增' + count(r.created) + '个，重新生成' + count(r.regenerated)
+        + '个，删除' + count(r.removed) + '个，跳过' + count(r.skipped) + '个，未变化' + r.unchanged + '个，归档' + r.archived + '个</p>';
+    for(var i=0; r.skipped != null && i<r.skipped.length; i++){
+        html += '<div class="text-warning">' + r.skipped[i].schedule + '：' + r.skipped[i].reason + '</div>';
+    }
+    $(tag.divJobReport).html(html);
+}
+
 function query(){
     $.ajax({
         url:'/admin/schedules/query',
@@ -37,7 +134,9 @@ function query(){
             $(tag.tableBody).empty();
             for(var i=0; result != null && i<result.schedules.length; i++){
                 var s = result.schedules[i];
-                var scheduleLink = '<a href="/admin/schedules/detail?scheduleID=' + s.id + '"><i class="fa fa-edit"></i></a>';
+                var scheduleLink = '<a href="/admin/schedules/detail?scheduleID=' + s.id + '"><i class="fa fa-edit"></i></a> '
+                    + '<a href="javascript:;" class="btn-suspend" data-tran="' + s.tranNum + '" data-date="' + s.departureDate + '" title="停运"><i class="fa fa-ban"></i></a> '
+                    + '<a href="javascript:;" class="btn-resume" data-tran="' + s.tranNum + '" data-date="' + s.departureDate + '" title="恢复"><i class="fa fa-undo"></i></a>';
                 var tr = '<tr><td>'+scheduleLink+'</td><td>'+s.departureDate+'</td><td>'+s.tranNum+'</td><td>'+s.saleTicketTime
                     +'</td><td>'+s.notSaleRemark+'</td></tr>';
                 $(tag.tableBody).append(tr);
@@ -49,3 +148,20 @@ function query(){
         }
     })
 }
+
+function changeSuspension(url, data){
+    $.ajax({
+        url:url,
+        type:'POST',
+        data:data,
+        dataType:'json',
+        success: function(result){
+            if (result.success){
+                toastr.success(result.count == undefined ? '操作成功' : '操作成功，影响车票' + result.count + '张');
+                query();
+            } else {
+                toastr.error(result.msg);
+            }
+        }
+    })
+}
\ No newline at end of file
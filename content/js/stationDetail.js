@@ -0,0 +1,123 @@
+var tag = {
+    stationID:'#stationID',
+    stationName:'#stationName',
+    stationCode:'#stationCode',
+    stationPinyin:'#stationPinyin',
+    cityCode:'#cityCode',
+    cityName:'#cityName',
+    isPassenger:'#isPassenger',
+    aliasesBody:'#aliasesBody',
+    txtMergeToID:'#mergeToID',
+    btnMerge:'#btn-merge',
+    btnSave:'#btn-save',
+    btnDelete:'#btn-delete'
+}
+
+$(function(){
+    initData();
+    $(tag.btnSave).click(save);
+    $(tag.btnDelete).click(deleteStation);
+    $(tag.btnMerge).click(merge);
+})
+
+function initData(){
+    var id = getQueryString('stationID');
+    if (id == null){
+        $(tag.btnDelete).hide();
+        $(tag.btnMerge).attr('disabled', true);
+        return;
+    }
+    $.ajax({
+        url:'/admin/stations/getDetail',
+        type:'GET',
+        dataType:'json',
+        data:{stationID:id},
+        success:function(result){
+            if (result == null || result.station == null || result.station.ID == 0) return;
+            var s = result.station;
+            $(tag.stationID).val(s.ID);
+            $(tag.stationName).val(s.StationName);
+            $(tag.stationCode).val(s.StationCode);
+            $(tag.stationPinyin).val(s.StationPinyin);
+            $(tag.cityCode).val(s.CityCode);
+            $(tag.cityName).val(s.CityName);
+            $(tag.isPassenger).prop('checked', s.IsPassenger);
+            $(tag.aliasesBody).empty();
+            for(var i=0; result.aliases != null && i<result.aliases.length; i++){
+                var a = result.aliases[i];
+                $(tag.aliasesBody).append('<tr><td>' + a.AliasName + '</td><td>' + a.StationCode + '</td><td>'
+                    + new Date(a.CreatedAt).toLocaleString() + '</td></tr>');
+            }
+        }
+    })
+}
+
+function save(){
+    var station = {
+        ID: getParseInt($(tag.stationID).val()),
+        StationName: $(tag.stationName).val(),
+        StationCode: $(tag.stationCode).val(),
+        StationPinyin: $(tag.stationPinyin).val(),
+        CityCode: $(tag.cityCode).val(),
+        CityName: $(tag.cityName).val(),
+        IsPassenger: $(tag.isPassenger).prop('checked')
+    };
+    $.ajax({
+        url:'/admin/station/save',
+        type:'POST',
+        dataType:'json',
+        data:JSON.stringify(station),
+        success:function(result){
+            if (result.success){
+                toastr.success('保存成功');
+                if (station.ID == 0){
+                    location.href = '/admin/stations/detail?stationID=' + result.id;
+                } else {
+                    initData();
+                }
+            } else {
+                toastr.error(result.msg);
+            }
+        }
+    })
+}
+
+function deleteStation(){
+    if (!confirm('确定删除该车站？')) return;
+    $.ajax({
+        url:'/admin/station/delete',
+        type:'POST',
+        dataType:'json',
+        data:{stationID:$(tag.stationID).val()},
+        success:function(result){
+            if (result.success){
+                location.href = '/admin/stations';
+            } else {
+                toastr.error(result.msg);
+            }
+        }
+    })
+}
+
+// 将当前车站合并到输入的车站，当前车站名成为其曾用名
+function merge(){
+    var toID = getParseInt($(tag.txtMergeToID).val());
+    if (toID == 0){
+        toastr.error('请输入保留的车站ID');
+        return;
+    }
+    if (!confirm('合并后当前车站将被删除，确定合并？')) return;
+    $.ajax({
+        url:'/admin/station/merge',
+        type:'POST',
+        dataType:'json',
+        data:{fromID:$(tag.stationID).val(), toID:toID},
+        success:function(result){
+            if (result.success){
+                location.href = '/admin/stations/detail?stationID=' + toID;
+            } else {
+                toastr.error(result.msg);
+            }
+        }
+    })
+}
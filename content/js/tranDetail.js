@@ -6,6 +6,10 @@ var tag = {
     isHighPriority:'#isHighPriority',
     enableSD:'#enableSD',
     enableED:'#enableED',
+    weekdays:'#weekdays',
+    calendarId:'#calendarId',
+    includeDates:'#includeDates',
+    excludeDates:'#excludeDates',
     timetableTab:'#timetable-tab',
     btnAddTimetable:'#btn-add-timetable',
     timetableContent:'#timetable-content',
@@ -19,11 +23,67 @@ var tag = {
     btnCancel:'#btn-cancel'
 }
 
+// 中途站分段席位配额，页面上不编辑，保存时原样提交
+var quotaRules = [];
+// 卧铺各铺位的价格，页面上不编辑，保存时原样提交，重置路段价格时一并清空
+var berthPriceMap = {};
+
 $(function(){
+    initCalendars();
     initData();
     initEvent();
 })
 
+// 初始化开行日历下拉框
+function initCalendars(){
+    $.ajax({
+        url:'/admin/calendars/query',
+        type:'GET',
+        dataType:'json',
+        async:false,
+        success: function(result){
+            if (result == null || result.calendars == null){
+                return;
+            }
+            for(var i=0;i<result.calendars.length;i++){
+                var c = result.calendars[i];
+                $(tag.calendarId).append('<option value="' + c.id + '">' + c.name + '</option>');
+            }
+        }
+    })
+}
+
+// 设置开行星期、开行日历及单独指定的加开停开日期
+function setCalendar(t){
+    $(tag.weekdays + ' input[type=checkbox]').each(function(){
+        $(this).prop('checked', (t.weekdays & (1 << parseInt($(this).val()))) != 0);
+    });
+    $(tag.calendarId).val(t.calendarID || 0);
+    var includes = new Array(), excludes = new Array();
+    var dates = t.operatingDates || [];
+    for(var i=0;i<dates.length;i++){
+        if (dates[i].isExclude){
+            excludes.push(dates[i].date);
+        } else {
+            includes.push(dates[i].date);
+        }
+    }
+    $(tag.includeDates).val(includes.join(','));
+    $(tag.excludeDates).val(excludes.join(','));
+}
+
+// 读取页面上的加开或停开日期
+function getOperatingDates(sel, isExclude){
+    var dates = new Array();
+    var arr = $.trim($(sel).val()).split(/[,，\s]+/);
+    for(var i=0;i<arr.length;i++){
+        if (arr[i] != ''){
+            dates.push({date: arr[i], isExclude: isExclude});
+        }
+    }
+    return dates;
+}
+
 // 初始化数据
 function initData(){
     var id = getQueryString('tranId');
@@ -41,6 +101,8 @@ function initData(){
                 return;
             }
             var t = result.tranInfo;
+            quotaRules = t.quotaRules || [];
+            berthPriceMap = t.berthPriceMap || {};
             // 设置基础信息
             $(tag.tranId).val(t.id);
             $(tag.tranNum).val(t.tranNum);
@@ -49,6 +111,7 @@ function initData(){
             $(tag.isHighPriority).val(t.isHighPriority);
             $(tag.enableSD).val(t.enableStartDate.split('T')[0]);
             $(tag.enableED).val(t.enableEndDate.split('T')[0]);
+            setCalendar(t);
             // 设置车厢
             setCars(t.tranNum, t.carIds);
             // 设置时刻表
@@ -64,7 +127,7 @@ function initData(){
                 rEle.find('input[name=depTime]').val(getDateHm(r.depTime));
                 rEle.find('input[name=checkTicketGate]').val(r.checkTicketGate);
                 rEle.find('input[name=platform]').val(r.platform);
-                rEle.find('input[name=maleageNext]').val(r.maleageNext);
+                rEle.find('input[name=mileageNext]').val(r.mileageNext);
             }
             resetTimetable();
             // 设置车厢信息
@@ -186,6 +249,7 @@ function initEvent() {
         setCarSumInfo();
     })
     $(tag.btnSetRoutePrice).click(function(){
+        berthPriceMap = {};
         var timetables = new Array();
         $(tag.timetableTab + ' .timetable-stationName').each(function(idx, ele){
             var stationName = $(ele).html();
@@ -230,13 +294,18 @@ function validData(){
         id: parseInt($(tag.tranId).val()),
         tranNum: $(tag.tranNum).val(),
         durationDay: parseInt($(tag.durationDay).val()),
-        scheduleDay: parseInt($(tag.scheduleDay).val()),
+        scheduleDays: parseInt($(tag.scheduleDay).val()),
+        weekdays: 0,
+        calendarID: parseInt($(tag.calendarId).val()),
+        operatingDates: getOperatingDates(tag.includeDates, false).concat(getOperatingDates(tag.excludeDates, true)),
         isHighPriority: parseInt($(tag.isHighPriority).val()),
         enableStartDate: new Date($(tag.enableSD).val()).toISOString(),
         enableEndDate: new Date($(tag.enableED).val()).toISOString(),
         timetable: new Array(),
         carIds: '',
-        seatPriceMap: new Map()
+        seatPriceMap: new Map(),
+        quotaRules: quotaRules,
+        berthPriceMap: berthPriceMap
     };
     $(tag.timetableContent + '>div').each(function(){
         var route = {
@@ -245,16 +314,19 @@ function validData(){
             depTime: $(this).find('input[name="depTime"]').val(),
             checkTicketGate: $(this).find('input[name="checkTicketGate"]').val(),
             platform: parseInt($(this).find('input[name="platform"]').val()),
-            maleageNext: parseFloat($(this).find('input[name="maleageNext"]').val()),
+            mileageNext: parseFloat($(this).find('input[name="mileageNext"]').val()),
         };
         if (isNaN(route.platform)) route.platform = 0;
-        if (isNaN(route.maleageNext)) route.maleageNext = 0;
+        if (isNaN(route.mileageNext)) route.mileageNext = 0;
         if (route.arrTime == '') route.arrTime = '00:00';
         if (route.depTime == '') route.depTime = '00:00';
         route.arrTime = new Date('1971-01-01 ' + route.arrTime + ':00').toISOString();
         route.depTime = new Date('1971-01-01 ' + route.depTime + ':00').toISOString();
         data.timetable.push(route);
     });
+    $(tag.weekdays + ' input[type=checkbox]:checked').each(function(){
+        data.weekdays |= 1 << parseInt($(this).val());
+    });
     var cars = new Array();
     $(tag.carsContent + ' .car-item').each(function(){
         var carId = $(this).find('select[name="carId"]').val();
@@ -277,7 +349,7 @@ function validData(){
             var price = $(this).find('td[data-seattype="' + st + '"] input[type="number"]').val();
             price = parseFloat($.trim(price));
             if (isNaN(price)){
-                price = 1;
+                price = 0;
             }
             var arr = data.seatPriceMap.get(st);
             arr.push(price);
@@ -308,9 +380,21 @@ function save(){
         success:function(result){
             if (result.success){
                 toastr.success('保存成功');
-            } else {
+            } else if (!result.issues || result.issues.length == 0) {
                 toastr.error(result.msg);
             }
+            showIssues(result.issues);
         }
     })
+}
+// 显示校验的错误及警告
+function showIssues(issues){
+    if (!issues) return;
+    for(var i=0;i<issues.length;i++){
+        if (issues[i].level == 'error'){
+            toastr.error(issues[i].msg);
+        } else {
+            toastr.warning(issues[i].msg);
+        }
+    }
 }
\ No newline at end of file
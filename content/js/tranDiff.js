@@ -0,0 +1,67 @@
+var tag = {
+    selFromId:'#fromId',
+    selToId:'#toId',
+    btnDiff:'#btn-diff',
+    tableBody:'#diffBody',
+}
+
+$(function(){
+    initVersions(getQueryString('tranNum'), getQueryString('toId'));
+    $(tag.btnDiff).click(function(){
+        diff();
+    })
+})
+
+function initVersions(tranNum, toId){
+    $.ajax({
+        url:'/admin/trans/versions',
+        type:'GET',
+        data:{tranNum:tranNum},
+        dataType:'json',
+        success: function(result){
+            var fromId = '';
+            for(var i=0; result != null && i<result.versions.length; i++){
+                var v = result.versions[i];
+                var option = '<option value="' + v.id + '">' + v.enableStartDate.split('T')[0] + ' 至 ' + v.enableEndDate.split('T')[0] + '</option>';
+                $(tag.selFromId).append(option);
+                $(tag.selToId).append(option);
+                // 默认与前一个版本对比
+                if (v.id == toId && i > 0){
+                    fromId = result.versions[i-1].id;
+                }
+            }
+            if (toId != null && toId != ''){
+                $(tag.selToId).val(toId);
+            }
+            if (fromId != ''){
+                $(tag.selFromId).val(fromId);
+                diff();
+            }
+        }
+    })
+}
+
+function diff(){
+    $.ajax({
+        url:'/admin/trans/getDiff',
+        type:'GET',
+        data:{fromId:$(tag.selFromId).val(), toId:$(tag.selToId).val()},
+        dataType:'json',
+        success: function(result){
+            $(tag.tableBody).empty();
+            if (!result.success){
+                toastr.error(result.msg);
+                return;
+            }
+            if (result.diffs == null || result.diffs.length == 0){
+                $(tag.tableBody).append('<tr><td colspan="4">两个版本没有差异</td></tr>');
+                return;
+            }
+            for(var i=0; i<result.diffs.length; i++){
+                var d = result.diffs[i];
+                var tr = '<tr><td>'+d.field+'</td><td>'+d.key+'</td><td>'+d.from+'</td><td>'+d.to+'</td></tr>';
+                $(tag.tableBody).append(tr);
+            }
+        }
+    })
+}
@@ -32,7 +32,8 @@ function query(){
             $(tag.tableBody).empty();
             for(var i=0; result != null && i<result.trans.length; i++){
                 var t = result.trans[i];
-                var tranNumLink = '<a href="/admin/trans/detail?tranId=' + t.id + '">' + t.tranNum + '</a>';
+                var tranNumLink = '<a href="/admin/trans/detail?tranId=' + t.id + '">' + t.tranNum + '</a>'
+                    + ' <a href="/admin/trans/diff?tranNum=' + t.tranNum + '&toId=' + t.id + '" title="版本对比"><i class="fa fa-code-fork"></i></a>';
                 var timetable = getTimeTableTd(t.timetable);
                 var start = new Date(t.enableStartDate).toLocaleDateString();
                 var end = new Date(t.enableEndDate).toLocaleDateString();
@@ -0,0 +1,43 @@
+/*
+SQLyog Ultimate v12.08 (64 bit)
+MySQL - 8.0.13 : Database - t-tran
+*********************************************************************
+*/
+
+
+/*!40101 SET NAMES utf8 */;
+
+/*!40101 SET SQL_MODE=''*/;
+
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+/*Table structure for table `admin_alerts` */
+
+DROP TABLE IF EXISTS `admin_alerts`;
+
+CREATE TABLE `admin_alerts` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `dedup_key` varchar(100) NOT NULL DEFAULT '' COMMENT '去重键 日期_车次_车厢_座位_类型[_关联记录ID]',
+  `date` varchar(10) NOT NULL DEFAULT '',
+  `tran_num` varchar(10) NOT NULL DEFAULT '',
+  `car_num` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `seat_num` varchar(5) NOT NULL DEFAULT '',
+  `dep_station` varchar(20) NOT NULL DEFAULT '',
+  `arr_station` varchar(20) NOT NULL DEFAULT '',
+  `notify_type` varchar(20) NOT NULL DEFAULT '',
+  `severity` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `message` varchar(500) NOT NULL DEFAULT '',
+  `status` tinyint(3) unsigned NOT NULL DEFAULT '0' COMMENT '状态 0.待处理 1.已确认 2.已解决',
+  `repeat_count` int(11) NOT NULL DEFAULT '0',
+  `first_time` datetime NOT NULL,
+  `last_time` datetime NOT NULL,
+  `ack_time` datetime NOT NULL,
+  `resolve_time` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `main` (`dedup_key`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
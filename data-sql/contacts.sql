@@ -29,6 +29,10 @@ CREATE TABLE `contacts` (
   `email` varchar(50) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
   `addr` varchar(200) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
   `zip_code` varchar(10) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
+  `birthday` date NOT NULL DEFAULT '0001-01-01',
+  `height` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `home_city` varchar(10) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
+  `school_city` varchar(10) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
   `add_date` date NOT NULL,
   KEY `query` (`uid`,`pid`,`status`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
@@ -0,0 +1,40 @@
+/*
+SQLyog Ultimate v12.08 (64 bit)
+MySQL - 8.0.13 : Database - t-tran
+*********************************************************************
+*/
+
+
+/*!40101 SET NAMES utf8 */;
+
+/*!40101 SET SQL_MODE=''*/;
+
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+/*Table structure for table `customer_notify_outboxes` */
+
+DROP TABLE IF EXISTS `customer_notify_outboxes`;
+
+CREATE TABLE `customer_notify_outboxes` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `event` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `channel` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `ref_id` bigint(20) unsigned NOT NULL DEFAULT '0' COMMENT '关联的车票ID',
+  `target` varchar(100) NOT NULL DEFAULT '',
+  `subject` varchar(100) NOT NULL DEFAULT '',
+  `content` varchar(1000) NOT NULL DEFAULT '',
+  `status` tinyint(3) unsigned NOT NULL DEFAULT '0' COMMENT '状态 0.待发送 1.已发送 2.失败',
+  `retry_times` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `last_err` varchar(200) NOT NULL DEFAULT '',
+  `create_time` datetime NOT NULL,
+  `next_try_time` datetime NOT NULL,
+  `sent_time` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `ref` (`ref_id`),
+  KEY `main` (`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
@@ -0,0 +1,34 @@
+/*
+SQLyog Ultimate v12.08 (64 bit)
+MySQL - 8.0.13 : Database - t-tran
+*********************************************************************
+*/
+
+
+/*!40101 SET NAMES utf8 */;
+
+/*!40101 SET SQL_MODE=''*/;
+
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+/*Table structure for table `limit_settings` */
+
+DROP TABLE IF EXISTS `limit_settings`;
+
+CREATE TABLE `limit_settings` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `query_rate` double NOT NULL DEFAULT '0',
+  `query_burst` int(11) NOT NULL DEFAULT '0',
+  `order_rate` double NOT NULL DEFAULT '0',
+  `order_burst` int(11) NOT NULL DEFAULT '0',
+  `max_tickets_per_passenger` int(11) NOT NULL DEFAULT '0',
+  `max_orders_per_day` int(11) NOT NULL DEFAULT '0',
+  `suspicious_contact_count` int(11) NOT NULL DEFAULT '0',
+  `suspicious_contact_mins` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
@@ -0,0 +1,36 @@
+/*
+SQLyog Ultimate v12.08 (64 bit)
+MySQL - 8.0.13 : Database - t-tran
+*********************************************************************
+*/
+
+
+/*!40101 SET NAMES utf8 */;
+
+/*!40101 SET SQL_MODE=''*/;
+
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+/*Table structure for table `order_event_outboxes` */
+
+DROP TABLE IF EXISTS `order_event_outboxes`;
+
+CREATE TABLE `order_event_outboxes` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `event_type` varchar(20) NOT NULL DEFAULT '',
+  `payload` varchar(500) NOT NULL DEFAULT '',
+  `dispatched` tinyint(1) NOT NULL DEFAULT '0',
+  `published` tinyint(1) NOT NULL DEFAULT '0',
+  `failed` tinyint(1) NOT NULL DEFAULT '0',
+  `retry_times` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `create_time` datetime NOT NULL,
+  `publish_time` datetime NOT NULL,
+  `next_try_time` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `main` (`dispatched`,`published`,`failed`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
@@ -11,6 +11,7 @@ CREATE TABLE `orders` (
   `pay_type` tinyint(4) unsigned NOT NULL COMMENT '支付类型 1.支付宝 2.微信',
   `pay_account` varchar(30) NOT NULL,
   `status` tinyint(4) unsigned NOT NULL COMMENT '订单状态 0.未支付 1.已取消 2.订单超时 3.已支付 4.已退票',
+  `order_type` tinyint(4) unsigned NOT NULL DEFAULT '0' COMMENT '订单类型 0.普通订单 1.联程订单 2.往返订单',
   PRIMARY KEY (`id`),
   KEY `idx_udi_pt_s` (`user_id`, `pay_time`, `status`)
 ) ENGINE=InnoDB DEFAULT CHARSET=ascii;
\ No newline at end of file
@@ -29,6 +29,10 @@ CREATE TABLE `passengers` (
   `email` varchar(50) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
   `addr` varchar(200) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
   `zip_code` varchar(10) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
+  `birthday` date NOT NULL DEFAULT '0001-01-01',
+  `height` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `home_city` varchar(10) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
+  `school_city` varchar(10) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
   PRIMARY KEY (`p_id`),
   KEY `paperwork_num` (`paperwork_num`,`paperwork_type`)
 ) ENGINE=InnoDB AUTO_INCREMENT=23136188 DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
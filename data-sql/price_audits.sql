@@ -0,0 +1,36 @@
+/*
+SQLyog Ultimate v12.08 (64 bit)
+MySQL - 8.0.13 : Database - t-tran
+*********************************************************************
+*/
+
+
+/*!40101 SET NAMES utf8 */;
+
+/*!40101 SET SQL_MODE=''*/;
+
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+/*Table structure for table `price_audits` */
+
+DROP TABLE IF EXISTS `price_audits`;
+
+CREATE TABLE `price_audits` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `ticket_id` bigint(20) unsigned NOT NULL DEFAULT '0',
+  `order_id` bigint(20) unsigned NOT NULL DEFAULT '0',
+  `base_price` double NOT NULL DEFAULT '0',
+  `rate` double NOT NULL DEFAULT '0',
+  `reasons` varchar(500) NOT NULL DEFAULT '',
+  `quote_id` varchar(24) NOT NULL DEFAULT '',
+  `fare_rule` varchar(10) NOT NULL DEFAULT '',
+  `price` double NOT NULL DEFAULT '0',
+  `create_time` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `main` (`ticket_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
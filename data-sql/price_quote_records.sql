@@ -0,0 +1,36 @@
+/*
+SQLyog Ultimate v12.08 (64 bit)
+MySQL - 8.0.13 : Database - t-tran
+*********************************************************************
+*/
+
+
+/*!40101 SET NAMES utf8 */;
+
+/*!40101 SET SQL_MODE=''*/;
+
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+/*Table structure for table `price_quote_records` */
+
+DROP TABLE IF EXISTS `price_quote_records`;
+
+CREATE TABLE `price_quote_records` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `quote_id` varchar(24) NOT NULL DEFAULT '' COMMENT '报价ID',
+  `tran_num` varchar(10) NOT NULL DEFAULT '' COMMENT '车次',
+  `date` varchar(10) NOT NULL DEFAULT '' COMMENT '发车日期',
+  `dep_idx` tinyint(3) unsigned NOT NULL DEFAULT '0' COMMENT '乘车站索引',
+  `arr_idx` tinyint(3) unsigned NOT NULL DEFAULT '0' COMMENT '到达站索引',
+  `seat_type` varchar(5) NOT NULL DEFAULT '' COMMENT '席别',
+  `rate` float NOT NULL DEFAULT '0' COMMENT '调价倍数',
+  `reasons` varchar(500) NOT NULL DEFAULT '' COMMENT '生效的调价规则',
+  `expire_time` datetime NOT NULL COMMENT '报价失效时间',
+  PRIMARY KEY (`id`),
+  KEY `main` (`quote_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
@@ -0,0 +1,38 @@
+/*
+SQLyog Ultimate v12.08 (64 bit)
+MySQL - 8.0.13 : Database - t-tran
+*********************************************************************
+*/
+
+
+/*!40101 SET NAMES utf8 */;
+
+/*!40101 SET SQL_MODE=''*/;
+
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+/*Table structure for table `price_rules` */
+
+DROP TABLE IF EXISTS `price_rules`;
+
+CREATE TABLE `price_rules` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `name` varchar(50) NOT NULL DEFAULT '',
+  `tran_num` varchar(10) NOT NULL DEFAULT '',
+  `seat_type` varchar(5) NOT NULL DEFAULT '',
+  `start_date` varchar(10) NOT NULL DEFAULT '',
+  `end_date` varchar(10) NOT NULL DEFAULT '',
+  `weekdays` varchar(20) NOT NULL DEFAULT '',
+  `start_time` varchar(5) NOT NULL DEFAULT '',
+  `end_time` varchar(5) NOT NULL DEFAULT '',
+  `min_load` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `max_load` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `rate` double NOT NULL DEFAULT '0',
+  `enabled` tinyint(1) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
@@ -0,0 +1,30 @@
+/*
+SQLyog Ultimate v12.08 (64 bit)
+MySQL - 8.0.13 : Database - t-tran
+*********************************************************************
+*/
+
+
+/*!40101 SET NAMES utf8 */;
+
+/*!40101 SET SQL_MODE=''*/;
+
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+/*Table structure for table `price_settings` */
+
+DROP TABLE IF EXISTS `price_settings`;
+
+CREATE TABLE `price_settings` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `min_rate` double NOT NULL DEFAULT '0',
+  `max_rate` double NOT NULL DEFAULT '0',
+  `quote_valid_mins` int(11) NOT NULL DEFAULT '0',
+  `round_trip_rate` double NOT NULL DEFAULT '0.95',
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
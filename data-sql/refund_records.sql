@@ -0,0 +1,39 @@
+/*
+SQLyog Ultimate v12.08 (64 bit)
+MySQL - 8.0.13 : Database - t-tran
+*********************************************************************
+*/
+
+
+/*!40101 SET NAMES utf8 */;
+
+/*!40101 SET SQL_MODE=''*/;
+
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+/*Table structure for table `refund_records` */
+
+DROP TABLE IF EXISTS `refund_records`;
+
+CREATE TABLE `refund_records` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `order_id` bigint(20) unsigned NOT NULL DEFAULT '0',
+  `user_id` bigint(20) unsigned NOT NULL DEFAULT '0',
+  `pay_type` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `pay_account` varchar(30) NOT NULL DEFAULT '',
+  `price` double NOT NULL DEFAULT '0',
+  `status` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `retry_times` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `last_err` varchar(200) NOT NULL DEFAULT '',
+  `create_time` datetime NOT NULL,
+  `next_try_time` datetime NOT NULL,
+  `refund_time` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `ref` (`order_id`),
+  KEY `main` (`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
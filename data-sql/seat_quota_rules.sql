@@ -0,0 +1,32 @@
+/*
+SQLyog Ultimate v12.08 (64 bit)
+MySQL - 8.0.13 : Database - t-tran
+*********************************************************************
+*/
+
+
+/*!40101 SET NAMES utf8 */;
+
+/*!40101 SET SQL_MODE=''*/;
+
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+/*Table structure for table `seat_quota_rules` */
+
+DROP TABLE IF EXISTS `seat_quota_rules`;
+
+CREATE TABLE `seat_quota_rules` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `tran_id` int(11) NOT NULL DEFAULT '0',
+  `seat_type` varchar(10) NOT NULL DEFAULT '',
+  `dep_idx` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `arr_idx` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `seat_count` int(11) NOT NULL DEFAULT '0',
+  PRIMARY KEY (`id`),
+  KEY `main` (`tran_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
@@ -37,6 +37,9 @@ CREATE TABLE `tickets` (
   `arr_station_idx` tinyint(4) unsigned NOT NULL,
   `arr_time` datetime NOT NULL,
   `change_ticket_id` bigint(20) unsigned NOT NULL DEFAULT '0',
+  `fare_rule` varchar(10) CHARACTER SET utf8 COLLATE utf8_general_ci NOT NULL DEFAULT '',
+  `leg_idx` tinyint(4) unsigned NOT NULL DEFAULT '0',
+  `pair_ticket_id` bigint(20) unsigned NOT NULL DEFAULT '0',
   PRIMARY KEY (`id`),
   KEY `q_passenger` (`passenger_id`,`status`),
   KEY `q_order` (`order_id`)
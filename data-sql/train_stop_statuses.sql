@@ -0,0 +1,36 @@
+/*
+SQLyog Ultimate v12.08 (64 bit)
+MySQL - 8.0.13 : Database - t-tran
+*********************************************************************
+*/
+
+
+/*!40101 SET NAMES utf8 */;
+
+/*!40101 SET SQL_MODE=''*/;
+
+/*!40014 SET @OLD_UNIQUE_CHECKS=@@UNIQUE_CHECKS, UNIQUE_CHECKS=0 */;
+/*!40101 SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO' */;
+/*!40111 SET @OLD_SQL_NOTES=@@SQL_NOTES, SQL_NOTES=0 */;
+/*Table structure for table `train_stop_statuses` */
+
+DROP TABLE IF EXISTS `train_stop_statuses`;
+
+CREATE TABLE `train_stop_statuses` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `tran_num` varchar(10) NOT NULL DEFAULT '',
+  `departure_date` varchar(10) NOT NULL DEFAULT '',
+  `station_idx` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `delay_minutes` int(11) NOT NULL DEFAULT '0',
+  `platform` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `check_ticket_gate` varchar(10) NOT NULL DEFAULT '',
+  `actual_arr_time` datetime NOT NULL,
+  `actual_dep_time` datetime NOT NULL,
+  `update_time` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `main` (`tran_num`,`departure_date`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+/*!40101 SET SQL_MODE=@OLD_SQL_MODE */;
+/*!40014 SET UNIQUE_CHECKS=@OLD_UNIQUE_CHECKS */;
+/*!40111 SET SQL_NOTES=@OLD_SQL_NOTES */;
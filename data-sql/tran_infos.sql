@@ -21,6 +21,8 @@ CREATE TABLE `tran_infos` (
   `tran_num` varchar(10) NOT NULL,
   `route_dep_corss_days` int(4) unsigned NOT NULL,
   `schedule_days` int(4) unsigned NOT NULL DEFAULT '1',
+  `weekdays` tinyint(3) unsigned NOT NULL DEFAULT '0',
+  `calendar_id` bigint(20) unsigned NOT NULL DEFAULT '0',
   `is_sale_ticket` tinyint(1) NOT NULL DEFAULT '1',
   `sale_ticket_time` datetime NOT NULL DEFAULT '2018-11-05 09:00:00',
   `non_sale_remark` varchar(100) NOT NULL DEFAULT '',
@@ -0,0 +1,33 @@
+/*
+已部署的数据库按顺序执行以下语句升级表结构，新部署时直接导入各表的建表语句即可
+*/
+
+/* 票价规则：车票记录适用的票价规则，乘客及常用联系人记录出生日期、身高及学生的家庭和学校所在城市 */
+ALTER TABLE `tickets` ADD COLUMN `fare_rule` varchar(10) NOT NULL DEFAULT '' AFTER `change_ticket_id`;
+ALTER TABLE `passengers`
+  ADD COLUMN `birthday` date NOT NULL DEFAULT '0001-01-01' AFTER `zip_code`,
+  ADD COLUMN `height` tinyint(3) unsigned NOT NULL DEFAULT '0' AFTER `birthday`,
+  ADD COLUMN `home_city` varchar(10) NOT NULL DEFAULT '' AFTER `height`,
+  ADD COLUMN `school_city` varchar(10) NOT NULL DEFAULT '' AFTER `home_city`;
+ALTER TABLE `contacts`
+  ADD COLUMN `birthday` date NOT NULL DEFAULT '0001-01-01' AFTER `zip_code`,
+  ADD COLUMN `height` tinyint(3) unsigned NOT NULL DEFAULT '0' AFTER `birthday`,
+  ADD COLUMN `home_city` varchar(10) NOT NULL DEFAULT '' AFTER `height`,
+  ADD COLUMN `school_city` varchar(10) NOT NULL DEFAULT '' AFTER `home_city`;
+
+/* 卧铺按铺位定价：路段票价增加铺位，为空时是该席别的价格 */
+ALTER TABLE `route_prices` ADD COLUMN `berth` varchar(10) NOT NULL DEFAULT '' AFTER `seat_type`;
+
+/* 联程订单：订单记录订单类型，车票记录在订单中的行程段序号 */
+ALTER TABLE `orders` ADD COLUMN `order_type` tinyint(4) unsigned NOT NULL DEFAULT '0' COMMENT '订单类型 0.普通订单 1.联程订单' AFTER `status`;
+ALTER TABLE `tickets` ADD COLUMN `leg_idx` tinyint(4) unsigned NOT NULL DEFAULT '0' AFTER `fare_rule`;
+
+/* 往返订单：车票记录同一乘客另一程车票的ID，调价配置增加往返折扣率 */
+ALTER TABLE `orders` MODIFY COLUMN `order_type` tinyint(4) unsigned NOT NULL DEFAULT '0' COMMENT '订单类型 0.普通订单 1.联程订单 2.往返订单';
+ALTER TABLE `tickets` ADD COLUMN `pair_ticket_id` bigint(20) unsigned NOT NULL DEFAULT '0' AFTER `leg_idx`;
+ALTER TABLE `price_settings` ADD COLUMN `round_trip_rate` double NOT NULL DEFAULT '0.95' AFTER `quote_valid_mins`;
+
+/* 开行日历：车次按星期或共用的开行日历开行，开行日历及加开、停开日期的建表语句见 operating_calendars.sql、operating_dates.sql */
+ALTER TABLE `tran_infos`
+  ADD COLUMN `weekdays` tinyint(3) unsigned NOT NULL DEFAULT '0' AFTER `schedule_days`,
+  ADD COLUMN `calendar_id` bigint(20) unsigned NOT NULL DEFAULT '0' AFTER `weekdays`;
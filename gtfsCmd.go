@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"t-tran/modules"
+)
+
+const gtfsUsage = `usage:
+  t-tran gtfs export <feed.zip>
+  t-tran gtfs import [-dry-run] <feed.zip>`
+
+// runGTFS 执行GTFS导入导出命令，返回进程退出码
+func runGTFS(args []string) int {
+	if len(args) == 0 {
+		fmt.Println(gtfsUsage)
+		return 2
+	}
+	switch args[0] {
+	case "export":
+		if len(args) != 2 {
+			fmt.Println(gtfsUsage)
+			return 2
+		}
+		return exportGTFS(args[1])
+	case "import":
+		fs := flag.NewFlagSet("gtfs import", flag.ContinueOnError)
+		dryRun := fs.Bool("dry-run", false, "只校验并输出报告，不保存")
+		if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 1 {
+			fmt.Println(gtfsUsage)
+			return 2
+		}
+		return importGTFS(fs.Arg(0), *dryRun)
+	default:
+		fmt.Println(gtfsUsage)
+		return 2
+	}
+}
+
+func exportGTFS(path string) int {
+	f, err := os.Create(path)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer f.Close()
+	if err = modules.ExportGTFS(f); err != nil {
+		fmt.Println("导出失败：", err)
+		return 1
+	}
+	fmt.Println("导出完成：", path)
+	return 0
+}
+
+func importGTFS(path string, dryRun bool) int {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	report, err := modules.ImportGTFS(f, info.Size(), dryRun)
+	if err != nil {
+		fmt.Println("导入失败：", err)
+		return 1
+	}
+	if report.DryRun {
+		fmt.Println("试运行，未保存任何数据")
+	}
+	for _, name := range report.Stations {
+		fmt.Println("新增车站：", name)
+	}
+	for _, name := range report.Calendars {
+		fmt.Println("开行日历：", name)
+	}
+	failed := 0
+	for _, t := range report.Trans {
+		status := "成功"
+		if !t.Success {
+			status, failed = "失败", failed+1
+		}
+		fmt.Printf("%s %s %s %s %s\n", t.TripID, t.TranNum, t.Action, status, t.Msg)
+		for _, issue := range t.Issues {
+			fmt.Printf("    [%s] %s %s\n", issue.Level, issue.Field, issue.Msg)
+		}
+	}
+	for _, w := range report.Warnings {
+		fmt.Println("警告：", w)
+	}
+	fmt.Printf("共%d个行程，失败%d个\n", len(report.Trans), failed)
+	if !report.DryRun && !report.Committed {
+		fmt.Println("有行程导入失败，未保存任何数据")
+	}
+	if failed != 0 {
+		return 1
+	}
+	return 0
+}
@@ -2,11 +2,23 @@ package main
 
 import (
 	"fmt"
+	"os"
+
 	_ "t-tran/modules"
-	_ "t-tran/web"
+	"t-tran/web"
 )
 
+// 不带参数时启动web服务，否则执行命令，如：t-tran gtfs import -dry-run feed.zip
 func main() {
-	var name string
-	fmt.Scan(&name)
+	if len(os.Args) < 2 {
+		web.Run()
+		return
+	}
+	switch os.Args[1] {
+	case "gtfs":
+		os.Exit(runGTFS(os.Args[2:]))
+	default:
+		fmt.Println("unknown command:", os.Args[1])
+		os.Exit(2)
+	}
 }
@@ -0,0 +1,295 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 告警级别
+	constAlertSeverityInfo     = iota // 提示
+	constAlertSeverityWarning         // 警告
+	constAlertSeverityError           // 错误
+	constAlertSeverityCritical        // 严重
+)
+
+const (
+	// 告警状态
+	constAlertOpen     = iota // 待处理
+	constAlertAcked           // 已确认
+	constAlertResolved        // 已解决
+)
+
+const constAlertWebhookTimeout = 5 * time.Second // webhook 请求超时时间
+
+var (
+	// 各告警级别的名称
+	alertSeverityNames = []string{"INFO", "WARNING", "ERROR", "CRITICAL"}
+	// 已注册的告警通道
+	alertSinks []alertSinkEntry
+	// 告警去重时的互斥锁，避免同一告警并发写入多条记录
+	alertLock sync.Mutex
+)
+
+// AdminAlert 管理员告警记录
+type AdminAlert struct {
+	ID          uint64    `json:"id"`
+	DedupKey    string    `gorm:"index:main;type:varchar(100)" json:"-"` // 去重键 日期_车次_车厢_座位_类型[_关联记录ID]
+	Date        string    `gorm:"type:varchar(10)" json:"date"`          // 日期
+	TranNum     string    `gorm:"type:varchar(10)" json:"tranNum"`       // 车次
+	CarNum      uint8     `json:"carNum"`                                // 车厢号
+	SeatNum     string    `gorm:"type:varchar(5)" json:"seatNum"`        // 座位号
+	DepStation  string    `gorm:"type:nvarchar(20)" json:"depStation"`   // 出发站
+	ArrStation  string    `gorm:"type:nvarchar(20)" json:"arrStation"`   // 到达站
+	NotifyType  string    `gorm:"type:varchar(20)" json:"notifyType"`    // 通知类型
+	Severity    uint8     `json:"severity"`                              // 告警级别
+	Message     string    `gorm:"type:nvarchar(500)" json:"message"`     // 消息说明
+	Status      uint8     `json:"status"`                                // 状态 0.待处理 1.已确认 2.已解决
+	RepeatCount int       `json:"repeatCount"`                           // 重复次数
+	FirstTime   time.Time `gorm:"type:datetime" json:"firstTime"`        // 首次告警时间
+	LastTime    time.Time `gorm:"type:datetime" json:"lastTime"`         // 最近告警时间
+	AckTime     time.Time `gorm:"type:datetime" json:"ackTime"`          // 确认时间
+	ResolveTime time.Time `gorm:"type:datetime" json:"resolveTime"`      // 解决时间
+}
+
+// 生成去重键，同一日期、车次、车厢、座位的同类告警视为重复；关联记录的告警按记录区分
+func getAlertDedupKey(n *notifyAdminInfo) string {
+	key := strings.Join([]string{n.date, n.tranNum, fmt.Sprint(n.carNum), n.seatNum, n.notifyType}, "_")
+	if n.refID != 0 {
+		key += "_" + fmt.Sprint(n.refID)
+	}
+	return key
+}
+
+func getAlertSeverityName(severity uint8) string {
+	if int(severity) < len(alertSeverityNames) {
+		return alertSeverityNames[severity]
+	}
+	return fmt.Sprint(severity)
+}
+
+// raiseAdminAlert 记录告警，未解决的重复告警只累加次数，不再重复投递
+// 投递在告警锁外进行，避免慢的告警通道阻塞其他告警；记录失败时仍投递，以免告警丢失
+func raiseAdminAlert(n *notifyAdminInfo) (*AdminAlert, error) {
+	alert, isNew, err := saveAdminAlert(n)
+	if err != nil {
+		log.Printf("admin alert %s save failed: %s\n", getAlertDedupKey(n), err)
+	}
+	if isNew {
+		dispatchAlert(alert)
+	}
+	return alert, err
+}
+
+// saveAdminAlert 在告警锁内查找未解决的重复告警并累加次数，没有时新建告警，返回是否需要投递
+func saveAdminAlert(n *notifyAdminInfo) (alert *AdminAlert, isNew bool, err error) {
+	alertLock.Lock()
+	defer alertLock.Unlock()
+	now, key := time.Now(), getAlertDedupKey(n)
+	alert = &AdminAlert{}
+	q := db.Where("dedup_key = ? and status != ?", key, constAlertResolved).First(alert)
+	if q.Error != nil && !q.RecordNotFound() {
+		alert = newAdminAlert(n, key, now)
+		return alert, true, q.Error
+	}
+	if alert.ID != 0 {
+		alert.RepeatCount++
+		alert.LastTime = now
+		alert.Message = n.message
+		if n.severity > alert.Severity {
+			alert.Severity = n.severity
+		}
+		return alert, false, db.Save(alert).Error
+	}
+	alert = newAdminAlert(n, key, now)
+	return alert, true, db.Create(alert).Error
+}
+
+// newAdminAlert 新建待处理的告警记录
+func newAdminAlert(n *notifyAdminInfo, key string, now time.Time) *AdminAlert {
+	return &AdminAlert{
+		DedupKey:    key,
+		Date:        n.date,
+		TranNum:     n.tranNum,
+		CarNum:      n.carNum,
+		SeatNum:     n.seatNum,
+		DepStation:  n.depStation,
+		ArrStation:  n.arrStation,
+		NotifyType:  n.notifyType,
+		Severity:    n.severity,
+		Message:     n.message,
+		Status:      constAlertOpen,
+		RepeatCount: 1,
+		FirstTime:   now,
+		LastTime:    now,
+	}
+}
+
+// dispatchAlert 投递到所有级别满足条件的告警通道，单个通道失败不影响其他通道
+func dispatchAlert(a *AdminAlert) {
+	for _, entry := range alertSinks {
+		if a.Severity < entry.minSeverity {
+			continue
+		}
+		if err := entry.sink.send(a); err != nil {
+			log.Printf("admin alert %d send by %s failed: %s\n", a.ID, entry.sink.name(), err)
+		}
+	}
+}
+
+// QueryAlerts 查询告警，status 小于0时查询所有未解决的告警
+func QueryAlerts(status int, page, pageSize int) (alerts []AdminAlert, count int) {
+	q := db.Table("admin_alerts")
+	if status < 0 {
+		q = q.Where("status != ?", constAlertResolved)
+	} else {
+		q = q.Where("status = ?", status)
+	}
+	q = q.Count(&count)
+	q.Order("severity desc, last_time desc").Offset((page - 1) * pageSize).Limit(pageSize).Find(&alerts)
+	return
+}
+
+// AckAlert 确认告警
+func AckAlert(alertID uint64) error {
+	alert := &AdminAlert{}
+	db.Where("id = ?", alertID).First(alert)
+	if alert.ID == 0 {
+		return errors.New("告警不存在")
+	}
+	if alert.Status != constAlertOpen {
+		return errors.New("告警已确认或已解决")
+	}
+	alert.Status = constAlertAcked
+	alert.AckTime = time.Now()
+	return db.Save(alert).Error
+}
+
+// ResolveAlert 解决告警，之后再出现的同类告警会重新记录并投递
+func ResolveAlert(alertID uint64) error {
+	alert := &AdminAlert{}
+	db.Where("id = ?", alertID).First(alert)
+	if alert.ID == 0 {
+		return errors.New("告警不存在")
+	}
+	if alert.Status == constAlertResolved {
+		return errors.New("告警已解决")
+	}
+	alert.Status = constAlertResolved
+	alert.ResolveTime = time.Now()
+	return db.Save(alert).Error
+}
+
+// alertSink 告警通道
+type alertSink interface {
+	name() string
+	send(a *AdminAlert) error
+}
+
+type alertSinkEntry struct {
+	sink        alertSink
+	minSeverity uint8 // 低于此级别的告警不经过该通道投递
+}
+
+// registerAlertSink 注册告警通道
+func registerAlertSink(sink alertSink, minSeverity uint8) {
+	alertSinks = append(alertSinks, alertSinkEntry{sink: sink, minSeverity: minSeverity})
+}
+
+func initAdminAlert() {
+	registerAlertSink(newLogAlertSink(os.Stdout), constAlertSeverityInfo)
+	// 需要邮件或webhook告警时，在此注册，如：
+	// registerAlertSink(newEmailAlertSink("smtp.example.com:25", nil, "t-tran@example.com", []string{"admin@example.com"}), constAlertSeverityError)
+	// registerAlertSink(newWebhookAlertSink("http://localhost:9000/alert"), constAlertSeverityWarning)
+}
+
+func formatAlert(a *AdminAlert) string {
+	return fmt.Sprintf("[%s] %s %s car:%d seat:%s %s-%s type:%s x%d %s",
+		getAlertSeverityName(a.Severity), a.Date, a.TranNum, a.CarNum, a.SeatNum,
+		a.DepStation, a.ArrStation, a.NotifyType, a.RepeatCount, a.Message)
+}
+
+// logAlertSink 日志告警通道，测试时可写入内存
+type logAlertSink struct {
+	logger *log.Logger
+}
+
+func newLogAlertSink(w io.Writer) *logAlertSink {
+	return &logAlertSink{logger: log.New(w, "admin alert ", log.LstdFlags)}
+}
+
+func (s *logAlertSink) name() string {
+	return "log"
+}
+
+func (s *logAlertSink) send(a *AdminAlert) error {
+	s.logger.Println(formatAlert(a))
+	return nil
+}
+
+// emailAlertSink 邮件告警通道，测试时替换 sendMail 即可
+type emailAlertSink struct {
+	addr     string    // smtp 服务地址 host:port
+	auth     smtp.Auth // 认证信息
+	from     string    // 发件人
+	to       []string  // 收件人
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func newEmailAlertSink(addr string, auth smtp.Auth, from string, to []string) *emailAlertSink {
+	return &emailAlertSink{addr: addr, auth: auth, from: from, to: to, sendMail: smtp.SendMail}
+}
+
+func (s *emailAlertSink) name() string {
+	return "email"
+}
+
+func (s *emailAlertSink) send(a *AdminAlert) error {
+	subject := fmt.Sprintf("[t-tran][%s] %s %s", getAlertSeverityName(a.Severity), a.TranNum, a.Date)
+	msg := "From: " + s.from + "\r\n" +
+		"To: " + strings.Join(s.to, ",") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n\r\n" +
+		formatAlert(a) + "\r\n"
+	return s.sendMail(s.addr, s.auth, s.from, s.to, []byte(msg))
+}
+
+// webhookAlertSink webhook告警通道，以JSON格式POST告警记录
+type webhookAlertSink struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookAlertSink(url string) *webhookAlertSink {
+	return &webhookAlertSink{url: url, client: &http.Client{Timeout: constAlertWebhookTimeout}}
+}
+
+func (s *webhookAlertSink) name() string {
+	return "webhook"
+}
+
+func (s *webhookAlertSink) send(a *AdminAlert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook response status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+)
+
+type memoryAlertSink struct {
+	alerts []*AdminAlert
+}
+
+func (s *memoryAlertSink) name() string {
+	return "memory"
+}
+
+func (s *memoryAlertSink) send(a *AdminAlert) error {
+	s.alerts = append(s.alerts, a)
+	return nil
+}
+
+func TestAlertDedupKey(t *testing.T) {
+	n1 := &notifyAdminInfo{date: "2018-01-01", tranNum: "G1", carNum: 3, seatNum: "01A", notifyType: "1", message: "a"}
+	n2 := &notifyAdminInfo{date: "2018-01-01", tranNum: "G1", carNum: 3, seatNum: "01A", notifyType: "1", message: "b"}
+	n3 := &notifyAdminInfo{date: "2018-01-01", tranNum: "G1", carNum: 3, seatNum: "01B", notifyType: "1", message: "a"}
+	if getAlertDedupKey(n1) == getAlertDedupKey(n2) && getAlertDedupKey(n1) != getAlertDedupKey(n3) {
+		t.Log("getAlertDedupKey pass")
+	} else {
+		t.Error("getAlertDedupKey fail")
+	}
+}
+
+func TestDispatchAlert(t *testing.T) {
+	oldSinks := alertSinks
+	defer func() { alertSinks = oldSinks }()
+	alertSinks = nil
+	all, severe := &memoryAlertSink{}, &memoryAlertSink{}
+	registerAlertSink(all, constAlertSeverityInfo)
+	registerAlertSink(severe, constAlertSeverityError)
+	dispatchAlert(&AdminAlert{Severity: constAlertSeverityWarning})
+	dispatchAlert(&AdminAlert{Severity: constAlertSeverityCritical})
+	if len(all.alerts) == 2 && len(severe.alerts) == 1 {
+		t.Log("dispatchAlert pass")
+	} else {
+		t.Error("dispatchAlert fail")
+	}
+}
+
+func TestAlertSinks(t *testing.T) {
+	a := &AdminAlert{ID: 1, Date: "2018-01-01", TranNum: "G1", CarNum: 3, SeatNum: "01A", Severity: constAlertSeverityCritical, Message: "Ticket Conflict"}
+
+	var buf bytes.Buffer
+	if err := newLogAlertSink(&buf).send(a); err == nil && strings.Contains(buf.String(), "[CRITICAL] 2018-01-01 G1") {
+		t.Log("logAlertSink pass")
+	} else {
+		t.Error("logAlertSink fail")
+	}
+
+	var mailTo []string
+	var mailMsg string
+	email := newEmailAlertSink("localhost:25", nil, "t-tran@example.com", []string{"admin@example.com"})
+	email.sendMail = func(addr string, auth smtp.Auth, from string, to []string, msg []byte) error {
+		mailTo, mailMsg = to, string(msg)
+		return nil
+	}
+	if err := email.send(a); err == nil && len(mailTo) == 1 && strings.Contains(mailMsg, "Subject: [t-tran][CRITICAL] G1 2018-01-01") {
+		t.Log("emailAlertSink pass")
+	} else {
+		t.Error("emailAlertSink fail")
+	}
+
+	var received AdminAlert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+	if err := newWebhookAlertSink(server.URL).send(a); err == nil && received.ID == 1 && received.SeatNum == "01A" {
+		t.Log("webhookAlertSink pass")
+	} else {
+		t.Error("webhookAlertSink fail")
+	}
+
+	failServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failServer.Close()
+	if err := newWebhookAlertSink(failServer.URL).send(a); err != nil {
+		t.Log("webhookAlertSink status pass")
+	} else {
+		t.Error("webhookAlertSink status fail")
+	}
+}
+
+func TestAlertDedupKeyByRef(t *testing.T) {
+	n1 := &notifyAdminInfo{date: "2018-01-01", notifyType: "refund", refID: 1}
+	n2 := &notifyAdminInfo{date: "2018-01-01", notifyType: "refund", refID: 2}
+	n3 := &notifyAdminInfo{date: "2018-01-01", notifyType: "refund"}
+	if getAlertDedupKey(n1) != getAlertDedupKey(n2) && getAlertDedupKey(n3) == "2018-01-01__0__refund" {
+		t.Log("getAlertDedupKey by ref pass")
+	} else {
+		t.Error("getAlertDedupKey by ref fail")
+	}
+}
@@ -59,6 +59,6 @@ func QueryStations(stationName, cityName string, page, pageSize int) (stations [
 
 // GetStationDetail 获取车站明细
 func GetStationDetail(stationID int) (s Station) {
-	db.Where("id = ?", stationID).First(s)
+	db.Where("id = ?", stationID).First(&s)
 	return
 }
@@ -7,6 +7,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/jinzhu/gorm"
 )
 
 const (
@@ -40,14 +42,34 @@ const (
 	constSeatTypeNoSeat              = "NST" // 无座
 )
 
+const (
+	// 卧铺的铺位
+	constBerthLower  = "lower"  // 下铺
+	constBerthMiddle = "middle" // 中铺
+	constBerthUpper  = "upper"  // 上铺
+)
+
+var (
+	// 卧铺座位号的后缀及其对应的铺位，卧铺座位号如：01上、01中、01下
+	berthSuffixMap = map[string]string{"上": constBerthUpper, "中": constBerthMiddle, "下": constBerthLower}
+	// 各类卧铺所包含的铺位
+	seatTypeBerths = map[string][]string{
+		constSeatTypeAdvancedSoftSleeper: []string{constBerthLower, constBerthUpper},
+		constSeatTypeSoftSleeper:         []string{constBerthLower, constBerthUpper},
+		constSeatTypeEMUSleeper:          []string{constBerthLower, constBerthUpper},
+		constSeatTypeMoveSleeper:         []string{constBerthLower, constBerthUpper},
+		constSeatTypeHardSleeper:         []string{constBerthLower, constBerthMiddle, constBerthUpper},
+	}
+)
+
 type tranCfgs []TranInfo
 
 func (t tranCfgs) Len() int {
 	return len(t)
 }
 func (t tranCfgs) Less(i, j int) bool {
-	if t[i].TranNum < t[j].TranNum {
-		return true
+	if t[i].TranNum != t[j].TranNum {
+		return t[i].TranNum < t[j].TranNum
 	}
 	return t[i].EnableStartDate.Before(t[j].EnableStartDate)
 }
@@ -55,34 +77,31 @@ func (t tranCfgs) Swap(i, j int) {
 	t[i], t[j] = t[j], t[i]
 }
 
-var (
-	// 所有车厢，存于内存，便于组装
-	carMap map[int](Car)
-	// 所有车次信息，不参与订票，用于查询列车的时刻表和各路段各座次的价格
-	tranInfos tranCfgs
-	// 各城市与经过该城市的列车映射
-	cityTranMap map[string]([]*TranInfo)
-)
-
 func initTranInfo() {
-	initCarMap()
-	initTranInfos()
-	initCityTranMap()
+	cars := initCarMap()
+	initOperatingCalendars()
+	// 加载车次时需用到车厢信息，先发布只有车厢的快照
+	snapshot := &tranSnapshot{carMap: cars, scheduleCarMap: initScheduleCar(cars)}
+	storeTranSnapshot(snapshot)
+	snapshot.tranInfos = initTranInfos()
+	snapshot.cityTranMap = initCityTranMap(snapshot.tranInfos)
+	storeTranSnapshot(snapshot)
 }
 
-func initCarMap() {
+func initCarMap() map[int](Car) {
 	start := time.Now()
 	var cars []Car
 	db.Find(&cars)
-	carMap = make(map[int](Car), len(cars))
+	carMap := make(map[int](Car), len(cars))
 	for i := 0; i < len(cars); i++ {
 		db.Where("car_id = ?", cars[i].ID).Find(&cars[i].Seats)
 		carMap[cars[i].ID] = cars[i]
 	}
 	fmt.Println("init car map complete, cost time:", time.Now().Sub(start).Seconds(), "(s)")
+	return carMap
 }
 
-func initTranInfos() {
+func initTranInfos() (tranInfos tranCfgs) {
 	start := time.Now()
 	today, lastDate := time.Now().Format(ConstYmdFormat), time.Now().AddDate(0, 0, constDays).Format(ConstYmdFormat)
 	db.Where("enable_end_date >= ? and ? >= enable_start_date", today, lastDate).Find(&tranInfos)
@@ -101,46 +120,55 @@ func initTranInfos() {
 	goPool.Close()
 	sort.Sort(tranInfos)
 	fmt.Println("init tran infos complete, cost time:", time.Now().Sub(start).Seconds(), "(s)")
+	return
 }
 
-func initCityTranMap() {
+func initCityTranMap(tranInfos tranCfgs) map[string]([]*TranInfo) {
 	start := time.Now()
-	cityTranMap = make(map[string]([]*TranInfo), constCityCount)
+	m := buildCityTranMap(tranInfos)
+	fmt.Println("init city tran map complete, cost time:", time.Now().Sub(start).Seconds(), "(s)")
+	return m
+}
+
+// buildCityTranMap 各城市与经过该城市的列车映射，指向tranInfos中的元素
+func buildCityTranMap(tranInfos tranCfgs) map[string]([]*TranInfo) {
+	m := make(map[string]([]*TranInfo), constCityCount)
 	for i := 0; i < len(tranInfos); i++ {
 		for j := 0; j < len(tranInfos[i].Timetable); j++ {
 			cityCode := tranInfos[i].Timetable[j].CityCode
-			tranPtrs, exist := cityTranMap[cityCode]
+			tranPtrs, exist := m[cityCode]
 			if exist {
 				tranPtrs = append(tranPtrs, &tranInfos[i])
 			} else {
 				tranPtrs = []*TranInfo{&tranInfos[i]}
 			}
-			cityTranMap[cityCode] = tranPtrs
+			m[cityCode] = tranPtrs
 		}
 	}
-	fmt.Println("init city tran map complete, cost time:", time.Now().Sub(start).Seconds(), "(s)")
+	return m
 }
 
+// getTranInfo 获取车次在某日生效的版本，同一车次的各版本按生效期排序且互不重叠
 func getTranInfo(tranNum string, date time.Time) (*TranInfo, bool) {
+	tranInfos := getTranSnapshot().tranInfos
 	idx := sort.Search(len(tranInfos), func(i int) bool {
-		if tranInfos[i].TranNum < tranNum ||
-			tranInfos[i].EnableEndDate.Before(date) ||
-			tranInfos[i].EnableStartDate.After(date) {
-			return false
+		if tranInfos[i].TranNum != tranNum {
+			return tranInfos[i].TranNum > tranNum
 		}
-		return true
+		return !tranInfos[i].EnableEndDate.Before(date)
 	})
-	if idx == -1 {
+	if idx == len(tranInfos) || tranInfos[idx].TranNum != tranNum || tranInfos[idx].EnableStartDate.After(date) {
 		return nil, false
 	}
 	// 该车次在所选日期不发车
-	if int(date.Sub(tranInfos[idx].EnableStartDate).Hours())%(tranInfos[idx].ScheduleDays*24) != 0 {
+	if !tranInfos[idx].isOperatingDay(date) {
 		return nil, false
 	}
 	return &tranInfos[idx], true
 }
 
 func getViaTrans(depS, arrS *Station) (result []*TranInfo) {
+	cityTranMap := getTranSnapshot().cityTranMap
 	// 获取经过出发站所在城市的所有车次
 	depTrans, exist := cityTranMap[depS.CityCode]
 	if !exist {
@@ -174,6 +202,8 @@ type TranInfo struct {
 	TranNum           string    `gorm:"index:main;type:varchar(10)" json:"tranNum"` // 车次号
 	RouteDepCrossDays int       `json:"durationDays"`                               // 路段出发跨天数：最后一个路段的发车时间与起点站发车时间的间隔天数
 	ScheduleDays      int       `gorm:"default:1" json:"scheduleDays"`              // 间隔多少天发一趟车，绝大多数是1天
+	Weekdays          uint8     `json:"weekdays"`                                   // 开行的星期，按位表示，第0位为周日，为零时按开行日历或每天开行
+	CalendarID        uint64    `json:"calendarID"`                                 // 共用的开行日历ID，为零时不使用
 	IsSaleTicket      bool      `json:"isSaleTicket"`                               // 是否售票
 	SaleTicketTime    time.Time `json:"saleTicketTime"`                             // 售票时间，不需要日期部分，只取时间部分
 	NonSaleRemark     string    `gorm:"type:varchar(100)" json:"nonSaleRemark"`     // 不售票说明
@@ -186,6 +216,12 @@ type TranInfo struct {
 	carTypeIdxMap map[string]([]uint8) // 各座次类型及其对应的车厢索引集合
 	Timetable     []Route              `gorm:"-" json:"timetable"`    // 时刻表
 	SeatPriceMap  map[string]([]int)   `gorm:"-" json:"seatPriceMap"` // 各类席位在各路段的价格
+	// 卧铺各铺位在各路段的价格，未单独设置价格的铺位按所属席别的价格
+	BerthPriceMap map[string](map[string]([]int)) `gorm:"-" json:"berthPriceMap"`
+	QuotaRules    []SeatQuotaRule                 `gorm:"-" json:"quotaRules"` // 中途站分段席位配额
+	// 单独指定的加开及停开日期，优先于开行日历
+	OperatingDates []OperatingDate `gorm:"-" json:"operatingDates"`
+	dateMap        map[string]bool // 单独指定的日期，加开为true，停开为false
 }
 
 // 是否为城际车次，城际车次在同一个城市内可能会有多个站，情况相对特殊
@@ -198,21 +234,29 @@ func (t *TranInfo) getFullInfo() {
 	db.Where("tran_id = ?", t.ID).Order("station_index").Find(&t.Timetable)
 	// 获取各席别在各路段的价格，大多数车次只有三类席别（无座不考虑）
 	t.SeatPriceMap = make(map[string]([]int), 3)
+	t.BerthPriceMap = make(map[string](map[string]([]int)))
 	var routePrices []RoutePrice
-	db.Where("tran_id = ?", t.ID).Order("seat_type, route_index").Find(&routePrices)
-	count := len(routePrices) + 1
-	for start, end := 0, 1; end < count; end++ {
-		if end == count-1 || routePrices[start].SeatType != routePrices[end].SeatType {
-			arr := routePrices[start:end]
-			prices := make([]int, len(arr))
-			for idx, rp := range arr {
-				prices[idx] = rp.Price
-			}
-			t.SeatPriceMap[routePrices[start].SeatType] = prices
-			start = end
+	db.Where("tran_id = ?", t.ID).Order("seat_type, berth, route_index").Find(&routePrices)
+	for _, rp := range routePrices {
+		if rp.Berth == "" {
+			t.SeatPriceMap[rp.SeatType] = append(t.SeatPriceMap[rp.SeatType], rp.Price)
+			continue
+		}
+		if t.BerthPriceMap[rp.SeatType] == nil {
+			t.BerthPriceMap[rp.SeatType] = make(map[string]([]int))
 		}
+		t.BerthPriceMap[rp.SeatType][rp.Berth] = append(t.BerthPriceMap[rp.SeatType][rp.Berth], rp.Price)
 	}
-	// 设置车厢信息
+	// 获取中途站分段席位配额
+	db.Where("tran_id = ?", t.ID).Order("id").Find(&t.QuotaRules)
+	// 获取单独指定的加开及停开日期
+	db.Where("tran_id = ?", t.ID).Order("date").Find(&t.OperatingDates)
+	t.dateMap = newDateMap(t.OperatingDates)
+	t.initCarIdx(getTranSnapshot().carMap)
+}
+
+// initCarIdx 按车厢编组设置各席别的车厢索引及车厢数
+func (t *TranInfo) initCarIdx(carMap map[int](Car)) {
 	t.carTypeIdxMap = make(map[string]([]uint8))
 	// 车厢ID及其数量，格式如：32:1;12:2; ...
 	carSettings, carIdx := strings.Split(t.CarIds, ";"), uint8(0)
@@ -238,12 +282,12 @@ func (t *TranInfo) getFullInfo() {
 	t.carCount = carIdx
 }
 
-// 要严格与getFullInfo中设置车厢信息部分的逻辑一致
+// 要严格与initCarIdx中设置车厢信息的逻辑一致
 func (t *TranInfo) getScheduleCars() []ScheduleCar {
 	// 获取排班的车厢信息
 	result := make([]ScheduleCar, t.carCount)
 	carIdx, routeCount := uint8(0), len(t.Timetable)-1
-	carSettings := strings.Split(t.CarIds, ";")
+	carSettings, scheduleCarMap := strings.Split(t.CarIds, ";"), getTranSnapshot().scheduleCarMap
 	for i := 0; i < len(carSettings); i++ {
 		setting := strings.Split(carSettings[i], ":")
 		if len(setting) == 2 {
@@ -255,37 +299,108 @@ func (t *TranInfo) getScheduleCars() []ScheduleCar {
 					SeatType:    sc.SeatType,
 					CarNum:      carIdx + 1,
 					NoSeatCount: sc.NoSeatCount,
-					Seats:       sc.Seats,
+					Seats:       append([]ScheduleSeat(nil), sc.Seats...), // 各车厢的座位单独分配配额，不能共享
 					EachRouteTravelerCount: make([]uint8, routeCount), //sc.EachRouteTravelerCount,
 				}
 				carIdx++
 			}
 		}
 	}
+	t.applySeatQuotas(result)
 	return result
 }
 
-// Save 保存到数据库
+// Save 保存到数据库，生效开始日期晚于原版本时另存为新版本，保存后重新加载该车次
 func (t *TranInfo) Save() (bool, string) {
+	tx := db.Begin()
+	if ok, msg := t.save(tx); !ok {
+		tx.Rollback()
+		return false, msg
+	}
+	if err := tx.Commit().Error; err != nil {
+		return false, err.Error()
+	}
+	reloadTrans(t.TranNum)
+	return true, ""
+}
+
+// save 在事务中校验并保存车次及其时刻表、票价、配额和开行日期
+func (t *TranInfo) save(tx *gorm.DB) (bool, string) {
+	if msg := FirstValidError(t.Validate()); msg != "" {
+		return false, msg
+	}
+	if ok, msg := t.validCalendar(); !ok {
+		return false, msg
+	}
+	return t.saveVersion(tx)
+}
+
+// saveVersion 在事务中保存已校验车站及开行日历的车次版本，
+// GTFS导入时车站及开行日历与车次在同一事务中保存，提交前尚未加载到内存，由导入自行校验
+func (t *TranInfo) saveVersion(tx *gorm.DB) (bool, string) {
+	if ok, msg := t.validSeatQuotaRules(); !ok {
+		return false, msg
+	}
+	if ok, msg := t.validBerthPrices(); !ok {
+		return false, msg
+	}
 	t.initTimetable()
+	t.fillStationCodes()
 	t.EnableEndDate = t.EnableEndDate.Add(24*time.Hour - time.Second)
+	if ok, msg := t.prepareVersion(tx); !ok {
+		return false, msg
+	}
 	if t.ID == 0 {
-		db.Create(t)
+		if err := tx.Create(t).Error; err != nil {
+			return false, err.Error()
+		}
 	} else {
-		db.Save(t)
-		db.Delete(Route{}, "tran_id = ?", t.ID)
-		db.Delete(RoutePrice{}, "tran_id = ?", t.ID)
+		if err := tx.Save(t).Error; err != nil {
+			return false, err.Error()
+		}
+		for _, m := range []interface{}{Route{}, RoutePrice{}, SeatQuotaRule{}, OperatingDate{}} {
+			if err := tx.Delete(m, "tran_id = ?", t.ID).Error; err != nil {
+				return false, err.Error()
+			}
+		}
 	}
 	for i, r := range t.Timetable {
 		r.TranID = t.ID
 		r.TranNum = t.TranNum
 		r.StationIndex = uint8(i + 1)
-		db.Create(&r)
+		if err := tx.Create(&r).Error; err != nil {
+			return false, err.Error()
+		}
 	}
 	for k, v := range t.SeatPriceMap {
 		for i, p := range v {
 			rp := &RoutePrice{TranID: t.ID, SeatType: k, RouteIndex: uint8(i), Price: p}
-			db.Create(rp)
+			if err := tx.Create(rp).Error; err != nil {
+				return false, err.Error()
+			}
+		}
+	}
+	for k, berths := range t.BerthPriceMap {
+		for berth, v := range berths {
+			for i, p := range v {
+				rp := &RoutePrice{TranID: t.ID, SeatType: k, Berth: berth, RouteIndex: uint8(i), Price: p}
+				if err := tx.Create(rp).Error; err != nil {
+					return false, err.Error()
+				}
+			}
+		}
+	}
+	for i := range t.QuotaRules {
+		t.QuotaRules[i].ID = 0
+		t.QuotaRules[i].TranID = t.ID
+		if err := tx.Create(&t.QuotaRules[i]).Error; err != nil {
+			return false, err.Error()
+		}
+	}
+	for i := range t.OperatingDates {
+		t.OperatingDates[i].ID, t.OperatingDates[i].CalendarID, t.OperatingDates[i].TranID = 0, 0, t.ID
+		if err := tx.Create(&t.OperatingDates[i]).Error; err != nil {
+			return false, err.Error()
 		}
 	}
 	return true, ""
@@ -316,23 +431,97 @@ func (t *TranInfo) initTimetable() {
 	t.RouteDepCrossDays = t.Timetable[len(t.Timetable)-1].DepTime.YearDay() - 1
 }
 
-// 根据起止站获取各类座位的票价
+// 根据起止站获取各类座位的票价，卧铺取各铺位中的最低价
 func (t *TranInfo) getSeatPrice(depIdx, arrIdx uint8) (result map[string]float32) {
 	result = make(map[string]float32)
-	for seatType, eachRoutePrice := range t.SeatPriceMap {
-		length := uint8(len(eachRoutePrice))
-		if arrIdx > length-1 {
-			arrIdx = length - 1
+	berthPrices := t.getBerthPrice(depIdx, arrIdx)
+	for seatType := range t.SeatPriceMap {
+		prices, isSleeper := berthPrices[seatType]
+		if !isSleeper {
+			result[seatType] = sumRoutePrice(t.SeatPriceMap[seatType], depIdx, arrIdx)
+			continue
+		}
+		lowest := float32(-1)
+		for _, p := range prices {
+			if lowest < 0 || p < lowest {
+				lowest = p
+			}
+		}
+		result[seatType] = lowest
+	}
+	return
+}
+
+// 根据起止站获取各类卧铺各铺位的票价
+func (t *TranInfo) getBerthPrice(depIdx, arrIdx uint8) (result map[string](map[string]float32)) {
+	result = make(map[string](map[string]float32))
+	for seatType := range t.SeatPriceMap {
+		berths, isSleeper := seatTypeBerths[seatType]
+		if !isSleeper {
+			continue
 		}
-		price := 0
-		for i := depIdx; i < arrIdx; i++ {
-			price += eachRoutePrice[i]
+		prices := make(map[string]float32, len(berths))
+		for _, berth := range berths {
+			prices[berth] = sumRoutePrice(t.getRoutePrices(seatType, berth), depIdx, arrIdx)
 		}
-		result[seatType] = float32(price) / 100
+		result[seatType] = prices
 	}
 	return
 }
 
+// getRoutePrices 获取席别或卧铺铺位在各路段的价格，铺位未单独设置价格时按所属席别的价格
+func (t *TranInfo) getRoutePrices(seatType, berth string) []int {
+	if prices, ok := t.BerthPriceMap[seatType][berth]; ok {
+		return prices
+	}
+	return t.SeatPriceMap[seatType]
+}
+
+// sumRoutePrice 累加起止站之间各路段的价格，路段i为第i站至第i+1站
+func sumRoutePrice(routePrices []int, depIdx, arrIdx uint8) float32 {
+	if int(arrIdx) > len(routePrices) {
+		arrIdx = uint8(len(routePrices))
+	}
+	price := 0
+	for i := depIdx; i < arrIdx; i++ {
+		price += routePrices[i]
+	}
+	return float32(price) / 100
+}
+
+// getBerth 根据座位号获取卧铺的铺位，非卧铺返回空
+func getBerth(seatNum string) string {
+	for suffix, berth := range berthSuffixMap {
+		if strings.HasSuffix(seatNum, suffix) {
+			return berth
+		}
+	}
+	return ""
+}
+
+// validBerthPrices 校验卧铺各铺位的价格，须为该席别所包含的铺位，且路段数与席别价格一致
+func (t *TranInfo) validBerthPrices() (bool, string) {
+	for seatType, berths := range t.BerthPriceMap {
+		seatPrices, exist := t.SeatPriceMap[seatType]
+		if !exist {
+			return false, "铺位价格的席别无效"
+		}
+		for berth, prices := range berths {
+			valid := false
+			for _, b := range seatTypeBerths[seatType] {
+				valid = valid || b == berth
+			}
+			if !valid {
+				return false, "铺位价格的铺位无效"
+			}
+			if len(prices) != len(seatPrices) {
+				return false, "铺位价格的路段数与席别价格不一致"
+			}
+		}
+	}
+	return true, ""
+}
+
 // IsMatchQuery 判断当前车次在日期上是否匹配
 func (t *TranInfo) IsMatchQuery(depS, arrS *Station, queryDate time.Time) (depIdx, arrIdx uint8, depDate string, ok bool) {
 	// 查询的日期需在车次配置的有效期内
@@ -386,41 +575,35 @@ func (t *TranInfo) IsMatchQuery(depS, arrS *Station, queryDate time.Time) (depId
 	}
 	// 计算当前车次信息的出发站发车日期
 	date := queryDate.AddDate(0, 0, 1-t.Timetable[depIdx].DepTime.Day())
-	// 不是每天发车的车次，要判断发车日期是否有效
-	if t.ScheduleDays > 1 {
-		if date.Sub(t.EnableStartDate).Hours()/float64(24*t.ScheduleDays) != 0 {
-			return
-		}
+	// 按开行日历判断发车日期是否开行
+	if !t.isOperatingDay(date) {
+		return
 	}
 	depDate = date.Format(ConstYmdFormat)
 	ok = true
 	return
 }
 
-// getOrderPrice 获取订单价格
+// getOrderPrice 获取订单价格，卧铺按座位号所在的铺位计价
 func (t *TranInfo) getOrderPrice(seatType, seatNum string, depIdx, arrIdx uint8) float32 {
-	var priceSlice []int
-	switch seatType {
-	case constSeatTypeAdvancedSoftSleeper, constSeatTypeSoftSleeper, constSeatTypeHardSleeper:
-		priceSlice = t.SeatPriceMap[seatType+strings.Split(seatNum, "-")[1]][depIdx : arrIdx-depIdx]
-	default:
-		priceSlice = t.SeatPriceMap[seatType][depIdx : arrIdx-depIdx]
-	}
-	price := 0
-	for _, p := range priceSlice {
-		price += p
+	berth := ""
+	if _, isSleeper := seatTypeBerths[seatType]; isSleeper {
+		berth = getBerth(seatNum)
 	}
-	return float32(price) / 100
+	return sumRoutePrice(t.getRoutePrices(seatType, berth), depIdx, arrIdx)
 }
 
-// getDepAndArrTime 获取出发和到站时间
+// getDepAndArrTime 获取出发和到站时间，date 为起点站的发车日期
 func (t *TranInfo) getDepAndArrTime(date string, depIdx, arrIdx uint8) (time.Time, time.Time) {
-	depTime, arrTime := t.Timetable[depIdx].DepTime, t.Timetable[arrIdx].ArrTime
-	dt, _ := time.Parse(ConstYmdFormat, date)
-	y, m, d := dt.Date()
-	depTime = depTime.AddDate(y, int(m), d)
-	arrTime = arrTime.AddDate(y, int(m), d)
-	return depTime, arrTime
+	dt, _ := time.ParseInLocation(ConstYmdFormat, date, time.Local)
+	return atDepDate(t.Timetable[depIdx].DepTime, dt), atDepDate(t.Timetable[arrIdx].ArrTime, dt)
+}
+
+// atDepDate 将时刻表中的时间换算为实际时间，时刻表从0001-01-01开始，所在天数减一即为跨天数
+func atDepDate(routeTime, depDate time.Time) time.Time {
+	y, m, d := depDate.Date()
+	h, mi, s := routeTime.Clock()
+	return time.Date(y, m, d+routeTime.YearDay()-1, h, mi, s, 0, time.Local)
 }
 
 // Route 时刻表信息
@@ -456,8 +639,9 @@ func (r *Route) getStrStayTime() string {
 // RoutePrice 各路段价格
 type RoutePrice struct {
 	ID         uint64
-	TranID     int    `gorm:"index:main"`      // 车次ID
-	SeatType   string `gorm:"type:varchar(5)"` // 座次类型
+	TranID     int    `gorm:"index:main"`       // 车次ID
+	SeatType   string `gorm:"type:varchar(5)"`  // 座次类型
+	Berth      string `gorm:"type:varchar(10)"` // 卧铺铺位，为空时是该席别的价格
 	RouteIndex uint8  // 路段索引
 	Price      int    // 价格, 单位：分
 }
@@ -475,16 +659,35 @@ type Car struct {
 
 // Save 保存车厢信息到数据库
 func (c *Car) Save() (bool, string) {
+	tx := db.Begin()
+	if ok, msg := c.save(tx); !ok {
+		tx.Rollback()
+		return false, msg
+	}
+	if err := tx.Commit().Error; err != nil {
+		return false, err.Error()
+	}
+	reloadCars(c.ID)
+	return true, ""
+}
+
+// save 在事务中保存车厢及其座位
+func (c *Car) save(tx *gorm.DB) (bool, string) {
 	c.SeatCount = uint8(len(c.Seats))
+	var err error
 	if c.ID == 0 {
-		db.Create(c)
+		err = tx.Create(c).Error
 	} else {
-		db.Save(c)
-		db.Delete(Seat{}, "car_id = ?", c.ID)
+		if err = tx.Save(c).Error; err == nil {
+			err = tx.Delete(Seat{}, "car_id = ?", c.ID).Error
+		}
 	}
-	for i := 0; i < len(c.Seats); i++ {
+	for i := 0; err == nil && i < len(c.Seats); i++ {
 		c.Seats[i].CarID = c.ID
-		db.Create(&c.Seats[i])
+		err = tx.Create(&c.Seats[i]).Error
+	}
+	if err != nil {
+		return false, err.Error()
 	}
 	return true, ""
 }
@@ -7,6 +7,7 @@ import (
 )
 
 func TestStructTranInfo(t *testing.T) {
+	tranInfos, cityTranMap := getTranSnapshot().tranInfos, getTranSnapshot().cityTranMap
 	if len(tranInfos) == 10425 {
 		t.Log("trans info pass")
 	} else {
@@ -137,4 +138,105 @@ func TestStructCar(t *testing.T) {
 	} else {
 		t.Error("Save fail")
 	}
-}
\ No newline at end of file
+}
+
+// Z38 武昌 -> 郑州 -> 石家庄 -> 北京西，各路段价格单位：分
+func newBerthPriceTran() *TranInfo {
+	return &TranInfo{
+		TranNum: "Z38",
+		Timetable: []Route{
+			Route{StationName: "武昌"},
+			Route{StationName: "郑州"},
+			Route{StationName: "石家庄"},
+			Route{StationName: "北京西"},
+		},
+		SeatPriceMap: map[string]([]int){
+			constSeatTypeHardSeat:    []int{5750, 2900, 3100},
+			constSeatTypeHardSleeper: []int{9950, 5050, 5350},
+			constSeatTypeSoftSleeper: []int{15250, 7750, 8150},
+		},
+		BerthPriceMap: map[string](map[string]([]int)){
+			constSeatTypeHardSleeper: map[string]([]int){
+				constBerthMiddle: []int{10350, 5250, 5550},
+				constBerthLower:  []int{10750, 5450, 5750},
+			},
+			constSeatTypeSoftSleeper: map[string]([]int){
+				constBerthLower: []int{16250, 8250, 8650},
+			},
+		},
+	}
+}
+
+func TestRoutePrice(t *testing.T) {
+	tran := newBerthPriceTran()
+	orderCases := []struct {
+		seatType, seatNum string
+		depIdx, arrIdx    uint8
+		price             float32
+	}{
+		{constSeatTypeHardSeat, "01A", 0, 3, 117.5},
+		{constSeatTypeHardSeat, "01A", 1, 3, 60},
+		{constSeatTypeHardSleeper, "01上", 0, 3, 203.5},
+		{constSeatTypeHardSleeper, "01中", 0, 3, 211.5},
+		{constSeatTypeHardSleeper, "01下", 0, 3, 219.5},
+		{constSeatTypeHardSleeper, "05下", 1, 2, 54.5},
+		{constSeatTypeHardSleeper, "05中", 2, 3, 55.5},
+		{constSeatTypeSoftSleeper, "02上", 1, 3, 159},
+		{constSeatTypeSoftSleeper, "02下", 1, 3, 169},
+	}
+	for _, c := range orderCases {
+		if p := tran.getOrderPrice(c.seatType, c.seatNum, c.depIdx, c.arrIdx); p == c.price {
+			t.Log("getOrderPrice pass")
+		} else {
+			t.Errorf("getOrderPrice %s %s %d-%d fail: %v", c.seatType, c.seatNum, c.depIdx, c.arrIdx, p)
+		}
+	}
+
+	seatPrice := tran.getSeatPrice(1, 3)
+	if seatPrice[constSeatTypeHardSeat] == 60 && seatPrice[constSeatTypeHardSleeper] == 104 && seatPrice[constSeatTypeSoftSleeper] == 159 {
+		t.Log("getSeatPrice pass")
+	} else {
+		t.Error("getSeatPrice fail")
+	}
+
+	berthPrice := tran.getBerthPrice(1, 3)
+	hs, ss := berthPrice[constSeatTypeHardSleeper], berthPrice[constSeatTypeSoftSleeper]
+	if len(berthPrice) == 2 && len(hs) == 3 && hs[constBerthUpper] == 104 && hs[constBerthMiddle] == 108 && hs[constBerthLower] == 112 &&
+		len(ss) == 2 && ss[constBerthUpper] == 159 && ss[constBerthLower] == 169 {
+		t.Log("getBerthPrice pass")
+	} else {
+		t.Error("getBerthPrice fail")
+	}
+
+	validCases := []struct {
+		seatType, berth string
+		prices          []int
+		ok              bool
+	}{
+		{constSeatTypeHardSleeper, constBerthUpper, []int{9950, 5050, 5350}, true},
+		{constSeatTypeSoftSleeper, constBerthMiddle, []int{15250, 7750, 8150}, false},
+		{constSeatTypeHardSleeper, constBerthUpper, []int{9950, 5050}, false},
+		{constSeatTypeAdvancedSoftSleeper, constBerthLower, []int{20000, 10000, 10000}, false},
+	}
+	for _, c := range validCases {
+		tran := newBerthPriceTran()
+		tran.BerthPriceMap[c.seatType] = map[string]([]int){c.berth: c.prices}
+		if ok, _ := tran.validBerthPrices(); ok == c.ok {
+			t.Log("validBerthPrices pass")
+		} else {
+			t.Errorf("validBerthPrices %s %s fail", c.seatType, c.berth)
+		}
+	}
+}
+
+func TestGetDepAndArrTime(t *testing.T) {
+	dep, _ := time.Parse(ConstYMdHmsFormat, "0001-01-01 21:10:00")
+	arr, _ := time.Parse(ConstYMdHmsFormat, "0001-01-02 07:05:00")
+	tran := &TranInfo{Timetable: []Route{Route{DepTime: dep}, Route{ArrTime: arr}}}
+	depTime, arrTime := tran.getDepAndArrTime("2018-06-30", 0, 1)
+	if depTime.Format(ConstYMdHmFormat) == "2018-06-30 21:10" && arrTime.Format(ConstYMdHmFormat) == "2018-07-01 07:05" {
+		t.Log("getDepAndArrTime pass")
+	} else {
+		t.Error("getDepAndArrTime fail")
+	}
+}
@@ -0,0 +1,238 @@
+package modules
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	constBookingQueueCap      = 5000             // 每个车次每天的排队上限，超过时拒绝提交
+	constBookingWorkerCount   = 200              // 同时处理订票请求的最大数量
+	constBookingQueueIdle     = time.Minute      // 队列空闲多久后回收
+	constBookingResultKeep    = 10 * time.Minute // 排队结果保留时长
+	constBookingDefaultCostMs = 50               // 尚无统计数据时，单个订票请求的预估耗时 单位：毫秒
+)
+
+const (
+	// 排队状态
+	constBookingQueueing = iota // 排队中
+	constBookingSuccess         // 订票成功
+	constBookingFail            // 订票失败
+)
+
+var (
+	// 各车次各日期的订票队列，key为 车次_日期
+	bookingQueues     = make(map[string]*bookingQueue)
+	bookingQueuesLock sync.Mutex
+	// 排队凭证与订票请求的映射
+	bookingRequests sync.Map
+	// 限制同时处理的订票请求数
+	bookingWorkerPool = newGoPool(constBookingWorkerCount)
+	// 处理订票请求的方法，测试时可替换
+	bookingProcess = submitOrder
+	// 入队前校验订票请求的方法，测试时可替换
+	bookingValid = validEnqueueOrder
+)
+
+// BookingResult 排队结果
+type BookingResult struct {
+	Token         string `json:"token"`         // 排队凭证
+	Status        uint8  `json:"status"`        // 状态 0.排队中 1.订票成功 2.订票失败
+	Position      int64  `json:"position"`      // 前面还有多少人
+	EstimatedWait int64  `json:"estimatedWait"` // 预计等待时间 单位：秒
+	OrderID       uint64 `json:"orderID"`       // 订票成功时的订单ID
+	Msg           string `json:"msg"`           // 订票失败的原因
+}
+
+type bookingRequest struct {
+	token string
+	par   SubmitOrderModel
+	queue *bookingQueue
+	seq   int64         // 在队列中的序号
+	done  chan struct{} // 处理完成后关闭
+	sync.Mutex
+	result BookingResult
+}
+
+// bookingQueue 某车次某日期的订票队列，按提交顺序逐个处理
+type bookingQueue struct {
+	key      string
+	requests chan *bookingRequest
+	enqueued int64 // 已入队数量
+	finished int64 // 已处理数量
+	avgCost  int64 // 单个请求的平均耗时 单位：纳秒
+}
+
+func (q *bookingQueue) getAvgCost() time.Duration {
+	if cost := atomic.LoadInt64(&q.avgCost); cost != 0 {
+		return time.Duration(cost)
+	}
+	return constBookingDefaultCostMs * time.Millisecond
+}
+
+// recordCost 以指数加权平均的方式更新平均耗时
+func (q *bookingQueue) recordCost(cost time.Duration) {
+	old := atomic.LoadInt64(&q.avgCost)
+	if old == 0 {
+		atomic.StoreInt64(&q.avgCost, int64(cost))
+		return
+	}
+	atomic.StoreInt64(&q.avgCost, old-old/8+int64(cost)/8)
+}
+
+// run 逐个处理队列中的订票请求，空闲超时后回收队列
+func (q *bookingQueue) run() {
+	for {
+		select {
+		case req := <-q.requests:
+			q.process(req)
+		case <-time.After(constBookingQueueIdle):
+			bookingQueuesLock.Lock()
+			if len(q.requests) == 0 {
+				delete(bookingQueues, q.key)
+				bookingQueuesLock.Unlock()
+				return
+			}
+			bookingQueuesLock.Unlock()
+		}
+	}
+}
+
+// process 处理单个订票请求，订票过程中的panic转为订票失败，不影响队列中的其它请求
+func (q *bookingQueue) process(req *bookingRequest) {
+	bookingWorkerPool.Take()
+	start := time.Now()
+	var order *Order
+	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			log.Println("booking panic:", req.token, r)
+			order, err = nil, errors.New("订票失败，请稍后重试")
+		}
+		bookingWorkerPool.Return()
+		q.recordCost(time.Since(start))
+		atomic.AddInt64(&q.finished, 1)
+		req.finish(order, err)
+	}()
+	order, err = bookingProcess(req.par)
+}
+
+func (r *bookingRequest) finish(order *Order, err error) {
+	r.Lock()
+	if err != nil {
+		r.result.Status = constBookingFail
+		r.result.Msg = err.Error()
+	} else {
+		r.result.Status = constBookingSuccess
+		r.result.OrderID = order.ID
+	}
+	r.result.Position = 0
+	r.result.EstimatedWait = 0
+	r.Unlock()
+	close(r.done)
+	time.AfterFunc(constBookingResultKeep, func() {
+		bookingRequests.Delete(r.token)
+	})
+}
+
+// getResult 获取当前的排队结果，排队中时实时计算前面的人数及预计等待时间
+func (r *bookingRequest) getResult() BookingResult {
+	r.Lock()
+	defer r.Unlock()
+	result := r.result
+	if result.Status == constBookingQueueing {
+		result.Position = r.seq - atomic.LoadInt64(&r.queue.finished)
+		if result.Position < 0 {
+			result.Position = 0
+		}
+		result.EstimatedWait = int64((time.Duration(result.Position+1) * r.queue.getAvgCost()).Seconds())
+	}
+	return result
+}
+
+// validEnqueueOrder 入队前校验车次、乘车区间及排班，无效的请求不进入队列
+func validEnqueueOrder(par SubmitOrderModel) error {
+	dt, err := time.Parse(ConstYmdFormat, par.Date)
+	if err != nil {
+		return errors.New("日期无效")
+	}
+	tran, exist := getTranInfo(par.TranNum, dt)
+	if !exist {
+		return errors.New("车次信息不存在")
+	}
+	return validBookingRange(tran, scheduleCache.getScheduleTran(par.TranNum, par.Date), par.DepIdx, par.ArrIdx)
+}
+
+// newBookingToken 生成随机的排队凭证，凭证即查询排队结果及订单ID的依据，不能被他人猜到
+func newBookingToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// EnqueueOrder 提交订票请求到所选车次、日期的队列，返回排队凭证；请求无效或队列已满时直接拒绝
+// 队列按单个车次、日期排队，只用于普通订单；联程、往返订单涉及多个车次，按排班排序加锁后直接订票，
+// 与排队的订单竞争余票时不保证先到先得，由下单接口的限流约束提交频率
+func EnqueueOrder(par SubmitOrderModel) (*BookingResult, error) {
+	if err := bookingValid(par); err != nil {
+		return nil, err
+	}
+	key := par.TranNum + "_" + par.Date
+	req := &bookingRequest{
+		token: newBookingToken(),
+		par:   par,
+		done:  make(chan struct{}),
+	}
+	req.result.Token = req.token
+	req.result.Status = constBookingQueueing
+	bookingQueuesLock.Lock()
+	q, exist := bookingQueues[key]
+	if !exist {
+		q = &bookingQueue{key: key, requests: make(chan *bookingRequest, constBookingQueueCap)}
+		bookingQueues[key] = q
+		go q.run()
+	}
+	req.queue = q
+	req.seq = atomic.LoadInt64(&q.enqueued)
+	select {
+	case q.requests <- req:
+		atomic.AddInt64(&q.enqueued, 1)
+	default:
+		bookingQueuesLock.Unlock()
+		return nil, errors.New("排队人数过多，请稍后再试")
+	}
+	bookingRequests.Store(req.token, req)
+	bookingQueuesLock.Unlock()
+	result := req.getResult()
+	return &result, nil
+}
+
+// QueryBookingResult 查询排队结果
+func QueryBookingResult(token string) (*BookingResult, error) {
+	val, ok := bookingRequests.Load(token)
+	if !ok {
+		return nil, errors.New("排队凭证无效或已过期")
+	}
+	result := val.(*bookingRequest).getResult()
+	return &result, nil
+}
+
+// WaitBookingResult 等待排队结果，订票完成或超时后返回当前结果
+func WaitBookingResult(token string, timeout time.Duration) (*BookingResult, error) {
+	val, ok := bookingRequests.Load(token)
+	if !ok {
+		return nil, errors.New("排队凭证无效或已过期")
+	}
+	req := val.(*bookingRequest)
+	select {
+	case <-req.done:
+	case <-time.After(timeout):
+	}
+	result := req.getResult()
+	return &result, nil
+}
@@ -0,0 +1,146 @@
+package modules
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 以固定耗时模拟订票，记录处理顺序
+type fakeBooking struct {
+	cost  time.Duration
+	order []uint64
+	sync.Mutex
+}
+
+func (f *fakeBooking) process(par SubmitOrderModel) (*Order, error) {
+	time.Sleep(f.cost)
+	f.Lock()
+	f.order = append(f.order, par.UserID)
+	f.Unlock()
+	if par.SeatType == "" {
+		return nil, errors.New("所选席别无效")
+	}
+	return &Order{ID: par.UserID}, nil
+}
+
+// fakeBookingProcess 替换订票方法并跳过入队校验，返回恢复原方法的函数
+func fakeBookingProcess(process func(SubmitOrderModel) (*Order, error)) func() {
+	oldProcess, oldValid := bookingProcess, bookingValid
+	bookingProcess = process
+	bookingValid = func(par SubmitOrderModel) error { return nil }
+	return func() { bookingProcess, bookingValid = oldProcess, oldValid }
+}
+
+func TestBookingQueueFairness(t *testing.T) {
+	f := &fakeBooking{cost: time.Millisecond}
+	defer fakeBookingProcess(f.process)()
+
+	count := 50
+	tokens := make([]string, count)
+	for i := 0; i < count; i++ {
+		result, err := EnqueueOrder(SubmitOrderModel{UserID: uint64(i), TranNum: "G1", Date: "2018-01-01", SeatType: constSeatTypeSecondClass})
+		if err != nil {
+			t.Fatal("EnqueueOrder fail")
+		}
+		if i == count-1 && result.Position == 0 {
+			t.Error("position fail")
+		}
+		tokens[i] = result.Token
+	}
+	for _, token := range tokens {
+		if result, err := WaitBookingResult(token, time.Second); err != nil || result.Status != constBookingSuccess {
+			t.Fatal("WaitBookingResult fail")
+		}
+	}
+	for i := 0; i < count; i++ {
+		if f.order[i] != uint64(i) {
+			t.Fatal("fairness fail")
+		}
+	}
+	t.Log("fairness pass")
+
+	result, _ := EnqueueOrder(SubmitOrderModel{UserID: 99, TranNum: "G1", Date: "2018-01-01"})
+	if result, err := WaitBookingResult(result.Token, time.Second); err == nil && result.Status == constBookingFail && result.Msg != "" {
+		t.Log("fail result pass")
+	} else {
+		t.Error("fail result fail")
+	}
+	if _, err := QueryBookingResult("invalid"); err != nil {
+		t.Log("invalid token pass")
+	} else {
+		t.Error("invalid token fail")
+	}
+}
+
+func TestBookingQueueFull(t *testing.T) {
+	block := make(chan struct{})
+	defer fakeBookingProcess(func(par SubmitOrderModel) (*Order, error) {
+		<-block
+		return &Order{}, nil
+	})()
+	defer close(block)
+	var err error
+	// 第一个请求被取出处理后阻塞，之后的请求填满队列
+	for i := 0; i <= constBookingQueueCap+1 && err == nil; i++ {
+		_, err = EnqueueOrder(SubmitOrderModel{TranNum: "G2", Date: "2018-01-01"})
+	}
+	if err != nil {
+		t.Log("back-pressure pass")
+	} else {
+		t.Error("back-pressure fail")
+	}
+}
+
+func TestBookingQueuePanic(t *testing.T) {
+	defer fakeBookingProcess(func(par SubmitOrderModel) (*Order, error) {
+		if par.UserID == 1 {
+			// 模拟排班不存在时访问车厢越界
+			var cars []*ScheduleCar
+			cars[par.DepIdx].CarNum = 1
+		}
+		return &Order{ID: par.UserID}, nil
+	})()
+	first, _ := EnqueueOrder(SubmitOrderModel{UserID: 1, TranNum: "G3", Date: "2018-01-01"})
+	second, _ := EnqueueOrder(SubmitOrderModel{UserID: 2, TranNum: "G3", Date: "2018-01-01"})
+	r1, err1 := WaitBookingResult(first.Token, time.Second)
+	r2, err2 := WaitBookingResult(second.Token, time.Second)
+	if err1 == nil && err2 == nil && r1.Status == constBookingFail && r2.Status == constBookingSuccess && r2.OrderID == 2 {
+		t.Log("recover panic pass")
+	} else {
+		t.Error("recover panic fail")
+	}
+}
+
+// BenchmarkBookingQueue 压测多车次并发排队的吞吐量，每个请求模拟1ms的订票耗时
+func BenchmarkBookingQueue(b *testing.B) {
+	f := &fakeBooking{cost: time.Millisecond}
+	defer fakeBookingProcess(f.process)()
+	trans := []string{"G1", "G2", "G3", "G4", "G5", "G6", "G7", "G8"}
+	tokens := make([]string, 0, b.N)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		par := SubmitOrderModel{UserID: uint64(i), TranNum: trans[i%len(trans)], Date: "2018-01-02", SeatType: constSeatTypeSecondClass}
+		result, err := EnqueueOrder(par)
+		for err != nil {
+			time.Sleep(time.Millisecond)
+			result, err = EnqueueOrder(par)
+		}
+		tokens = append(tokens, result.Token)
+	}
+	for _, token := range tokens {
+		WaitBookingResult(token, time.Minute)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "orders/s")
+}
+
+func TestBookingToken(t *testing.T) {
+	t1, t2 := newBookingToken(), newBookingToken()
+	if len(t1) == 32 && t1 != t2 {
+		t.Log("booking token pass")
+	} else {
+		t.Error("booking token fail")
+	}
+}
@@ -0,0 +1,594 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// 批量导入导出的数据类型
+	constBulkKindTran    = "trans"
+	constBulkKindCar     = "cars"
+	constBulkKindStation = "stations"
+	// 批量导入导出的文件格式
+	constBulkFormatCSV  = "csv"
+	constBulkFormatXLSX = "xlsx"
+
+	constBulkExcelEpochDays = 25569 // Excel日期序列号1970-01-01对应的值
+)
+
+var (
+	// 所有席别，用于校验导入的车厢及票价列
+	bulkSeatTypes = []string{constSeatTypeSpecial, constSeatTypeFristClass, constSeatTypeSecondClass, constSeatTypeAdvancedSoftSleeper,
+		constSeatTypeSoftSleeper, constSeatTypeEMUSleeper, constSeatTypeMoveSleeper, constSeatTypeHardSleeper,
+		constSeatTypeSoftSeat, constSeatTypeHardSeat, constSeatTypeNoSeat}
+	bulkStationColumns = []string{"id", "stationName", "stationCode", "stationPinyin", "cityCode", "cityName", "isPassenger", "delete"}
+	// 车厢每个座位一行，车厢信息只在第一行填写
+	bulkCarColumns = []string{"id", "tranType", "seatType", "noSeatCount", "remark", "seatNum", "isStudent", "delete"}
+)
+
+// BulkRowError 导入文件中某行的错误或警告
+type BulkRowError struct {
+	Row    int    `json:"row"`    // 行号，表头为第1行
+	Column string `json:"column"` // 列名，整行的问题为空
+	Msg    string `json:"msg"`
+}
+
+// BulkPreview 批量导入的预览，有错误时不提交，提交时所有数据在同一个事务中保存
+type BulkPreview struct {
+	Kind      string         `json:"kind"`
+	Committed bool           `json:"committed"` // 是否已提交
+	Creates   []string       `json:"creates"`   // 新增的数据
+	Updates   []string       `json:"updates"`   // 修改的数据
+	Deletes   []string       `json:"deletes"`   // 删除的数据
+	Unchanged int            `json:"unchanged"` // 未修改的数据数量
+	Errors    []BulkRowError `json:"errors"`
+	Warnings  []BulkRowError `json:"warnings"`
+}
+
+// bulkPlan 校验后待提交的修改
+type bulkPlan struct {
+	preview *BulkPreview
+	applies []func(tx *gorm.DB) error
+	// 提交后需重新加载的车次及车厢
+	tranNums []string
+	cars     []*Car
+}
+
+func (p *bulkPlan) errorf(row int, column, format string, a ...interface{}) {
+	p.preview.Errors = append(p.preview.Errors, BulkRowError{Row: row, Column: column, Msg: fmt.Sprintf(format, a...)})
+}
+
+func (p *bulkPlan) warnf(row int, column, format string, a ...interface{}) {
+	p.preview.Warnings = append(p.preview.Warnings, BulkRowError{Row: row, Column: column, Msg: fmt.Sprintf(format, a...)})
+}
+
+// bulkTable 导入文件的内容，按表头的列名取值
+type bulkTable struct {
+	columns map[string]int
+	header  []string
+	rows    [][]string
+}
+
+func newBulkTable(rows [][]string) (*bulkTable, error) {
+	if len(rows) == 0 {
+		return nil, errors.New("文件为空")
+	}
+	t := &bulkTable{columns: make(map[string]int), header: rows[0], rows: rows[1:]}
+	for i, name := range t.header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		t.header[i] = name
+		if name != "" {
+			t.columns[name] = i
+		}
+	}
+	return t, nil
+}
+
+// bulkRow 导入文件中的一行，取值出错时记录到所属的导入计划
+type bulkRow struct {
+	table *bulkTable
+	plan  *bulkPlan
+	idx   int
+}
+
+// num 行号，表头为第1行
+func (r *bulkRow) num() int {
+	return r.idx + 2
+}
+
+func (r *bulkRow) str(column string) string {
+	i, ok := r.table.columns[column]
+	if !ok || i >= len(r.table.rows[r.idx]) {
+		return ""
+	}
+	return strings.TrimSpace(r.table.rows[r.idx][i])
+}
+
+func (r *bulkRow) isEmpty() bool {
+	for _, v := range r.table.rows[r.idx] {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *bulkRow) integer(column string, min, max int) int {
+	v := r.str(column)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || n > max {
+		r.plan.errorf(r.num(), column, "%s无效", v)
+		return 0
+	}
+	return n
+}
+
+// flag 是或否，未填写时为def
+func (r *bulkRow) flag(column string, def bool) bool {
+	switch strings.ToLower(r.str(column)) {
+	case "":
+		return def
+	case "0", "false", "否":
+		return false
+	case "1", "true", "是":
+		return true
+	}
+	r.plan.errorf(r.num(), column, "%s无效，应为是或否", r.str(column))
+	return false
+}
+
+func (r *bulkRow) decimal(column string) float64 {
+	v := r.str(column)
+	if v == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		r.plan.errorf(r.num(), column, "%s无效", v)
+		return 0
+	}
+	return f
+}
+
+// price 以元为单位的价格，转换为分
+func (r *bulkRow) price(column string) int {
+	v := r.str(column)
+	if v == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 {
+		r.plan.errorf(r.num(), column, "价格%s无效", v)
+		return 0
+	}
+	return int(math.Round(f * 100))
+}
+
+// date 日期，支持 2006-01-02、2006/1/2 及Excel日期序列号
+func (r *bulkRow) date(column string) time.Time {
+	v := r.str(column)
+	for _, layout := range []string{ConstYmdFormat, "2006/1/2", "2006-1-2"} {
+		if d, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return d
+		}
+	}
+	if n, err := strconv.ParseFloat(v, 64); err == nil && n > 0 {
+		d := time.Unix(int64(math.Round((n-constBulkExcelEpochDays)*86400)), 0).UTC()
+		return time.Date(d.Year(), d.Month(), d.Day(), 0, 0, 0, 0, time.Local)
+	}
+	r.plan.errorf(r.num(), column, "日期%s无效", v)
+	return time.Time{}
+}
+
+// clock 时刻，支持 15:04、15:04:05、跨天的 +1 06:30 及Excel时间小数，结果为0001-01-01起的本地时间
+func (r *bulkRow) clock(column string) time.Time {
+	v, days := r.str(column), 0
+	if strings.HasPrefix(v, "+") {
+		if i := strings.Index(v, " "); i > 0 {
+			n, err := strconv.Atoi(v[1:i])
+			if err != nil || n < 0 {
+				r.plan.errorf(r.num(), column, "时刻%s无效", v)
+				return time.Time{}
+			}
+			days, v = n, strings.TrimSpace(v[i+1:])
+		}
+	}
+	for _, layout := range []string{ConstHmFormat, ConstHmsFormat, "15:4"} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return time.Date(1, 1, 1+days, t.Hour(), t.Minute(), t.Second(), 0, time.Local)
+		}
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f < 1 {
+		seconds := int(math.Round(f * 86400))
+		return time.Date(1, 1, 1+days, 0, 0, seconds, 0, time.Local)
+	}
+	r.plan.errorf(r.num(), column, "时刻%s无效", v)
+	return time.Time{}
+}
+
+func formatBulkBool(b bool) string {
+	if b {
+		return "是"
+	}
+	return "否"
+}
+
+func formatBulkPrice(p int) string {
+	return strconv.FormatFloat(float64(p)/100, 'f', 2, 64)
+}
+
+// readBulkRows 读取csv或xlsx文件的所有行
+func readBulkRows(format string, r io.ReaderAt, size int64) ([][]string, error) {
+	switch format {
+	case constBulkFormatCSV:
+		cr := csv.NewReader(io.NewSectionReader(r, 0, size))
+		cr.FieldsPerRecord = -1
+		rows, err := cr.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("csv格式无效：%v", err)
+		}
+		return rows, nil
+	case constBulkFormatXLSX:
+		return readXLSX(r, size)
+	}
+	return nil, errors.New("文件格式无效，只支持csv及xlsx")
+}
+
+// writeBulkRows 写入csv或xlsx文件，csv带BOM以便Excel正确识别中文
+func writeBulkRows(format string, w io.Writer, sheetName string, rows [][]string) error {
+	switch format {
+	case constBulkFormatCSV:
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			return err
+		}
+		cw := csv.NewWriter(w)
+		cw.WriteAll(rows)
+		return cw.Error()
+	case constBulkFormatXLSX:
+		return writeXLSX(w, sheetName, rows)
+	}
+	return errors.New("文件格式无效，只支持csv及xlsx")
+}
+
+// ExportBulk 导出车次、车厢或车站
+func ExportBulk(kind, format string, w io.Writer) error {
+	var rows [][]string
+	switch kind {
+	case constBulkKindTran:
+		rows = exportBulkTrans()
+	case constBulkKindCar:
+		rows = exportBulkCars()
+	case constBulkKindStation:
+		rows = exportBulkStations()
+	default:
+		return errors.New("数据类型无效")
+	}
+	var buf bytes.Buffer
+	if err := writeBulkRows(format, &buf, kind, rows); err != nil {
+		return err
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// ImportBulk 导入车次、车厢或车站，commit为false或有错误时只返回预览，否则在一个事务中全部提交
+func ImportBulk(kind, format string, r io.ReaderAt, size int64, commit bool) (*BulkPreview, error) {
+	rows, err := readBulkRows(format, r, size)
+	if err != nil {
+		return nil, err
+	}
+	table, err := newBulkTable(rows)
+	if err != nil {
+		return nil, err
+	}
+	plan := &bulkPlan{preview: &BulkPreview{Kind: kind}}
+	switch kind {
+	case constBulkKindTran:
+		plan.planTrans(table)
+	case constBulkKindCar:
+		plan.planCars(table)
+	case constBulkKindStation:
+		plan.planStations(table)
+	default:
+		return nil, errors.New("数据类型无效")
+	}
+	if !commit || len(plan.preview.Errors) != 0 {
+		return plan.preview, nil
+	}
+	tx := db.Begin()
+	for _, apply := range plan.applies {
+		if err = apply(tx); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	if err = tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	plan.preview.Committed = true
+	switch kind {
+	case constBulkKindTran:
+		reloadTrans(plan.tranNums...)
+	case constBulkKindCar:
+		carIDs := make([]int, len(plan.cars))
+		for i, c := range plan.cars {
+			carIDs[i] = c.ID
+		}
+		reloadCars(carIDs...)
+	case constBulkKindStation:
+		refreshStations()
+		syncRouteStations(nil)
+	}
+	return plan.preview, nil
+}
+
+func exportBulkStations() [][]string {
+	var list []Station
+	db.Order("city_code, station_name").Find(&list)
+	rows := [][]string{bulkStationColumns}
+	for _, s := range list {
+		rows = append(rows, []string{strconv.Itoa(int(s.ID)), s.StationName, s.StationCode, s.StationPinyin,
+			s.CityCode, s.CityName, formatBulkBool(s.IsPassenger), ""})
+	}
+	return rows
+}
+
+// planStations 校验导入的车站：站名及车站编码不能重复，删除或停用的车站不能被生效中的时刻表使用
+func (p *bulkPlan) planStations(table *bulkTable) {
+	var existing []Station
+	db.Find(&existing)
+	byID := make(map[uint]*Station, len(existing))
+	names, codes := make(map[string]uint), make(map[string]uint)
+	for i := range existing {
+		byID[existing[i].ID] = &existing[i]
+		names[existing[i].StationName], codes[existing[i].StationCode] = existing[i].ID, existing[i].ID
+	}
+	fileNames, fileCodes := make(map[string]int), make(map[string]int)
+	for i := range table.rows {
+		row := &bulkRow{table: table, plan: p, idx: i}
+		if row.isEmpty() {
+			continue
+		}
+		errCount := len(p.preview.Errors)
+		s := Station{ID: uint(row.integer("id", 0, math.MaxInt32)), StationName: row.str("stationName"), StationCode: row.str("stationCode"),
+			StationPinyin: row.str("stationPinyin"), CityCode: row.str("cityCode"), CityName: row.str("cityName"), IsPassenger: row.flag("isPassenger", true)}
+		old := byID[s.ID]
+		if s.ID != 0 && old == nil {
+			p.errorf(row.num(), "id", "车站%d不存在", s.ID)
+			continue
+		}
+		key := s.StationName + "(" + s.StationCode + ")"
+		if row.flag("delete", false) {
+			if old == nil {
+				p.errorf(row.num(), "id", "删除车站时需指定车站ID")
+			} else if n := countActiveRoutes(old.StationCode); n != 0 {
+				p.errorf(row.num(), "delete", "车站%s仍被%d个生效中的时刻表使用，无法删除", old.StationName, n)
+			} else if len(p.preview.Errors) == errCount {
+				p.preview.Deletes = append(p.preview.Deletes, old.StationName+"("+old.StationCode+")")
+				p.applies = append(p.applies, func(tx *gorm.DB) error {
+					if err := tx.Delete(StationAlias{}, "station_id = ?", old.ID).Error; err != nil {
+						return err
+					}
+					return tx.Delete(Station{}, "id = ?", old.ID).Error
+				})
+			}
+			continue
+		}
+		if s.StationName == "" {
+			p.errorf(row.num(), "stationName", "车站名不能为空")
+		} else if j, ok := fileNames[s.StationName]; ok {
+			p.errorf(row.num(), "stationName", "车站名与第%d行重复", j)
+		} else if id, ok := names[s.StationName]; ok && id != s.ID {
+			p.errorf(row.num(), "stationName", "车站名与车站%d重复", id)
+		}
+		if s.StationCode == "" {
+			p.errorf(row.num(), "stationCode", "车站编码不能为空")
+		} else if j, ok := fileCodes[s.StationCode]; ok {
+			p.errorf(row.num(), "stationCode", "车站编码与第%d行重复", j)
+		} else if id, ok := codes[s.StationCode]; ok && id != s.ID {
+			p.errorf(row.num(), "stationCode", "车站编码与车站%d重复", id)
+		}
+		fileNames[s.StationName], fileCodes[s.StationCode] = row.num(), row.num()
+		if s.CityCode == "" {
+			p.errorf(row.num(), "cityCode", "城市编码不能为空")
+		}
+		if old != nil && old.StationCode != s.StationCode && countActiveRoutes(old.StationCode) != 0 {
+			p.errorf(row.num(), "stationCode", "车站%s仍被生效中的时刻表使用，不能修改车站编码", old.StationName)
+		}
+		if old != nil && old.IsPassenger && !s.IsPassenger && countActiveRoutes(old.StationCode) != 0 {
+			p.errorf(row.num(), "isPassenger", "车站%s仍被生效中的时刻表使用，不能停用", old.StationName)
+		}
+		if len(p.preview.Errors) != errCount {
+			continue
+		}
+		switch {
+		case old == nil:
+			p.preview.Creates = append(p.preview.Creates, key)
+		case *old == s:
+			p.preview.Unchanged++
+			continue
+		default:
+			p.preview.Updates = append(p.preview.Updates, key)
+		}
+		p.applies = append(p.applies, func(tx *gorm.DB) error {
+			if ok, msg := s.save(tx); !ok {
+				return errors.New(msg)
+			}
+			return nil
+		})
+	}
+}
+
+func exportBulkCars() [][]string {
+	var cars []Car
+	db.Order("id").Find(&cars)
+	rows := [][]string{bulkCarColumns}
+	for _, c := range cars {
+		var seats []Seat
+		db.Where("car_id = ?", c.ID).Order("seat_num").Find(&seats)
+		head := []string{strconv.Itoa(c.ID), c.TranType, c.SeatType, strconv.Itoa(int(c.NoSeatCount)), c.Remark}
+		if len(seats) == 0 {
+			rows = append(rows, append(head, "", "", ""))
+		}
+		for i, s := range seats {
+			if i != 0 {
+				head = []string{"", "", "", "", ""}
+			}
+			rows = append(rows, append(head, s.SeatNum, formatBulkBool(s.IsStudent), ""))
+		}
+	}
+	return rows
+}
+
+// bulkCar 导入的车厢及其所在的行
+type bulkCar struct {
+	car    Car
+	row    int
+	delete bool
+}
+
+// planCars 校验导入的车厢：车厢信息所在行开始一个车厢，之后只填写座位的行都属于该车厢；删除的车厢不能被车次编组使用
+func (p *bulkPlan) planCars(table *bulkTable) {
+	var cars []*bulkCar
+	var current *bulkCar
+	seatNums := make(map[string]int)
+	for i := range table.rows {
+		row := &bulkRow{table: table, plan: p, idx: i}
+		if row.isEmpty() {
+			continue
+		}
+		if row.str("id") != "" || row.str("tranType") != "" || row.str("seatType") != "" {
+			current = &bulkCar{row: row.num(), delete: row.flag("delete", false), car: Car{ID: row.integer("id", 0, math.MaxInt32),
+				TranType: row.str("tranType"), SeatType: row.str("seatType"), NoSeatCount: uint8(row.integer("noSeatCount", 0, math.MaxUint8)),
+				Remark: row.str("remark")}}
+			cars = append(cars, current)
+			seatNums = make(map[string]int)
+		} else if current == nil {
+			p.errorf(row.num(), "", "座位行之前缺少车厢信息")
+			continue
+		}
+		if seatNum := row.str("seatNum"); seatNum != "" {
+			if j, ok := seatNums[seatNum]; ok {
+				p.errorf(row.num(), "seatNum", "座位号与第%d行重复", j)
+			}
+			seatNums[seatNum] = row.num()
+			current.car.Seats = append(current.car.Seats, Seat{SeatNum: seatNum, IsStudent: row.flag("isStudent", false)})
+		}
+	}
+
+	usedCarIds := make(map[int]bool)
+	var carIds []string
+	db.Table("tran_infos").Pluck("car_ids", &carIds)
+	for _, ids := range carIds {
+		for _, setting := range strings.Split(ids, ";") {
+			if id, err := strconv.Atoi(strings.Split(setting, ":")[0]); err == nil {
+				usedCarIds[id] = true
+			}
+		}
+	}
+	for _, c := range cars {
+		key := fmt.Sprintf("车厢%d %s %s", c.car.ID, c.car.SeatType, c.car.Remark)
+		if c.car.ID == 0 {
+			key = fmt.Sprintf("新车厢 %s %s", c.car.SeatType, c.car.Remark)
+		}
+		var old *Car
+		if c.car.ID != 0 {
+			if existing := GetCarDetail(c.car.ID); existing.ID != 0 {
+				old = &existing
+			} else {
+				p.errorf(c.row, "id", "车厢%d不存在", c.car.ID)
+				continue
+			}
+		}
+		if c.delete {
+			if old == nil {
+				p.errorf(c.row, "id", "删除车厢时需指定车厢ID")
+			} else if usedCarIds[old.ID] {
+				p.errorf(c.row, "delete", "车厢%d仍被车次编组使用，无法删除", old.ID)
+			} else {
+				id := old.ID
+				p.preview.Deletes = append(p.preview.Deletes, key)
+				p.cars = append(p.cars, old)
+				p.applies = append(p.applies, func(tx *gorm.DB) error {
+					if err := tx.Delete(Seat{}, "car_id = ?", id).Error; err != nil {
+						return err
+					}
+					return tx.Delete(Car{}, "id = ?", id).Error
+				})
+			}
+			continue
+		}
+		errCount := len(p.preview.Errors)
+		if c.car.TranType == "" {
+			p.errorf(c.row, "tranType", "车次类型不能为空")
+		}
+		if !isBulkSeatType(c.car.SeatType) {
+			p.errorf(c.row, "seatType", "席别%s无效", c.car.SeatType)
+		}
+		if len(c.car.Seats) > math.MaxUint8 {
+			p.errorf(c.row, "seatNum", "座位数不能超过%d个", math.MaxUint8)
+		}
+		if len(c.car.Seats) == 0 && c.car.SeatType != constSeatTypeNoSeat {
+			p.warnf(c.row, "seatNum", "车厢没有座位")
+		}
+		if len(p.preview.Errors) != errCount {
+			continue
+		}
+		switch {
+		case old == nil:
+			p.preview.Creates = append(p.preview.Creates, key)
+		case isSameCar(old, &c.car):
+			p.preview.Unchanged++
+			continue
+		default:
+			p.preview.Updates = append(p.preview.Updates, key)
+		}
+		car := c.car
+		p.cars = append(p.cars, &car)
+		p.applies = append(p.applies, func(tx *gorm.DB) error {
+			if ok, msg := car.save(tx); !ok {
+				return errors.New(msg)
+			}
+			return nil
+		})
+	}
+}
+
+func isSameCar(a, b *Car) bool {
+	if a.TranType != b.TranType || a.SeatType != b.SeatType || a.NoSeatCount != b.NoSeatCount ||
+		a.Remark != b.Remark || len(a.Seats) != len(b.Seats) {
+		return false
+	}
+	seats := make(map[string]bool, len(a.Seats))
+	for _, s := range a.Seats {
+		seats[s.SeatNum] = s.IsStudent
+	}
+	for _, s := range b.Seats {
+		if isStudent, ok := seats[s.SeatNum]; !ok || isStudent != s.IsStudent {
+			return false
+		}
+	}
+	return true
+}
+
+func isBulkSeatType(seatType string) bool {
+	for _, st := range bulkSeatTypes {
+		if st == seatType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,345 @@
+package modules
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// 车次每个车站一行，车次信息只在第一站所在行填写；之后为各席别（卧铺为 席别-铺位）的票价列，值为该站至下一站的票价（元）
+	bulkTranColumns = []string{"id", "tranNum", "enableStartDate", "enableEndDate", "scheduleDays", "weekdays", "calendarID", "carIds",
+		"isSaleTicket", "saleTicketTime", "nonSaleRemark", "delete", "stationName", "arrTime", "depTime", "checkTicketGate", "platform", "mileageNext"}
+	bulkTranStationColumn = 12 // 车站信息的第一列
+)
+
+func exportBulkTrans() [][]string {
+	var trans []TranInfo
+	db.Order("tran_num, enable_start_date").Find(&trans)
+	columns := make(map[string]bool)
+	for i := range trans {
+		trans[i].getFullInfo()
+		for seatType := range trans[i].SeatPriceMap {
+			columns[seatType] = true
+		}
+		for seatType, berths := range trans[i].BerthPriceMap {
+			for berth := range berths {
+				columns[seatType+"-"+berth] = true
+			}
+		}
+	}
+	priceColumns := make([]string, 0, len(columns))
+	for c := range columns {
+		priceColumns = append(priceColumns, c)
+	}
+	sort.Strings(priceColumns)
+
+	rows := [][]string{append(append([]string(nil), bulkTranColumns...), priceColumns...)}
+	for _, t := range trans {
+		last := len(t.Timetable) - 1
+		for i, r := range t.Timetable {
+			row := make([]string, len(rows[0]))
+			if i == 0 {
+				copy(row, []string{strconv.Itoa(t.ID), t.TranNum, t.EnableStartDate.Format(ConstYmdFormat), t.EnableEndDate.Format(ConstYmdFormat),
+					strconv.Itoa(t.ScheduleDays), formatBulkWeekdays(t.Weekdays), strconv.FormatUint(t.CalendarID, 10), t.CarIds,
+					formatBulkBool(t.IsSaleTicket), t.SaleTicketTime.In(time.Local).Format(ConstHmFormat), t.NonSaleRemark, ""})
+			}
+			copy(row[bulkTranStationColumn:], []string{r.StationName, formatRouteTime(r.ArrTime, i > 0), formatRouteTime(r.DepTime, i < last),
+				r.CheckTicketGate, formatPlatform(r.Platform), strconv.FormatFloat(float64(r.MileageNext), 'f', -1, 32)})
+			for j, c := range priceColumns {
+				if prices := t.getBulkPrices(c); i < last && i < len(prices) {
+					row[len(bulkTranColumns)+j] = formatBulkPrice(prices[i])
+				}
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// getBulkPrices 票价列对应的各路段价格，列名为 席别 或 席别-铺位
+func (t *TranInfo) getBulkPrices(column string) []int {
+	if i := strings.Index(column, "-"); i > 0 {
+		return t.BerthPriceMap[column[:i]][column[i+1:]]
+	}
+	return t.SeatPriceMap[column]
+}
+
+// formatBulkWeekdays 开行星期，按位列出星期几，0为周日，如：0246
+func formatBulkWeekdays(weekdays uint8) string {
+	s := ""
+	for d := 0; d < 7; d++ {
+		if weekdays&(1<<uint(d)) != 0 {
+			s += strconv.Itoa(d)
+		}
+	}
+	return s
+}
+
+// bulkTran 导入的车次及其各车站所在的行
+type bulkTran struct {
+	tran   TranInfo
+	rows   []int
+	delete bool
+}
+
+// planTrans 校验导入的车次：车次号所在行开始一个车次，之后只填写车站的行都属于该车次；
+// 按车次校验时刻表、车厢编组及票价，问题定位到对应的车站行；删除的车次版本不能已有排班
+func (p *bulkPlan) planTrans(table *bulkTable) {
+	priceColumns := make([]string, 0)
+	for _, name := range table.header {
+		if name == "" || isBulkTranColumn(name) {
+			continue
+		}
+		seatType, berth := name, ""
+		if i := strings.Index(name, "-"); i > 0 {
+			seatType, berth = name[:i], name[i+1:]
+		}
+		valid := isBulkSeatType(seatType) && seatType != constSeatTypeNoSeat
+		if berth != "" {
+			valid = false
+			for _, b := range seatTypeBerths[seatType] {
+				valid = valid || b == berth
+			}
+		}
+		if !valid {
+			p.errorf(1, name, "未知的列%s", name)
+			continue
+		}
+		priceColumns = append(priceColumns, name)
+	}
+
+	var trans []*bulkTran
+	var current *bulkTran
+	for i := range table.rows {
+		row := &bulkRow{table: table, plan: p, idx: i}
+		if row.isEmpty() {
+			continue
+		}
+		if row.str("tranNum") != "" {
+			current = &bulkTran{delete: row.flag("delete", false), tran: TranInfo{ID: row.integer("id", 0, math.MaxInt32),
+				TranNum: row.str("tranNum"), ScheduleDays: row.integer("scheduleDays", 0, 365), CalendarID: uint64(row.integer("calendarID", 0, math.MaxInt32)),
+				CarIds: row.str("carIds"), IsSaleTicket: row.flag("isSaleTicket", true), NonSaleRemark: row.str("nonSaleRemark"),
+				SeatPriceMap: make(map[string]([]int)), BerthPriceMap: make(map[string](map[string]([]int)))}}
+			t := &current.tran
+			if t.ScheduleDays == 0 {
+				t.ScheduleDays = 1
+			}
+			if !current.delete {
+				t.EnableStartDate, t.EnableEndDate = row.date("enableStartDate"), row.date("enableEndDate")
+				t.Weekdays = parseBulkWeekdays(row)
+				if row.str("saleTicketTime") != "" {
+					t.SaleTicketTime = row.clock("saleTicketTime")
+				}
+			}
+			trans = append(trans, current)
+		} else if current == nil {
+			p.errorf(row.num(), "", "车站行之前缺少车次信息")
+			continue
+		}
+		if current.delete {
+			continue
+		}
+		route := Route{StationName: row.str("stationName"), CheckTicketGate: row.str("checkTicketGate"),
+			Platform: uint8(row.integer("platform", 0, math.MaxUint8)), MileageNext: float32(row.decimal("mileageNext"))}
+		if row.str("arrTime") != "" {
+			route.ArrTime = row.clock("arrTime")
+		}
+		if row.str("depTime") != "" {
+			route.DepTime = row.clock("depTime")
+		}
+		idx := len(current.tran.Timetable)
+		current.tran.Timetable = append(current.tran.Timetable, route)
+		current.rows = append(current.rows, row.num())
+		for _, c := range priceColumns {
+			if row.str(c) == "" {
+				continue
+			}
+			prices := current.tran.getBulkPrices(c)
+			for len(prices) <= idx {
+				prices = append(prices, 0)
+			}
+			prices[idx] = row.price(c)
+			current.tran.setBulkPrices(c, prices)
+		}
+	}
+
+	versions := make(map[string]int)
+	for _, bt := range trans {
+		p.planTran(bt, versions)
+	}
+}
+
+// planTran 校验一个导入的车次，versions记录文件中已出现的车次版本及其所在行
+func (p *bulkPlan) planTran(bt *bulkTran, versions map[string]int) {
+	t, first := &bt.tran, 0
+	if len(bt.rows) > 0 {
+		first = bt.rows[0]
+	}
+	var old *TranInfo
+	if t.ID != 0 {
+		if existing := GetTranDetail(t.ID); existing.ID != 0 && existing.TranNum == t.TranNum {
+			old = &existing
+		} else {
+			p.errorf(first, "id", "车次%s的版本%d不存在", t.TranNum, t.ID)
+			return
+		}
+	}
+	if bt.delete {
+		if old == nil {
+			p.errorf(first, "id", "删除车次时需指定车次版本ID")
+		} else if countScheduleTrans(old.TranNum, old.EnableStartDate.Format(ConstYmdFormat), old.EnableEndDate.Format(ConstYmdFormat)) != 0 {
+			p.errorf(first, "delete", "车次%s的该版本已有排班，无法删除", old.TranNum)
+		} else {
+			id := old.ID
+			p.preview.Deletes = append(p.preview.Deletes, old.TranNum+" "+old.EnableStartDate.Format(ConstYmdFormat))
+			p.tranNums = append(p.tranNums, old.TranNum)
+			p.applies = append(p.applies, func(tx *gorm.DB) error {
+				for _, table := range []interface{}{Route{}, RoutePrice{}, SeatQuotaRule{}, OperatingDate{}} {
+					if err := tx.Delete(table, "tran_id = ?", id).Error; err != nil {
+						return err
+					}
+				}
+				return tx.Delete(TranInfo{}, "id = ?", id).Error
+			})
+		}
+		return
+	}
+
+	key := t.TranNum + " " + t.EnableStartDate.Format(ConstYmdFormat)
+	if row, ok := versions[key]; ok {
+		p.errorf(first, "enableStartDate", "与第%d行的车次版本重复", row)
+		return
+	}
+	versions[key] = first
+	// 最后一站的票价列不使用
+	routeCount := len(t.Timetable) - 1
+	for seatType, prices := range t.SeatPriceMap {
+		if len(prices) > routeCount && routeCount >= 0 {
+			t.SeatPriceMap[seatType] = prices[:routeCount]
+		}
+	}
+	for _, berths := range t.BerthPriceMap {
+		for berth, prices := range berths {
+			if len(prices) > routeCount && routeCount >= 0 {
+				berths[berth] = prices[:routeCount]
+			}
+		}
+	}
+	if old != nil {
+		t.QuotaRules, t.OperatingDates = old.QuotaRules, old.OperatingDates
+	}
+
+	errCount := len(p.preview.Errors)
+	for _, issue := range t.Validate() {
+		row, column := bulkTranIssuePosition(issue.Field, bt.rows)
+		if issue.Level == constValidLevelError {
+			p.errorf(row, column, "%s", issue.Msg)
+		} else {
+			p.warnf(row, column, "%s", issue.Msg)
+		}
+	}
+	for _, valid := range []func() (bool, string){t.validSeatQuotaRules, t.validBerthPrices, t.validCalendar} {
+		if ok, msg := valid(); !ok {
+			p.errorf(first, "", "%s", msg)
+		}
+	}
+	if len(p.preview.Errors) != errCount {
+		return
+	}
+	switch {
+	case old == nil:
+		p.preview.Creates = append(p.preview.Creates, key)
+	case isSameTran(old, t):
+		p.preview.Unchanged++
+		return
+	default:
+		p.preview.Updates = append(p.preview.Updates, key)
+	}
+	p.tranNums = append(p.tranNums, t.TranNum)
+	p.applies = append(p.applies, func(tx *gorm.DB) error {
+		if ok, msg := t.save(tx); !ok {
+			return fmt.Errorf("第%d行 车次%s：%s", first, key, msg)
+		}
+		return nil
+	})
+}
+
+func (t *TranInfo) setBulkPrices(column string, prices []int) {
+	if i := strings.Index(column, "-"); i > 0 {
+		if t.BerthPriceMap[column[:i]] == nil {
+			t.BerthPriceMap[column[:i]] = make(map[string]([]int))
+		}
+		t.BerthPriceMap[column[:i]][column[i+1:]] = prices
+		return
+	}
+	t.SeatPriceMap[column] = prices
+}
+
+func isBulkTranColumn(name string) bool {
+	for _, c := range bulkTranColumns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func parseBulkWeekdays(row *bulkRow) (weekdays uint8) {
+	for _, c := range row.str("weekdays") {
+		if c < '0' || c > '6' {
+			row.plan.errorf(row.num(), "weekdays", "开行星期%s无效，应为0至6的数字，0为周日", row.str("weekdays"))
+			return 0
+		}
+		weekdays |= 1 << uint(c-'0')
+	}
+	return
+}
+
+// bulkTranIssuePosition 校验问题所在的行及列，如：timetable[2].depTime 为第3站所在行的depTime列，不对应某一列时列名为空
+func bulkTranIssuePosition(field string, rows []int) (int, string) {
+	first := 0
+	if len(rows) > 0 {
+		first = rows[0]
+	}
+	rowOf := func(idx string) int {
+		if i, err := strconv.Atoi(idx); err == nil && i >= 0 && i < len(rows) {
+			return rows[i]
+		}
+		return first
+	}
+	if strings.HasPrefix(field, "timetable[") {
+		if end := strings.Index(field, "]"); end > 0 {
+			return rowOf(field[len("timetable["):end]), strings.TrimPrefix(field[end+1:], ".")
+		}
+	}
+	if strings.HasPrefix(field, "seatPriceMap.") {
+		rest := strings.TrimPrefix(field, "seatPriceMap.")
+		if i := strings.Index(rest, "["); i > 0 {
+			return rowOf(strings.TrimSuffix(rest[i+1:], "]")), rest[:i]
+		}
+		return first, rest
+	}
+	if !isBulkTranColumn(field) {
+		return first, ""
+	}
+	return first, field
+}
+
+// isSameTran 导入的车次与原版本是否一致
+func isSameTran(old, t *TranInfo) bool {
+	if len(diffTranInfo(old, t)) != 0 {
+		return false
+	}
+	for i := range old.Timetable {
+		if old.Timetable[i].MileageNext != t.Timetable[i].MileageNext {
+			return false
+		}
+	}
+	return true
+}
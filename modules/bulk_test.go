@@ -0,0 +1,103 @@
+package modules
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestXLSXRoundTrip(t *testing.T) {
+	rows := [][]string{{"id", "stationName", "remark"}, {"1", "北京西", "<A&B>"}, {}, {"", "", "第三列"}}
+	var buf bytes.Buffer
+	if err := writeXLSX(&buf, "stations", rows); err != nil {
+		t.Fatal("write xlsx fail", err)
+	}
+	read, err := readXLSX(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err == nil && len(read) == 4 && read[1][1] == "北京西" && read[1][2] == "<A&B>" && len(read[2]) == 0 &&
+		len(read[3]) == 3 && read[3][2] == "第三列" {
+		t.Log("xlsx round trip pass")
+	} else {
+		t.Error("xlsx round trip fail", err, read)
+	}
+	for idx, name := range map[int]string{0: "A", 25: "Z", 26: "AA", 701: "ZZ", 702: "AAA"} {
+		if xlsxColumnName(idx) == name && xlsxColumnIndex(name+"12") == idx {
+			t.Log("xlsx column " + name + " pass")
+		} else {
+			t.Error("xlsx column " + name + " fail")
+		}
+	}
+}
+
+func TestBulkRowValues(t *testing.T) {
+	table, _ := newBulkTable([][]string{
+		{"\ufeffdate", "clock", "price", "flag"},
+		{"2018-10-01", "+1 06:30", "123.45", "是"},
+		{"43374", "0.75", "-1", "x"},
+		{"2018/10/1", "8:05", "", ""},
+	})
+	p := &bulkPlan{preview: &BulkPreview{}}
+	row := func(i int) *bulkRow { return &bulkRow{table: table, plan: p, idx: i} }
+	day := time.Date(2018, 10, 1, 0, 0, 0, 0, time.Local)
+	if row(0).date("date").Equal(day) && row(1).date("date").Equal(day) && row(2).date("date").Equal(day) {
+		t.Log("bulk date pass")
+	} else {
+		t.Error("bulk date fail", row(1).date("date"))
+	}
+	if row(0).clock("clock").Equal(time.Date(1, 1, 2, 6, 30, 0, 0, time.Local)) &&
+		row(1).clock("clock").Equal(time.Date(1, 1, 1, 18, 0, 0, 0, time.Local)) &&
+		row(2).clock("clock").Equal(time.Date(1, 1, 1, 8, 5, 0, 0, time.Local)) {
+		t.Log("bulk clock pass")
+	} else {
+		t.Error("bulk clock fail")
+	}
+	if row(0).price("price") == 12345 && row(0).flag("flag", false) && !row(2).flag("flag", false) && len(p.preview.Errors) == 0 {
+		t.Log("bulk price and flag pass")
+	} else {
+		t.Error("bulk price and flag fail", p.preview.Errors)
+	}
+	row(1).price("price")
+	row(1).flag("flag", false)
+	if len(p.preview.Errors) == 2 && p.preview.Errors[0].Row == 3 && p.preview.Errors[1].Column == "flag" {
+		t.Log("bulk invalid values pass")
+	} else {
+		t.Error("bulk invalid values fail", p.preview.Errors)
+	}
+}
+
+func TestBulkTranIssuePosition(t *testing.T) {
+	rows := []int{5, 6, 8}
+	cases := []struct {
+		field  string
+		row    int
+		column string
+	}{
+		{"timetable[2].depTime", 8, "depTime"},
+		{"seatPriceMap.SC[1]", 6, "SC"},
+		{"seatPriceMap.SC", 5, "SC"},
+		{"carIds", 5, "carIds"},
+		{"timetable", 5, ""},
+	}
+	for _, c := range cases {
+		if row, column := bulkTranIssuePosition(c.field, rows); row == c.row && column == c.column {
+			t.Log("issue position " + c.field + " pass")
+		} else {
+			t.Error("issue position "+c.field+" fail", row, column)
+		}
+	}
+}
+
+func TestBulkWeekdays(t *testing.T) {
+	table, _ := newBulkTable([][]string{{"weekdays"}, {"056"}, {"7"}})
+	p := &bulkPlan{preview: &BulkPreview{}}
+	weekdays := parseBulkWeekdays(&bulkRow{table: table, plan: p, idx: 0})
+	if weekdays == 1<<time.Sunday|1<<time.Friday|1<<time.Saturday && formatBulkWeekdays(weekdays) == "056" {
+		t.Log("bulk weekdays pass")
+	} else {
+		t.Error("bulk weekdays fail", weekdays)
+	}
+	if parseBulkWeekdays(&bulkRow{table: table, plan: p, idx: 1}) == 0 && len(p.preview.Errors) == 1 {
+		t.Log("bulk invalid weekdays pass")
+	} else {
+		t.Error("bulk invalid weekdays fail")
+	}
+}
@@ -0,0 +1,219 @@
+package modules
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	// 开行日历，key为日历ID
+	operatingCalendars     = make(map[uint64]*OperatingCalendar)
+	operatingCalendarsLock sync.RWMutex
+)
+
+// OperatingCalendar 开行日历，可被多个车次共用，如“工作日开行”、“周末开行”、“节假日加开”
+type OperatingCalendar struct {
+	ID       uint64          `json:"id"`
+	Name     string          `gorm:"type:nvarchar(50)" json:"name"` // 日历名称
+	Weekdays uint8           `json:"weekdays"`                      // 开行的星期，按位表示，第0位为周日，为零时不限
+	Dates    []OperatingDate `gorm:"-" json:"dates"`                // 加开及停开的日期
+	dateMap  map[string]bool // 加开日期为true，停开日期为false
+}
+
+// OperatingDate 加开或停开的日期，属于开行日历或单独指定给某车次
+type OperatingDate struct {
+	ID         uint64 `json:"id"`
+	CalendarID uint64 `gorm:"index:main" json:"calendarID"` // 开行日历ID，单独指定给车次时为零
+	TranID     int    `gorm:"index:tran" json:"tranID"`     // 车次ID，属于开行日历时为零
+	Date       string `gorm:"type:varchar(10)" json:"date"` // 日期 yyyy-MM-dd
+	IsExclude  bool   `json:"isExclude"`                    // 是否停开，否则为加开
+}
+
+func initOperatingCalendars() {
+	var calendars []OperatingCalendar
+	db.Find(&calendars)
+	var dates []OperatingDate
+	db.Where("calendar_id != 0").Order("date").Find(&dates)
+	calendarMap := make(map[uint64]*OperatingCalendar, len(calendars))
+	for i := 0; i < len(calendars); i++ {
+		calendarMap[calendars[i].ID] = &calendars[i]
+	}
+	for _, d := range dates {
+		if c, ok := calendarMap[d.CalendarID]; ok {
+			c.Dates = append(c.Dates, d)
+		}
+	}
+	for _, c := range calendarMap {
+		c.dateMap = newDateMap(c.Dates)
+	}
+	operatingCalendarsLock.Lock()
+	operatingCalendars = calendarMap
+	operatingCalendarsLock.Unlock()
+}
+
+// getOperatingCalendar 获取开行日历，不存在时返回nil
+func getOperatingCalendar(id uint64) *OperatingCalendar {
+	if id == 0 {
+		return nil
+	}
+	operatingCalendarsLock.RLock()
+	defer operatingCalendarsLock.RUnlock()
+	return operatingCalendars[id]
+}
+
+// GetOperatingCalendars 获取所有开行日历
+func GetOperatingCalendars() []OperatingCalendar {
+	operatingCalendarsLock.RLock()
+	result := make([]OperatingCalendar, 0, len(operatingCalendars))
+	for _, c := range operatingCalendars {
+		result = append(result, *c)
+	}
+	operatingCalendarsLock.RUnlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+// Save 保存开行日历，保存后立即生效
+func (c *OperatingCalendar) Save() (bool, string) {
+	tx := db.Begin()
+	if ok, msg := c.save(tx); !ok {
+		tx.Rollback()
+		return false, msg
+	}
+	if err := tx.Commit().Error; err != nil {
+		return false, err.Error()
+	}
+	c.cache()
+	return true, ""
+}
+
+// save 在事务中校验并保存开行日历及其加开、停开日期
+func (c *OperatingCalendar) save(tx *gorm.DB) (bool, string) {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		return false, "日历名称不能为空"
+	}
+	if c.Weekdays >= 1<<7 {
+		return false, "开行星期无效"
+	}
+	if err := validOperatingDates(c.Dates); err != nil {
+		return false, err.Error()
+	}
+	if c.ID == 0 {
+		if err := tx.Create(c).Error; err != nil {
+			return false, err.Error()
+		}
+	} else {
+		if err := tx.Save(c).Error; err != nil {
+			return false, err.Error()
+		}
+		if err := tx.Delete(OperatingDate{}, "calendar_id = ?", c.ID).Error; err != nil {
+			return false, err.Error()
+		}
+	}
+	for i := range c.Dates {
+		c.Dates[i].ID, c.Dates[i].CalendarID, c.Dates[i].TranID = 0, c.ID, 0
+		if err := tx.Create(&c.Dates[i]).Error; err != nil {
+			return false, err.Error()
+		}
+	}
+	return true, ""
+}
+
+// cache 事务提交后更新内存中的开行日历
+func (c *OperatingCalendar) cache() {
+	saved := *c
+	saved.dateMap = newDateMap(saved.Dates)
+	operatingCalendarsLock.Lock()
+	operatingCalendars[saved.ID] = &saved
+	operatingCalendarsLock.Unlock()
+}
+
+// DeleteOperatingCalendar 删除开行日历，仍有车次使用时不允许删除
+func DeleteOperatingCalendar(id uint64) error {
+	count := 0
+	db.Model(&TranInfo{}).Where("calendar_id = ?", id).Count(&count)
+	if count != 0 {
+		return fmt.Errorf("有%d个车次使用该日历，无法删除", count)
+	}
+	db.Delete(OperatingDate{}, "calendar_id = ?", id)
+	db.Delete(OperatingCalendar{}, "id = ?", id)
+	operatingCalendarsLock.Lock()
+	delete(operatingCalendars, id)
+	operatingCalendarsLock.Unlock()
+	return nil
+}
+
+// validOperatingDates 校验加开及停开日期，同一日期不能重复
+func validOperatingDates(dates []OperatingDate) error {
+	exist := make(map[string]bool, len(dates))
+	for _, d := range dates {
+		if _, err := time.Parse(ConstYmdFormat, d.Date); err != nil {
+			return fmt.Errorf("日期%s无效", d.Date)
+		}
+		if exist[d.Date] {
+			return fmt.Errorf("日期%s重复", d.Date)
+		}
+		exist[d.Date] = true
+	}
+	return nil
+}
+
+// validCalendar 校验车次的开行星期、开行日历及单独指定的日期
+func (t *TranInfo) validCalendar() (bool, string) {
+	if t.Weekdays >= 1<<7 {
+		return false, "开行星期无效"
+	}
+	if t.CalendarID != 0 && getOperatingCalendar(t.CalendarID) == nil {
+		return false, "开行日历不存在"
+	}
+	if err := validOperatingDates(t.OperatingDates); err != nil {
+		return false, err.Error()
+	}
+	return true, ""
+}
+
+func newDateMap(dates []OperatingDate) map[string]bool {
+	m := make(map[string]bool, len(dates))
+	for _, d := range dates {
+		m[d.Date] = !d.IsExclude
+	}
+	return m
+}
+
+// isOperatingDay 判断车次在某发车日期是否开行，依次按以下条件判断：
+// 生效期、车次单独指定的加开停开日期、开行日历的加开停开日期、排班间隔天数、开行星期（车次未设置时取开行日历的）
+func (t *TranInfo) isOperatingDay(date time.Time) bool {
+	day, start, end := dateOnly(date), dateOnly(t.EnableStartDate), dateOnly(t.EnableEndDate)
+	if day.Before(start) || day.After(end) {
+		return false
+	}
+	strDate := day.Format(ConstYmdFormat)
+	if operating, ok := t.dateMap[strDate]; ok {
+		return operating
+	}
+	weekdays := t.Weekdays
+	if c := getOperatingCalendar(t.CalendarID); c != nil {
+		if operating, ok := c.dateMap[strDate]; ok {
+			return operating
+		}
+		if weekdays == 0 {
+			weekdays = c.Weekdays
+		}
+	}
+	if t.ScheduleDays > 1 && int(day.Sub(start).Hours()/24)%t.ScheduleDays != 0 {
+		return false
+	}
+	return weekdays == 0 || weekdays&(1<<uint(day.Weekday())) != 0
+}
+
+// dateOnly 只保留日期部分，用于按天计算间隔
+func dateOnly(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,67 @@
+package modules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsOperatingDay(t *testing.T) {
+	oldCalendars := operatingCalendars
+	defer func() { operatingCalendars = oldCalendars }()
+	// 周末开行，国庆期间每天加开，2018-10-06停开
+	weekend := &OperatingCalendar{ID: 1, Name: "周末", Weekdays: 1<<uint(time.Saturday) | 1<<uint(time.Sunday),
+		Dates: []OperatingDate{
+			OperatingDate{Date: "2018-10-01"}, OperatingDate{Date: "2018-10-02"},
+			OperatingDate{Date: "2018-10-06", IsExclude: true},
+		}}
+	weekend.dateMap = newDateMap(weekend.Dates)
+	operatingCalendars = map[uint64]*OperatingCalendar{1: weekend}
+
+	start, _ := time.Parse(ConstYmdFormat, "2018-09-01")
+	end, _ := time.Parse(ConstYmdFormat, "2018-12-31")
+	daily := &TranInfo{ScheduleDays: 1, EnableStartDate: start, EnableEndDate: end}
+	workday := &TranInfo{ScheduleDays: 1, EnableStartDate: start, EnableEndDate: end, Weekdays: 0x3e}
+	everyTwoDays := &TranInfo{ScheduleDays: 2, EnableStartDate: start, EnableEndDate: end}
+	weekendTran := &TranInfo{ScheduleDays: 1, EnableStartDate: start, EnableEndDate: end, CalendarID: 1,
+		dateMap: newDateMap([]OperatingDate{OperatingDate{Date: "2018-10-13", IsExclude: true}})}
+	cases := []struct {
+		name string
+		tran *TranInfo
+		date string
+		ok   bool
+	}{
+		{"daily", daily, "2018-10-10", true},
+		{"before enable", daily, "2018-08-31", false},
+		{"after enable", daily, "2019-01-01", false},
+		{"workday monday", workday, "2018-10-08", true},
+		{"workday saturday", workday, "2018-10-13", false},
+		{"every two days", everyTwoDays, "2018-09-03", true},
+		{"every two days off", everyTwoDays, "2018-09-04", false},
+		{"weekend saturday", weekendTran, "2018-10-20", true},
+		{"weekend wednesday", weekendTran, "2018-10-17", false},
+		{"calendar include", weekendTran, "2018-10-02", true},
+		{"calendar exclude", weekendTran, "2018-10-06", false},
+		{"tran exclude", weekendTran, "2018-10-13", false},
+	}
+	for _, c := range cases {
+		date, _ := time.ParseInLocation(ConstYmdFormat, c.date, time.Local)
+		if c.tran.isOperatingDay(date) == c.ok {
+			t.Log("isOperatingDay " + c.name + " pass")
+		} else {
+			t.Error("isOperatingDay " + c.name + " fail")
+		}
+	}
+
+	tran := &TranInfo{Weekdays: 1 << 7}
+	if ok, _ := tran.validCalendar(); !ok {
+		t.Log("validCalendar weekdays pass")
+	} else {
+		t.Error("validCalendar weekdays fail")
+	}
+	tran = &TranInfo{CalendarID: 1, OperatingDates: []OperatingDate{OperatingDate{Date: "2018-10-01"}, OperatingDate{Date: "2018-10-01", IsExclude: true}}}
+	if ok, _ := tran.validCalendar(); !ok {
+		t.Log("validCalendar duplicate pass")
+	} else {
+		t.Error("validCalendar duplicate fail")
+	}
+}
@@ -1,70 +1,235 @@
 package modules
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// 核验模式
+	constCheckModeReport = iota // 仅输出核验报告
+	constCheckModeRepair        // 以车票数据为准修复排班
+)
+
+// ticketConflict 车票冲突：同一座位的同一路段被多张车票占用
+type ticketConflict struct {
+	CarNum    uint8    `json:"carNum"`    // 车厢号
+	SeatNum   string   `json:"seatNum"`   // 座位号
+	Segments  []uint8  `json:"segments"`  // 冲突的路段索引
+	TicketIDs []uint64 `json:"ticketIDs"` // 冲突的车票ID，第一张为先占用该座位的车票
+}
+
+// seatMismatch 座位位标记不一致
+type seatMismatch struct {
+	CarNum        uint8  `json:"carNum"`        // 车厢号
+	SeatNum       string `json:"seatNum"`       // 座位号
+	CacheSeatBit  int64  `json:"cacheSeatBit"`  // 缓存中的位标记
+	TicketSeatBit int64  `json:"ticketSeatBit"` // 根据车票重建的位标记
+}
+
+// travelerCountMismatch 路段乘客人数不一致
+type travelerCountMismatch struct {
+	CarNum      uint8 `json:"carNum"`      // 车厢号
+	RouteIdx    int   `json:"routeIdx"`    // 路段索引
+	CacheCount  uint8 `json:"cacheCount"`  // 缓存中的人数
+	TicketCount uint8 `json:"ticketCount"` // 根据车票重建的人数
+}
+
+// TicketCheckReport 某趟车次的核验报告
+type TicketCheckReport struct {
+	TranNum          string                  `json:"tranNum"`          // 车次号
+	Date             string                  `json:"date"`             // 发车日期
+	Mode             int                     `json:"mode"`             // 核验模式
+	TicketCount      int                     `json:"ticketCount"`      // 参与核验的车票数
+	Conflicts        []ticketConflict        `json:"conflicts"`        // 车票冲突
+	InvalidTicketIDs []uint64                `json:"invalidTicketIDs"` // 车厢或座位在排班中不存在的车票
+	SeatMismatches   []seatMismatch          `json:"seatMismatches"`   // 座位位标记不一致
+	CountMismatches  []travelerCountMismatch `json:"countMismatches"`  // 路段乘客人数不一致
+	Repaired         bool                    `json:"repaired"`         // 是否已用重建结果替换缓存
+}
+
+// hasProblem 是否存在需要处理的问题
+func (r *TicketCheckReport) hasProblem() bool {
+	return len(r.Conflicts) != 0 || len(r.InvalidTicketIDs) != 0 ||
+		len(r.SeatMismatches) != 0 || len(r.CountMismatches) != 0
+}
+
+// CheckTicket 核验车票与排班，repair为真时以车票为准修复排班；
+// 未指定车次时核验可订票天数内的所有排班，只返回有问题的报告
+func CheckTicket(tranNum, date string, repair bool) []*TicketCheckReport {
+	mode := constCheckModeReport
+	if repair {
+		mode = constCheckModeRepair
+	}
+	if tranNum == "" {
+		return checkTicket(mode)
+	}
+	return []*TicketCheckReport{checkTranTicket(tranNum, date, mode)}
+}
 
 // checkTicket 校验订单，以便释放无效订单所占用的资源 或 暴露冲突的订单
-func checkTicket() {
-	date := time.Now()
-	for _, t := range tranInfos {
-		for i := 0; i < constDays; i++ {
-			dateStr := date.AddDate(0, 0, -i).Format(ConstYmdFormat)
-			checkTranTicket(t.TranNum, dateStr)
+// 只核验可订票天数内的排班，已发车的排班不再订票、退票，无需修复
+func checkTicket(mode int) (reports []*TicketCheckReport) {
+	y, m, d := time.Now().Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	tranInfos := getTranSnapshot().tranInfos
+	for i := range tranInfos {
+		for _, date := range tranInfos[i].getCheckDates(today) {
+			if r := checkTranTicket(tranInfos[i].TranNum, date, mode); r.hasProblem() {
+				reports = append(reports, r)
+			}
+		}
+	}
+	return
+}
+
+// getCheckDates 车次版本在可订票天数内需要核验的发车日期：售票、在生效期内且开行
+func (t *TranInfo) getCheckDates(today time.Time) (dates []string) {
+	if !t.IsSaleTicket {
+		return
+	}
+	for i := 0; i <= constDays; i++ {
+		if day := today.AddDate(0, 0, i); t.isOperatingDay(day) {
+			dates = append(dates, day.Format(ConstYmdFormat))
 		}
 	}
+	return
 }
 
 // checkTranTicket 校验具体某趟车次的订单
-func checkTranTicket(tranNum, date string) {
+// 以有效车票为准，在排班的深拷贝上重建各座位的位标记及各路段乘客人数，再与缓存对比；
+// 修复模式下，对比完成后用重建的结果替换缓存中的车厢信息
+func checkTranTicket(tranNum, date string, mode int) *TicketCheckReport {
+	report := &TicketCheckReport{TranNum: tranNum, Date: date, Mode: mode}
 	st := scheduleCache.getScheduleTran(tranNum, date)
-	copySt := *st
-	for ci := 0; ci < len(copySt.Cars); ci++ {
-		if copySt.Cars[ci].NoSeatCount != 0 {
-			for ei := 0; ei < len(copySt.Cars[ci].EachRouteTravelerCount); ei++ {
-				copySt.Cars[ci].EachRouteTravelerCount[ei] = 0
-			}
-		}
-		for si := 0; si < len(copySt.Cars[ci].Seats); si++ {
-			copySt.Cars[ci].Seats[si].SeatBit = 0
-		}
+	if st.TranNum == "" {
+		// 尚未生成排班
+		return report
 	}
-	validTicketStatus := []uint8{constTicketPaid, constTicketIssued, constTicketChangePaid, constTicketChangeIssued}
+	// 核验期间不允许订票、退票，否则重建结果会漏掉这期间的变更
+	st.repairLock.Lock()
+	defer st.repairLock.Unlock()
+	rebuilt := st.deepCopy()
+	rebuilt.resetSeats()
+
+	// 未支付的车票在超时前同样占用座位
+	validTicketStatus := []uint8{constTicketUnpay, constTicketPaid, constTicketIssued, constTicketChangeUnpay, constTicketChangePaid, constTicketChangeIssued}
 	var tickets []Ticket
-	db.Where("tran_num = ? and tran_dep_date = ? and status in (?)", tranNum, date, validTicketStatus).Find(&tickets)
+	db.Where("tran_num = ? and tran_dep_date = ? and status in (?)", tranNum, date, validTicketStatus).Order("id").Find(&tickets)
+	report.TicketCount = len(tickets)
+	// 各座位最先占用者，用于列出冲突的车票
+	seatOwners := make(map[*ScheduleSeat]([]Ticket))
 	for _, t := range tickets {
-		car, seat := getCarAndSeat(&copySt, t.CarNum, t.SeatType, t.SeatNum)
+		car, seat := getCarAndSeat(rebuilt, t.CarNum, t.SeatType, t.SeatNum)
+		if car == nil || (t.SeatType != constSeatTypeNoSeat && seat == nil) {
+			report.InvalidTicketIDs = append(report.InvalidTicketIDs, t.ID)
+			continue
+		}
+		car.countTraveler(t.DepStationIdx, t.ArrStationIdx)
+		if seat == nil {
+			continue
+		}
 		seatBit := countSeatBit(t.DepStationIdx, t.ArrStationIdx)
-
-		car.occupySeat(t.DepStationIdx, t.ArrStationIdx)
-		if seat != nil {
-			if ok := seat.Book(seatBit, t.IsStudent); !ok {
-				notify := &notifyAdminInfo{
-					date:       t.TranDepDate,
-					tranNum:    t.TranNum,
-					carNum:     t.CarNum,
-					depStation: t.DepStation,
-					arrStation: t.ArrStation,
-					notifyType: "1",
-					message:    "Ticket Conflict"}
-				notify.notifyAdmin()
+		if seat.SeatBit&seatBit != 0 {
+			conflict := ticketConflict{
+				CarNum:   t.CarNum,
+				SeatNum:  t.SeatNum,
+				Segments: getSegments(seat.SeatBit & seatBit),
+			}
+			for _, owner := range seatOwners[seat] {
+				if countSeatBit(owner.DepStationIdx, owner.ArrStationIdx)&seatBit != 0 {
+					conflict.TicketIDs = append(conflict.TicketIDs, owner.ID)
+				}
 			}
+			conflict.TicketIDs = append(conflict.TicketIDs, t.ID)
+			report.Conflicts = append(report.Conflicts, conflict)
+			notify := &notifyAdminInfo{
+				date:       t.TranDepDate,
+				tranNum:    t.TranNum,
+				carNum:     t.CarNum,
+				seatNum:    t.SeatNum,
+				depStation: t.DepStation,
+				arrStation: t.ArrStation,
+				notifyType: "1",
+				severity:   constAlertSeverityCritical,
+				message:    fmt.Sprintf("Ticket Conflict: seat %s, segments %v, tickets %v", t.SeatNum, conflict.Segments, conflict.TicketIDs)}
+			notify.notifyAdmin()
 		}
+		seat.SeatBit |= seatBit
+		seatOwners[seat] = append(seatOwners[seat], t)
 	}
-	for ci:=0;ci<len(st.Cars);ci++{
-		if st.Cars[ci].NoSeatCount != 0 {
-			for ei:=0;ei<len(st.Cars[ci].EachRouteTravelerCount);ei++{
-				if st.Cars[ci].EachRouteTravelerCount[ei] != copySt.Cars[ci].EachRouteTravelerCount[ei]{
-					// TODO: 乘客人数不匹配，通知管理员处理
+	diffScheduleTran(st, rebuilt, report)
+	notifyCheckMismatch(report)
+	if mode == constCheckModeRepair && (len(report.SeatMismatches) != 0 || len(report.CountMismatches) != 0) {
+		st.Cars = rebuilt.Cars
+		st.hasChanged = true
+		report.Repaired = true
+	}
+	return report
+}
+
+// diffScheduleTran 对比缓存与重建的排班，结果写入报告
+func diffScheduleTran(st, rebuilt *ScheduleTran, report *TicketCheckReport) {
+	for ci := 0; ci < len(st.Cars); ci++ {
+		cacheCar, ticketCar := &st.Cars[ci], &rebuilt.Cars[ci]
+		if cacheCar.NoSeatCount != 0 {
+			for ei := 0; ei < len(cacheCar.EachRouteTravelerCount); ei++ {
+				if cacheCar.EachRouteTravelerCount[ei] != ticketCar.EachRouteTravelerCount[ei] {
+					report.CountMismatches = append(report.CountMismatches, travelerCountMismatch{
+						CarNum:      cacheCar.CarNum,
+						RouteIdx:    ei,
+						CacheCount:  cacheCar.EachRouteTravelerCount[ei],
+						TicketCount: ticketCar.EachRouteTravelerCount[ei],
+					})
 				}
 			}
 		}
-		for si:=0;si<len(st.Cars[ci].Seats);si++{
-			if st.Cars[ci].Seats[si].SeatBit != copySt.Cars[ci].Seats[si].SeatBit{
-				// TODO：该座位存在问题，有可能是票冲突，也有可能是路段释放失败
+		for si := 0; si < len(cacheCar.Seats); si++ {
+			// 有可能是票冲突，也有可能是路段释放失败
+			if cacheCar.Seats[si].SeatBit != ticketCar.Seats[si].SeatBit {
+				report.SeatMismatches = append(report.SeatMismatches, seatMismatch{
+					CarNum:        cacheCar.CarNum,
+					SeatNum:       cacheCar.Seats[si].SeatNum,
+					CacheSeatBit:  cacheCar.Seats[si].SeatBit,
+					TicketSeatBit: ticketCar.Seats[si].SeatBit,
+				})
 			}
 		}
 	}
 }
 
+// notifyCheckMismatch 缓存与车票不一致时通知管理员处理
+func notifyCheckMismatch(r *TicketCheckReport) {
+	if len(r.SeatMismatches) != 0 {
+		notify := &notifyAdminInfo{
+			date:       r.Date,
+			tranNum:    r.TranNum,
+			notifyType: "2",
+			severity:   constAlertSeverityWarning,
+			message:    fmt.Sprintf("Seat Bit Mismatch: %d seats", len(r.SeatMismatches))}
+		notify.notifyAdmin()
+	}
+	if len(r.CountMismatches) != 0 {
+		notify := &notifyAdminInfo{
+			date:       r.Date,
+			tranNum:    r.TranNum,
+			notifyType: "3",
+			severity:   constAlertSeverityWarning,
+			message:    fmt.Sprintf("Traveler Count Mismatch: %d routes", len(r.CountMismatches))}
+		notify.notifyAdmin()
+	}
+}
+
+// getSegments 将位标记转换为路段索引
+func getSegments(seatBit int64) (result []uint8) {
+	for i := uint8(0); i < 64; i++ {
+		if seatBit&(1<<i) != 0 {
+			result = append(result, i)
+		}
+	}
+	return
+}
+
 func getCarAndSeat(st *ScheduleTran, carNum uint8, seatType, seatNum string) (*ScheduleCar, *ScheduleSeat) {
 	for ci := 0; ci < len(st.Cars); ci++ {
 		if st.Cars[ci].CarNum == carNum {
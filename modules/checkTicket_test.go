@@ -1 +1,86 @@
-package modules
\ No newline at end of file
+package modules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleTranDeepCopy(t *testing.T) {
+	st := &ScheduleTran{
+		TranNum:       "G1",
+		DepartureDate: "2018-01-01",
+		Cars: []ScheduleCar{
+			ScheduleCar{
+				CarNum:                 1,
+				NoSeatCount:            2,
+				Seats:                  []ScheduleSeat{ScheduleSeat{SeatNum: "01A", SeatBit: 3}},
+				EachRouteTravelerCount: []uint8{1, 1, 0},
+			},
+		},
+	}
+	copySt := st.deepCopy()
+	copySt.resetSeats()
+	if st.Cars[0].Seats[0].SeatBit == 3 && st.Cars[0].EachRouteTravelerCount[0] == 1 {
+		t.Log("deepCopy pass")
+	} else {
+		t.Error("deepCopy fail")
+	}
+	if copySt.Cars[0].Seats[0].SeatNum == "01A" && copySt.Cars[0].Seats[0].SeatBit == 0 {
+		t.Log("resetSeats pass")
+	} else {
+		t.Error("resetSeats fail")
+	}
+
+	report := &TicketCheckReport{}
+	diffScheduleTran(st, copySt, report)
+	if len(report.SeatMismatches) == 1 && len(report.CountMismatches) == 2 {
+		t.Log("diffScheduleTran pass")
+	} else {
+		t.Error("diffScheduleTran fail")
+	}
+}
+
+func TestGetSegments(t *testing.T) {
+	segments := getSegments(countSeatBit(1, 3) & countSeatBit(2, 5))
+	if len(segments) == 2 && segments[0] == 2 && segments[1] == 3 {
+		t.Log("getSegments pass")
+	} else {
+		t.Error("getSegments fail")
+	}
+}
+
+func TestGetCheckDates(t *testing.T) {
+	today := time.Date(2018, 10, 1, 0, 0, 0, 0, time.Local)
+	tran := &TranInfo{IsSaleTicket: true, EnableStartDate: today.AddDate(0, 0, -10), EnableEndDate: today.AddDate(0, 0, 2),
+		Weekdays: 1<<time.Monday | 1<<time.Wednesday}
+	dates := tran.getCheckDates(today)
+	if len(dates) == 2 && dates[0] == "2018-10-01" && dates[1] == "2018-10-03" {
+		t.Log("check dates pass")
+	} else {
+		t.Error("check dates fail", dates)
+	}
+	tran.IsSaleTicket = false
+	if len(tran.getCheckDates(today)) == 0 {
+		t.Log("check dates not sale pass")
+	} else {
+		t.Error("check dates not sale fail")
+	}
+}
+
+func TestRLockSchedules(t *testing.T) {
+	a := &ScheduleTran{TranNum: "G2", DepartureDate: "2018-10-01"}
+	b := &ScheduleTran{TranNum: "G1", DepartureDate: "2018-10-02"}
+	unlock := rLockSchedules(b, a, b)
+	if !a.repairLock.TryLock() && !b.repairLock.TryLock() && a.repairLock.TryRLock() {
+		a.repairLock.RUnlock()
+		t.Log("rlock schedules pass")
+	} else {
+		t.Error("rlock schedules fail")
+	}
+	unlock()
+	if a.repairLock.TryLock() && b.repairLock.TryLock() {
+		t.Log("unlock schedules pass")
+	} else {
+		t.Error("unlock schedules fail")
+	}
+}
@@ -0,0 +1,174 @@
+package modules
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// 乘客类型
+	constPassengerAdult    = iota + 1 // 成人
+	constPassengerChild               // 儿童
+	constPassengerStudent             // 学生
+	constPassengerDisabled            // 伤残军人/伤残人民警察
+)
+
+const constPaperworkIDCard = 1 // 证件类型：居民身份证
+
+const (
+	// 票价规则
+	constFareAdult    = "adult"    // 全价票
+	constFareInfant   = "infant"   // 免费儿童，须有成人同行
+	constFareChild    = "child"    // 儿童票
+	constFareStudent  = "student"  // 学生票
+	constFareDisabled = "disabled" // 伤残军人/伤残人民警察优待票
+)
+
+const (
+	constInfantMaxAge    = 6   // 未满此年龄的儿童可由成人携带免费乘车
+	constChildMaxAge     = 14  // 未满此年龄的儿童购买儿童票
+	constInfantMaxHeight = 120 // 无法确认年龄时，未达此身高的儿童免费 单位：厘米
+	constChildMaxHeight  = 150 // 无法确认年龄时，未达此身高的儿童购买儿童票 单位：厘米
+)
+
+var (
+	// 学生票可购买的席别及折扣
+	studentFareRates = map[string]float32{
+		constSeatTypeSecondClass: 0.75,
+		constSeatTypeHardSeat:    0.5,
+		constSeatTypeHardSleeper: 0.5,
+	}
+	// 票价规则，按顺序匹配，第一个匹配的规则生效，均不匹配时为全价票
+	fareRules = []fareRule{
+		{name: constFareInfant, discount: infantFare},
+		{name: constFareChild, discount: childFare},
+		{name: constFareDisabled, discount: disabledFare},
+		{name: constFareStudent, discount: studentFare},
+	}
+)
+
+// fareContext 计算单张车票票价所需的信息
+type fareContext struct {
+	passenger     *Passenger
+	seatType      string    // 所选席别
+	depCity       string    // 出发站所在城市编码
+	arrCity       string    // 到达站所在城市编码
+	depTime       time.Time // 乘车时间，用于计算年龄
+	isStudent     bool      // 是否申请学生票
+	freeInfantNum *int      // 同一订单中还可免费携带的儿童数，每名成人可免费携带一名
+}
+
+// fareRule 票价规则，discount 返回折扣率，不适用时 ok 为false
+type fareRule struct {
+	name     string
+	discount func(c *fareContext) (rate float32, ok bool)
+}
+
+// age 乘车时的周岁年龄，无法确认时返回-1
+func (c *fareContext) age() int {
+	birthday := c.passenger.Birthday
+	if c.passenger.PaperworkType == constPaperworkIDCard && len(c.passenger.PaperworkNum) == 18 {
+		if t, err := time.Parse("20060102", c.passenger.PaperworkNum[6:14]); err == nil {
+			birthday = t
+		}
+	}
+	// 未填写的出生日期在数据库中为 0001-01-01，按本地时区读出时不是零值
+	if birthday.Year() <= 1 {
+		return -1
+	}
+	age := c.depTime.Year() - birthday.Year()
+	if c.depTime.Month() < birthday.Month() || (c.depTime.Month() == birthday.Month() && c.depTime.Day() < birthday.Day()) {
+		age--
+	}
+	return age
+}
+
+// isChildBelow 按年龄判断是否未满 maxAge，无法确认年龄时按身高判断是否未达 maxHeight
+func (c *fareContext) isChildBelow(maxAge int, maxHeight uint8) bool {
+	if age := c.age(); age >= 0 {
+		return age < maxAge
+	}
+	return c.passenger.Height != 0 && c.passenger.Height < maxHeight
+}
+
+func infantFare(c *fareContext) (float32, bool) {
+	if !c.isChildBelow(constInfantMaxAge, constInfantMaxHeight) || *c.freeInfantNum <= 0 {
+		return 0, false
+	}
+	*c.freeInfantNum--
+	return 0, true
+}
+
+func childFare(c *fareContext) (float32, bool) {
+	return 0.5, c.passenger.PassengerType == constPassengerChild || c.isChildBelow(constChildMaxAge, constChildMaxHeight)
+}
+
+func disabledFare(c *fareContext) (float32, bool) {
+	return 0.5, c.passenger.PassengerType == constPassengerDisabled
+}
+
+// studentFare 学生票限定席别，且只能在登记的家庭所在地与学校所在地之间乘车
+func studentFare(c *fareContext) (float32, bool) {
+	p := c.passenger
+	if !c.isStudent || p.PassengerType != constPassengerStudent || p.HomeCity == "" || p.SchoolCity == "" {
+		return 0, false
+	}
+	rate, ok := studentFareRates[c.seatType]
+	if !ok {
+		return 0, false
+	}
+	if (c.depCity == p.HomeCity && c.arrCity == p.SchoolCity) || (c.depCity == p.SchoolCity && c.arrCity == p.HomeCity) {
+		return rate, true
+	}
+	return 0, false
+}
+
+// getFare 按票价规则计算票价，返回折后价及适用的规则
+func getFare(fullPrice float32, c *fareContext) (float32, string) {
+	for _, r := range fareRules {
+		if rate, ok := r.discount(c); ok {
+			return fullPrice * rate, r.name
+		}
+	}
+	return fullPrice, constFareAdult
+}
+
+// applyFares 按乘客类型计算订单中各车票的票价，并记录适用的规则；查询乘客失败时返回错误，不按成人票计价
+func applyFares(tran *TranInfo, par *SubmitOrderModel, tickets []*Ticket) error {
+	pids := make([]uint64, len(tickets))
+	for i, t := range tickets {
+		pids[i] = t.PassengerID
+	}
+	var list []Passenger
+	if err := db.Where("p_id in (?)", pids).Find(&list).Error; err != nil {
+		return fmt.Errorf("查询乘客信息失败: %v", err)
+	}
+	passengers := make(map[uint64]*Passenger, len(list))
+	for i := 0; i < len(list); i++ {
+		passengers[list[i].PID] = &list[i]
+	}
+	contexts := make([]*fareContext, len(tickets))
+	freeInfantNum := 0
+	for i, t := range tickets {
+		p, ok := passengers[t.PassengerID]
+		if !ok {
+			p = &Passenger{PID: t.PassengerID, PassengerType: constPassengerAdult}
+		}
+		contexts[i] = &fareContext{
+			passenger:     p,
+			seatType:      par.SeatType,
+			depCity:       tran.Timetable[par.DepIdx].CityCode,
+			arrCity:       tran.Timetable[par.ArrIdx].CityCode,
+			depTime:       par.depTime,
+			isStudent:     par.IsStudent,
+			freeInfantNum: &freeInfantNum,
+		}
+		if !contexts[i].isChildBelow(constChildMaxAge, constChildMaxHeight) && p.PassengerType != constPassengerChild {
+			freeInfantNum++
+		}
+	}
+	for i, t := range tickets {
+		t.Price, t.FareRule = getFare(t.Price, contexts[i])
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package modules
+
+import (
+	"testing"
+	"time"
+)
+
+func newFareContext(p *Passenger, freeInfantNum *int) *fareContext {
+	return &fareContext{
+		passenger:     p,
+		seatType:      constSeatTypeSecondClass,
+		depCity:       "BJ",
+		arrCity:       "SH",
+		depTime:       time.Date(2018, 6, 1, 8, 0, 0, 0, time.Local),
+		freeInfantNum: freeInfantNum,
+	}
+}
+
+func TestFareAge(t *testing.T) {
+	c := newFareContext(&Passenger{PaperworkType: constPaperworkIDCard, PaperworkNum: "420116201206025568"}, nil)
+	if c.age() == 5 {
+		t.Log("age by id card pass")
+	} else {
+		t.Error("age by id card fail")
+	}
+	c.passenger = &Passenger{Birthday: time.Date(2012, 6, 1, 0, 0, 0, 0, time.Local)}
+	if c.age() == 6 {
+		t.Log("age by birthday pass")
+	} else {
+		t.Error("age by birthday fail")
+	}
+	c.passenger = &Passenger{Height: 130}
+	if c.age() == -1 && c.isChildBelow(constChildMaxAge, constChildMaxHeight) && !c.isChildBelow(constInfantMaxAge, constInfantMaxHeight) {
+		t.Log("child by height pass")
+	} else {
+		t.Error("child by height fail")
+	}
+}
+
+func TestGetFare(t *testing.T) {
+	freeInfantNum := 1
+	infant := &Passenger{Birthday: time.Date(2015, 1, 1, 0, 0, 0, 0, time.Local)}
+	if price, rule := getFare(100, newFareContext(infant, &freeInfantNum)); price == 0 && rule == constFareInfant {
+		t.Log("infant pass")
+	} else {
+		t.Error("infant fail")
+	}
+	// 每名成人只能免费携带一名儿童
+	if price, rule := getFare(100, newFareContext(infant, &freeInfantNum)); price == 50 && rule == constFareChild {
+		t.Log("second infant pass")
+	} else {
+		t.Error("second infant fail")
+	}
+	disabled := &Passenger{PassengerType: constPassengerDisabled}
+	if price, rule := getFare(100, newFareContext(disabled, &freeInfantNum)); price == 50 && rule == constFareDisabled {
+		t.Log("disabled pass")
+	} else {
+		t.Error("disabled fail")
+	}
+	student := &Passenger{PassengerType: constPassengerStudent, HomeCity: "SH", SchoolCity: "BJ"}
+	c := newFareContext(student, &freeInfantNum)
+	if price, rule := getFare(100, c); price == 100 && rule == constFareAdult {
+		t.Log("student not requested pass")
+	} else {
+		t.Error("student not requested fail")
+	}
+	c.isStudent = true
+	if price, rule := getFare(100, c); price == 75 && rule == constFareStudent {
+		t.Log("student pass")
+	} else {
+		t.Error("student fail")
+	}
+	c.seatType = constSeatTypeFristClass
+	if _, rule := getFare(100, c); rule == constFareAdult {
+		t.Log("student seat type pass")
+	} else {
+		t.Error("student seat type fail")
+	}
+	c.seatType, c.arrCity = constSeatTypeSecondClass, "GZ"
+	if _, rule := getFare(100, c); rule == constFareAdult {
+		t.Log("student city pass")
+	} else {
+		t.Error("student city fail")
+	}
+}
+
+// 按乘客ID从数据库查询乘客类型后计价，需连接数据库
+func TestApplyFares(t *testing.T) {
+	disabled := &Passenger{PID: uint64(time.Now().UnixNano() % 1e12), Name: "test", PaperworkNum: "test", PassengerType: constPassengerDisabled}
+	if err := db.Create(disabled).Error; err != nil {
+		t.Fatal("create passenger fail", err)
+	}
+	defer db.Delete(disabled)
+	tran := &TranInfo{Timetable: []Route{Route{CityCode: "BJ"}, Route{CityCode: "SH"}}}
+	par := &SubmitOrderModel{SeatType: constSeatTypeSecondClass, DepIdx: 0, ArrIdx: 1}
+	tickets := []*Ticket{&Ticket{PassengerID: disabled.PID, Price: 100}, &Ticket{PassengerID: disabled.PID + 1, Price: 100}}
+	if err := applyFares(tran, par, tickets); err == nil && tickets[0].Price == 50 && tickets[0].FareRule == constFareDisabled &&
+		tickets[1].Price == 100 && tickets[1].FareRule == constFareAdult {
+		t.Log("applyFares pass")
+	} else {
+		t.Error("applyFares fail", err)
+	}
+}
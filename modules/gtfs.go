@@ -0,0 +1,286 @@
+package modules
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// GTFS 文件
+	constGTFSAgency        = "agency.txt"
+	constGTFSStops         = "stops.txt"
+	constGTFSRoutes        = "routes.txt"
+	constGTFSTrips         = "trips.txt"
+	constGTFSStopTimes     = "stop_times.txt"
+	constGTFSCalendar      = "calendar.txt"
+	constGTFSCalendarDates = "calendar_dates.txt"
+	constGTFSFareAttrs     = "fare_attributes.txt"
+	constGTFSFareRules     = "fare_rules.txt"
+
+	constGTFSAgencyID        = "t-tran"
+	constGTFSAgencyName      = "t-tran"
+	constGTFSAgencyURL       = "http://localhost:8080"
+	constGTFSTimezone        = "Asia/Shanghai"
+	constGTFSCurrency        = "CNY"
+	constGTFSRouteTypeRail   = "2"
+	constGTFSDateFormat      = "20060102"
+	constGTFSExceptionAdd    = "1" // calendar_dates 中的加开
+	constGTFSExceptionRemove = "2" // calendar_dates 中的停开
+	constGTFSMaxExpandDays   = 366 // 间隔多天开行的车次，导出时逐日列出开行日期的最大天数
+)
+
+var (
+	// 导出的GTFS文件及其列，city_code、city_name、car_ids、platform、check_ticket_gate、seat_type、berth为扩展列
+	gtfsFiles   = []string{constGTFSAgency, constGTFSStops, constGTFSRoutes, constGTFSTrips, constGTFSStopTimes, constGTFSCalendar, constGTFSCalendarDates, constGTFSFareAttrs, constGTFSFareRules}
+	gtfsHeaders = map[string][]string{
+		constGTFSAgency:        []string{"agency_id", "agency_name", "agency_url", "agency_timezone"},
+		constGTFSStops:         []string{"stop_id", "stop_code", "stop_name", "stop_lat", "stop_lon", "zone_id", "city_code", "city_name"},
+		constGTFSRoutes:        []string{"route_id", "agency_id", "route_short_name", "route_long_name", "route_type"},
+		constGTFSTrips:         []string{"route_id", "service_id", "trip_id", "trip_short_name", "car_ids"},
+		constGTFSStopTimes:     []string{"trip_id", "arrival_time", "departure_time", "stop_id", "stop_sequence", "shape_dist_traveled", "platform", "check_ticket_gate"},
+		constGTFSCalendar:      []string{"service_id", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday", "start_date", "end_date"},
+		constGTFSCalendarDates: []string{"service_id", "date", "exception_type"},
+		constGTFSFareAttrs:     []string{"fare_id", "price", "currency_type", "payment_method", "transfers", "seat_type", "berth"},
+		constGTFSFareRules:     []string{"fare_id", "route_id", "origin_id", "destination_id"},
+	}
+	// calendar.txt 中星期列的顺序，对应开行星期的位
+	gtfsWeekdays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+)
+
+// gtfsFeed GTFS数据，key为文件名，value为除表头外的各行
+type gtfsFeed map[string]([][]string)
+
+func (f gtfsFeed) add(file string, row ...string) {
+	f[file] = append(f[file], row)
+}
+
+// ExportGTFS 将车站、所有车次版本的时刻表、开行日期及票价导出为GTFS压缩包
+func ExportGTFS(w io.Writer) error {
+	var trans []TranInfo
+	db.Order("tran_num, enable_start_date").Find(&trans)
+	feed := gtfsFeed{}
+	feed.add(constGTFSAgency, constGTFSAgencyID, constGTFSAgencyName, constGTFSAgencyURL, constGTFSTimezone)
+	stations := getStationSnapshot().stations
+	stops := make(map[string]bool, len(stations))
+	for _, s := range stations {
+		stops[s.StationCode] = true
+		feed.add(constGTFSStops, s.StationCode, s.StationCode, s.StationName, "", "", s.StationCode, s.CityCode, s.CityName)
+	}
+	for i := range trans {
+		trans[i].getFullInfo()
+		// 时刻表中不在车站集合里的车站（如非客运站），也需要导出
+		for _, r := range trans[i].Timetable {
+			if id := getGTFSStopID(&r); !stops[id] {
+				stops[id] = true
+				feed.add(constGTFSStops, id, r.StationCode, r.StationName, "", "", id, r.CityCode, "")
+			}
+		}
+		feed.addTran(&trans[i])
+	}
+	return feed.write(w)
+}
+
+func getGTFSStopID(r *Route) string {
+	if r.StationCode != "" {
+		return r.StationCode
+	}
+	return r.StationName
+}
+
+// getGTFSTripID 车次版本的GTFS标识，同时作为线路及服务日历的标识，如：G1_20180101
+func getGTFSTripID(t *TranInfo) string {
+	return t.TranNum + "_" + t.EnableStartDate.Format(constGTFSDateFormat)
+}
+
+// addTran 添加车次版本的线路、行程、时刻、开行日期及票价
+func (f gtfsFeed) addTran(t *TranInfo) {
+	if len(t.Timetable) < 2 {
+		return
+	}
+	tripID, last := getGTFSTripID(t), len(t.Timetable)-1
+	f.add(constGTFSRoutes, tripID, constGTFSAgencyID, t.TranNum,
+		t.Timetable[0].StationName+"-"+t.Timetable[last].StationName, constGTFSRouteTypeRail)
+	f.add(constGTFSTrips, tripID, tripID, tripID, t.TranNum, t.CarIds)
+
+	base, mileage := dateOnly(t.Timetable[0].DepTime.In(time.Local)), float32(0)
+	for i, r := range t.Timetable {
+		arrTime, depTime := r.ArrTime, r.DepTime
+		if i == 0 {
+			arrTime = depTime
+		}
+		if i == last {
+			depTime = arrTime
+		}
+		f.add(constGTFSStopTimes, tripID, formatGTFSTime(arrTime, base), formatGTFSTime(depTime, base), getGTFSStopID(&r),
+			strconv.Itoa(i+1), strconv.FormatFloat(float64(mileage), 'f', -1, 32), formatPlatform(r.Platform), r.CheckTicketGate)
+		mileage += r.MileageNext
+	}
+	f.addService(tripID, t)
+
+	addFare := func(name, seatType, berth string, prices []int) {
+		for i, p := range prices {
+			if i >= last {
+				break
+			}
+			fareID := tripID + ":" + name + ":" + strconv.Itoa(i+1)
+			f.add(constGTFSFareAttrs, fareID, strconv.FormatFloat(float64(p)/100, 'f', 2, 64), constGTFSCurrency, "0", "0", seatType, berth)
+			f.add(constGTFSFareRules, fareID, tripID, getGTFSStopID(&t.Timetable[i]), getGTFSStopID(&t.Timetable[i+1]))
+		}
+	}
+	for _, seatType := range unionSeatTypes(t.SeatPriceMap, nil) {
+		addFare(seatType, seatType, "", t.SeatPriceMap[seatType])
+		for _, berth := range unionSeatTypes(t.BerthPriceMap[seatType], nil) {
+			addFare(seatType+"-"+berth, seatType, berth, t.BerthPriceMap[seatType][berth])
+		}
+	}
+}
+
+// addService 添加车次版本的服务日历：按开行星期生成calendar，单独指定及开行日历中的加开停开日期生成calendar_dates
+// 间隔多天开行的车次无法用星期表示，逐日列出开行日期
+func (f gtfsFeed) addService(serviceID string, t *TranInfo) {
+	start, end := dateOnly(t.EnableStartDate), dateOnly(t.EnableEndDate)
+	weekdays, cal := t.Weekdays, getOperatingCalendar(t.CalendarID)
+	if weekdays == 0 && cal != nil {
+		weekdays = cal.Weekdays
+	}
+	if weekdays == 0 {
+		weekdays = 1<<7 - 1
+	}
+	if t.ScheduleDays > 1 {
+		weekdays = 0
+		if limit := start.AddDate(0, 0, constGTFSMaxExpandDays); end.After(limit) {
+			end = limit
+		}
+	}
+	row := []string{serviceID}
+	for _, d := range gtfsWeekdays {
+		row = append(row, strconv.Itoa(int(weekdays>>uint(d)&1)))
+	}
+	f.add(constGTFSCalendar, append(row, start.Format(constGTFSDateFormat), end.Format(constGTFSDateFormat))...)
+
+	if t.ScheduleDays > 1 {
+		for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+			if t.isOperatingDay(day) {
+				f.add(constGTFSCalendarDates, serviceID, day.Format(constGTFSDateFormat), constGTFSExceptionAdd)
+			}
+		}
+		return
+	}
+	// 车次单独指定的日期优先于开行日历
+	dates := make(map[string]bool)
+	if cal != nil {
+		for k, v := range cal.dateMap {
+			dates[k] = v
+		}
+	}
+	for k, v := range t.dateMap {
+		dates[k] = v
+	}
+	keys := make([]string, 0, len(dates))
+	for k := range dates {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		day, err := time.Parse(ConstYmdFormat, k)
+		if err != nil || day.Before(start) || day.After(end) {
+			continue
+		}
+		exception := constGTFSExceptionRemove
+		if dates[k] {
+			exception = constGTFSExceptionAdd
+		}
+		f.add(constGTFSCalendarDates, serviceID, day.Format(constGTFSDateFormat), exception)
+	}
+}
+
+// formatGTFSTime 相对于起点站发车日期的时间，跨天时小时数超过24，如：25:30:00
+func formatGTFSTime(t time.Time, base time.Time) string {
+	t = t.In(time.Local)
+	days := int(dateOnly(t).Sub(base).Hours() / 24)
+	return fmt.Sprintf("%02d:%02d:%02d", days*24+t.Hour(), t.Minute(), t.Second())
+}
+
+// parseGTFSTime 解析GTFS时间，结果为0001-01-01起的本地时间，与initTimetable的约定一致
+func parseGTFSTime(s string) (time.Time, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("时间%s无效", s)
+	}
+	var hms [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || (i > 0 && n >= 60) {
+			return time.Time{}, fmt.Errorf("时间%s无效", s)
+		}
+		hms[i] = n
+	}
+	return time.Date(1, 1, 1+hms[0]/24, hms[0]%24, hms[1], hms[2], 0, time.Local), nil
+}
+
+// write 按文件顺序写入zip压缩包，没有数据的可选文件也写入表头
+func (f gtfsFeed) write(w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for _, name := range gtfsFiles {
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		cw := csv.NewWriter(fw)
+		cw.Write(gtfsHeaders[name])
+		cw.WriteAll(f[name])
+		if err = cw.Error(); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// readGTFS 读取GTFS压缩包中的文件，每行按列名存为map，文件可以位于压缩包的子目录中
+func readGTFS(r io.ReaderAt, size int64) (map[string]([]map[string]string), error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("GTFS压缩包无效：%v", err)
+	}
+	result := make(map[string]([]map[string]string))
+	for _, zf := range zr.File {
+		name := zf.Name[strings.LastIndex(zf.Name, "/")+1:]
+		if _, ok := gtfsHeaders[name]; !ok {
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return nil, err
+		}
+		cr := csv.NewReader(rc)
+		cr.FieldsPerRecord = -1
+		records, err := cr.ReadAll()
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s格式无效：%v", name, err)
+		}
+		if len(records) == 0 {
+			continue
+		}
+		header := records[0]
+		for i := range header {
+			header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+		}
+		rows := make([]map[string]string, 0, len(records)-1)
+		for _, record := range records[1:] {
+			row := make(map[string]string, len(header))
+			for i := 0; i < len(header) && i < len(record); i++ {
+				row[header[i]] = strings.TrimSpace(record[i])
+			}
+			rows = append(rows, row)
+		}
+		result[name] = rows
+	}
+	return result, nil
+}
@@ -0,0 +1,415 @@
+package modules
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	constGTFSActionCreate = "新增"
+	constGTFSActionUpdate = "更新"
+)
+
+// GTFSReport GTFS导入报告，试运行或有行程导入失败时全部回滚
+type GTFSReport struct {
+	DryRun    bool             `json:"dryRun"`
+	Committed bool             `json:"committed"` // 是否已提交
+	Stations  []string         `json:"stations"`  // 新增的车站
+	Calendars []string         `json:"calendars"` // 新增或更新的开行日历，由多个行程共用的服务日历生成
+	Trans     []GTFSTranResult `json:"trans"`     // 各行程的导入结果
+	Warnings  []string         `json:"warnings"`  // 被忽略的数据
+}
+
+// GTFSTranResult 一个GTFS行程（即车次版本）的导入结果
+type GTFSTranResult struct {
+	TripID  string           `json:"tripID"`
+	TranNum string           `json:"tranNum"`
+	Action  string           `json:"action"` // 新增、更新
+	Success bool             `json:"success"`
+	Msg     string           `json:"msg"`
+	Issues  []TranValidIssue `json:"issues"` // 校验的错误及警告
+}
+
+// gtfsService GTFS服务日历
+type gtfsService struct {
+	weekdays   uint8
+	start, end time.Time
+	dates      []OperatingDate
+	tripCount  int
+}
+
+// gtfsFare GTFS票价，seat_type为空时按二等座
+type gtfsFare struct {
+	price           int
+	seatType, berth string
+}
+
+// ImportGTFS 从GTFS压缩包导入车站、开行日历及车次，每个行程作为一个车次版本，按生效开始日期新增或更新；
+// 全部数据在一个事务中保存，试运行时执行同样的校验及保存后回滚，有行程导入失败时也回滚
+func ImportGTFS(r io.ReaderAt, size int64, dryRun bool) (*GTFSReport, error) {
+	feed, err := readGTFS(r, size)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{constGTFSStops, constGTFSTrips, constGTFSStopTimes} {
+		if len(feed[name]) == 0 {
+			return nil, fmt.Errorf("缺少%s", name)
+		}
+	}
+	report := &GTFSReport{DryRun: dryRun}
+	warn := func(format string, a ...interface{}) {
+		report.Warnings = append(report.Warnings, fmt.Sprintf(format, a...))
+	}
+
+	// 车站，按站名匹配已有车站
+	stops, zones, newStations := make(map[string]*Station), make(map[string]string), make(map[string]*Station)
+	for _, row := range feed[constGTFSStops] {
+		if t := row["location_type"]; t != "" && t != "0" {
+			continue
+		}
+		name := row["stop_name"]
+		if row["stop_id"] == "" || name == "" {
+			return nil, fmt.Errorf("%s中的车站缺少stop_id或stop_name", constGTFSStops)
+		}
+		zones[row["stop_id"]] = row["zone_id"]
+		if zones[row["stop_id"]] == "" {
+			zones[row["stop_id"]] = row["stop_id"]
+		}
+		if s := getStationInfoByName(name); s != nil {
+			stops[row["stop_id"]] = s
+			continue
+		}
+		if s, ok := newStations[name]; ok {
+			stops[row["stop_id"]] = s
+			continue
+		}
+		code := row["stop_code"]
+		if code == "" {
+			code = row["stop_id"]
+		}
+		s := &Station{StationName: name, StationCode: code, CityCode: row["city_code"], CityName: row["city_name"], IsPassenger: true}
+		stops[row["stop_id"]], newStations[name] = s, s
+		report.Stations = append(report.Stations, name)
+	}
+
+	services, err := readGTFSServices(feed)
+	if err != nil {
+		return nil, err
+	}
+	routeNames := make(map[string]string)
+	for _, row := range feed[constGTFSRoutes] {
+		routeNames[row["route_id"]] = row["route_short_name"]
+	}
+	stopTimes := make(map[string]([]map[string]string))
+	for _, row := range feed[constGTFSStopTimes] {
+		stopTimes[row["trip_id"]] = append(stopTimes[row["trip_id"]], row)
+	}
+	for _, rows := range stopTimes {
+		sort.Slice(rows, func(i, j int) bool {
+			a, _ := strconv.Atoi(rows[i]["stop_sequence"])
+			b, _ := strconv.Atoi(rows[j]["stop_sequence"])
+			return a < b
+		})
+	}
+	fares := make(map[string]gtfsFare)
+	for _, row := range feed[constGTFSFareAttrs] {
+		price, err := strconv.ParseFloat(row["price"], 64)
+		if err != nil || price < 0 {
+			warn("票价%s的价格无效", row["fare_id"])
+			continue
+		}
+		if row["currency_type"] != "" && row["currency_type"] != constGTFSCurrency {
+			warn("票价%s的币种%s不是%s，已忽略", row["fare_id"], row["currency_type"], constGTFSCurrency)
+			continue
+		}
+		seatType := row["seat_type"]
+		if seatType == "" {
+			seatType = constSeatTypeSecondClass
+		}
+		fares[row["fare_id"]] = gtfsFare{price: int(math.Round(price * 100)), seatType: seatType, berth: row["berth"]}
+	}
+	fareRules := make(map[string]([]map[string]string))
+	for _, row := range feed[constGTFSFareRules] {
+		fareRules[row["route_id"]] = append(fareRules[row["route_id"]], row)
+	}
+
+	trips := feed[constGTFSTrips]
+	sort.SliceStable(trips, func(i, j int) bool { return trips[i]["trip_id"] < trips[j]["trip_id"] })
+	for _, trip := range trips {
+		if s, ok := services[trip["service_id"]]; ok {
+			s.tripCount++
+		}
+	}
+	// 多个行程共用的服务日历，作为开行日历导入，以服务日历ID为日历名称
+	calendars := make(map[string]*OperatingCalendar)
+	for id, s := range services {
+		if s.tripCount < 2 {
+			continue
+		}
+		c := &OperatingCalendar{Name: id, Weekdays: s.weekdays, Dates: s.dates}
+		for _, existing := range GetOperatingCalendars() {
+			if existing.Name == id {
+				c.ID = existing.ID
+			}
+		}
+		calendars[id] = c
+		report.Calendars = append(report.Calendars, id)
+	}
+	sort.Strings(report.Calendars)
+
+	tx := db.Begin()
+	for _, name := range report.Stations {
+		if err := tx.Create(newStations[name]).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	for _, id := range report.Calendars {
+		if ok, msg := calendars[id].save(tx); !ok {
+			tx.Rollback()
+			return nil, fmt.Errorf("开行日历%s保存失败：%s", id, msg)
+		}
+	}
+
+	failed := false
+	tranNums := make([]string, 0, len(trips))
+	for _, trip := range trips {
+		result := GTFSTranResult{TripID: trip["trip_id"]}
+		tran, stopIDs, err := buildGTFSTran(trip, routeNames, stopTimes[trip["trip_id"]], stops, services, calendars)
+		if err != nil {
+			result.Msg = err.Error()
+			report.Trans = append(report.Trans, result)
+			failed = true
+			continue
+		}
+		result.TranNum = tran.TranNum
+		stopZones := make([]string, len(stopIDs))
+		for i, id := range stopIDs {
+			stopZones[i] = zones[id]
+		}
+		for _, rule := range fareRules[trip["route_id"]] {
+			fare, ok := fares[rule["fare_id"]]
+			if !ok {
+				continue
+			}
+			if !tran.applyGTFSFare(rule, fare, stopZones) {
+				warn("行程%s的票价%s不是相邻车站之间的票价，已忽略", result.TripID, rule["fare_id"])
+			}
+		}
+		result.Action = constGTFSActionCreate
+		for _, v := range GetTranVersions(tran.TranNum) {
+			// 生效开始日期相同的版本直接修改，生效期覆盖新行程开始日期的版本在新行程开始前截止
+			if dateOnly(v.EnableStartDate).Equal(dateOnly(tran.EnableStartDate)) ||
+				(v.EnableStartDate.Before(tran.EnableStartDate) && !v.EnableEndDate.Before(tran.EnableStartDate)) {
+				tran.ID, result.Action = v.ID, constGTFSActionUpdate
+			}
+		}
+		// 新车站及开行日历在事务提交后才加载到内存，忽略新车站不存在的错误，开行日历只校验开行星期及日期
+		for _, issue := range tran.Validate() {
+			if isGTFSNewStationIssue(tran, issue, newStations) {
+				continue
+			}
+			result.Issues = append(result.Issues, issue)
+		}
+		if result.Msg = FirstValidError(result.Issues); result.Msg == "" && tran.CalendarID == 0 {
+			_, result.Msg = tran.validCalendar()
+		}
+		if result.Msg == "" {
+			result.Success, result.Msg = tran.saveVersion(tx)
+		}
+		failed = failed || !result.Success
+		tranNums = append(tranNums, tran.TranNum)
+		report.Trans = append(report.Trans, result)
+	}
+	if dryRun || failed {
+		tx.Rollback()
+		return report, nil
+	}
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	report.Committed = true
+	if len(report.Stations) != 0 {
+		refreshStations()
+	}
+	for _, id := range report.Calendars {
+		calendars[id].cache()
+	}
+	reloadTrans(tranNums...)
+	return report, nil
+}
+
+// readGTFSServices 读取服务日历，只有calendar_dates的服务日历，以列出的日期为生效期，其余日期停开
+func readGTFSServices(feed map[string]([]map[string]string)) (map[string]*gtfsService, error) {
+	services := make(map[string]*gtfsService)
+	for _, row := range feed[constGTFSCalendar] {
+		s := &gtfsService{}
+		var err1, err2 error
+		s.start, err1 = time.ParseInLocation(constGTFSDateFormat, row["start_date"], time.Local)
+		s.end, err2 = time.ParseInLocation(constGTFSDateFormat, row["end_date"], time.Local)
+		if err1 != nil || err2 != nil || s.end.Before(s.start) {
+			return nil, fmt.Errorf("服务日历%s的日期无效", row["service_id"])
+		}
+		for i, d := range gtfsWeekdays {
+			if row[gtfsHeaders[constGTFSCalendar][i+1]] == "1" {
+				s.weekdays |= 1 << uint(d)
+			}
+		}
+		services[row["service_id"]] = s
+	}
+	added := make(map[string](map[string]bool))
+	for _, row := range feed[constGTFSCalendarDates] {
+		day, err := time.ParseInLocation(constGTFSDateFormat, row["date"], time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("服务日历%s的日期%s无效", row["service_id"], row["date"])
+		}
+		s, ok := services[row["service_id"]]
+		if !ok {
+			s = &gtfsService{start: day, end: day}
+			services[row["service_id"]] = s
+			added[row["service_id"]] = make(map[string]bool)
+		}
+		if day.Before(s.start) {
+			s.start = day
+		}
+		if day.After(s.end) {
+			s.end = day
+		}
+		isExclude := row["exception_type"] == constGTFSExceptionRemove
+		s.dates = append(s.dates, OperatingDate{Date: day.Format(ConstYmdFormat), IsExclude: isExclude})
+		if m, ok := added[row["service_id"]]; ok && !isExclude {
+			m[day.Format(ConstYmdFormat)] = true
+		}
+	}
+	for id, s := range services {
+		if s.weekdays == 1<<7-1 {
+			s.weekdays = 0
+			continue
+		}
+		if s.weekdays != 0 {
+			continue
+		}
+		// 开行星期为零表示每天开行，没有开行星期的服务日历需将未列出的日期停开
+		listed := added[id]
+		if listed == nil {
+			listed = make(map[string]bool)
+			for _, d := range s.dates {
+				listed[d.Date] = !d.IsExclude
+			}
+		}
+		s.dates = s.dates[:0]
+		for day := s.start; !day.After(s.end); day = day.AddDate(0, 0, 1) {
+			date := day.Format(ConstYmdFormat)
+			s.dates = append(s.dates, OperatingDate{Date: date, IsExclude: !listed[date]})
+		}
+	}
+	return services, nil
+}
+
+// buildGTFSTran 按GTFS行程生成车次版本，同时返回各站的stop_id，票价另行设置
+func buildGTFSTran(trip map[string]string, routeNames map[string]string, stopTimes []map[string]string,
+	stops map[string]*Station, services map[string]*gtfsService, calendars map[string]*OperatingCalendar) (*TranInfo, []string, error) {
+	t := &TranInfo{TranNum: trip["trip_short_name"], CarIds: trip["car_ids"], ScheduleDays: 1, IsSaleTicket: true}
+	if t.TranNum == "" {
+		t.TranNum = routeNames[trip["route_id"]]
+	}
+	if t.TranNum == "" {
+		return nil, nil, fmt.Errorf("行程%s缺少车次号", trip["trip_id"])
+	}
+	s, ok := services[trip["service_id"]]
+	if !ok {
+		return nil, nil, fmt.Errorf("服务日历%s不存在", trip["service_id"])
+	}
+	t.EnableStartDate, t.EnableEndDate = s.start, s.end
+	if c, ok := calendars[trip["service_id"]]; ok {
+		t.CalendarID = c.ID
+	} else {
+		t.Weekdays, t.OperatingDates = s.weekdays, append([]OperatingDate(nil), s.dates...)
+	}
+
+	var lastDist float64
+	stopIDs := make([]string, 0, len(stopTimes))
+	for i, row := range stopTimes {
+		station, ok := stops[row["stop_id"]]
+		if !ok {
+			return nil, nil, fmt.Errorf("车站%s不存在", row["stop_id"])
+		}
+		arr, dep := row["arrival_time"], row["departure_time"]
+		if arr == "" {
+			arr = dep
+		}
+		if dep == "" {
+			dep = arr
+		}
+		if arr == "" {
+			return nil, nil, fmt.Errorf("%s缺少到达及出发时间", station.StationName)
+		}
+		arrTime, err := parseGTFSTime(arr)
+		if err != nil {
+			return nil, nil, err
+		}
+		depTime, err := parseGTFSTime(dep)
+		if err != nil {
+			return nil, nil, err
+		}
+		platform, _ := strconv.Atoi(row["platform"])
+		stopIDs = append(stopIDs, row["stop_id"])
+		t.Timetable = append(t.Timetable, Route{StationName: station.StationName, StationCode: station.StationCode, CityCode: station.CityCode,
+			ArrTime: arrTime, DepTime: depTime, Platform: uint8(platform), CheckTicketGate: row["check_ticket_gate"]})
+		if dist, err := strconv.ParseFloat(row["shape_dist_traveled"], 64); err == nil {
+			if i > 0 {
+				t.Timetable[i-1].MileageNext = float32(dist - lastDist)
+			}
+			lastDist = dist
+		}
+	}
+	return t, stopIDs, nil
+}
+
+// applyGTFSFare 将相邻车站之间的票价设置到对应路段，不是相邻车站时返回false
+// stopZones为时刻表中各站的票价区域
+func (t *TranInfo) applyGTFSFare(rule map[string]string, fare gtfsFare, stopZones []string) bool {
+	routeCount := len(t.Timetable) - 1
+	for i := 0; i < routeCount; i++ {
+		if stopZones[i] != rule["origin_id"] || stopZones[i+1] != rule["destination_id"] {
+			continue
+		}
+		if fare.berth == "" {
+			if t.SeatPriceMap == nil {
+				t.SeatPriceMap = make(map[string]([]int))
+			}
+			if t.SeatPriceMap[fare.seatType] == nil {
+				t.SeatPriceMap[fare.seatType] = make([]int, routeCount)
+			}
+			t.SeatPriceMap[fare.seatType][i] = fare.price
+			return true
+		}
+		if t.BerthPriceMap == nil {
+			t.BerthPriceMap = make(map[string](map[string]([]int)))
+		}
+		if t.BerthPriceMap[fare.seatType] == nil {
+			t.BerthPriceMap[fare.seatType] = make(map[string]([]int))
+		}
+		if t.BerthPriceMap[fare.seatType][fare.berth] == nil {
+			t.BerthPriceMap[fare.seatType][fare.berth] = make([]int, routeCount)
+		}
+		t.BerthPriceMap[fare.seatType][fare.berth][i] = fare.price
+		return true
+	}
+	return false
+}
+
+// isGTFSNewStationIssue 是否为待新增车站的“车站不存在”错误
+func isGTFSNewStationIssue(t *TranInfo, issue TranValidIssue, newStations map[string]*Station) bool {
+	for i, r := range t.Timetable {
+		if issue.Field == routeField(i, "stationName") && newStations[r.StationName] != nil &&
+			issue.Msg == routeName(t, i)+"不存在" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package modules
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestGTFSTime(t *testing.T) {
+	base := time.Date(1, 1, 1, 0, 0, 0, 0, time.Local)
+	cases := []string{"08:05:00", "23:59:30", "25:30:00", "49:00:00"}
+	for _, c := range cases {
+		tm, err := parseGTFSTime(c)
+		if err == nil && formatGTFSTime(tm, dateOnly(base)) == c {
+			t.Log("GTFS time " + c + " pass")
+		} else {
+			t.Error("GTFS time " + c + " fail")
+		}
+	}
+	if _, err := parseGTFSTime("08:60:00"); err != nil {
+		t.Log("GTFS time invalid pass")
+	} else {
+		t.Error("GTFS time invalid fail")
+	}
+}
+
+func TestGTFSRoundTrip(t *testing.T) {
+	at := func(day, hour, min int) time.Time {
+		return time.Date(1, 1, day, hour, min, 0, 0, time.Local)
+	}
+	tran := &TranInfo{TranNum: "Z1", CarIds: "1:6", ScheduleDays: 1, IsSaleTicket: true, Weekdays: 1<<time.Friday | 1<<time.Sunday,
+		EnableStartDate: time.Date(2018, 10, 1, 0, 0, 0, 0, time.Local),
+		EnableEndDate:   time.Date(2018, 12, 31, 23, 59, 59, 0, time.Local),
+		Timetable: []Route{
+			Route{StationName: "北京西", StationCode: "BXP", DepTime: at(1, 20, 0), MileageNext: 1200, CheckTicketGate: "A1", Platform: 3},
+			Route{StationName: "武昌", StationCode: "WCN", ArrTime: at(2, 5, 30), DepTime: at(2, 5, 40), MileageNext: 900.5},
+			Route{StationName: "广州", StationCode: "GZQ", ArrTime: at(2, 14, 0)},
+		},
+		SeatPriceMap:  map[string]([]int){constSeatTypeHardSleeper: []int{30050, 20000}},
+		BerthPriceMap: map[string](map[string]([]int)){constSeatTypeHardSleeper: {constBerthLower: []int{32050, 21000}}},
+		dateMap:       map[string]bool{"2018-10-01": true, "2018-10-05": false, "2019-01-01": true},
+	}
+	feed := gtfsFeed{}
+	feed.addTran(tran)
+	var buf bytes.Buffer
+	if err := feed.write(&buf); err != nil {
+		t.Fatal("write GTFS fail", err)
+	}
+	read, err := readGTFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil || len(read[constGTFSStopTimes]) != 3 || len(read[constGTFSFareRules]) != 4 {
+		t.Fatal("read GTFS fail", err)
+	}
+	if st := read[constGTFSStopTimes][2]; st["arrival_time"] == "38:00:00" && st["shape_dist_traveled"] == "2100.5" {
+		t.Log("export stop times pass")
+	} else {
+		t.Error("export stop times fail", st)
+	}
+
+	services, err := readGTFSServices(read)
+	s := services["Z1_20181001"]
+	if err == nil && s != nil && s.weekdays == tran.Weekdays && len(s.dates) == 2 && s.dates[0].Date == "2018-10-01" && s.dates[1].IsExclude {
+		t.Log("read services pass")
+	} else {
+		t.Error("read services fail", err, s)
+	}
+
+	stops := map[string]*Station{"BXP": &Station{StationName: "北京西"}, "WCN": &Station{StationName: "武昌"}, "GZQ": &Station{StationName: "广州"}}
+	trip := read[constGTFSTrips][0]
+	imported, stopIDs, err := buildGTFSTran(trip, nil, read[constGTFSStopTimes], stops, services, nil)
+	if err != nil {
+		t.Fatal("buildGTFSTran fail", err)
+	}
+	fares := make(map[string]gtfsFare)
+	for _, row := range read[constGTFSFareAttrs] {
+		fares[row["fare_id"]] = gtfsFare{price: map[string]int{"300.50": 30050, "200.00": 20000, "320.50": 32050, "210.00": 21000}[row["price"]],
+			seatType: row["seat_type"], berth: row["berth"]}
+	}
+	for _, rule := range read[constGTFSFareRules] {
+		if !imported.applyGTFSFare(rule, fares[rule["fare_id"]], stopIDs) {
+			t.Error("applyGTFSFare fail", rule)
+		}
+	}
+	diffs := diffTranInfo(tran, imported)
+	if imported.TranNum == "Z1" && imported.CarIds == "1:6" && imported.Timetable[1].MileageNext == 900.5 &&
+		len(diffs) == 0 && imported.Timetable[2].ArrTime.Equal(at(2, 14, 0)) {
+		t.Log("import round trip pass")
+	} else {
+		t.Error("import round trip fail", diffs)
+	}
+}
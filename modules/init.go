@@ -44,9 +44,18 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	initAdminAlert()
+	initRateLimit()
 	initStation()
 	initTranInfo()
 	initSchedule()
+	initTrainStatus()
+	initSeatQuota()
+	initPricing()
+	initCustomerNotify()
+	initOrderEvent()
+	initOrderTimeout()
+	initRefundRetry()
 	// 需要初始化用户数据，则取消下面一行代码的注释
 	// initUserInfos()
 }
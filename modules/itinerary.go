@@ -0,0 +1,319 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	constItineraryMaxLegs      = 4                // 联程订单的最多行程段数
+	constItineraryTransferTime = 20 * time.Minute // 换乘所需的最短时间
+	constItineraryRebookReason = "联程晚点免费改签"
+)
+
+const (
+	// 订单类型
+	constOrderTypeNormal    = iota // 普通订单
+	constOrderTypeItinerary        // 联程订单
+	constOrderTypeRoundTrip        // 往返订单
+)
+
+// trainArrDelay 列车到达某站的晚点时长，按上报的实时运行状态计算
+var trainArrDelay = getStopDelay
+
+// ItineraryOrderModel 提交联程订单的请求结构体，各行程段的乘客相同
+type ItineraryOrderModel struct {
+	UserID       uint64             `bson:"userID"`       // 用户ID
+	PassengerIDs []uint64           `bson:"passengerIDs"` // 乘客
+	IsStudent    bool               `bson:"isStudent"`    // 是否为学生票
+	Legs         []SubmitOrderModel `bson:"legs"`         // 各行程段，按乘车顺序排列
+}
+
+// itineraryLeg 联程或往返订单中的一个行程段
+type itineraryLeg struct {
+	tran       *TranInfo
+	st         *ScheduleTran
+	carIdxList []uint8
+	par        *SubmitOrderModel
+	cars       []*ScheduleCar
+	seats      []*ScheduleSeat
+	tickets    []*Ticket
+}
+
+// SubmitItinerary 提交联程订单，各行程段要么全部订票成功，要么全部失败
+// 涉及多个车次，不经过按车次排队的订票队列，原因见 EnqueueOrder
+func SubmitItinerary(m ItineraryOrderModel) (*Order, error) {
+	if len(m.Legs) < 2 || len(m.Legs) > constItineraryMaxLegs {
+		return nil, errors.New("联程订单的行程段数无效")
+	}
+	legs, err := newItineraryLegs(m.UserID, m.PassengerIDs, m.IsStudent, m.Legs)
+	if err != nil {
+		return nil, err
+	}
+	if err = validConnections(legs); err != nil {
+		return nil, err
+	}
+	return submitLegs(m.UserID, legs, constOrderTypeItinerary)
+}
+
+// submitLegs 为各行程段占座并创建同一个订单，共用一个订单号，一次支付
+func submitLegs(userID uint64, legs []*itineraryLeg, orderType uint8) (*Order, error) {
+	now := time.Now()
+	for _, leg := range legs {
+		if err := checkScalping(leg.par, now); err != nil {
+			return nil, err
+		}
+	}
+	// 按排班排序后加锁，避免与其它联程订单交叉加锁
+	sts := make([]*ScheduleTran, 0, len(legs))
+	for _, leg := range legs {
+		sts = append(sts, leg.st)
+	}
+	defer rLockSchedules(sts...)()
+	decisions := make([]*priceDecision, len(legs))
+	for i, leg := range legs {
+		decisions[i] = getOrderPriceDecision(leg.tran, leg.st, leg.par, now)
+		if orderType == constOrderTypeRoundTrip {
+			decisions[i] = withRoundTripDiscount(decisions[i])
+		}
+	}
+	if err := bookItinerarySeats(legs); err != nil {
+		return nil, err
+	}
+	o := &Order{
+		ID:        getOrderID(userID),
+		OrderNum:  "", // TODO: 订单号生成器需返回一个全局唯一订单号
+		UserID:    userID,
+		BookTime:  now,
+		Status:    constOrderUnpay,
+		OrderType: orderType,
+	}
+	var tickets []*Ticket
+	var audits []*PriceAudit
+	for i, leg := range legs {
+		audits = append(audits, applyDynamicPrice(leg.tickets, decisions[i], now)...)
+		if err := applyFares(leg.tran, leg.par, leg.tickets); err != nil {
+			releaseItinerarySeats(legs)
+			return nil, err
+		}
+		tickets = append(tickets, leg.tickets...)
+	}
+	for i := 0; i < len(tickets); i++ {
+		tickets[i].ID = getTicketID(tickets[i].PassengerID)
+		tickets[i].OrderID = o.ID
+		o.Price += tickets[i].Price
+	}
+	if orderType == constOrderTypeRoundTrip {
+		pairRoundTripTickets(legs[0].tickets, legs[1].tickets)
+	}
+	fillPriceAudits(tickets, audits)
+	if err := createOrder(o, tickets, audits); err != nil {
+		releaseItinerarySeats(legs)
+		return nil, err
+	}
+	for _, leg := range legs {
+		leg.st.hasChanged = true
+	}
+	return o, nil
+}
+
+// newItineraryLegs 校验各行程段，并初始化其车次及排班信息，各行程段的乘客相同
+func newItineraryLegs(userID uint64, passengerIDs []uint64, isStudent bool, pars []SubmitOrderModel) ([]*itineraryLeg, error) {
+	if len(passengerIDs) == 0 {
+		return nil, errors.New("请选择乘客")
+	}
+	legs := make([]*itineraryLeg, len(pars))
+	for i := range pars {
+		par := &pars[i]
+		par.UserID, par.PassengerIDs, par.IsStudent, par.IsPortion = userID, passengerIDs, isStudent, false
+		tran, err := submitOrderValid(par.UserID, par.TranNum, par.Date)
+		if err != nil {
+			return nil, err
+		}
+		st := scheduleCache.getScheduleTran(par.TranNum, par.Date)
+		if err = validBookingRange(tran, st, par.DepIdx, par.ArrIdx); err != nil {
+			return nil, fmt.Errorf("第%d程%v", i+1, err)
+		}
+		carIdxList, exist := tran.carTypeIdxMap[par.SeatType]
+		if !exist {
+			return nil, fmt.Errorf("第%d程所选席别无效", i+1)
+		}
+		par.init(tran)
+		legs[i] = &itineraryLeg{
+			tran:       tran,
+			st:         st,
+			carIdxList: carIdxList,
+			par:        par,
+		}
+	}
+	return legs, nil
+}
+
+// validConnections 校验相邻行程段能否换乘：换乘站在同一城市，且换乘时间充足
+func validConnections(legs []*itineraryLeg) error {
+	for i := 1; i < len(legs); i++ {
+		prev, cur := legs[i-1], legs[i]
+		if prev.tran.Timetable[prev.par.ArrIdx].CityCode != cur.tran.Timetable[cur.par.DepIdx].CityCode {
+			return fmt.Errorf("第%d程的出发站与上一程的到达站不在同一城市", i+1)
+		}
+		if isConnectionBroken(prev.par.arrTime, cur.par.depTime) {
+			return fmt.Errorf("第%d程的换乘时间不足", i+1)
+		}
+	}
+	return nil
+}
+
+// isConnectionBroken 上一程到达后，是否已来不及换乘下一程
+func isConnectionBroken(prevArrTime, nextDepTime time.Time) bool {
+	return nextDepTime.Sub(prevArrTime) < constItineraryTransferTime
+}
+
+// bookItinerarySeats 为所有乘客在各行程段占座，任一乘客在任一行程段无票时释放全部已占用的座位
+func bookItinerarySeats(legs []*itineraryLeg) error {
+	for li, leg := range legs {
+		if err := leg.st.checkSuspended(leg.par.DepIdx, leg.par.ArrIdx); err != nil {
+			releaseItinerarySeats(legs)
+			return fmt.Errorf("第%d程%v", li+1, err)
+		}
+		for _, pid := range leg.par.PassengerIDs {
+			if hasTimeConflict(pid, leg.par.depTime, leg.par.arrTime) {
+				releaseItinerarySeats(legs)
+				return errors.New("乘车人时间冲突")
+			}
+			car, seat, seatIdx, isMedley, ok := bookSeat(leg.st, leg.carIdxList, leg.par)
+			if !ok {
+				releaseItinerarySeats(legs)
+				return fmt.Errorf("第%d程没有足够的票", li+1)
+			}
+			leg.cars = append(leg.cars, car)
+			leg.seats = append(leg.seats, seat)
+			t := buildTicket(leg.tran, car, leg.par, seatIdx, pid, isMedley)
+			t.LegIdx = uint8(li + 1)
+			leg.tickets = append(leg.tickets, t)
+		}
+	}
+	return nil
+}
+
+// releaseItinerarySeats 释放各行程段已在内存中占用的座位
+func releaseItinerarySeats(legs []*itineraryLeg) {
+	for _, leg := range legs {
+		releaseBookedSeats(leg.cars, leg.seats, leg.par)
+		leg.cars, leg.seats, leg.tickets = nil, nil, nil
+	}
+}
+
+// RebookItineraryLeg 联程订单中上一程晚点导致无法换乘时，免费改签后续的行程段
+// 改签后的车票仍属于原订单，按原票价收费，不退还或补交差额
+func RebookItineraryLeg(par SubmitOrderModel, oldTicketID uint64) error {
+	oldTicket := &Ticket{ID: oldTicketID}
+	db.First(oldTicket)
+	if oldTicket.LegIdx <= 1 {
+		return errors.New("只有联程订单的后续行程可以免费改签")
+	}
+	if oldTicket.Status != constTicketPaid && oldTicket.Status != constTicketIssued &&
+		oldTicket.Status != constTicketChangePaid && oldTicket.Status != constTicketChangeIssued {
+		return errors.New("车票状态无法改签")
+	}
+	o := &Order{ID: oldTicket.OrderID}
+	db.First(o)
+	if o.UserID != par.UserID || o.OrderType != constOrderTypeItinerary {
+		return errors.New("订单无效")
+	}
+	prevTicket := &Ticket{}
+	validTicketStatus := []uint8{constTicketPaid, constTicketIssued, constTicketChangePaid, constTicketChangeIssued}
+	db.Where("order_id = ? and passenger_id = ? and leg_idx = ? and status in (?)",
+		oldTicket.OrderID, oldTicket.PassengerID, oldTicket.LegIdx-1, validTicketStatus).First(prevTicket)
+	if prevTicket.ID == 0 {
+		return errors.New("未找到上一程车票")
+	}
+	prevArrTime := prevTicket.ArrTime.Add(trainArrDelay(prevTicket.TranNum, prevTicket.TranDepDate, prevTicket.ArrStationIdx))
+	if !isConnectionBroken(prevArrTime, oldTicket.DepTime) {
+		return errors.New("上一程未晚点至无法换乘，请办理普通改签")
+	}
+	oldDate, _ := time.Parse(ConstYmdFormat, oldTicket.TranDepDate)
+	oldTran, exist := getTranInfo(oldTicket.TranNum, oldDate)
+	if !exist {
+		return errors.New("原车次信息不存在")
+	}
+	date, err := time.Parse(ConstYmdFormat, par.Date)
+	if err != nil {
+		return errors.New("日期无效")
+	}
+	tran, exist := getTranInfo(par.TranNum, date)
+	if !exist {
+		return errors.New("车次信息不存在")
+	}
+	scheduleTran := scheduleCache.getScheduleTran(par.TranNum, par.Date)
+	if err = validBookingRange(tran, scheduleTran, par.DepIdx, par.ArrIdx); err != nil {
+		return err
+	}
+	par.PassengerIDs, par.IsStudent, par.IsPortion = []uint64{oldTicket.PassengerID}, oldTicket.IsStudent, false
+	par.init(tran)
+	if tran.Timetable[par.DepIdx].CityCode != oldTran.Timetable[oldTicket.DepStationIdx].CityCode ||
+		tran.Timetable[par.ArrIdx].CityCode != oldTran.Timetable[oldTicket.ArrStationIdx].CityCode {
+		return errors.New("改签后的起止站须与原车票在同一城市")
+	}
+	if isConnectionBroken(prevArrTime, par.depTime) {
+		return errors.New("改签后的车次换乘时间不足")
+	}
+	if hasTimeConflictInChange(oldTicket.PassengerID, oldTicket.ID, par.depTime, par.arrTime) {
+		return errors.New("乘车人时间冲突")
+	}
+	carIdxList, exist := tran.carTypeIdxMap[par.SeatType]
+	if !exist {
+		return errors.New("所选席别无效")
+	}
+	// 新旧排班按固定顺序加锁，避免与反向的改签交叉等待
+	oldScheduleTran := scheduleCache.getScheduleTran(oldTicket.TranNum, oldTicket.TranDepDate)
+	defer rLockSchedules(scheduleTran, oldScheduleTran)()
+	if err = scheduleTran.checkSuspended(par.DepIdx, par.ArrIdx); err != nil {
+		return err
+	}
+	car, seat, seatIdx, isMedley, ok := bookSeat(scheduleTran, carIdxList, &par)
+	if !ok {
+		return errors.New("没有足够的票")
+	}
+	now := time.Now()
+	newTicket := buildTicket(tran, car, &par, seatIdx, oldTicket.PassengerID, isMedley)
+	newTicket.ID = getTicketID(oldTicket.PassengerID)
+	newTicket.OrderID = oldTicket.OrderID
+	newTicket.LegIdx = oldTicket.LegIdx
+	newTicket.ChangeTicketID = oldTicket.ID
+	newTicket.Status = constTicketChangePaid
+	newTicket.Price, newTicket.FareRule = oldTicket.Price, oldTicket.FareRule
+	audit := &PriceAudit{BasePrice: oldTicket.Price, Rate: 1, Reasons: constItineraryRebookReason, CreateTime: now}
+	fillPriceAudits([]*Ticket{newTicket}, []*PriceAudit{audit})
+	oldTicket.Status = constTicketChanged
+	tx := db.Begin()
+	if tx.Error != nil {
+		releaseBookedSeats([]*ScheduleCar{car}, []*ScheduleSeat{seat}, &par)
+		return fmt.Errorf("改签失败: %v", tx.Error)
+	}
+	if err = tx.Create(newTicket).Error; err == nil {
+		if err = tx.Create(audit).Error; err == nil {
+			if err = tx.Save(oldTicket).Error; err == nil {
+				err = publishOrderEvent(tx, constEventTicketChanged, o.ID, newTicket.ID, o.UserID)
+			}
+		}
+	}
+	if err == nil {
+		err = tx.Commit().Error
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		releaseBookedSeats([]*ScheduleCar{car}, []*ScheduleSeat{seat}, &par)
+		return fmt.Errorf("改签失败: %v", err)
+	}
+	scheduleTran.hasChanged = true
+	// 改签成功后释放原车票的座位
+	if oldTicket.SeatType != constSeatTypeNoSeat {
+		seatBit := countSeatBit(oldTicket.DepStationIdx, oldTicket.ArrStationIdx)
+		oldScheduleTran.Cars[oldTicket.CarNum-1].Seats[oldTicket.SeatIdx].Release(seatBit)
+	}
+	oldScheduleTran.Cars[oldTicket.CarNum-1].releaseSeat(oldTicket.DepStationIdx, oldTicket.ArrStationIdx)
+	oldScheduleTran.hasChanged = true
+	return nil
+}
@@ -0,0 +1,124 @@
+package modules
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestLeg(depCity, arrCity string, depTime, arrTime time.Time) *itineraryLeg {
+	return &itineraryLeg{
+		tran: &TranInfo{Timetable: []Route{Route{CityCode: depCity}, Route{CityCode: arrCity}}},
+		par:  &SubmitOrderModel{DepIdx: 0, ArrIdx: 1, depTime: depTime, arrTime: arrTime, seatBit: countSeatBit(0, 1)},
+	}
+}
+
+func TestValidConnections(t *testing.T) {
+	day := time.Date(2018, 6, 30, 0, 0, 0, 0, time.Local)
+	first := newTestLeg("wuhan", "beijing", day.Add(21*time.Hour), day.Add(31*time.Hour))
+	cases := []struct {
+		name string
+		next *itineraryLeg
+		ok   bool
+	}{
+		{"connect", newTestLeg("beijing", "tianjin", day.Add(32*time.Hour), day.Add(33*time.Hour)), true},
+		{"short transfer", newTestLeg("beijing", "tianjin", day.Add(31*time.Hour+10*time.Minute), day.Add(33*time.Hour)), false},
+		{"depart before arrive", newTestLeg("beijing", "tianjin", day.Add(30*time.Hour), day.Add(33*time.Hour)), false},
+		{"other city", newTestLeg("shanghai", "tianjin", day.Add(32*time.Hour), day.Add(33*time.Hour)), false},
+	}
+	for _, c := range cases {
+		if err := validConnections([]*itineraryLeg{first, c.next}); (err == nil) == c.ok {
+			t.Log("validConnections " + c.name + " pass")
+		} else {
+			t.Error("validConnections " + c.name + " fail")
+		}
+	}
+}
+
+func TestReleaseItinerarySeats(t *testing.T) {
+	legs := []*itineraryLeg{
+		newTestLeg("wuhan", "beijing", time.Time{}, time.Time{}),
+		newTestLeg("beijing", "tianjin", time.Time{}, time.Time{}),
+	}
+	for _, leg := range legs {
+		car := &ScheduleCar{CarNum: 1, Seats: []ScheduleSeat{ScheduleSeat{SeatNum: "01A"}}}
+		seat, _, ok := car.getAvailableSeat(leg.par)
+		if !ok {
+			t.Fatal("book seat fail")
+		}
+		leg.cars, leg.seats = []*ScheduleCar{car}, []*ScheduleSeat{seat}
+	}
+	seats := []*ScheduleSeat{legs[0].seats[0], legs[1].seats[0]}
+	releaseItinerarySeats(legs)
+	if seats[0].SeatBit == 0 && seats[1].SeatBit == 0 && legs[0].cars == nil && legs[1].seats == nil {
+		t.Log("releaseItinerarySeats pass")
+	} else {
+		t.Error("releaseItinerarySeats fail")
+	}
+}
+
+// newTestTran 两站的测试车次，时刻为发车当天的时:分
+func newTestTran(id int, tranNum, depCity, arrCity string, dep, arr int) TranInfo {
+	return TranInfo{ID: id, TranNum: tranNum, CarIds: "1:1",
+		EnableStartDate: time.Date(2000, 1, 1, 0, 0, 0, 0, time.Local), EnableEndDate: time.Date(2100, 1, 1, 0, 0, 0, 0, time.Local),
+		Timetable: []Route{
+			Route{StationIndex: 0, StationName: depCity, CityCode: depCity, DepTime: time.Date(1, 1, 1, dep/100, dep%100, 0, 0, time.Local)},
+			Route{StationIndex: 1, StationName: arrCity, CityCode: arrCity, ArrTime: time.Date(1, 1, 1, arr/100, arr%100, 0, 0, time.Local)},
+		}}
+}
+
+// 支付联程订单后，上一程晚点导致无法换乘时免费改签第二程，需连接数据库
+func TestItineraryPayAndRebook(t *testing.T) {
+	defer storeTranSnapshot(getTranSnapshot())
+	oldDelay := trainArrDelay
+	defer func() { trainArrDelay = oldDelay }()
+	cars := map[int](Car){1: Car{ID: 1, SeatType: constSeatTypeSecondClass, Seats: []Seat{Seat{SeatNum: "01A"}, Seat{SeatNum: "01B"}}}}
+	c := cars[1]
+	trans := tranCfgs{
+		newTestTran(1, "G9001", "wuhan", "beijing", 800, 1000),
+		newTestTran(2, "G9002", "beijing", "tianjin", 1030, 1200),
+		newTestTran(3, "G9003", "beijing", "tianjin", 1300, 1400),
+	}
+	for i := range trans {
+		trans[i].initCarIdx(cars)
+	}
+	storeTranSnapshot(&tranSnapshot{carMap: cars, scheduleCarMap: map[int](*ScheduleCar){1: newScheduleCar(&c)}, tranInfos: trans})
+	day := time.Now().AddDate(0, 0, 1)
+	date := day.Format(ConstYmdFormat)
+	for i := range trans {
+		key := trans[i].TranNum + "_" + date
+		scheduleTranMap.Store(key, trans[i].newScheduleTran(day, trans[i].scheduleSignature()))
+		defer scheduleTranMap.Delete(key)
+	}
+
+	userID, passengerID := uint64(time.Now().UnixNano()), uint64(time.Now().UnixNano())
+	o, err := SubmitItinerary(ItineraryOrderModel{UserID: userID, PassengerIDs: []uint64{passengerID}, Legs: []SubmitOrderModel{
+		SubmitOrderModel{TranNum: "G9001", Date: date, DepIdx: 0, ArrIdx: 1, SeatType: constSeatTypeSecondClass},
+		SubmitOrderModel{TranNum: "G9002", Date: date, DepIdx: 0, ArrIdx: 1, SeatType: constSeatTypeSecondClass},
+	}})
+	if err != nil {
+		t.Fatal("SubmitItinerary fail", err)
+	}
+	if err = o.Payment(PayTypeAliPay, "test", o.Price); err != nil {
+		t.Fatal("Payment fail", err)
+	}
+	leg2 := &Ticket{}
+	db.Where("order_id = ? and leg_idx = ?", o.ID, 2).First(leg2)
+	if leg2.Status == constTicketPaid {
+		t.Log("payment ticket status pass")
+	} else {
+		t.Error("payment ticket status fail")
+	}
+
+	trainArrDelay = func(tranNum, date string, stationIdx uint8) time.Duration { return 2 * time.Hour }
+	par := SubmitOrderModel{UserID: userID, TranNum: "G9003", Date: date, DepIdx: 0, ArrIdx: 1, SeatType: constSeatTypeSecondClass}
+	if err = RebookItineraryLeg(par, leg2.ID); err != nil {
+		t.Error("RebookItineraryLeg fail", err)
+	}
+	newTicket := &Ticket{}
+	db.Where("change_ticket_id = ?", leg2.ID).First(newTicket)
+	if newTicket.OrderID == o.ID && newTicket.TranNum == "G9003" && newTicket.Status == constTicketChangePaid && newTicket.LegIdx == 2 {
+		t.Log("RebookItineraryLeg pass")
+	} else {
+		t.Error("RebookItineraryLeg ticket fail")
+	}
+}
@@ -1,6 +1,134 @@
 package modules
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 通知客户的事件
+	constNotifyBooked            = iota // 订票成功
+	constNotifyPaid                     // 支付成功
+	constNotifyOrderTimeout             // 订单超时
+	constNotifyRefunded                 // 退款完成
+	constNotifyChanged                  // 改签完成
+	constNotifyDepartureReminder        // 发车提醒
+	constNotifySuspended                // 列车停运
+	constNotifyStopChanged              // 检票口变更
+	constNotifySuspendCancelled         // 列车停运，未支付的订单已取消
+	constNotifyPlatformChanged          // 站台变更，检票口未变
+)
+
+const (
+	// 通知渠道
+	constNotifyChannelSMS   = iota // 短信
+	constNotifyChannelEmail        // 邮件
+)
+
+const (
+	// 待发通知状态
+	constOutboxPending = iota // 待发送
+	constOutboxSent           // 已发送
+	constOutboxFailed         // 重试后仍失败
+)
+
+const (
+	constOutboxMaxRetryTimes = 5                // 待发通知最大重试次数
+	constOutboxBatchSize     = 200              // 每次投递的待发通知数量
+	constOutboxInterval      = 10 * time.Second // 投递待发通知的时间间隔
+	constReminderInterval    = time.Minute      // 扫描需发车提醒车票的时间间隔
+)
+
+var (
+	// 当前生效的通知配置
+	notifySetting     = defaultNotifySetting
+	notifySettingLock sync.RWMutex
+	// 短信服务
+	smsProv smsProvider = &logSMSProvider{}
+	// 邮件服务
+	emailProv emailProvider = &logEmailProvider{}
+	// 通知模板，key为 事件_渠道
+	notifyTemplates     map[string]*NotifyTemplate
+	notifyTemplatesLock sync.RWMutex
+	// 上次扫描发车提醒时车票发车时间的截止时间，修改提前提醒的时长后从此时间继续扫描，不遗漏车票
+	lastReminderScanEnd time.Time
+)
+
+// NotifySetting 通知配置，只保存一条记录
+type NotifySetting struct {
+	ID                   uint64 `json:"id"`
+	DepartureRemindHours int    `json:"departureRemindHours"` // 发车前多少小时提醒乘客
+}
+
+var defaultNotifySetting = NotifySetting{DepartureRemindHours: 3}
+
+// GetNotifySetting 获取当前的通知配置
+func GetNotifySetting() NotifySetting {
+	notifySettingLock.RLock()
+	defer notifySettingLock.RUnlock()
+	return notifySetting
+}
+
+// Save 保存通知配置，保存后立即生效
+func (s *NotifySetting) Save() (bool, string) {
+	if s.DepartureRemindHours <= 0 {
+		return false, "发车提醒的提前时长必须大于零"
+	}
+	notifySettingLock.Lock()
+	defer notifySettingLock.Unlock()
+	s.ID = notifySetting.ID
+	var err error
+	if s.ID == 0 {
+		err = db.Create(s).Error
+	} else {
+		err = db.Save(s).Error
+	}
+	if err != nil {
+		return false, fmt.Sprintf("保存失败: %v", err)
+	}
+	notifySetting = *s
+	return true, ""
+}
+
+func initNotifySetting() {
+	setting := NotifySetting{}
+	db.First(&setting)
+	if setting.ID != 0 {
+		notifySettingLock.Lock()
+		notifySetting = setting
+		notifySettingLock.Unlock()
+	}
+}
+
+// 默认通知模板，数据库中有同事件同渠道的模板时以数据库为准
+var defaultNotifyTemplates = []NotifyTemplate{
+	{Event: constNotifyBooked, Channel: constNotifyChannelSMS, Content: "订单{orderNum}已提交，{passengerName} {depTime} {tranNum}次 {depStation}-{arrStation} {carNum}车{seatNum}，请在" + strconv.Itoa(constUnpayOrderAvaliableTime) + "分钟内完成支付。"},
+	{Event: constNotifyBooked, Channel: constNotifyChannelEmail, Subject: "订单{orderNum}已提交", Content: "{userName}您好：\n订单{orderNum}已提交，{passengerName} {depTime} {tranNum}次 {depStation}-{arrStation} {carNum}车{seatNum}（{seatType}），请在" + strconv.Itoa(constUnpayOrderAvaliableTime) + "分钟内完成支付。"},
+	{Event: constNotifyPaid, Channel: constNotifyChannelSMS, Content: "订单{orderNum}支付成功，{passengerName} {depTime} {tranNum}次 {depStation}-{arrStation} {carNum}车{seatNum}，检票口{checkTicketGate}。"},
+	{Event: constNotifyPaid, Channel: constNotifyChannelEmail, Subject: "订单{orderNum}支付成功", Content: "{userName}您好：\n订单{orderNum}支付成功，{passengerName} {depTime} {tranNum}次 {depStation}-{arrStation} {carNum}车{seatNum}（{seatType}），检票口{checkTicketGate}。"},
+	{Event: constNotifyOrderTimeout, Channel: constNotifyChannelSMS, Content: "订单{orderNum}超时未支付，已自动取消。"},
+	{Event: constNotifyOrderTimeout, Channel: constNotifyChannelEmail, Subject: "订单{orderNum}已取消", Content: "{userName}您好：\n订单{orderNum}（{passengerName} {depTime} {tranNum}次）超时未支付，已自动取消。"},
+	{Event: constNotifyRefunded, Channel: constNotifyChannelSMS, Content: "订单{orderNum}已退票，{passengerName} {depTime} {tranNum}次，票款将原路退回。"},
+	{Event: constNotifyRefunded, Channel: constNotifyChannelEmail, Subject: "订单{orderNum}已退票", Content: "{userName}您好：\n订单{orderNum}已退票，{passengerName} {depTime} {tranNum}次 {depStation}-{arrStation}，票款将原路退回。"},
+	{Event: constNotifyChanged, Channel: constNotifyChannelSMS, Content: "改签成功，{passengerName} {depTime} {tranNum}次 {depStation}-{arrStation} {carNum}车{seatNum}，检票口{checkTicketGate}。"},
+	{Event: constNotifyChanged, Channel: constNotifyChannelEmail, Subject: "订单{orderNum}改签成功", Content: "{userName}您好：\n改签成功，{passengerName} {depTime} {tranNum}次 {depStation}-{arrStation} {carNum}车{seatNum}（{seatType}），检票口{checkTicketGate}。"},
+	{Event: constNotifyDepartureReminder, Channel: constNotifyChannelSMS, Content: "{passengerName}乘坐的{tranNum}次列车将于{depTime}从{depStation}发车（{delay}），{carNum}车{seatNum}，检票口{checkTicketGate}，{platform}站台，请合理安排出行。"},
+	{Event: constNotifyDepartureReminder, Channel: constNotifyChannelEmail, Subject: "{tranNum}次列车发车提醒", Content: "{userName}您好：\n{passengerName}乘坐的{tranNum}次列车将于{depTime}从{depStation}发车（{delay}），{carNum}车{seatNum}，检票口{checkTicketGate}，{platform}站台，请合理安排出行。"},
+	{Event: constNotifySuspended, Channel: constNotifyChannelSMS, Content: "{passengerName} {depTime} {tranNum}次 {depStation}-{arrStation}因{remark}取消，订单{orderNum}中的车票已退，已支付的票款将全额原路退回。"},
+	{Event: constNotifySuspended, Channel: constNotifyChannelEmail, Subject: "{tranNum}次列车停运通知", Content: "{userName}您好：\n{passengerName} {depTime} {tranNum}次 {depStation}-{arrStation}因{remark}取消，订单{orderNum}中的车票已退，已支付的票款将全额原路退回，不收取手续费。"},
+	{Event: constNotifySuspendCancelled, Channel: constNotifyChannelSMS, Content: "{passengerName} {depTime} {tranNum}次 {depStation}-{arrStation}因{remark}取消，未支付的订单{orderNum}已取消，无需支付。"},
+	{Event: constNotifySuspendCancelled, Channel: constNotifyChannelEmail, Subject: "{tranNum}次列车停运通知", Content: "{userName}您好：\n{passengerName} {depTime} {tranNum}次 {depStation}-{arrStation}因{remark}取消，未支付的订单{orderNum}已取消，无需支付。"},
+	{Event: constNotifyStopChanged, Channel: constNotifyChannelSMS, Content: "{passengerName}乘坐的{depTime} {tranNum}次列车在{depStation}的检票口变更为{checkTicketGate}，{platform}站台（{delay}），请留意车站广播。"},
+	{Event: constNotifyStopChanged, Channel: constNotifyChannelEmail, Subject: "{tranNum}次列车检票口变更", Content: "{userName}您好：\n{passengerName}乘坐的{depTime} {tranNum}次列车在{depStation}的检票口变更为{checkTicketGate}，{platform}站台（{delay}），{carNum}车{seatNum}，请留意车站广播。"},
+	{Event: constNotifyPlatformChanged, Channel: constNotifyChannelSMS, Content: "{passengerName}乘坐的{depTime} {tranNum}次列车在{depStation}的站台变更为{platform}站台，检票口{checkTicketGate}（{delay}），请留意车站广播。"},
+	{Event: constNotifyPlatformChanged, Channel: constNotifyChannelEmail, Subject: "{tranNum}次列车站台变更", Content: "{userName}您好：\n{passengerName}乘坐的{depTime} {tranNum}次列车在{depStation}的站台变更为{platform}站台，检票口{checkTicketGate}（{delay}），{carNum}车{seatNum}，请留意车站广播。"},
+}
 
 type notifyCustomerInfo struct {
 	phoneNum        string    // 用户电话（短信提醒）
@@ -12,22 +140,372 @@ type notifyCustomerInfo struct {
 	carNum          string    // 车厢号
 	seatNum         string    // 座位号
 	seatType        string    // 席别
-	checkTicketGate string    // 检票口
+	checkTicketGate string    // 检票口，有调整时为调整后的检票口
+	platform        uint8     // 站台，有调整时为调整后的站台
+	delayMinutes    int       // 出发站预计晚点分钟数
 	depTime         time.Time // 乘车日期
 	depStation      string    // 出发站
 	arrStation      string    // 到达站
+	remark          string    // 说明，如停运原因
+}
+
+// render 用通知信息替换模板中的占位符
+func (n *notifyCustomerInfo) render(tpl string) string {
+	return strings.NewReplacer(
+		"{orderNum}", n.orderNum,
+		"{userName}", n.userName,
+		"{passengerName}", n.passengerName,
+		"{tranNum}", n.tranNum,
+		"{carNum}", n.carNum,
+		"{seatNum}", n.seatNum,
+		"{seatType}", n.seatType,
+		"{checkTicketGate}", n.checkTicketGate,
+		"{depTime}", n.depTime.Format(ConstYMdHmFormat),
+		"{depStation}", n.depStation,
+		"{arrStation}", n.arrStation,
+		"{remark}", n.remark,
+		"{platform}", strconv.Itoa(int(n.platform)),
+		"{delay}", n.getDelayText(),
+	).Replace(tpl)
+}
+
+// getDelayText 晚点说明
+func (n *notifyCustomerInfo) getDelayText() string {
+	if n.delayMinutes > 0 {
+		return "预计晚点" + strconv.Itoa(n.delayMinutes) + "分钟"
+	}
+	return "正点"
+}
+
+// notifyCustomer 按模板生成短信及邮件，写入待发通知表，由投递任务发送
+func (n *notifyCustomerInfo) notifyCustomer(event uint8, refID uint64) {
+	targets := map[uint8]string{constNotifyChannelSMS: n.phoneNum, constNotifyChannelEmail: n.emailAddr}
+	for channel, target := range targets {
+		if target == "" {
+			continue
+		}
+		tpl := getNotifyTemplate(event, channel)
+		if tpl == nil {
+			continue
+		}
+		db.Create(&CustomerNotifyOutbox{
+			Event:       event,
+			Channel:     channel,
+			RefID:       refID,
+			Target:      target,
+			Subject:     n.render(tpl.Subject),
+			Content:     n.render(tpl.Content),
+			Status:      constOutboxPending,
+			CreateTime:  time.Now(),
+			NextTryTime: time.Now(),
+		})
+	}
+}
+
+// buildNotifyCustomerInfo 根据订单与车票组装通知信息，通知发给订票的用户
+func buildNotifyCustomerInfo(o *Order, t *Ticket) *notifyCustomerInfo {
+	var user User
+	db.Where(&User{UID: o.UserID}).First(&user)
+	// 注册用户与其本人的乘客信息共用ID
+	var contact, passenger Passenger
+	db.Where(&Passenger{PID: o.UserID}).First(&contact)
+	db.Where(&Passenger{PID: t.PassengerID}).First(&passenger)
+	orderNum := o.OrderNum
+	if orderNum == "" {
+		orderNum = strconv.FormatUint(o.ID, 10)
+	}
+	// 检票口、站台及晚点按实时运行状态
+	live := buildTicketLiveInfo(t)
+	return &notifyCustomerInfo{
+		phoneNum:        contact.PhoneNum,
+		emailAddr:       contact.Email,
+		orderNum:        orderNum,
+		userName:        user.UserName,
+		passengerName:   passenger.Name,
+		tranNum:         t.TranNum,
+		carNum:          strconv.Itoa(int(t.CarNum)),
+		seatNum:         t.SeatNum,
+		seatType:        t.SeatType,
+		checkTicketGate: live.CheckTicketGate,
+		platform:        live.Platform,
+		delayMinutes:    live.DepDelayMinutes,
+		depTime:         t.DepTime,
+		depStation:      t.DepStation,
+		arrStation:      t.ArrStation,
+	}
+}
+
+// notifyOrderCustomer 订单中的每张车票各发一条通知
+func notifyOrderCustomer(o *Order, tickets []*Ticket, event uint8) {
+	for _, t := range tickets {
+		buildNotifyCustomerInfo(o, t).notifyCustomer(event, t.ID)
+	}
+}
+
+// notifyOrderEventCustomer 订阅订单事件后，按事件通知客户；事件带车票ID时只通知该车票
+func notifyOrderEventCustomer(event uint8) eventHandler {
+	return func(e *orderEvent) {
+		o := &Order{}
+		db.Where("id = ?", e.OrderID).First(o)
+		var tickets []*Ticket
+		if e.TicketID != 0 {
+			db.Where("id = ?", e.TicketID).Find(&tickets)
+		} else {
+			db.Where("order_id = ?", e.OrderID).Find(&tickets)
+		}
+		notifyOrderCustomer(o, tickets, event)
+	}
+}
+
+// NotifyTemplate 通知模板，支持的占位符：{orderNum} {userName} {passengerName} {tranNum} {carNum} {seatNum}
+// {seatType} {checkTicketGate} {platform} {delay} {depTime} {depStation} {arrStation} {remark}
+type NotifyTemplate struct {
+	ID      uint64 `json:"id"`
+	Event   uint8  `gorm:"index:main" json:"event"`            // 事件
+	Channel uint8  `json:"channel"`                            // 渠道 0.短信 1.邮件
+	Subject string `gorm:"type:nvarchar(100)" json:"subject"`  // 邮件标题，短信无标题
+	Content string `gorm:"type:nvarchar(1000)" json:"content"` // 内容
+}
+
+func getNotifyTemplateKey(event, channel uint8) string {
+	return strconv.Itoa(int(event)) + "_" + strconv.Itoa(int(channel))
+}
+
+func getNotifyTemplate(event, channel uint8) *NotifyTemplate {
+	notifyTemplatesLock.RLock()
+	defer notifyTemplatesLock.RUnlock()
+	return notifyTemplates[getNotifyTemplateKey(event, channel)]
+}
+
+// GetNotifyTemplates 获取所有通知模板
+func GetNotifyTemplates() []NotifyTemplate {
+	notifyTemplatesLock.RLock()
+	defer notifyTemplatesLock.RUnlock()
+	result := make([]NotifyTemplate, 0, len(notifyTemplates))
+	for _, tpl := range defaultNotifyTemplates {
+		result = append(result, *notifyTemplates[getNotifyTemplateKey(tpl.Event, tpl.Channel)])
+	}
+	return result
+}
+
+// Save 保存通知模板，保存后立即生效
+func (tpl *NotifyTemplate) Save() (bool, string) {
+	if getNotifyTemplate(tpl.Event, tpl.Channel) == nil {
+		return false, "通知事件或渠道无效"
+	}
+	if strings.TrimSpace(tpl.Content) == "" {
+		return false, "模板内容不能为空"
+	}
+	exist := &NotifyTemplate{}
+	db.Where("event = ? and channel = ?", tpl.Event, tpl.Channel).First(exist)
+	tpl.ID = exist.ID
+	if tpl.ID == 0 {
+		db.Create(tpl)
+	} else {
+		db.Save(tpl)
+	}
+	notifyTemplatesLock.Lock()
+	saved := *tpl
+	notifyTemplates[getNotifyTemplateKey(tpl.Event, tpl.Channel)] = &saved
+	notifyTemplatesLock.Unlock()
+	return true, ""
+}
+
+func initNotifyTemplates() {
+	notifyTemplates = make(map[string]*NotifyTemplate, len(defaultNotifyTemplates))
+	for i := 0; i < len(defaultNotifyTemplates); i++ {
+		tpl := defaultNotifyTemplates[i]
+		notifyTemplates[getNotifyTemplateKey(tpl.Event, tpl.Channel)] = &tpl
+	}
+	var tpls []NotifyTemplate
+	db.Find(&tpls)
+	for i := 0; i < len(tpls); i++ {
+		key := getNotifyTemplateKey(tpls[i].Event, tpls[i].Channel)
+		if _, ok := notifyTemplates[key]; ok {
+			notifyTemplates[key] = &tpls[i]
+		}
+	}
+}
+
+// CustomerNotifyOutbox 待发通知，先落库再投递，投递失败时按次数退避重试，保证通知不丢失
+type CustomerNotifyOutbox struct {
+	ID          uint64
+	Event       uint8     // 事件
+	Channel     uint8     // 渠道
+	RefID       uint64    `gorm:"index:ref"`           // 关联的车票ID
+	Target      string    `gorm:"type:varchar(100)"`   // 手机号或邮箱
+	Subject     string    `gorm:"type:nvarchar(100)"`  // 标题
+	Content     string    `gorm:"type:nvarchar(1000)"` // 内容
+	Status      uint8     `gorm:"index:main"`          // 状态 0.待发送 1.已发送 2.失败
+	RetryTimes  uint8     // 重试次数
+	LastErr     string    `gorm:"type:nvarchar(200)"` // 最近一次失败的原因
+	CreateTime  time.Time `gorm:"type:datetime"`      // 创建时间
+	NextTryTime time.Time `gorm:"type:datetime"`      // 下次投递时间
+	SentTime    time.Time `gorm:"type:datetime"`      // 发送成功时间
+}
+
+func (m *CustomerNotifyOutbox) deliver() error {
+	switch m.Channel {
+	case constNotifyChannelSMS:
+		return smsProv.sendSMS(m.Target, m.Content)
+	case constNotifyChannelEmail:
+		return emailProv.sendEmail(m.Target, m.Subject, m.Content)
+	}
+	return errors.New("unknown notify channel")
+}
+
+// deliverOutbox 投递到期的待发通知
+func deliverOutbox() {
+	var list []CustomerNotifyOutbox
+	db.Where("status = ? and next_try_time <= ?", constOutboxPending, time.Now()).Order("id").Limit(constOutboxBatchSize).Find(&list)
+	for i := 0; i < len(list); i++ {
+		m := &list[i]
+		if err := m.deliver(); err != nil {
+			m.RetryTimes++
+			m.LastErr = err.Error()
+			// 按重试次数的平方退避，单位：分钟
+			m.NextTryTime = time.Now().Add(time.Duration(m.RetryTimes) * time.Duration(m.RetryTimes) * time.Minute)
+			if m.RetryTimes >= constOutboxMaxRetryTimes {
+				m.Status = constOutboxFailed
+				notify := &notifyAdminInfo{
+					date:       time.Now().Format(ConstYmdFormat),
+					refID:      m.ID,
+					notifyType: "customer-notify",
+					severity:   constAlertSeverityError,
+					message:    fmt.Sprintf("Customer Notify %d To %s Failed: %s", m.ID, m.Target, m.LastErr)}
+				notify.notifyAdmin()
+			}
+		} else {
+			m.Status = constOutboxSent
+			m.SentTime = time.Now()
+		}
+		db.Save(m)
+	}
+}
+
+// remindDeparture 给即将发车的已支付车票发送提醒，已提醒过的车票不再提醒
+func remindDeparture(now time.Time) {
+	start, end := getReminderScanRange(lastReminderScanEnd, now, GetNotifySetting().DepartureRemindHours)
+	if !start.Before(end) {
+		return
+	}
+	lastReminderScanEnd = end
+	validTicketStatus := []uint8{constTicketPaid, constTicketIssued, constTicketChangePaid, constTicketChangeIssued}
+	var tickets []*Ticket
+	db.Where("status in (?) and dep_time >= ? and dep_time < ?", validTicketStatus, start, end).Find(&tickets)
+	for _, t := range tickets {
+		count := 0
+		db.Model(&CustomerNotifyOutbox{}).Where("ref_id = ? and event = ?", t.ID, constNotifyDepartureReminder).Count(&count)
+		if count != 0 {
+			continue
+		}
+		o := &Order{}
+		db.Where("id = ?", t.OrderID).First(o)
+		buildNotifyCustomerInfo(o, t).notifyCustomer(constNotifyDepartureReminder, t.ID)
+	}
+}
+
+// getReminderScanRange 本次需提醒车票的发车时间范围，从上次扫描的截止时间继续；
+// 首次扫描从当前时间开始，缩短提前提醒的时长后已提醒过的范围不再扫描
+func getReminderScanRange(lastEnd, now time.Time, remindHours int) (start, end time.Time) {
+	start, end = lastEnd, now.Add(time.Duration(remindHours)*time.Hour)
+	if start.IsZero() {
+		start = now
+	}
+	return
+}
+
+func initCustomerNotify() {
+	initNotifyTemplates()
+	initNotifySetting()
+	orderEventBus.subscribe(constEventOrderPaid, notifyOrderEventCustomer(constNotifyPaid))
+	orderEventBus.subscribe(constEventOrderTimedOut, notifyOrderEventCustomer(constNotifyOrderTimeout))
+	orderEventBus.subscribe(constEventTicketRefunded, func(e *orderEvent) {
+		// 因列车停运退票的车票已发送停运通知
+		t := &Ticket{}
+		db.Where("id = ?", e.TicketID).First(t)
+		if !isTicketSuspended(t) {
+			notifyOrderEventCustomer(constNotifyRefunded)(e)
+		}
+	})
+	orderEventBus.subscribe(constEventTicketChanged, notifyOrderEventCustomer(constNotifyChanged))
+	orderEventBus.subscribe(constEventOrderCreated, func(e *orderEvent) {
+		// 改签产生的订单由 TicketChanged 事件通知
+		count := 0
+		db.Model(&Ticket{}).Where("order_id = ? and change_ticket_id != 0", e.OrderID).Count(&count)
+		if count == 0 {
+			notifyOrderEventCustomer(constNotifyBooked)(e)
+		}
+	})
+	go func() {
+		outboxTicker, reminderTicker := time.Tick(constOutboxInterval), time.Tick(constReminderInterval)
+		for {
+			select {
+			case <-outboxTicker:
+				deliverOutbox()
+			case now := <-reminderTicker:
+				remindDeparture(now)
+			}
+		}
+	}()
+}
+
+// smsProvider 短信服务
+type smsProvider interface {
+	sendSMS(phoneNum, content string) error
+}
+
+// emailProvider 邮件服务
+type emailProvider interface {
+	sendEmail(addr, subject, content string) error
+}
+
+// logSMSProvider 仅输出日志的短信服务，未接入短信平台时使用
+type logSMSProvider struct{}
+
+func (p *logSMSProvider) sendSMS(phoneNum, content string) error {
+	log.Printf("sms to %s: %s\n", phoneNum, content)
+	return nil
+}
+
+// logEmailProvider 仅输出日志的邮件服务，未配置邮件服务器时使用
+type logEmailProvider struct{}
+
+func (p *logEmailProvider) sendEmail(addr, subject, content string) error {
+	log.Printf("email to %s: %s %s\n", addr, subject, content)
+	return nil
+}
+
+// smtpEmailProvider 通过smtp发送邮件
+type smtpEmailProvider struct {
+	addr string    // smtp 服务地址 host:port
+	auth smtp.Auth // 认证信息
+	from string    // 发件人
+}
+
+func (p *smtpEmailProvider) sendEmail(addr, subject, content string) error {
+	msg := "From: " + p.from + "\r\n" +
+		"To: " + addr + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n\r\n" +
+		content + "\r\n"
+	return smtp.SendMail(p.addr, p.auth, p.from, []string{addr}, []byte(msg))
 }
 
 type notifyAdminInfo struct {
 	date       string // 日期
 	tranNum    string // 车次
 	carNum     uint8  // 车厢号
+	seatNum    string // 座位号
 	depStation string // 出发站
 	arrStation string // 到达站
 	notifyType string // 通知类型
+	severity   uint8  // 告警级别
 	message    string // 消息说明
+	refID      uint64 // 关联记录的ID，如待发通知、退款记录，不同记录的告警分别去重
 }
 
+// notifyAdmin 通知管理员，重复的告警会被合并
 func (n *notifyAdminInfo) notifyAdmin() {
-
+	raiseAdminAlert(n)
 }
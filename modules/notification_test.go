@@ -1,2 +1,101 @@
 package modules
 
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memorySMSProvider struct {
+	phoneNums []string
+	err       error
+}
+
+func (p *memorySMSProvider) sendSMS(phoneNum, content string) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.phoneNums = append(p.phoneNums, phoneNum)
+	return nil
+}
+
+func TestNotifyCustomerRender(t *testing.T) {
+	depTime, _ := time.Parse(ConstYMdHmFormat, "2018-01-01 13:08")
+	n := &notifyCustomerInfo{
+		orderNum:        "1001",
+		passengerName:   "张三",
+		tranNum:         "G1",
+		carNum:          "3",
+		seatNum:         "01A",
+		checkTicketGate: "A5",
+		depTime:         depTime,
+		depStation:      "北京南",
+		arrStation:      "上海虹桥",
+	}
+	result := n.render("{passengerName} {depTime} {tranNum}次 {depStation}-{arrStation} {carNum}车{seatNum} 检票口{checkTicketGate} 订单{orderNum}")
+	if result == "张三 2018-01-01 13:08 G1次 北京南-上海虹桥 3车01A 检票口A5 订单1001" {
+		t.Log("render pass")
+	} else {
+		t.Error("render fail")
+	}
+}
+
+func TestNotifyTemplates(t *testing.T) {
+	initNotifyTemplates()
+	if len(GetNotifyTemplates()) == len(defaultNotifyTemplates) {
+		t.Log("GetNotifyTemplates pass")
+	} else {
+		t.Error("GetNotifyTemplates fail")
+	}
+	tpl := getNotifyTemplate(constNotifyDepartureReminder, constNotifyChannelEmail)
+	if tpl != nil && strings.Contains(tpl.Subject, "{tranNum}") {
+		t.Log("getNotifyTemplate pass")
+	} else {
+		t.Error("getNotifyTemplate fail")
+	}
+	if ok, _ := (&NotifyTemplate{Event: 99, Channel: constNotifyChannelSMS, Content: "test"}).Save(); !ok {
+		t.Log("Save invalid event pass")
+	} else {
+		t.Error("Save invalid event fail")
+	}
+}
+
+func TestOutboxDeliver(t *testing.T) {
+	oldProv := smsProv
+	defer func() { smsProv = oldProv }()
+	prov := &memorySMSProvider{}
+	smsProv = prov
+	m := &CustomerNotifyOutbox{Channel: constNotifyChannelSMS, Target: "13800000000", Content: "test"}
+	if err := m.deliver(); err == nil && len(prov.phoneNums) == 1 {
+		t.Log("deliver pass")
+	} else {
+		t.Error("deliver fail")
+	}
+	prov.err = errors.New("sms gateway down")
+	if err := m.deliver(); err != nil {
+		t.Log("deliver error pass")
+	} else {
+		t.Error("deliver error fail")
+	}
+}
+
+func TestReminderScanRange(t *testing.T) {
+	now := time.Date(2018, 10, 1, 8, 0, 0, 0, time.Local)
+	if start, end := getReminderScanRange(time.Time{}, now, 3); start.Equal(now) && end.Equal(now.Add(3*time.Hour)) {
+		t.Log("first scan pass")
+	} else {
+		t.Error("first scan fail")
+	}
+	lastEnd := now.Add(3 * time.Hour)
+	if start, end := getReminderScanRange(lastEnd, now.Add(time.Minute), 5); start.Equal(lastEnd) && end.Equal(now.Add(5*time.Hour+time.Minute)) {
+		t.Log("longer remind hours pass")
+	} else {
+		t.Error("longer remind hours fail")
+	}
+	if start, end := getReminderScanRange(lastEnd, now.Add(time.Minute), 1); !start.Before(end) {
+		t.Log("shorter remind hours pass")
+	} else {
+		t.Error("shorter remind hours fail")
+	}
+}
@@ -2,7 +2,10 @@ package modules
 
 import (
 	"errors"
+	"fmt"
 	"time"
+
+	"github.com/jinzhu/gorm"
 )
 
 const (
@@ -27,6 +30,7 @@ const (
 	constTicketChangeRefund        // 改签票已退票
 	constTicketChangeIssued        // 改签票已出票
 	constTicketExpired             // 已过期（旅程已结束）
+	constTicketCancelled           // 订单取消或超时
 )
 
 var (
@@ -62,6 +66,7 @@ type Order struct {
 	PayType    uint8     // 支付类型 1.支付宝 2.微信
 	PayAccount string    // 支付账户
 	Status     uint8     // 订单状态 0.未支付 1.已取消 2.订单超时 3.已支付 4.已退票
+	OrderType  uint8     // 订单类型 0.普通订单 1.联程订单 2.往返订单
 }
 
 // GetOrderInfo 获取订单信息
@@ -97,6 +102,17 @@ func submitOrderValid(userID uint64, tranNum, date string) (*TranInfo, error) {
 	return tran, nil
 }
 
+// validBookingRange 校验乘车区间在车次的路线内，且所选日期的车次已排班
+func validBookingRange(tran *TranInfo, st *ScheduleTran, depIdx, arrIdx uint8) error {
+	if depIdx >= arrIdx || int(arrIdx) >= len(tran.Timetable) {
+		return errors.New("乘车区间无效")
+	}
+	if st.TranNum == "" || len(st.Cars) == 0 {
+		return errors.New("该日期的车次未排班")
+	}
+	return nil
+}
+
 // SubmitOrderModel 提交订单的请求结构体
 type SubmitOrderModel struct {
 	UserID       uint64   `bson:"userID"`       // 用户ID
@@ -108,6 +124,7 @@ type SubmitOrderModel struct {
 	IsPortion    bool     `bson:"isPortion"`    // 是否部分提交
 	IsStudent    bool     `bson:"isStudent"`    // 是否为学生票
 	SeatType     string   `bson:"seatType"`     // 席别
+	QuoteID      string   `bson:"quoteID"`      // 报价ID，报价有效期内按报价收费
 
 	depTime time.Time // 乘车时间
 	arrTime time.Time // 到达时间
@@ -129,61 +146,133 @@ func (m *SubmitOrderModel) init(tran *TranInfo) {
 
 // SubmitOrder 订票
 func SubmitOrder(par SubmitOrderModel) error {
+	_, err := submitOrder(par)
+	return err
+}
+
+// submitOrder 订票，成功时返回订单
+func submitOrder(par SubmitOrderModel) (*Order, error) {
 	tran, err := submitOrderValid(par.UserID, par.TranNum, par.Date)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err = checkScalping(&par, time.Now()); err != nil {
+		return nil, err
 	}
 	// 排班信息
 	scheduleTran := scheduleCache.getScheduleTran(par.TranNum, par.Date)
+	if err = validBookingRange(tran, scheduleTran, par.DepIdx, par.ArrIdx); err != nil {
+		return nil, err
+	}
 	carIdxList, exist := tran.carTypeIdxMap[par.SeatType]
 	if !exist {
-		return errors.New("所选席别无效")
+		return nil, errors.New("所选席别无效")
 	}
 	par.init(tran)
+	// 占座到车票落库期间，不允许余票核验修复排班
+	scheduleTran.repairLock.RLock()
+	defer scheduleTran.repairLock.RUnlock()
+	if err = scheduleTran.checkSuspended(par.DepIdx, par.ArrIdx); err != nil {
+		return nil, err
+	}
+	// 上座率按占座前计算
+	now := time.Now()
+	priceDecision := getOrderPriceDecision(tran, scheduleTran, &par, now)
 	tickets := make([]*Ticket, 0, par.pLen)
 	cars := make([]*ScheduleCar, 0, par.pLen)
 	seats := make([]*ScheduleSeat, 0, par.pLen)
 	for i := 0; i < par.pLen; i++ {
 		if hasTimeConflict(par.PassengerIDs[i], par.depTime, par.arrTime) {
-			return errors.New("乘车人时间冲突")
+			releaseBookedSeats(cars, seats, &par)
+			return nil, errors.New("乘车人时间冲突")
 		}
 		car, seat, seatIdx, isMedley, ok := bookSeat(scheduleTran, carIdxList, &par)
-		if ok {
-			cars = append(cars, car)
-			seats = append(seats, seat)
-		} else if !par.IsPortion {
-			// 无票 且要求全部提交时，释放已占用的资源 直接返回
-			for i := 0; i < len(cars); i++ {
-				// 非站票才需要释放席位的资源
-				if seats[i].SeatNum != "" {
-					seats[i].Release(par.seatBit)
-				}
-				cars[i].releaseSeat(par.DepIdx, par.ArrIdx)
+		if !ok {
+			if par.IsPortion {
+				// 部分提交时，无票的乘客不出票
+				continue
 			}
-			return errors.New("没有足够的票")
+			// 无票 且要求全部提交时，释放已占用的资源 直接返回
+			releaseBookedSeats(cars, seats, &par)
+			return nil, errors.New("没有足够的票")
 		}
+		cars = append(cars, car)
+		seats = append(seats, seat)
 		tickets = append(tickets, buildTicket(tran, car, &par, seatIdx, par.PassengerIDs[i], isMedley))
 	}
+	if len(tickets) == 0 {
+		return nil, errors.New("没有足够的票")
+	}
 	o := &Order{
 		ID:       getOrderID(par.UserID),
 		OrderNum: "", // TODO: 订单号生成器需返回一个全局唯一订单号
 		UserID:   par.UserID,
-		BookTime: time.Now(),
+		BookTime: now,
 		Status:   constOrderUnpay,
 	}
-	ticketIDs := getMultiTicketID(par.PassengerIDs)
+	audits := applyDynamicPrice(tickets, priceDecision, now)
+	if err = applyFares(tran, &par, tickets); err != nil {
+		releaseBookedSeats(cars, seats, &par)
+		return nil, err
+	}
 	for i := 0; i < len(tickets); i++ {
-		tickets[i].ID = ticketIDs[i]
+		tickets[i].ID = getTicketID(tickets[i].PassengerID)
 		tickets[i].OrderID = o.ID
-		db.Create(tickets[i])
 		o.Price += tickets[i].Price
 	}
-	db.Create(o)
+	fillPriceAudits(tickets, audits)
+	// 车票、订单、票价审计、订单事件在同一事务中落库，失败时回滚并释放已占用的座位
+	if err := createOrder(o, tickets, audits); err != nil {
+		releaseBookedSeats(cars, seats, &par)
+		return nil, err
+	}
 	scheduleTran.hasChanged = true
-	// TODO：将新订单ID发向mq
+	return o, nil
+}
+
+// createOrder 在同一事务中保存车票、订单及订单创建事件
+func createOrder(o *Order, tickets []*Ticket, audits []*PriceAudit) error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("订单提交失败: %v", tx.Error)
+	}
+	for i := 0; i < len(tickets); i++ {
+		if err := tx.Create(tickets[i]).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("订单提交失败: %v", err)
+		}
+	}
+	for i := 0; i < len(audits); i++ {
+		if err := tx.Create(audits[i]).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("订单提交失败: %v", err)
+		}
+	}
+	if err := tx.Create(o).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("订单提交失败: %v", err)
+	}
+	if err := publishOrderEvent(tx, constEventOrderCreated, o.ID, 0, o.UserID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("订单提交失败: %v", err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("订单提交失败: %v", err)
+	}
 	return nil
 }
 
+// releaseBookedSeats 订票失败时，释放已在内存中占用的座位
+func releaseBookedSeats(cars []*ScheduleCar, seats []*ScheduleSeat, par *SubmitOrderModel) {
+	for i := 0; i < len(cars); i++ {
+		// 非站票才需要释放席位的资源
+		if seats[i].SeatNum != "" {
+			seats[i].Release(par.seatBit)
+		}
+		cars[i].releaseSeat(par.DepIdx, par.ArrIdx)
+	}
+}
+
 // 订座位
 func bookSeat(st *ScheduleTran, carIdxList []uint8, par *SubmitOrderModel) (car *ScheduleCar, seat *ScheduleSeat, seatIdx uint8, isMedley, ok bool) {
 	// 优先席位票
@@ -212,28 +301,51 @@ func ChangeOrder(par SubmitOrderModel, oldTicketID uint64) error {
 	if err != nil {
 		return err
 	}
+	if err = checkScalping(&par, time.Now()); err != nil {
+		return err
+	}
 	oldTicket := &Ticket{ID: oldTicketID}
 	db.First(oldTicket)
 	if oldTicket.ChangeTicketID != 0 {
 		return errors.New("已经改签，无法再次改签")
 	}
+	scheduleTran := scheduleCache.getScheduleTran(par.TranNum, par.Date)
+	if err = validBookingRange(tran, scheduleTran, par.DepIdx, par.ArrIdx); err != nil {
+		return err
+	}
 	par.init(tran)
 	// 出发时间和到站时间
 	if hasTimeConflictInChange(par.PassengerIDs[0], oldTicketID, par.depTime, par.arrTime) {
 		return errors.New("乘车人时间冲突")
 	}
-	scheduleTran := scheduleCache.getScheduleTran(par.TranNum, par.Date)
+	if err = validRoundTripChange(oldTicket, par.depTime, par.arrTime); err != nil {
+		return err
+	}
 	// 锁定座位，创建订单
 	carIdxList, exist := tran.carTypeIdxMap[par.SeatType]
 	if !exist {
 		return errors.New("所选席别无效")
 	}
-	car, _, seatIdx, isMedley, ok := bookSeat(scheduleTran, carIdxList, &par)
+	// 新旧排班按固定顺序加锁，避免与反向的改签交叉等待
+	oldScheduleTran := scheduleCache.getScheduleTran(oldTicket.TranNum, oldTicket.TranDepDate)
+	defer rLockSchedules(scheduleTran, oldScheduleTran)()
+	if err = scheduleTran.checkSuspended(par.DepIdx, par.ArrIdx); err != nil {
+		return err
+	}
+	now := time.Now()
+	priceDecision := getOrderPriceDecision(tran, scheduleTran, &par, now)
+	car, seat, seatIdx, isMedley, ok := bookSeat(scheduleTran, carIdxList, &par)
 	// 无票
 	if !ok {
 		return errors.New("没有足够的票")
 	}
 	newTicket := buildTicket(tran, car, &par, seatIdx, par.PassengerIDs[0], isMedley)
+	audits := applyDynamicPrice([]*Ticket{newTicket}, priceDecision, now)
+	// 改签只有一名乘客，免费儿童改签时按儿童票计价
+	if err = applyFares(tran, &par, []*Ticket{newTicket}); err != nil {
+		releaseBookedSeats([]*ScheduleCar{car}, []*ScheduleSeat{seat}, &par)
+		return err
+	}
 	newTicket.ID = getTicketID(par.PassengerIDs[0])
 	newTicket.ChangeTicketID = oldTicket.ID
 	newOrder := &Order{
@@ -241,40 +353,229 @@ func ChangeOrder(par SubmitOrderModel, oldTicketID uint64) error {
 		OrderNum: "", // TODO: 订单号生成器需返回一个全局唯一订单号
 		UserID:   par.UserID,
 		Price:    newTicket.Price,
-		BookTime: time.Now(),
+		BookTime: now,
 		Status:   constOrderUnpay,
 	}
-	oldScheduleTran := scheduleCache.getScheduleTran(oldTicket.TranNum, oldTicket.TranDepDate)
+	oldOrder := &Order{ID: oldTicket.OrderID}
+	db.First(oldOrder)
+	newTicket.OrderID = newOrder.ID
+	// 往返订单的改签票保留与另一程车票的关联
+	newTicket.LegIdx, newTicket.PairTicketID = oldTicket.LegIdx, oldTicket.PairTicketID
+	refund := settleChange(oldTicket, newTicket, oldOrder, newOrder)
+	fillPriceAudits([]*Ticket{newTicket}, audits)
+	// 改签票、新订单、原车票及原订单在同一事务中落库，失败时释放新占用的座位，原车票不受影响
+	r, err := saveChangeOrder(oldTicket, newTicket, oldOrder, newOrder, audits, refund)
+	if err != nil {
+		releaseBookedSeats([]*ScheduleCar{car}, []*ScheduleSeat{seat}, &par)
+		return err
+	}
+	scheduleTran.hasChanged = true
+	// 改签成功后释放原车票的座位
 	if oldTicket.SeatType != constSeatTypeNoSeat {
 		seatBit := countSeatBit(oldTicket.DepStationIdx, oldTicket.ArrStationIdx)
 		oldScheduleTran.Cars[oldTicket.CarNum-1].Seats[oldTicket.SeatIdx].Release(seatBit)
 	}
 	oldScheduleTran.Cars[oldTicket.CarNum-1].releaseSeat(oldTicket.DepStationIdx, oldTicket.ArrStationIdx)
 	oldScheduleTran.hasChanged = true
-	oldOrder := &Order{ID: oldTicket.OrderID}
-	db.First(oldOrder)
-	// 原票价高于改签后的票价则需设置改签票为已支付状态，且需退还差额；否则改签票保持未支付状态，且用户需补交差额
-	if oldOrder.Price >= newOrder.Price {
-		// 退款
-		Refund(oldOrder.ID, oldOrder.UserID, oldOrder.PayType, oldOrder.PayAccount, oldOrder.Price-newOrder.Price, time.Now().Format(ConstYMdHmsFormat))
-		newOrder.Status = constOrderPaid
+	if r != nil {
+		if err = r.refund(); err != nil {
+			return fmt.Errorf("改签成功，退还差额失败，将自动重试: %v", err)
+		}
+	}
+	return nil
+}
+
+// settleChange 按新旧票价结算改签，返回需退还的差额
+// 原车票的票价不低于改签后的票价时，改签票置为已支付并退还差额；否则改签票保持未支付状态，新订单只需补交差额
+func settleChange(oldTicket, newTicket *Ticket, oldOrder, newOrder *Order) (refund float32) {
+	oldTicket.Status = constTicketChanged
+	newTicket.Status = constTicketChangeUnpay
+	if oldTicket.Price < newOrder.Price {
+		newOrder.Price -= oldTicket.Price
+		return 0
+	}
+	newOrder.Status = constOrderPaid
+	newTicket.Status = constTicketChangePaid
+	// 往返订单只改签其中一程，另一程仍有效
+	if oldOrder.OrderType != constOrderTypeRoundTrip {
 		oldOrder.Status = constOrderChanged
+	}
+	return oldTicket.Price - newOrder.Price
+}
+
+// saveChangeOrder 在同一事务中保存改签票、新订单、原车票、原订单、改签的订单事件及需退还差额的退款记录
+func saveChangeOrder(oldTicket, newTicket *Ticket, oldOrder, newOrder *Order, audits []*PriceAudit, refund float32) (*RefundRecord, error) {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("改签失败: %v", tx.Error)
+	}
+	err := tx.Create(newTicket).Error
+	for i := 0; err == nil && i < len(audits); i++ {
+		err = tx.Create(audits[i]).Error
+	}
+	if err == nil {
+		err = tx.Create(newOrder).Error
+	}
+	if err == nil {
+		err = tx.Save(oldTicket).Error
+	}
+	if err == nil {
+		err = tx.Save(oldOrder).Error
+	}
+	if err == nil && newTicket.PairTicketID != 0 {
+		err = tx.Model(&Ticket{}).Where("id = ?", newTicket.PairTicketID).Update("pair_ticket_id", newTicket.ID).Error
+	}
+	if err == nil {
+		err = publishOrderEvent(tx, constEventOrderCreated, newOrder.ID, 0, newOrder.UserID)
+	}
+	if err == nil {
+		err = publishOrderEvent(tx, constEventTicketChanged, newOrder.ID, newTicket.ID, newOrder.UserID)
+	}
+	var r *RefundRecord
+	if err == nil && refund > 0 {
+		r, err = addRefundRecord(tx, oldOrder, refund)
+	}
+	if err == nil {
+		err = tx.Commit().Error
 	} else {
-		newOrder.Price -= oldOrder.Price
-		// TODO：新订单ID需发向mq
+		tx.Rollback()
 	}
-	return nil
+	if err != nil {
+		return nil, fmt.Errorf("改签失败: %v", err)
+	}
+	return r, nil
 }
 
 // CancelOrder 取消订单
 func CancelOrder(orderID uint64) error {
 	o := &Order{ID: orderID}
 	db.First(o)
+	return cancelOrder(o, constOrderCancelled)
+}
+
+// cancelOrder 将订单置为取消、超时或退款状态，并释放订单占用的座位
+// 订单及车票状态、订单事件、退款记录在同一事务中落库，提交成功后才释放内存中的座位并退款；
+// 释放座位失败时，由余票核验修复；退款失败时，由退款重试任务处理
+func cancelOrder(o *Order, status uint8) error {
+	fromStatus := uint8(constOrderUnpay)
+	if status == constOrderRefund {
+		fromStatus = constOrderPaid
+	}
 	var tickets []Ticket
-	db.Where("order_id = ?", o.ID).Find(&tickets)
+	if err := db.Where("order_id = ?", o.ID).Find(&tickets).Error; err != nil {
+		return fmt.Errorf("订单取消失败: %v", err)
+	}
+	tx := db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("订单取消失败: %v", tx.Error)
+	}
+	// 以原状态为条件更新，避免重复取消而多次释放座位
+	q := tx.Model(&Order{}).Where("id = ? and status = ?", o.ID, fromStatus).Update("status", status)
+	if q.Error != nil {
+		tx.Rollback()
+		return fmt.Errorf("订单取消失败: %v", q.Error)
+	}
+	if q.RowsAffected != 1 {
+		tx.Rollback()
+		return errors.New("订单状态已变更，无法取消")
+	}
+	// 车票按各自的状态更新，已退票、已改签等已失效的车票保持不变
+	var cancelled []Ticket
+	var price float32
+	var err error
+	for i := 0; err == nil && i < len(tickets); i++ {
+		ticketStatus, ok := getCancelTicketStatus(tickets[i].Status)
+		if !ok {
+			continue
+		}
+		q := tx.Model(&Ticket{}).Where("id = ? and status = ?", tickets[i].ID, tickets[i].Status).Update("status", ticketStatus)
+		if err = q.Error; err == nil && q.RowsAffected != 1 {
+			err = errors.New("车票状态已变更")
+		}
+		cancelled = append(cancelled, tickets[i])
+		price += tickets[i].Price
+	}
+	if err == nil {
+		err = publishCancelEvent(tx, o, cancelled, status)
+	}
+	var r *RefundRecord
+	if err == nil && status == constOrderRefund && price > 0 {
+		// 改签票的票价包含原车票已付的部分，退款金额不超过订单的实付金额
+		if price > o.Price {
+			price = o.Price
+		}
+		r, err = addRefundRecord(tx, o, price)
+	}
+	if err == nil {
+		err = tx.Commit().Error
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		return fmt.Errorf("订单取消失败: %v", err)
+	}
+	o.Status = status
+	if len(cancelled) != 0 {
+		releaseTicketSeats(cancelled)
+	}
+	if r != nil {
+		if err = r.refund(); err != nil {
+			return fmt.Errorf("退票成功，退款失败，将自动重试: %v", err)
+		}
+	}
+	return nil
+}
+
+// getCancelTicketStatus 订单取消、超时或退款后车票的状态：未支付的车票置为已取消，改签票退票后置为改签票已退票；
+// 车票已失效时返回假
+func getCancelTicketStatus(ticketStatus uint8) (uint8, bool) {
+	switch ticketStatus {
+	case constTicketUnpay, constTicketChangeUnpay:
+		return constTicketCancelled, true
+	case constTicketPaid, constTicketIssued:
+		return constTicketRefund, true
+	case constTicketChangePaid, constTicketChangeIssued:
+		return constTicketChangeRefund, true
+	}
+	return 0, false
+}
+
+// publishCancelEvent 根据取消后的订单状态写入对应的订单事件
+func publishCancelEvent(tx *gorm.DB, o *Order, tickets []Ticket, status uint8) error {
+	switch status {
+	case constOrderCancelled:
+		return publishOrderEvent(tx, constEventOrderCancelled, o.ID, 0, o.UserID)
+	case constOrderTimeout:
+		return publishOrderEvent(tx, constEventOrderTimedOut, o.ID, 0, o.UserID)
+	case constOrderRefund:
+		for _, t := range tickets {
+			if err := publishOrderEvent(tx, constEventTicketRefunded, o.ID, t.ID, o.UserID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// releaseTicketSeats 释放车票在排班中占用的座位，联程订单的车票按车次及发车日期分别释放
+func releaseTicketSeats(tickets []Ticket) {
+	groups := make(map[string][]Ticket)
+	for _, t := range tickets {
+		key := t.TranNum + "_" + t.TranDepDate
+		groups[key] = append(groups[key], t)
+	}
+	for _, group := range groups {
+		releaseScheduleSeats(group)
+	}
+}
+
+// releaseScheduleSeats 释放同一排班中的车票占用的座位
+func releaseScheduleSeats(tickets []Ticket) {
 	st := scheduleCache.getScheduleTran(tickets[0].TranNum, tickets[0].TranDepDate)
-	seatBit := countSeatBit(tickets[0].DepStationIdx, tickets[0].ArrStationIdx)
+	st.repairLock.RLock()
+	defer st.repairLock.RUnlock()
 	for ti := 0; ti < len(tickets); ti++ {
+		seatBit := countSeatBit(tickets[ti].DepStationIdx, tickets[ti].ArrStationIdx)
 		for ci := 0; ci < len(st.Cars); ci++ {
 			if tickets[ti].CarNum == st.Cars[ci].CarNum {
 				// 非站票需要释放资源
@@ -292,9 +593,6 @@ func CancelOrder(orderID uint64) error {
 		}
 	}
 	st.hasChanged = true
-	o.Status = constOrderCancelled
-	db.Save(o)
-	return nil
 }
 
 // Payment 订单支付
@@ -311,14 +609,44 @@ func (o *Order) Payment(payType uint8, payAccount string, price float32) error {
 			return errors.New("订单已改签")
 		}
 	}
+	if o.isExpired(time.Now()) {
+		return errors.New("订单已过期")
+	}
 	if o.Price != price {
 		return errors.New("支付金额错误")
 	}
-	o.PayType = payType
-	o.PayAccount = payAccount
-	o.PayTime = time.Now()
-	o.Status = constOrderPaid
-	db.Save(o)
+	// 订单状态与支付事件在同一事务中落库，避免已支付的订单丢失事件
+	tx := db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("支付失败: %v", tx.Error)
+	}
+	now := time.Now()
+	// 以原状态为条件更新，避免并发的支付、取消重复处理同一订单
+	q := tx.Model(&Order{}).Where("id = ? and status = ?", o.ID, constOrderUnpay).
+		Updates(map[string]interface{}{"pay_type": payType, "pay_account": payAccount, "pay_time": now, "status": constOrderPaid})
+	err := q.Error
+	if err == nil && q.RowsAffected == 0 {
+		err = errors.New("订单状态已变更")
+	}
+	// 车票随订单置为已支付，改签补交差额的车票置为改签票已支付
+	if err == nil {
+		err = tx.Model(&Ticket{}).Where("order_id = ? and status = ?", o.ID, constTicketUnpay).Update("status", constTicketPaid).Error
+	}
+	if err == nil {
+		err = tx.Model(&Ticket{}).Where("order_id = ? and status = ?", o.ID, constTicketChangeUnpay).Update("status", constTicketChangePaid).Error
+	}
+	if err == nil {
+		err = publishOrderEvent(tx, constEventOrderPaid, o.ID, 0, o.UserID)
+	}
+	if err == nil {
+		err = tx.Commit().Error
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		return fmt.Errorf("支付失败: %v", err)
+	}
+	o.PayType, o.PayAccount, o.PayTime, o.Status = payType, payAccount, now, constOrderPaid
 	return nil
 }
 
@@ -326,13 +654,11 @@ func (o *Order) Payment(payType uint8, payAccount string, price float32) error {
 func RefundOrder(orderID uint64) error {
 	o := &Order{ID: orderID}
 	db.First(o)
-	if err := CancelOrder(orderID); err != nil {
-		return err
+	// 往返订单可能已单独退过其中一程，按程退票
+	if o.OrderType == constOrderTypeRoundTrip {
+		return refundRoundTrip(o.ID)
 	}
-	if err := Refund(o.ID, o.UserID, o.PayType, o.PayAccount, o.Price, time.Now().Format(ConstYMdHmsFormat)); err != nil {
-		return err
-	}
-	return nil
+	return cancelOrder(o, constOrderRefund)
 }
 
 // Ticket 车票
@@ -357,14 +683,18 @@ type Ticket struct {
 	ArrStationIdx   uint8     // 到达站在路线中的索引
 	ArrTime         time.Time `gorm:"type:datetime"` // 到达时间
 	ChangeTicketID  uint64    // 改签票的ID
+	FareRule        string    // 适用的票价规则 adult、infant、child、student、disabled
+	LegIdx          uint8     // 在联程或往返订单中的行程段序号，从1开始，普通订单为0；往返订单中1为去程，2为返程
+	PairTicketID    uint64    // 往返订单中同一乘客另一程车票的ID
 }
 
 // hasTimeConflict 判断乘车人的乘车时间是否冲突
+// 乘车时间有重叠即为冲突，上一程到达后再出发的行程（如往返订单的返程）不冲突
 func hasTimeConflict(passengerID uint64, depTime, arrTime time.Time) bool {
 	count := 0
 	validTicketStatus := []uint8{constTicketUnpay, constTicketPaid, constTicketIssued, constTicketChangeUnpay, constTicketChangePaid, constTicketChangeIssued}
-	db.Model(&Ticket{}).Where("passenger_id = ? and status in (?) and ((dep_time < ? and ? < arr_time) or (dep_time < ? and ? < arr_time) or (? < dep_time and arr_time < ?))",
-		passengerID, validTicketStatus, depTime, depTime, arrTime, arrTime, depTime, arrTime).Count(&count)
+	db.Model(&Ticket{}).Where("passenger_id = ? and status in (?) and dep_time < ? and ? < arr_time",
+		passengerID, validTicketStatus, arrTime, depTime).Count(&count)
 	return count != 0
 }
 
@@ -373,8 +703,8 @@ func hasTimeConflictInChange(passengerID, ticketID uint64, depTime, arrTime time
 	count := 0
 	validTicketStatus := []uint8{constTicketUnpay, constTicketPaid, constTicketIssued, constTicketChangeUnpay, constTicketChangePaid, constTicketChangeIssued}
 	// 相较于hasTimeConflict，多了一个ticketID的限制
-	db.Model(&Ticket{}).Where("passenger_id = ? and status in (?) and id != ? and ((dep_time < ? and ? < arr_time) or (dep_time < ? and ? < arr_time) or (? < dep_time and arr_time < ?))",
-		passengerID, validTicketStatus, ticketID, depTime, depTime, arrTime, arrTime, depTime, arrTime).Count(&count)
+	db.Model(&Ticket{}).Where("passenger_id = ? and status in (?) and id != ? and dep_time < ? and ? < arr_time",
+		passengerID, validTicketStatus, ticketID, arrTime, depTime).Count(&count)
 	return count != 0
 }
 
@@ -406,12 +736,40 @@ func buildTicket(tran *TranInfo, car *ScheduleCar, par *SubmitOrderModel, seatId
 	return t
 }
 
-// CheckIn 取票
-func CheckIn(ticketID uint64) {
+// CheckIn 取票，车票状态与取票事件在同一事务中落库
+func CheckIn(ticketID uint64) error {
 	t := &Ticket{ID: ticketID}
-	db.First(t)
-	if t.Status == constTicketPaid || t.Status == constTicketChangePaid {
-		t.Status = constTicketIssued
+	if err := db.First(t).Error; err != nil {
+		return fmt.Errorf("取票失败: %v", err)
+	}
+	if t.Status != constTicketPaid && t.Status != constTicketChangePaid {
+		return nil
+	}
+	status := uint8(constTicketIssued)
+	if t.Status == constTicketChangePaid {
+		status = constTicketChangeIssued
 	}
-	db.Save(t)
+	o := &Order{ID: t.OrderID}
+	if err := db.First(o).Error; err != nil {
+		return fmt.Errorf("取票失败: %v", err)
+	}
+	tx := db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("取票失败: %v", tx.Error)
+	}
+	// 以原状态为条件更新，重复取票时不再发布事件
+	q := tx.Model(&Ticket{}).Where("id = ? and status = ?", t.ID, t.Status).Update("status", status)
+	err := q.Error
+	if err == nil && q.RowsAffected != 0 {
+		err = publishOrderEvent(tx, constEventTicketIssued, t.OrderID, t.ID, o.UserID)
+	}
+	if err == nil {
+		err = tx.Commit().Error
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		return fmt.Errorf("取票失败: %v", err)
+	}
+	return nil
 }
@@ -0,0 +1,226 @@
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// 订单生命周期事件
+	constEventOrderCreated   = "OrderCreated"   // 订单已创建
+	constEventOrderPaid      = "OrderPaid"      // 订单已支付
+	constEventOrderCancelled = "OrderCancelled" // 订单已取消
+	constEventOrderTimedOut  = "OrderTimedOut"  // 订单超时未支付
+	constEventTicketRefunded = "TicketRefunded" // 已退票
+	constEventTicketChanged  = "TicketChanged"  // 已改签
+	constEventTicketIssued   = "TicketIssued"   // 已出票
+)
+
+const (
+	constEventRelayInterval = time.Second // 投递订单事件的时间间隔
+	constEventRelayBatch    = 500         // 每次投递的订单事件数量
+	constEventMaxRetryTimes = 10          // 发往消息队列的最大重试次数
+)
+
+var (
+	// 进程内的事件总线
+	orderEventBus = newEventBus()
+	// 外部消息队列，为空时只投递到进程内的事件总线
+	orderEventBroker eventBroker
+	// 投递订单事件的互斥锁，避免同一事件被重复投递
+	eventRelayLock sync.Mutex
+)
+
+// orderEvent 订单事件
+type orderEvent struct {
+	Type      string    `json:"type"`      // 事件类型
+	OrderID   uint64    `json:"orderID"`   // 订单ID
+	TicketID  uint64    `json:"ticketID"`  // 车票ID，订单级事件为零
+	UserID    uint64    `json:"userID"`    // 用户ID
+	OccurTime time.Time `json:"occurTime"` // 发生时间
+}
+
+// OrderEventOutbox 订单事件发件箱，与订单数据在同一事务中写入，由投递任务发往事件总线及消息队列
+type OrderEventOutbox struct {
+	ID          uint64
+	EventType   string    `gorm:"type:varchar(20)"`  // 事件类型
+	Payload     string    `gorm:"type:varchar(500)"` // 事件内容 JSON
+	Dispatched  bool      `gorm:"index:main"`        // 是否已投递到进程内的事件总线
+	Published   bool      `gorm:"index:main"`        // 是否已发往消息队列
+	Failed      bool      `gorm:"index:main"`        // 内容无效或重试后仍发送失败，不再投递
+	RetryTimes  uint8     // 发往消息队列的重试次数
+	CreateTime  time.Time `gorm:"type:datetime"` // 创建时间
+	PublishTime time.Time `gorm:"type:datetime"` // 发往消息队列的时间
+	NextTryTime time.Time `gorm:"type:datetime"` // 下次发往消息队列的时间
+}
+
+// publishOrderEvent 将订单事件写入发件箱，tx 为订单数据所在的事务
+func publishOrderEvent(tx *gorm.DB, eventType string, orderID, ticketID, userID uint64) error {
+	e := &orderEvent{Type: eventType, OrderID: orderID, TicketID: ticketID, UserID: userID, OccurTime: time.Now()}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&OrderEventOutbox{
+		EventType:   eventType,
+		Payload:     string(payload),
+		Published:   orderEventBroker == nil,
+		CreateTime:  e.OccurTime,
+		NextTryTime: e.OccurTime,
+	}).Error
+}
+
+// relayOrderEvents 投递发件箱中的订单事件
+// 投递到事件总线与发往消息队列分别查询，发往消息队列失败的事件不会阻塞后续事件的投递
+func relayOrderEvents() {
+	eventRelayLock.Lock()
+	defer eventRelayLock.Unlock()
+	now := time.Now()
+	var list []OrderEventOutbox
+	db.Where("dispatched = ? and failed = ?", false, false).Order("id").Limit(constEventRelayBatch).Find(&list)
+	for i := 0; i < len(list); i++ {
+		relayOrderEvent(&list[i], now)
+	}
+	if orderEventBroker == nil {
+		return
+	}
+	list = nil
+	db.Where("dispatched = ? and published = ? and failed = ? and next_try_time <= ?", true, false, false, now).
+		Order("id").Limit(constEventRelayBatch).Find(&list)
+	for i := 0; i < len(list); i++ {
+		relayOrderEvent(&list[i], now)
+	}
+}
+
+// relayOrderEvent 投递单个订单事件，内容无效或重试次数用尽时标记为失败并通知管理员
+func relayOrderEvent(m *OrderEventOutbox, now time.Time) {
+	e := &orderEvent{}
+	if err := json.Unmarshal([]byte(m.Payload), e); err != nil {
+		m.Failed = true
+		notifyEventFailed(m, fmt.Sprintf("payload invalid: %s", err))
+		db.Save(m)
+		return
+	}
+	if !m.Dispatched {
+		orderEventBus.dispatch(e)
+		m.Dispatched = true
+	}
+	if !m.Published && orderEventBroker != nil && !m.NextTryTime.After(now) {
+		if err := orderEventBroker.publish(m.EventType, []byte(m.Payload)); err != nil {
+			log.Printf("order event %d publish failed: %s\n", m.ID, err)
+			if m.publishFailed(now) {
+				notifyEventFailed(m, fmt.Sprintf("publish failed: %s", err))
+			}
+		} else {
+			m.Published = true
+			m.PublishTime = now
+		}
+	}
+	db.Save(m)
+}
+
+// publishFailed 记录一次发送失败，按重试次数的平方退避，单位：秒；重试次数用尽时返回真
+func (m *OrderEventOutbox) publishFailed(now time.Time) bool {
+	m.RetryTimes++
+	m.NextTryTime = now.Add(time.Duration(m.RetryTimes) * time.Duration(m.RetryTimes) * time.Second)
+	m.Failed = m.RetryTimes >= constEventMaxRetryTimes
+	return m.Failed
+}
+
+// notifyEventFailed 订单事件不再投递时通知管理员处理
+func notifyEventFailed(m *OrderEventOutbox, reason string) {
+	notify := &notifyAdminInfo{
+		date:       time.Now().Format(ConstYmdFormat),
+		refID:      m.ID,
+		notifyType: "order-event",
+		severity:   constAlertSeverityError,
+		message:    fmt.Sprintf("Order Event %d %s Failed: %s", m.ID, m.EventType, reason)}
+	notify.notifyAdmin()
+}
+
+func initOrderEvent() {
+	go func() {
+		for range time.Tick(constEventRelayInterval) {
+			relayOrderEvents()
+		}
+	}()
+}
+
+// eventHandler 事件处理方法
+type eventHandler func(e *orderEvent)
+
+// eventBus 进程内的事件总线，同步调用各订阅者，单个订阅者出错不影响其他订阅者
+type eventBus struct {
+	handlers map[string]([]eventHandler)
+	sync.RWMutex
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{handlers: make(map[string]([]eventHandler))}
+}
+
+// subscribe 订阅事件
+func (b *eventBus) subscribe(eventType string, h eventHandler) {
+	b.Lock()
+	b.handlers[eventType] = append(b.handlers[eventType], h)
+	b.Unlock()
+}
+
+// dispatch 将事件分发给所有订阅者
+func (b *eventBus) dispatch(e *orderEvent) {
+	b.RLock()
+	handlers := b.handlers[e.Type]
+	b.RUnlock()
+	for _, h := range handlers {
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					log.Printf("order event %s of order %d handle panic: %v\n", e.Type, e.OrderID, p)
+				}
+			}()
+			h(e)
+		}()
+	}
+}
+
+// eventBroker 外部消息队列适配器，topic 为事件类型
+type eventBroker interface {
+	publish(topic string, payload []byte) error
+}
+
+// localEventBroker 内嵌的消息队列，未接入外部消息队列时用于联调和测试
+type localEventBroker struct {
+	subscribers map[string]([]chan []byte)
+	sync.RWMutex
+}
+
+func newLocalEventBroker() *localEventBroker {
+	return &localEventBroker{subscribers: make(map[string]([]chan []byte))}
+}
+
+// subscribe 订阅某类事件，buffer 为通道容量
+func (b *localEventBroker) subscribe(topic string, buffer int) <-chan []byte {
+	ch := make(chan []byte, buffer)
+	b.Lock()
+	b.subscribers[topic] = append(b.subscribers[topic], ch)
+	b.Unlock()
+	return ch
+}
+
+func (b *localEventBroker) publish(topic string, payload []byte) error {
+	b.RLock()
+	defer b.RUnlock()
+	for _, ch := range b.subscribers[topic] {
+		select {
+		case ch <- payload:
+		default:
+			return fmt.Errorf("local broker topic %s is full", topic)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package modules
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := newEventBus()
+	received := 0
+	bus.subscribe(constEventOrderPaid, func(e *orderEvent) {
+		panic("handler panic")
+	})
+	bus.subscribe(constEventOrderPaid, func(e *orderEvent) {
+		if e.OrderID == 1001 {
+			received++
+		}
+	})
+	bus.dispatch(&orderEvent{Type: constEventOrderPaid, OrderID: 1001})
+	bus.dispatch(&orderEvent{Type: constEventOrderCancelled, OrderID: 1001})
+	if received == 1 {
+		t.Log("dispatch pass")
+	} else {
+		t.Error("dispatch fail")
+	}
+}
+
+func TestLocalEventBroker(t *testing.T) {
+	broker := newLocalEventBroker()
+	ch := broker.subscribe(constEventOrderCreated, 1)
+	payload, _ := json.Marshal(&orderEvent{Type: constEventOrderCreated, OrderID: 1001})
+	if err := broker.publish(constEventOrderCreated, payload); err != nil {
+		t.Error("publish fail")
+	}
+	e := &orderEvent{}
+	if err := json.Unmarshal(<-ch, e); err == nil && e.OrderID == 1001 {
+		t.Log("subscribe pass")
+	} else {
+		t.Error("subscribe fail")
+	}
+	broker.publish(constEventOrderCreated, payload)
+	if err := broker.publish(constEventOrderCreated, payload); err != nil {
+		t.Log("publish full pass")
+	} else {
+		t.Error("publish full fail")
+	}
+	if err := broker.publish(constEventTicketIssued, payload); err == nil {
+		t.Log("publish without subscriber pass")
+	} else {
+		t.Error("publish without subscriber fail")
+	}
+}
+
+func TestEventPublishFailed(t *testing.T) {
+	now := time.Now()
+	m := &OrderEventOutbox{RetryTimes: 1}
+	if m.publishFailed(now) || m.RetryTimes != 2 || !m.NextTryTime.Equal(now.Add(4*time.Second)) {
+		t.Error("publishFailed retry fail")
+	} else {
+		t.Log("publishFailed retry pass")
+	}
+	m.RetryTimes = constEventMaxRetryTimes - 1
+	if m.publishFailed(now) && m.Failed {
+		t.Log("publishFailed give up pass")
+	} else {
+		t.Error("publishFailed give up fail")
+	}
+}
@@ -0,0 +1,36 @@
+package modules
+
+import (
+	"fmt"
+	"time"
+)
+
+// initOrderTimeout 每分钟取消一次超时未支付的订单
+func initOrderTimeout() {
+	go func() {
+		for now := range time.Tick(time.Minute) {
+			expireUnpayOrders(now)
+		}
+	}()
+}
+
+// expireUnpayOrders 取消超时未支付的订单，释放其占用的座位
+func expireUnpayOrders(now time.Time) {
+	var orders []Order
+	db.Where("status = ? and book_time < ?", constOrderUnpay, getUnpayOrderDeadline(now)).Find(&orders)
+	for i := 0; i < len(orders); i++ {
+		if err := cancelOrder(&orders[i], constOrderTimeout); err != nil {
+			fmt.Println("expire order", orders[i].ID, "error:", err)
+		}
+	}
+}
+
+// getUnpayOrderDeadline 在此时间之前提交的未支付订单已超时
+func getUnpayOrderDeadline(now time.Time) time.Time {
+	return now.Add(-constUnpayOrderAvaliableTime * time.Minute)
+}
+
+// isExpired 未支付订单是否已超时，超时任务每分钟才执行一次，支付时需自行判断
+func (o *Order) isExpired(now time.Time) bool {
+	return o.Status == constOrderUnpay && o.BookTime.Before(getUnpayOrderDeadline(now))
+}
@@ -0,0 +1,28 @@
+package modules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOrderExpired(t *testing.T) {
+	now := time.Date(2018, 10, 1, 12, 0, 0, 0, time.Local)
+	o := &Order{Status: constOrderUnpay, BookTime: now.Add(-(constUnpayOrderAvaliableTime + 1) * time.Minute)}
+	if o.isExpired(now) && getUnpayOrderDeadline(now).Equal(now.Add(-constUnpayOrderAvaliableTime*time.Minute)) {
+		t.Log("order expired pass")
+	} else {
+		t.Error("order expired fail")
+	}
+	o.BookTime = now.Add(-time.Minute)
+	if !o.isExpired(now) {
+		t.Log("order not expired pass")
+	} else {
+		t.Error("order not expired fail")
+	}
+	o.Status, o.BookTime = constOrderPaid, now.Add(-time.Hour)
+	if !o.isExpired(now) {
+		t.Log("paid order not expired pass")
+	} else {
+		t.Error("paid order not expired fail")
+	}
+}
@@ -1 +1,81 @@
 package modules
+
+import "testing"
+
+func TestReleaseBookedSeats(t *testing.T) {
+	car := &ScheduleCar{
+		CarNum:                 1,
+		NoSeatCount:            1,
+		Seats:                  []ScheduleSeat{ScheduleSeat{SeatNum: "01A"}, ScheduleSeat{SeatNum: "01B"}},
+		EachRouteTravelerCount: []uint8{0, 0, 0},
+	}
+	par := &SubmitOrderModel{DepIdx: 0, ArrIdx: 2, seatBit: countSeatBit(0, 2)}
+	var cars []*ScheduleCar
+	var seats []*ScheduleSeat
+	for i := 0; i < 3; i++ {
+		seat, _, ok := car.getAvailableSeat(par)
+		if !ok {
+			seat, ok = car.getAvailableNoSeat(par)
+		}
+		if !ok {
+			t.Fatal("book seat fail")
+		}
+		cars = append(cars, car)
+		seats = append(seats, seat)
+	}
+	releaseBookedSeats(cars, seats, par)
+	if car.Seats[0].SeatBit == 0 && car.Seats[1].SeatBit == 0 &&
+		car.EachRouteTravelerCount[0] == 0 && car.EachRouteTravelerCount[1] == 0 {
+		t.Log("releaseBookedSeats pass")
+	} else {
+		t.Error("releaseBookedSeats fail")
+	}
+}
+
+func TestSettleChange(t *testing.T) {
+	oldTicket, newTicket := &Ticket{Price: 100, Status: constTicketPaid}, &Ticket{Price: 80}
+	oldOrder, newOrder := &Order{Status: constOrderPaid}, &Order{Price: 80, Status: constOrderUnpay}
+	if refund := settleChange(oldTicket, newTicket, oldOrder, newOrder); refund == 20 && newOrder.Status == constOrderPaid &&
+		newTicket.Status == constTicketChangePaid && oldTicket.Status == constTicketChanged && oldOrder.Status == constOrderChanged {
+		t.Log("settle change with refund pass")
+	} else {
+		t.Error("settle change with refund fail", refund)
+	}
+	oldTicket, newTicket = &Ticket{Price: 100, Status: constTicketPaid}, &Ticket{Price: 150}
+	oldOrder, newOrder = &Order{Status: constOrderPaid, OrderType: constOrderTypeRoundTrip}, &Order{Price: 150, Status: constOrderUnpay}
+	if refund := settleChange(oldTicket, newTicket, oldOrder, newOrder); refund == 0 && newOrder.Price == 50 &&
+		newOrder.Status == constOrderUnpay && newTicket.Status == constTicketChangeUnpay && oldOrder.Status == constOrderPaid {
+		t.Log("settle change with supplement pass")
+	} else {
+		t.Error("settle change with supplement fail", refund)
+	}
+	oldTicket, newTicket = &Ticket{Price: 100}, &Ticket{Price: 90}
+	oldOrder, newOrder = &Order{Status: constOrderPaid, OrderType: constOrderTypeRoundTrip}, &Order{Price: 90}
+	if settleChange(oldTicket, newTicket, oldOrder, newOrder) == 10 && oldOrder.Status == constOrderPaid {
+		t.Log("settle round trip change pass")
+	} else {
+		t.Error("settle round trip change fail")
+	}
+}
+
+func TestGetCancelTicketStatus(t *testing.T) {
+	cases := map[uint8]uint8{
+		constTicketUnpay:        constTicketCancelled,
+		constTicketChangeUnpay:  constTicketCancelled,
+		constTicketPaid:         constTicketRefund,
+		constTicketIssued:       constTicketRefund,
+		constTicketChangePaid:   constTicketChangeRefund,
+		constTicketChangeIssued: constTicketChangeRefund,
+	}
+	for from, to := range cases {
+		if status, ok := getCancelTicketStatus(from); !ok || status != to {
+			t.Error("getCancelTicketStatus fail", from, status)
+			return
+		}
+	}
+	if _, ok := getCancelTicketStatus(constTicketChangeRefund); ok {
+		t.Error("getCancelTicketStatus skip invalid ticket fail")
+	} else {
+		t.Log("getCancelTicketStatus pass")
+	}
+}
@@ -0,0 +1,99 @@
+package modules
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+const constPriceCalendarCacheTime = time.Minute // 价格日历的缓存时长
+
+var (
+	// 价格日历缓存，key为 出发站_到达站_是否学生票
+	priceCalendarCache     = make(map[string]*priceCalendarEntry)
+	priceCalendarCacheLock sync.Mutex
+)
+
+type priceCalendarEntry struct {
+	days       []PriceCalendarDay
+	expireTime time.Time
+}
+
+// PriceCalendarDay 价格日历中某一天的结果
+type PriceCalendarDay struct {
+	Date       string             `json:"date"`       // 乘车日期
+	LowestFare map[string]float32 `json:"lowestFare"` // 各席别有余票的车次中的最低票价，已按当前规则调价
+	SoldOut    bool               `json:"soldOut"`    // 当天有可售车次，但均无余票
+}
+
+// QueryPriceCalendar 查询可订票天数内，两站之间每天各席别的最低票价及是否售罄
+func QueryPriceCalendar(depStationName, arrStationName string, isStudent bool) []PriceCalendarDay {
+	depS, arrS := getStationInfoByName(depStationName), getStationInfoByName(arrStationName)
+	if depS == nil || arrS == nil {
+		return nil
+	}
+	key := depS.StationCode + "_" + arrS.StationCode + "_" + strconv.FormatBool(isStudent)
+	now := time.Now()
+	priceCalendarCacheLock.Lock()
+	entry, exist := priceCalendarCache[key]
+	priceCalendarCacheLock.Unlock()
+	if exist && now.Before(entry.expireTime) {
+		return entry.days
+	}
+	days := buildPriceCalendar(depS, arrS, isStudent, now)
+	priceCalendarCacheLock.Lock()
+	// 顺带清理过期的缓存
+	for k, e := range priceCalendarCache {
+		if now.After(e.expireTime) {
+			delete(priceCalendarCache, k)
+		}
+	}
+	priceCalendarCache[key] = &priceCalendarEntry{days: days, expireTime: now.Add(constPriceCalendarCacheTime)}
+	priceCalendarCacheLock.Unlock()
+	return days
+}
+
+// buildPriceCalendar 逐日计算经过两站所在城市的车次中，有余票的各席别最低票价
+func buildPriceCalendar(depS, arrS *Station, isStudent bool, now time.Time) []PriceCalendarDay {
+	matchTrans := getViaTrans(depS, arrS)
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	days := make([]PriceCalendarDay, constDays)
+	for i := 0; i < constDays; i++ {
+		queryDate := today.AddDate(0, 0, i)
+		day := PriceCalendarDay{Date: queryDate.Format(ConstYmdFormat), LowestFare: make(map[string]float32)}
+		onSale := false
+		for _, t := range matchTrans {
+			depIdx, arrIdx, date, ok := t.IsMatchQuery(depS, arrS, queryDate)
+			if !ok || !t.IsSaleTicket {
+				continue
+			}
+			st := scheduleCache.getScheduleTran(t.TranNum, date)
+			if st.TranNum == "" || st.SaleTicketTime.After(now) {
+				continue
+			}
+			// 余票及上座率读取车厢，余票核验修复时会整体替换车厢，停运区间也在该锁下设置
+			st.repairLock.RLock()
+			if st.removed || st.isSuspended(depIdx, arrIdx) { // 与余票查询一致，停运区间不计入售票
+				st.repairLock.RUnlock()
+				continue
+			}
+			onSale = true
+			seatCount := st.getAvaliableSeatCount(t, depIdx, arrIdx, isStudent)
+			for seatType, price := range t.getSeatPrice(depIdx, arrIdx) {
+				idx, exist := seatTypeIdxMap[seatType]
+				if !exist || seatCount[idx] <= 0 {
+					continue
+				}
+				price = adjustPrice(price, getPriceDecision(newPriceContext(t, st, date, seatType, depIdx, arrIdx)).rate)
+				if lowest, ok := day.LowestFare[seatType]; !ok || price < lowest {
+					day.LowestFare[seatType] = price
+				}
+			}
+			st.repairLock.RUnlock()
+		}
+		day.SoldOut = onSale && len(day.LowestFare) == 0
+		days[i] = day
+	}
+	return days
+}
@@ -0,0 +1,79 @@
+package modules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriceCalendar(t *testing.T) {
+	defer storeStationSnapshot(getStationSnapshot())
+	stations := stationCfgs{
+		Station{StationName: "上海", StationCode: "SHH", CityCode: "SH"},
+		Station{StationName: "北京", StationCode: "BJP", CityCode: "BJ"},
+	}
+	storeStationSnapshot(&stationSnapshot{stations: stations})
+	days := buildPriceCalendar(&stations[1], &stations[0], false, time.Now())
+	if len(days) == constDays && days[0].Date == time.Now().Format(ConstYmdFormat) && !days[0].SoldOut && len(days[0].LowestFare) == 0 {
+		t.Log("no tran pass")
+	} else {
+		t.Error("no tran fail")
+	}
+
+	cached := []PriceCalendarDay{PriceCalendarDay{Date: "2018-06-01", LowestFare: map[string]float32{constSeatTypeSecondClass: 553}}}
+	priceCalendarCacheLock.Lock()
+	priceCalendarCache["BJP_SHH_false"] = &priceCalendarEntry{days: cached, expireTime: time.Now().Add(time.Minute)}
+	priceCalendarCacheLock.Unlock()
+	if days := QueryPriceCalendar("北京", "上海", false); len(days) == 1 && days[0].LowestFare[constSeatTypeSecondClass] == 553 {
+		t.Log("cache pass")
+	} else {
+		t.Error("cache fail")
+	}
+	if days := QueryPriceCalendar("北京", "上海", true); len(days) == constDays {
+		t.Log("cache key pass")
+	} else {
+		t.Error("cache key fail")
+	}
+}
+
+// 停运区间不计入最低票价，也不视为售罄
+func TestPriceCalendarSuspended(t *testing.T) {
+	defer storeStationSnapshot(getStationSnapshot())
+	defer storeTranSnapshot(getTranSnapshot())
+	stations := stationCfgs{
+		Station{StationName: "北京", StationCode: "BJP", CityCode: "BJ"},
+		Station{StationName: "上海", StationCode: "SHH", CityCode: "SH"},
+	}
+	storeStationSnapshot(&stationSnapshot{stations: stations})
+	cars := map[int](Car){1: Car{ID: 1, SeatType: constSeatTypeSecondClass, Seats: []Seat{Seat{SeatNum: "01A"}}}}
+	c := cars[1]
+	trans := tranCfgs{newTestTran(1, "G9101", "BJ", "SH", 2330, 2359)}
+	trans[0].IsSaleTicket = true
+	trans[0].SeatPriceMap = map[string]([]int){constSeatTypeSecondClass: []int{553}}
+	trans[0].initCarIdx(cars)
+	storeTranSnapshot(&tranSnapshot{carMap: cars, scheduleCarMap: map[int](*ScheduleCar){1: newScheduleCar(&c)},
+		tranInfos: trans, cityTranMap: buildCityTranMap(trans)})
+	now := time.Now()
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < constDays; i++ {
+		day := today.AddDate(0, 0, i)
+		st := trans[0].newScheduleTran(day, trans[0].scheduleSignature())
+		if i == 1 {
+			st.Suspension = &ScheduleSuspension{DepIdx: 0, ArrIdx: 1, Remark: constSuspendDefaultRemark}
+		}
+		key := trans[0].TranNum + "_" + day.Format(ConstYmdFormat)
+		scheduleTranMap.Store(key, st)
+		defer scheduleTranMap.Delete(key)
+	}
+	days := buildPriceCalendar(&stations[0], &stations[1], false, now)
+	if _, ok := days[0].LowestFare[constSeatTypeSecondClass]; ok {
+		t.Log("on sale pass")
+	} else {
+		t.Error("on sale fail")
+	}
+	if len(days[1].LowestFare) == 0 && !days[1].SoldOut {
+		t.Log("suspended pass")
+	} else {
+		t.Error("suspended fail")
+	}
+}
@@ -0,0 +1,430 @@
+package modules
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const constQuoteCleanInterval = time.Minute // 清理过期报价的时间间隔
+
+var (
+	// 当前生效的动态调价配置
+	priceSetting     = defaultPriceSetting
+	priceSettingLock sync.RWMutex
+	// 已启用的调价规则
+	priceRules     []PriceRule
+	priceRulesLock sync.RWMutex
+	// 本实例生成的有效期内的报价，key为报价ID；报价同时落库，重启或由其它实例下单时从数据库读取
+	priceQuotes     = make(map[string]*PriceQuote)
+	priceQuotesLock sync.Mutex
+)
+
+// PriceSetting 动态调价配置，只保存一条记录
+type PriceSetting struct {
+	ID             uint64  `json:"id"`
+	MinRate        float32 `json:"minRate"`        // 调价后票价不低于基础票价的倍数
+	MaxRate        float32 `json:"maxRate"`        // 调价后票价不高于基础票价的倍数
+	QuoteValidMins int     `json:"quoteValidMins"` // 报价有效期 单位：分钟，有效期内下单按报价收费
+	RoundTripRate  float32 `json:"roundTripRate"`  // 往返订单的折扣率，在动态调价的基础上再打折
+}
+
+var defaultPriceSetting = PriceSetting{MinRate: 0.6, MaxRate: 1.5, QuoteValidMins: 15, RoundTripRate: 0.95}
+
+// PriceRule 调价规则，所有非空条件均满足时生效，多条规则同时生效时调价倍数相乘
+type PriceRule struct {
+	ID        uint64  `json:"id"`
+	Name      string  `gorm:"type:nvarchar(50)" json:"name"`     // 规则名称，记录在票价审计中
+	TranNum   string  `gorm:"type:varchar(10)" json:"tranNum"`   // 车次，为空时适用所有车次
+	SeatType  string  `gorm:"type:varchar(5)" json:"seatType"`   // 席别，为空时适用所有席别
+	StartDate string  `gorm:"type:varchar(10)" json:"startDate"` // 发车日期范围的开始日期，如节假日 yyyy-MM-dd
+	EndDate   string  `gorm:"type:varchar(10)" json:"endDate"`   // 发车日期范围的截止日期 yyyy-MM-dd
+	Weekdays  string  `gorm:"type:varchar(20)" json:"weekdays"`  // 发车日期是星期几，如周末为 6,0
+	StartTime string  `gorm:"type:varchar(5)" json:"startTime"`  // 乘车站发车时段的开始时间 HH:mm
+	EndTime   string  `gorm:"type:varchar(5)" json:"endTime"`    // 乘车站发车时段的截止时间 HH:mm，不含
+	MinLoad   uint8   `json:"minLoad"`                           // 上座率下限 百分比
+	MaxLoad   uint8   `json:"maxLoad"`                           // 上座率上限 百分比，为零时不限
+	Rate      float32 `json:"rate"`                              // 调价倍数
+	Enabled   bool    `json:"enabled"`                           // 是否启用
+}
+
+// priceContext 调价所需的车次、日期及上座率信息
+type priceContext struct {
+	tranNum  string
+	seatType string
+	date     string // 发车日期
+	depTime  string // 乘车站发车时间 HH:mm
+	load     uint8  // 所选路段该席别的上座率 百分比
+}
+
+// match 判断规则是否适用
+func (r *PriceRule) match(c *priceContext) bool {
+	if (r.TranNum != "" && r.TranNum != c.tranNum) || (r.SeatType != "" && r.SeatType != c.seatType) {
+		return false
+	}
+	if (r.StartDate != "" && c.date < r.StartDate) || (r.EndDate != "" && c.date > r.EndDate) {
+		return false
+	}
+	if r.Weekdays != "" {
+		dt, err := time.Parse(ConstYmdFormat, c.date)
+		if err != nil || !strings.Contains(","+r.Weekdays+",", ","+strconv.Itoa(int(dt.Weekday()))+",") {
+			return false
+		}
+	}
+	if (r.StartTime != "" && c.depTime < r.StartTime) || (r.EndTime != "" && c.depTime >= r.EndTime) {
+		return false
+	}
+	if c.load < r.MinLoad || (r.MaxLoad != 0 && c.load > r.MaxLoad) {
+		return false
+	}
+	return true
+}
+
+// priceDecision 调价结果
+type priceDecision struct {
+	rate    float32  // 最终调价倍数
+	reasons []string // 生效的规则，及是否触及上下限
+	quoteID string   // 按报价收费时的报价ID
+}
+
+// getPriceDecision 计算调价倍数，先相乘所有生效规则的倍数，再按配置的上下限截断
+func getPriceDecision(c *priceContext) *priceDecision {
+	setting := GetPriceSetting()
+	d := &priceDecision{rate: 1}
+	priceRulesLock.RLock()
+	for i := 0; i < len(priceRules); i++ {
+		if priceRules[i].match(c) {
+			d.rate *= priceRules[i].Rate
+			d.reasons = append(d.reasons, fmt.Sprintf("%s x%.2f", priceRules[i].Name, priceRules[i].Rate))
+		}
+	}
+	priceRulesLock.RUnlock()
+	if d.rate < setting.MinRate {
+		d.rate = setting.MinRate
+		d.reasons = append(d.reasons, fmt.Sprintf("下限 x%.2f", setting.MinRate))
+	}
+	if d.rate > setting.MaxRate {
+		d.rate = setting.MaxRate
+		d.reasons = append(d.reasons, fmt.Sprintf("上限 x%.2f", setting.MaxRate))
+	}
+	return d
+}
+
+// getLoadFactor 所选路段某席别的上座率，即该路段已不可售的座位占比，调用方需持有repairLock
+func (st *ScheduleTran) getLoadFactor(t *TranInfo, seatType string, depIdx, arrIdx uint8) uint8 {
+	seatBit, total, sold := countSeatBit(depIdx, arrIdx), 0, 0
+	for _, idx := range t.carTypeIdxMap[seatType] {
+		if int(idx) >= len(st.Cars) {
+			continue
+		}
+		for i := 0; i < len(st.Cars[idx].Seats); i++ {
+			total++
+			if !st.Cars[idx].Seats[i].IsAvailable(seatBit, true) {
+				sold++
+			}
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return uint8(sold * 100 / total)
+}
+
+// newPriceContext 构建某车次、日期、路段、席别的调价信息
+func newPriceContext(t *TranInfo, st *ScheduleTran, date, seatType string, depIdx, arrIdx uint8) *priceContext {
+	return &priceContext{
+		tranNum:  t.TranNum,
+		seatType: seatType,
+		date:     date,
+		depTime:  t.Timetable[depIdx].DepTime.Format(ConstHmFormat),
+		load:     st.getLoadFactor(t, seatType, depIdx, arrIdx),
+	}
+}
+
+// adjustPrice 按调价倍数调整票价，精确到分
+func adjustPrice(price, rate float32) float32 {
+	return float32(math.Round(float64(price*rate)*100) / 100)
+}
+
+// PriceQuote 报价，有效期内以报价下单时按报价的调价倍数收费
+type PriceQuote struct {
+	QuoteID    string             `json:"quoteID"`    // 报价ID，下单时提交
+	Prices     map[string]float32 `json:"prices"`     // 各席别调价后的票价，卧铺为各铺位中的最低价
+	ExpireTime time.Time          `json:"expireTime"` // 报价失效时间
+	// 各类卧铺各铺位调价后的票价
+	BerthPrices map[string](map[string]float32) `json:"berthPrices"`
+
+	tranNum   string
+	date      string
+	depIdx    uint8
+	arrIdx    uint8
+	decisions map[string]*priceDecision // 各席别的调价结果
+}
+
+// newPriceQuote 计算各席别调价后的票价，并保存报价
+func newPriceQuote(t *TranInfo, date string, depIdx, arrIdx uint8, now time.Time) *PriceQuote {
+	st := scheduleCache.getScheduleTran(t.TranNum, date)
+	q := &PriceQuote{
+		QuoteID:     newQuoteID(),
+		Prices:      t.getSeatPrice(depIdx, arrIdx),
+		BerthPrices: t.getBerthPrice(depIdx, arrIdx),
+		ExpireTime:  now.Add(time.Duration(GetPriceSetting().QuoteValidMins) * time.Minute),
+		tranNum:     t.TranNum,
+		date:        date,
+		depIdx:      depIdx,
+		arrIdx:      arrIdx,
+		decisions:   make(map[string]*priceDecision),
+	}
+	st.repairLock.RLock()
+	for seatType, price := range q.Prices {
+		d := getPriceDecision(newPriceContext(t, st, date, seatType, depIdx, arrIdx))
+		d.quoteID = q.QuoteID
+		q.decisions[seatType] = d
+		q.Prices[seatType] = adjustPrice(price, d.rate)
+		// 同一席别的各铺位按相同的倍数调价，与下单时按铺位计价后再调价一致
+		for berth, p := range q.BerthPrices[seatType] {
+			q.BerthPrices[seatType][berth] = adjustPrice(p, d.rate)
+		}
+	}
+	st.repairLock.RUnlock()
+	// 落库失败时报价只在本实例内有效
+	if err := q.save(); err != nil {
+		log.Printf("price quote %s save failed: %s\n", q.QuoteID, err)
+	}
+	priceQuotesLock.Lock()
+	priceQuotes[q.QuoteID] = q
+	priceQuotesLock.Unlock()
+	return q
+}
+
+// PriceQuoteRecord 报价中某席别的调价结果，保证重启后或多实例部署时，有效期内下单仍按报价收费
+type PriceQuoteRecord struct {
+	ID         uint64
+	QuoteID    string    `gorm:"index:main;type:varchar(24)"` // 报价ID
+	TranNum    string    `gorm:"type:varchar(10)"`            // 车次
+	Date       string    `gorm:"type:varchar(10)"`            // 发车日期
+	DepIdx     uint8     // 乘车站索引
+	ArrIdx     uint8     // 到达站索引
+	SeatType   string    `gorm:"type:varchar(5)"` // 席别
+	Rate       float32   // 调价倍数
+	Reasons    string    `gorm:"type:nvarchar(500)"` // 生效的调价规则
+	ExpireTime time.Time `gorm:"type:datetime"`      // 报价失效时间
+}
+
+// save 在同一事务中保存报价各席别的调价结果
+func (q *PriceQuote) save() error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	for seatType, d := range q.decisions {
+		r := &PriceQuoteRecord{QuoteID: q.QuoteID, TranNum: q.tranNum, Date: q.date, DepIdx: q.depIdx, ArrIdx: q.arrIdx,
+			SeatType: seatType, Rate: d.rate, Reasons: strings.Join(d.reasons, ";"), ExpireTime: q.ExpireTime}
+		if err := tx.Create(r).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit().Error
+}
+
+// loadQuoteDecision 从数据库读取其它实例或重启前生成的报价
+func loadQuoteDecision(par *SubmitOrderModel, now time.Time) (*priceDecision, bool) {
+	r := &PriceQuoteRecord{}
+	db.Where("quote_id = ? and seat_type = ? and tran_num = ? and date = ? and dep_idx = ? and arr_idx = ? and expire_time > ?",
+		par.QuoteID, par.SeatType, par.TranNum, par.Date, par.DepIdx, par.ArrIdx, now).First(r)
+	if r.ID == 0 {
+		return nil, false
+	}
+	d := &priceDecision{rate: r.Rate, quoteID: r.QuoteID}
+	if r.Reasons != "" {
+		d.reasons = strings.Split(r.Reasons, ";")
+	}
+	return d, true
+}
+
+func newQuoteID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// getOrderPriceDecision 下单时的调价结果，报价有效且与订单一致时按报价收费，否则按当前规则重新计算
+func getOrderPriceDecision(t *TranInfo, st *ScheduleTran, par *SubmitOrderModel, now time.Time) *priceDecision {
+	priceQuotesLock.Lock()
+	q, exist := priceQuotes[par.QuoteID]
+	priceQuotesLock.Unlock()
+	if exist && now.Before(q.ExpireTime) && q.tranNum == par.TranNum && q.date == par.Date && q.depIdx == par.DepIdx && q.arrIdx == par.ArrIdx {
+		if d, ok := q.decisions[par.SeatType]; ok {
+			return d
+		}
+	}
+	if !exist && par.QuoteID != "" {
+		if d, ok := loadQuoteDecision(par, now); ok {
+			return d
+		}
+	}
+	return getPriceDecision(newPriceContext(t, st, par.Date, par.SeatType, par.DepIdx, par.ArrIdx))
+}
+
+// cleanPriceQuotes 清理过期的报价
+func cleanPriceQuotes(now time.Time) {
+	priceQuotesLock.Lock()
+	for id, q := range priceQuotes {
+		if now.After(q.ExpireTime) {
+			delete(priceQuotes, id)
+		}
+	}
+	priceQuotesLock.Unlock()
+	db.Delete(PriceQuoteRecord{}, "expire_time < ?", now)
+}
+
+// PriceAudit 票价审计，记录每张车票的基础票价、调价倍数及原因
+type PriceAudit struct {
+	ID         uint64    `json:"id"`
+	TicketID   uint64    `gorm:"index:main" json:"ticketID"`        // 车票ID
+	OrderID    uint64    `json:"orderID"`                           // 订单ID
+	BasePrice  float32   `json:"basePrice"`                         // 基础票价
+	Rate       float32   `json:"rate"`                              // 调价倍数
+	Reasons    string    `gorm:"type:nvarchar(500)" json:"reasons"` // 生效的调价规则
+	QuoteID    string    `gorm:"type:varchar(24)" json:"quoteID"`   // 按报价收费时的报价ID
+	FareRule   string    `gorm:"type:varchar(10)" json:"fareRule"`  // 适用的票价规则
+	Price      float32   `json:"price"`                             // 实收票价
+	CreateTime time.Time `gorm:"type:datetime" json:"createTime"`   // 记录时间
+}
+
+// applyDynamicPrice 按调价结果调整各车票的票价，返回各车票的票价审计，需在计算乘客票价规则之前调用
+func applyDynamicPrice(tickets []*Ticket, d *priceDecision, now time.Time) []*PriceAudit {
+	audits := make([]*PriceAudit, len(tickets))
+	for i, t := range tickets {
+		audits[i] = &PriceAudit{
+			BasePrice:  t.Price,
+			Rate:       d.rate,
+			Reasons:    strings.Join(d.reasons, ";"),
+			QuoteID:    d.quoteID,
+			CreateTime: now,
+		}
+		t.Price = adjustPrice(t.Price, d.rate)
+	}
+	return audits
+}
+
+// fillPriceAudits 车票落库前，补全票价审计中的车票信息及最终票价
+func fillPriceAudits(tickets []*Ticket, audits []*PriceAudit) {
+	for i, t := range tickets {
+		audits[i].TicketID = t.ID
+		audits[i].OrderID = t.OrderID
+		audits[i].FareRule = t.FareRule
+		audits[i].Price = t.Price
+	}
+}
+
+// GetPriceAudits 查询车票的票价审计
+func GetPriceAudits(ticketID uint64) (audits []PriceAudit) {
+	db.Where("ticket_id = ?", ticketID).Order("id").Find(&audits)
+	return
+}
+
+// GetPriceSetting 获取当前的动态调价配置
+func GetPriceSetting() PriceSetting {
+	priceSettingLock.RLock()
+	defer priceSettingLock.RUnlock()
+	return priceSetting
+}
+
+// Save 保存动态调价配置，保存后立即生效
+func (s *PriceSetting) Save() (bool, string) {
+	if s.MinRate <= 0 || s.MaxRate < s.MinRate {
+		return false, "调价上下限无效"
+	}
+	if s.QuoteValidMins <= 0 {
+		return false, "报价有效期必须大于零"
+	}
+	if s.RoundTripRate <= 0 || s.RoundTripRate > 1 {
+		return false, "往返折扣率无效"
+	}
+	priceSettingLock.Lock()
+	defer priceSettingLock.Unlock()
+	s.ID = priceSetting.ID
+	var err error
+	if s.ID == 0 {
+		err = db.Create(s).Error
+	} else {
+		err = db.Save(s).Error
+	}
+	if err != nil {
+		return false, fmt.Sprintf("保存失败: %v", err)
+	}
+	priceSetting = *s
+	return true, ""
+}
+
+// GetPriceRules 获取所有调价规则
+func GetPriceRules() (rules []PriceRule) {
+	db.Order("id").Find(&rules)
+	return
+}
+
+// Save 保存调价规则，保存后立即生效
+func (r *PriceRule) Save() (bool, string) {
+	if strings.TrimSpace(r.Name) == "" {
+		return false, "规则名称不能为空"
+	}
+	if r.Rate <= 0 {
+		return false, "调价倍数必须大于零"
+	}
+	if r.MaxLoad != 0 && r.MaxLoad < r.MinLoad {
+		return false, "上座率范围无效"
+	}
+	var err error
+	if r.ID == 0 {
+		err = db.Create(r).Error
+	} else {
+		err = db.Save(r).Error
+	}
+	if err != nil {
+		return false, fmt.Sprintf("保存失败: %v", err)
+	}
+	loadPriceRules()
+	return true, ""
+}
+
+// DeletePriceRule 删除调价规则
+func DeletePriceRule(ruleID uint64) error {
+	if db.Delete(PriceRule{}, "id = ?", ruleID).RowsAffected == 0 {
+		return errors.New("调价规则不存在")
+	}
+	loadPriceRules()
+	return nil
+}
+
+func loadPriceRules() {
+	var rules []PriceRule
+	db.Where("enabled = ?", true).Order("id").Find(&rules)
+	priceRulesLock.Lock()
+	priceRules = rules
+	priceRulesLock.Unlock()
+}
+
+func initPricing() {
+	setting := PriceSetting{}
+	db.First(&setting)
+	if setting.ID != 0 {
+		priceSettingLock.Lock()
+		priceSetting = setting
+		priceSettingLock.Unlock()
+	}
+	loadPriceRules()
+	go func() {
+		for now := range time.Tick(constQuoteCleanInterval) {
+			cleanPriceQuotes(now)
+		}
+	}()
+}
@@ -0,0 +1,109 @@
+package modules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPriceRuleMatch(t *testing.T) {
+	// 2018-06-02 为星期六
+	c := &priceContext{tranNum: "G1", seatType: constSeatTypeSecondClass, date: "2018-06-02", depTime: "07:30", load: 85}
+	rules := []PriceRule{
+		PriceRule{Name: "weekend", Weekdays: "6,0"},
+		PriceRule{Name: "holiday", StartDate: "2018-06-01", EndDate: "2018-06-03"},
+		PriceRule{Name: "morning", StartTime: "07:00", EndTime: "09:00"},
+		PriceRule{Name: "load", MinLoad: 80},
+		PriceRule{Name: "seat", SeatType: constSeatTypeSecondClass, TranNum: "G1"},
+	}
+	for _, r := range rules {
+		if r.match(c) {
+			t.Log(r.Name, "match pass")
+		} else {
+			t.Error(r.Name, "match fail")
+		}
+	}
+	misses := []PriceRule{
+		PriceRule{Name: "weekday", Weekdays: "1,2,3,4,5"},
+		PriceRule{Name: "later", StartDate: "2018-06-03"},
+		PriceRule{Name: "evening", StartTime: "18:00"},
+		PriceRule{Name: "low load", MaxLoad: 30},
+		PriceRule{Name: "other tran", TranNum: "G2"},
+	}
+	for _, r := range misses {
+		if !r.match(c) {
+			t.Log(r.Name, "miss pass")
+		} else {
+			t.Error(r.Name, "miss fail")
+		}
+	}
+}
+
+func TestPriceDecision(t *testing.T) {
+	oldRules, oldSetting := priceRules, priceSetting
+	defer func() { priceRules, priceSetting = oldRules, oldSetting }()
+	priceSetting = PriceSetting{MinRate: 0.5, MaxRate: 1.3, QuoteValidMins: 10}
+	priceRules = []PriceRule{
+		PriceRule{Name: "weekend", Weekdays: "6,0", Rate: 1.2},
+		PriceRule{Name: "load", MinLoad: 80, Rate: 1.2},
+	}
+	c := &priceContext{date: "2018-06-02", load: 90}
+	if d := getPriceDecision(c); d.rate == 1.3 && len(d.reasons) == 3 {
+		t.Log("ceiling pass")
+	} else {
+		t.Error("ceiling fail")
+	}
+	c.load = 10
+	if d := getPriceDecision(c); d.rate == 1.2 && len(d.reasons) == 1 {
+		t.Log("single rule pass")
+	} else {
+		t.Error("single rule fail")
+	}
+
+	now := time.Now()
+	tickets := []*Ticket{&Ticket{Price: 100}, &Ticket{Price: 33.33}}
+	audits := applyDynamicPrice(tickets, &priceDecision{rate: 1.2, reasons: []string{"weekend x1.20"}}, now)
+	if tickets[0].Price == 120 && tickets[1].Price == 40 && audits[1].BasePrice == 33.33 && audits[0].Reasons == "weekend x1.20" {
+		t.Log("applyDynamicPrice pass")
+	} else {
+		t.Error("applyDynamicPrice fail")
+	}
+}
+
+func TestQuoteHonored(t *testing.T) {
+	now := time.Now()
+	d := &priceDecision{rate: 1.1, quoteID: "q1"}
+	priceQuotesLock.Lock()
+	priceQuotes["q1"] = &PriceQuote{QuoteID: "q1", ExpireTime: now.Add(time.Minute), tranNum: "G1", date: "2018-06-02", depIdx: 0, arrIdx: 2,
+		decisions: map[string]*priceDecision{constSeatTypeSecondClass: d}}
+	priceQuotesLock.Unlock()
+	par := &SubmitOrderModel{TranNum: "G1", Date: "2018-06-02", ArrIdx: 2, SeatType: constSeatTypeSecondClass, QuoteID: "q1"}
+	if getOrderPriceDecision(nil, nil, par, now) == d {
+		t.Log("quote honored pass")
+	} else {
+		t.Error("quote honored fail")
+	}
+	cleanPriceQuotes(now.Add(2 * time.Minute))
+	priceQuotesLock.Lock()
+	_, exist := priceQuotes["q1"]
+	priceQuotesLock.Unlock()
+	if !exist {
+		t.Log("quote expired pass")
+	} else {
+		t.Error("quote expired fail")
+	}
+}
+
+func TestLoadFactor(t *testing.T) {
+	tran := &TranInfo{carTypeIdxMap: map[string]([]uint8){constSeatTypeSecondClass: []uint8{0}}}
+	st := &ScheduleTran{Cars: []ScheduleCar{ScheduleCar{Seats: []ScheduleSeat{
+		ScheduleSeat{SeatBit: countSeatBit(0, 1)},
+		ScheduleSeat{SeatBit: countSeatBit(3, 4)},
+		ScheduleSeat{},
+		ScheduleSeat{},
+	}}}}
+	if st.getLoadFactor(tran, constSeatTypeSecondClass, 0, 2) == 25 && st.getLoadFactor(tran, constSeatTypeSecondClass, 0, 4) == 50 {
+		t.Log("getLoadFactor pass")
+	} else {
+		t.Error("getLoadFactor fail")
+	}
+}
@@ -0,0 +1,228 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	constLimitBucketIdle     = 10 * time.Minute // 令牌桶空闲多久后回收
+	constLimitCleanInterval  = time.Minute      // 回收空闲令牌桶的时间间隔
+	constSuspiciousFlagLimit = 100              // 每次查询可疑行为记录的最大数量
+)
+
+var (
+	// 当前生效的限流及防黄牛配置
+	limitSetting     = defaultLimitSetting
+	limitSettingLock sync.RWMutex
+	// 查询接口的令牌桶
+	queryLimiter = newRateLimiter(func(s *LimitSetting) (float64, int) { return s.QueryRate, s.QueryBurst })
+	// 下单接口的令牌桶
+	orderLimiter = newRateLimiter(func(s *LimitSetting) (float64, int) { return s.OrderRate, s.OrderBurst })
+)
+
+// LimitSetting 限流及防黄牛配置，只保存一条记录
+type LimitSetting struct {
+	ID                     uint64  `json:"id"`
+	QueryRate              float64 `json:"queryRate"`              // 查询接口每秒补充的令牌数
+	QueryBurst             int     `json:"queryBurst"`             // 查询接口的令牌桶容量
+	OrderRate              float64 `json:"orderRate"`              // 下单接口每秒补充的令牌数
+	OrderBurst             int     `json:"orderBurst"`             // 下单接口的令牌桶容量
+	MaxTicketsPerPassenger int     `json:"maxTicketsPerPassenger"` // 同一乘客同一车次同一天的最多车票数
+	MaxOrdersPerDay        int     `json:"maxOrdersPerDay"`        // 同一账号每天的最多订单数
+	SuspiciousContactCount int     `json:"suspiciousContactCount"` // 订单中新添加的联系人达到此数量时，标记为可疑
+	SuspiciousContactMins  int     `json:"suspiciousContactMins"`  // 下单前多少分钟内添加的联系人视为新添加 单位：分钟
+}
+
+var defaultLimitSetting = LimitSetting{
+	QueryRate:              5,
+	QueryBurst:             20,
+	OrderRate:              0.2,
+	OrderBurst:             3,
+	MaxTicketsPerPassenger: 2,
+	MaxOrdersPerDay:        20,
+	SuspiciousContactCount: 3,
+	SuspiciousContactMins:  30,
+}
+
+// GetLimitSetting 获取当前的限流及防黄牛配置
+func GetLimitSetting() LimitSetting {
+	limitSettingLock.RLock()
+	defer limitSettingLock.RUnlock()
+	return limitSetting
+}
+
+// Save 保存限流及防黄牛配置，保存后立即生效
+func (s *LimitSetting) Save() (bool, string) {
+	if s.QueryRate <= 0 || s.QueryBurst <= 0 || s.OrderRate <= 0 || s.OrderBurst <= 0 {
+		return false, "限流速率及容量必须大于零"
+	}
+	if s.MaxTicketsPerPassenger <= 0 || s.MaxOrdersPerDay <= 0 {
+		return false, "购票上限必须大于零"
+	}
+	if s.SuspiciousContactCount <= 0 || s.SuspiciousContactMins <= 0 {
+		return false, "可疑行为的判定条件必须大于零"
+	}
+	limitSettingLock.Lock()
+	defer limitSettingLock.Unlock()
+	s.ID = limitSetting.ID
+	if s.ID == 0 {
+		db.Create(s)
+	} else {
+		db.Save(s)
+	}
+	limitSetting = *s
+	return true, ""
+}
+
+func initRateLimit() {
+	setting := LimitSetting{}
+	db.First(&setting)
+	if setting.ID != 0 {
+		limitSettingLock.Lock()
+		limitSetting = setting
+		limitSettingLock.Unlock()
+	}
+	go func() {
+		for range time.Tick(constLimitCleanInterval) {
+			queryLimiter.clean(time.Now())
+			orderLimiter.clean(time.Now())
+		}
+	}()
+}
+
+// AllowQuery 查询接口限流，key 为用户或IP
+func AllowQuery(key string) bool {
+	return queryLimiter.allow(key, time.Now())
+}
+
+// AllowOrder 下单接口限流，key 为用户或IP
+func AllowOrder(key string) bool {
+	return orderLimiter.allow(key, time.Now())
+}
+
+// tokenBucket 令牌桶
+type tokenBucket struct {
+	tokens float64   // 剩余令牌数
+	last   time.Time // 上次补充令牌的时间
+}
+
+// rateLimiter 按 key 区分的令牌桶限流器，速率及容量取自当前配置，修改配置后立即生效
+type rateLimiter struct {
+	buckets map[string]*tokenBucket
+	config  func(s *LimitSetting) (rate float64, burst int)
+	sync.Mutex
+}
+
+func newRateLimiter(config func(s *LimitSetting) (float64, int)) *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket), config: config}
+}
+
+// allow 取一个令牌，令牌不足时返回false
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	setting := GetLimitSetting()
+	rate, burst := l.config(&setting)
+	l.Lock()
+	defer l.Unlock()
+	b, exist := l.buckets[key]
+	if !exist {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * rate
+		b.last = now
+	}
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// clean 回收长时间未使用的令牌桶，这些桶必然已补满，回收后再次使用时重建即可
+func (l *rateLimiter) clean(now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > constLimitBucketIdle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// SuspiciousFlag 可疑的购票行为记录，仅做标记，由管理员核查
+type SuspiciousFlag struct {
+	ID         uint64    `json:"id"`
+	UserID     uint64    `gorm:"index:main" json:"userID"`         // 用户ID
+	TranNum    string    `gorm:"type:varchar(10)" json:"tranNum"`  // 车次
+	Date       string    `gorm:"type:varchar(10)" json:"date"`     // 发车日期
+	Reason     string    `gorm:"type:nvarchar(200)" json:"reason"` // 原因
+	CreateTime time.Time `gorm:"type:datetime" json:"createTime"`  // 记录时间
+}
+
+// QuerySuspiciousFlags 查询最近的可疑行为记录，userID 为零时查询所有用户
+func QuerySuspiciousFlags(userID uint64) (flags []SuspiciousFlag) {
+	q := db.Order("id desc").Limit(constSuspiciousFlagLimit)
+	if userID != 0 {
+		q = q.Where("user_id = ?", userID)
+	}
+	q.Find(&flags)
+	return
+}
+
+// checkScalping 防黄牛校验，超出购票上限时拒绝下单，可疑行为只做标记
+func checkScalping(par *SubmitOrderModel, now time.Time) error {
+	setting := GetLimitSetting()
+	dayStart, _ := time.ParseInLocation(ConstYmdFormat, now.Format(ConstYmdFormat), now.Location())
+	orderCount := 0
+	if err := db.Model(&Order{}).Where("user_id = ? and book_time >= ?", par.UserID, dayStart).Count(&orderCount).Error; err != nil {
+		return fmt.Errorf("下单失败: %v", err)
+	}
+	if orderCount >= setting.MaxOrdersPerDay {
+		return errors.New("您今日的订单数已达上限")
+	}
+	validTicketStatus := []uint8{constTicketUnpay, constTicketPaid, constTicketIssued, constTicketChangeUnpay, constTicketChangePaid, constTicketChangeIssued}
+	for _, pid := range par.PassengerIDs {
+		ticketCount := 0
+		err := db.Model(&Ticket{}).Where("passenger_id = ? and tran_num = ? and tran_dep_date = ? and status in (?)",
+			pid, par.TranNum, par.Date, validTicketStatus).Count(&ticketCount).Error
+		if err != nil {
+			return fmt.Errorf("下单失败: %v", err)
+		}
+		if ticketCount >= setting.MaxTicketsPerPassenger {
+			return fmt.Errorf("乘客%d在该车次的购票数已达上限", pid)
+		}
+	}
+	var contacts []Contact
+	db.Where("uid = ? and pid in (?)", par.UserID, par.PassengerIDs).Find(&contacts)
+	if reason, suspicious := isSuspiciousContacts(contacts, now, &setting); suspicious {
+		// 可疑行为只记录不拦截，记录失败时不影响下单
+		flag := &SuspiciousFlag{UserID: par.UserID, TranNum: par.TranNum, Date: par.Date, Reason: reason, CreateTime: now}
+		if err := db.Create(flag).Error; err != nil {
+			log.Printf("suspicious flag of user %d %s save failed: %s\n", par.UserID, reason, err)
+		}
+	}
+	return nil
+}
+
+// isSuspiciousContacts 订单中有多名乘客是下单前不久才添加的联系人，视为可疑
+func isSuspiciousContacts(contacts []Contact, now time.Time, setting *LimitSetting) (string, bool) {
+	since := now.Add(-time.Duration(setting.SuspiciousContactMins) * time.Minute)
+	count := 0
+	for _, c := range contacts {
+		if c.AddDate.After(since) {
+			count++
+		}
+	}
+	if count < setting.SuspiciousContactCount {
+		return "", false
+	}
+	return fmt.Sprintf("下单前%d分钟内添加了%d名乘客", setting.SuspiciousContactMins, count), true
+}
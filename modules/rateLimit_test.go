@@ -0,0 +1,52 @@
+package modules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(func(s *LimitSetting) (float64, int) { return 1, 2 })
+	now := time.Now()
+	if l.allow("ip_1", now) && l.allow("ip_1", now) && !l.allow("ip_1", now) {
+		t.Log("burst pass")
+	} else {
+		t.Error("burst fail")
+	}
+	if l.allow("ip_2", now) {
+		t.Log("key isolation pass")
+	} else {
+		t.Error("key isolation fail")
+	}
+	if l.allow("ip_1", now.Add(time.Second)) && !l.allow("ip_1", now.Add(time.Second)) {
+		t.Log("refill pass")
+	} else {
+		t.Error("refill fail")
+	}
+	l.clean(now.Add(time.Second + constLimitBucketIdle + time.Second))
+	if len(l.buckets) == 0 {
+		t.Log("clean pass")
+	} else {
+		t.Error("clean fail")
+	}
+}
+
+func TestIsSuspiciousContacts(t *testing.T) {
+	now := time.Now()
+	setting := &LimitSetting{SuspiciousContactCount: 2, SuspiciousContactMins: 30}
+	contacts := []Contact{
+		Contact{AddDate: now.Add(-time.Minute)},
+		Contact{AddDate: now.Add(-time.Hour)},
+	}
+	if _, suspicious := isSuspiciousContacts(contacts, now, setting); !suspicious {
+		t.Log("not suspicious pass")
+	} else {
+		t.Error("not suspicious fail")
+	}
+	contacts[1].AddDate = now.Add(-10 * time.Minute)
+	if reason, suspicious := isSuspiciousContacts(contacts, now, setting); suspicious && reason != "" {
+		t.Log("suspicious pass")
+	} else {
+		t.Error("suspicious fail")
+	}
+}
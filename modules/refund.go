@@ -0,0 +1,113 @@
+package modules
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// 退款记录状态
+	constRefundPending  = iota // 待退款
+	constRefundFinished        // 已退款
+	constRefundFailed          // 重试后仍失败，需人工处理
+
+	constRefundMaxRetryTimes = 5  // 退款的最大重试次数
+	constRefundBatchSize     = 50 // 每次重试的退款记录数量
+)
+
+// 退款重试同一时间只执行一个
+var refundRetryLock sync.Mutex
+
+// RefundRecord 退款记录，与退票、改签的车票及订单状态在同一事务中写入，事务提交后再调用支付渠道退款，
+// 退款失败时由定时任务重试，保证已退票的订单最终能退还票款
+type RefundRecord struct {
+	ID          uint64
+	OrderID     uint64    `gorm:"index:ref"` // 订单ID
+	UserID      uint64    // 用户ID
+	PayType     uint8     // 支付类型
+	PayAccount  string    // 支付账户
+	Price       float32   // 退款金额
+	Status      uint8     `gorm:"index:main"` // 状态 0.待退款 1.已退款 2.失败
+	RetryTimes  uint8     // 重试次数
+	LastErr     string    `gorm:"type:nvarchar(200)"` // 最近一次失败的原因
+	CreateTime  time.Time `gorm:"type:datetime"`      // 创建时间，同时作为支付渠道退款请求的时间戳，重试时不变
+	NextTryTime time.Time `gorm:"type:datetime"`      // 下次退款时间
+	RefundTime  time.Time `gorm:"type:datetime"`      // 退款成功时间
+}
+
+// addRefundRecord 在订单所在的事务中写入待退款记录
+func addRefundRecord(tx *gorm.DB, o *Order, price float32) (*RefundRecord, error) {
+	now := time.Now()
+	// 事务提交后会立即退款，重试任务延后处理，避免同时退款
+	r := &RefundRecord{
+		OrderID:     o.ID,
+		UserID:      o.UserID,
+		PayType:     o.PayType,
+		PayAccount:  o.PayAccount,
+		Price:       price,
+		Status:      constRefundPending,
+		CreateTime:  now,
+		NextTryTime: now.Add(time.Minute),
+	}
+	if err := tx.Create(r).Error; err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// refund 调用支付渠道退款并保存结果，失败时记录重试时间
+func (r *RefundRecord) refund() error {
+	now := time.Now()
+	err := Refund(r.OrderID, r.UserID, r.PayType, r.PayAccount, r.Price, r.CreateTime.Format(ConstYMdHmsFormat))
+	if err != nil {
+		if r.refundFailed(err, now) {
+			notify := &notifyAdminInfo{
+				date:       now.Format(ConstYmdFormat),
+				refID:      r.ID,
+				notifyType: "refund",
+				severity:   constAlertSeverityError,
+				message:    fmt.Sprintf("Refund %d Order %d Price %.2f Failed: %s", r.ID, r.OrderID, r.Price, r.LastErr)}
+			notify.notifyAdmin()
+		}
+	} else {
+		r.Status = constRefundFinished
+		r.RefundTime = now
+	}
+	db.Save(r)
+	return err
+}
+
+// refundFailed 记录一次退款失败，按重试次数的平方退避，单位：分钟；重试次数用尽时返回真
+func (r *RefundRecord) refundFailed(err error, now time.Time) bool {
+	r.RetryTimes++
+	r.LastErr = err.Error()
+	r.NextTryTime = now.Add(time.Duration(r.RetryTimes) * time.Duration(r.RetryTimes) * time.Minute)
+	if r.RetryTimes >= constRefundMaxRetryTimes {
+		r.Status = constRefundFailed
+		return true
+	}
+	return false
+}
+
+// initRefundRetry 每分钟重试一次到期的待退款记录
+func initRefundRetry() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			retryRefunds()
+		}
+	}()
+}
+
+// retryRefunds 重试到期的待退款记录，包括事务提交后进程退出而未及调用支付渠道的记录
+func retryRefunds() {
+	refundRetryLock.Lock()
+	defer refundRetryLock.Unlock()
+	var list []RefundRecord
+	db.Where("status = ? and next_try_time <= ?", constRefundPending, time.Now()).Order("id").Limit(constRefundBatchSize).Find(&list)
+	for i := 0; i < len(list); i++ {
+		list[i].refund()
+	}
+}
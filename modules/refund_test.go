@@ -0,0 +1,24 @@
+package modules
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRefundFailed(t *testing.T) {
+	now := time.Now()
+	r := &RefundRecord{Status: constRefundPending}
+	if r.refundFailed(errors.New("channel error"), now) || r.Status != constRefundPending ||
+		r.LastErr != "channel error" || !r.NextTryTime.Equal(now.Add(time.Minute)) {
+		t.Error("refundFailed retry fail")
+	} else {
+		t.Log("refundFailed retry pass")
+	}
+	r.RetryTimes = constRefundMaxRetryTimes - 1
+	if r.refundFailed(errors.New("channel error"), now) && r.Status == constRefundFailed {
+		t.Log("refundFailed give up pass")
+	} else {
+		t.Error("refundFailed give up fail")
+	}
+}
@@ -0,0 +1,252 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// 往返订单的行程段序号
+	constRoundTripOutbound = iota + 1 // 去程
+	constRoundTripReturn              // 返程
+)
+
+// RoundTripOrderModel 提交往返订单的请求结构体，去程与返程的乘客相同
+type RoundTripOrderModel struct {
+	UserID       uint64           `bson:"userID"`       // 用户ID
+	PassengerIDs []uint64         `bson:"passengerIDs"` // 乘客
+	IsStudent    bool             `bson:"isStudent"`    // 是否为学生票
+	Outbound     SubmitOrderModel `bson:"outbound"`     // 去程
+	Return       SubmitOrderModel `bson:"return"`       // 返程
+}
+
+// SubmitRoundTrip 提交往返订单，去程与返程同时订票，共用一个订单一次支付，并按配置的折扣率优惠
+// 下单后可分别退票或改签去程、返程；与联程订单相同，不经过订票队列
+func SubmitRoundTrip(m RoundTripOrderModel) (*Order, error) {
+	legs, err := newItineraryLegs(m.UserID, m.PassengerIDs, m.IsStudent, []SubmitOrderModel{m.Outbound, m.Return})
+	if err != nil {
+		return nil, err
+	}
+	if err = validRoundTrip(legs[0], legs[1]); err != nil {
+		return nil, err
+	}
+	return submitLegs(m.UserID, legs, constOrderTypeRoundTrip)
+}
+
+// validRoundTrip 校验返程：从去程的到达城市返回去程的出发城市，且在去程到达之后出发
+func validRoundTrip(outbound, back *itineraryLeg) error {
+	if outbound.tran.Timetable[outbound.par.ArrIdx].CityCode != back.tran.Timetable[back.par.DepIdx].CityCode ||
+		outbound.tran.Timetable[outbound.par.DepIdx].CityCode != back.tran.Timetable[back.par.ArrIdx].CityCode {
+		return errors.New("返程的起止站须与去程相反")
+	}
+	if back.par.depTime.Before(outbound.par.arrTime) {
+		return errors.New("返程须在去程到达之后出发")
+	}
+	return nil
+}
+
+// validRoundTripChange 改签往返订单中的一程时，校验改签后返程仍在去程到达之后出发，另一程已失效时不校验
+func validRoundTripChange(oldTicket *Ticket, depTime, arrTime time.Time) error {
+	if oldTicket.PairTicketID == 0 {
+		return nil
+	}
+	pair := &Ticket{ID: oldTicket.PairTicketID}
+	db.First(pair)
+	if _, valid := getCancelTicketStatus(pair.Status); !valid || pair.TranNum == "" {
+		return nil
+	}
+	if !isRoundTripLegInOrder(oldTicket.LegIdx, pair, depTime, arrTime) {
+		return errors.New("返程须在去程到达之后出发")
+	}
+	return nil
+}
+
+// isRoundTripLegInOrder 改签后的一程与另一程的车票是否仍为先去程后返程
+func isRoundTripLegInOrder(legIdx uint8, pair *Ticket, depTime, arrTime time.Time) bool {
+	switch legIdx {
+	case constRoundTripOutbound:
+		return !pair.DepTime.Before(arrTime)
+	case constRoundTripReturn:
+		return !depTime.Before(pair.ArrTime)
+	}
+	return true
+}
+
+// withRoundTripDiscount 在动态调价的基础上叠加往返折扣
+func withRoundTripDiscount(d *priceDecision) *priceDecision {
+	rate := GetPriceSetting().RoundTripRate
+	reasons := append(append([]string(nil), d.reasons...), fmt.Sprintf("往返优惠 x%.2f", rate))
+	return &priceDecision{rate: d.rate * rate, reasons: reasons, quoteID: d.quoteID}
+}
+
+// pairRoundTripTickets 同一乘客的去程与返程车票互相记录对方的车票ID
+func pairRoundTripTickets(outbound, back []*Ticket) {
+	for _, o := range outbound {
+		for _, b := range back {
+			if o.PassengerID == b.PassengerID {
+				o.PairTicketID, b.PairTicketID = b.ID, o.ID
+				break
+			}
+		}
+	}
+}
+
+// roundTripTicketStatus 往返订单中仍有效的车票状态，包括改签至新订单的车票
+var roundTripTicketStatus = []uint8{constTicketPaid, constTicketIssued, constTicketChangeUnpay, constTicketChangePaid, constTicketChangeIssued}
+
+// roundTripLegTickets 查询往返订单中有效的车票，legCond 为行程段序号的比较符
+// 改签后的车票属于新订单，按其改签前的车票属于该订单查询；车票只能改签一次
+func roundTripLegTickets(tx *gorm.DB, orderID uint64, legCond string, legIdx uint8) *gorm.DB {
+	return tx.Model(&Ticket{}).Where("leg_idx "+legCond+" ? and status in (?) and (order_id = ? or change_ticket_id in (select id from tickets where order_id = ?))",
+		legIdx, roundTripTicketStatus, orderID, orderID)
+}
+
+// RefundRoundTripLeg 退往返订单中某一程的车票，另一程不受影响，两程均已退票时订单置为已退款
+// 按该程车票的实收票价退款，已享受的往返优惠不追回；已改签的车票一并退票，其新订单置为已退款或已取消
+func RefundRoundTripLeg(orderID uint64, legIdx uint8) error {
+	o := &Order{ID: orderID}
+	db.First(o)
+	if o.OrderType != constOrderTypeRoundTrip {
+		return errors.New("订单无效")
+	}
+	if o.Status != constOrderPaid {
+		return errors.New("订单未支付或已退款")
+	}
+	if legIdx != constRoundTripOutbound && legIdx != constRoundTripReturn {
+		return errors.New("行程无效")
+	}
+	var tickets []Ticket
+	if err := roundTripLegTickets(db, o.ID, "=", legIdx).Find(&tickets).Error; err != nil {
+		return fmt.Errorf("退票失败: %v", err)
+	}
+	if len(tickets) == 0 {
+		return errors.New("该程没有可退的车票")
+	}
+	orders := map[uint64]*Order{o.ID: o}
+	for _, t := range tickets {
+		if _, exist := orders[t.OrderID]; !exist {
+			n := &Order{ID: t.OrderID}
+			if err := db.First(n).Error; err != nil {
+				return fmt.Errorf("退票失败: %v", err)
+			}
+			orders[n.ID] = n
+		}
+	}
+	tx := db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("退票失败: %v", tx.Error)
+	}
+	// 锁定订单，同时退两程时依次执行，由后退的一程将订单置为已退款
+	err := tx.Set("gorm:query_option", "FOR UPDATE").First(&Order{ID: o.ID}).Error
+	// 各订单的退款金额
+	prices := make(map[uint64]float32)
+	for i := 0; err == nil && i < len(tickets); i++ {
+		t := &tickets[i]
+		status, _ := getCancelTicketStatus(t.Status)
+		// 以原状态为条件更新，避免重复退票而多次释放座位
+		q := tx.Model(&Ticket{}).Where("id = ? and status = ?", t.ID, t.Status).Update("status", status)
+		if err = q.Error; err == nil && q.RowsAffected != 1 {
+			err = errors.New("车票状态已变更")
+		}
+		if err == nil && t.OrderID != o.ID {
+			n := orders[t.OrderID]
+			oldPart, newPart := getRoundTripRefundParts(t, n)
+			prices[o.ID] += oldPart
+			prices[n.ID] += newPart
+			err = cancelChangedOrder(tx, n)
+		} else {
+			prices[o.ID] += t.Price
+		}
+		if err == nil {
+			err = publishOrderEvent(tx, constEventTicketRefunded, t.OrderID, t.ID, o.UserID)
+		}
+	}
+	remain := 0
+	if err == nil {
+		err = roundTripLegTickets(tx, o.ID, "!=", legIdx).Count(&remain).Error
+	}
+	if err == nil && remain == 0 {
+		err = tx.Model(&Order{}).Where("id = ?", o.ID).Update("status", constOrderRefund).Error
+	}
+	var records []*RefundRecord
+	for id, price := range prices {
+		if err != nil {
+			break
+		}
+		if price <= 0 {
+			continue
+		}
+		var r *RefundRecord
+		if r, err = addRefundRecord(tx, orders[id], price); err == nil {
+			records = append(records, r)
+		}
+	}
+	if err == nil {
+		err = tx.Commit().Error
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		return fmt.Errorf("退票失败: %v", err)
+	}
+	releaseTicketSeats(tickets)
+	for _, r := range records {
+		if e := r.refund(); e != nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("退票成功，退款失败，将自动重试: %v", err)
+	}
+	return nil
+}
+
+// getRoundTripRefundParts 已改签车票的退款金额分别从原订单及改签后的新订单退还
+// 新订单的金额为改签时另行支付（或待支付）的差额，其余部分由原订单支付；新订单未支付时，差额部分无需退还
+// 改签后票价较低时新订单无需支付，差额已在改签时退还，车票票价全部从原订单退还
+func getRoundTripRefundParts(t *Ticket, n *Order) (oldPart, newPart float32) {
+	if n.Status != constOrderUnpay && n.PayTime.IsZero() {
+		return t.Price, 0
+	}
+	diff := n.Price
+	if diff > t.Price {
+		diff = t.Price
+	}
+	if n.Status == constOrderUnpay {
+		return t.Price - diff, 0
+	}
+	return t.Price - diff, diff
+}
+
+// cancelChangedOrder 往返订单中已改签的一程退票后，其改签生成的新订单置为已退款，未支付时置为已取消
+func cancelChangedOrder(tx *gorm.DB, n *Order) error {
+	status := uint8(constOrderRefund)
+	if n.Status == constOrderUnpay {
+		status = constOrderCancelled
+	}
+	q := tx.Model(&Order{}).Where("id = ? and status = ?", n.ID, n.Status).Update("status", status)
+	if q.Error == nil && q.RowsAffected != 1 {
+		return errors.New("改签订单状态已变更")
+	}
+	return q.Error
+}
+
+// refundRoundTrip 退往返订单中尚未退票的各程车票
+func refundRoundTrip(orderID uint64) error {
+	for _, legIdx := range []uint8{constRoundTripOutbound, constRoundTripReturn} {
+		count := 0
+		if err := roundTripLegTickets(db, orderID, "=", legIdx).Count(&count).Error; err != nil {
+			return fmt.Errorf("退票失败: %v", err)
+		}
+		if count == 0 {
+			continue
+		}
+		if err := RefundRoundTripLeg(orderID, legIdx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package modules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidRoundTrip(t *testing.T) {
+	day := time.Date(2018, 6, 30, 0, 0, 0, 0, time.Local)
+	outbound := newTestLeg("wuhan", "beijing", day.Add(8*time.Hour), day.Add(13*time.Hour))
+	cases := []struct {
+		name string
+		back *itineraryLeg
+		ok   bool
+	}{
+		{"same day return", newTestLeg("beijing", "wuhan", day.Add(13*time.Hour), day.Add(18*time.Hour)), true},
+		{"next week return", newTestLeg("beijing", "wuhan", day.AddDate(0, 0, 7), day.AddDate(0, 0, 7).Add(5*time.Hour)), true},
+		{"overlap", newTestLeg("beijing", "wuhan", day.Add(12*time.Hour), day.Add(17*time.Hour)), false},
+		{"other city", newTestLeg("beijing", "shanghai", day.Add(15*time.Hour), day.Add(20*time.Hour)), false},
+	}
+	for _, c := range cases {
+		if err := validRoundTrip(outbound, c.back); (err == nil) == c.ok {
+			t.Log("validRoundTrip " + c.name + " pass")
+		} else {
+			t.Error("validRoundTrip " + c.name + " fail")
+		}
+	}
+}
+
+func TestRoundTripDiscount(t *testing.T) {
+	oldSetting := priceSetting
+	defer func() { priceSetting = oldSetting }()
+	priceSetting = PriceSetting{MinRate: 0.6, MaxRate: 1.5, QuoteValidMins: 15, RoundTripRate: 0.9}
+	d := &priceDecision{rate: 1.2, reasons: []string{"春运 x1.20"}, quoteID: "q1"}
+	rd := withRoundTripDiscount(d)
+	if adjustPrice(100, rd.rate) == 108 && len(rd.reasons) == 2 && len(d.reasons) == 1 && rd.quoteID == "q1" {
+		t.Log("withRoundTripDiscount pass")
+	} else {
+		t.Error("withRoundTripDiscount fail")
+	}
+
+	outbound := []*Ticket{&Ticket{ID: 11, PassengerID: 1}, &Ticket{ID: 12, PassengerID: 2}}
+	back := []*Ticket{&Ticket{ID: 21, PassengerID: 2}, &Ticket{ID: 22, PassengerID: 1}}
+	pairRoundTripTickets(outbound, back)
+	if outbound[0].PairTicketID == 22 && outbound[1].PairTicketID == 21 && back[0].PairTicketID == 12 && back[1].PairTicketID == 11 {
+		t.Log("pairRoundTripTickets pass")
+	} else {
+		t.Error("pairRoundTripTickets fail")
+	}
+}
+
+func TestRoundTripLegInOrder(t *testing.T) {
+	day := time.Date(2018, 10, 1, 0, 0, 0, 0, time.Local)
+	back := &Ticket{DepTime: day.Add(18 * time.Hour), ArrTime: day.Add(22 * time.Hour)}
+	outbound := &Ticket{DepTime: day.Add(8 * time.Hour), ArrTime: day.Add(12 * time.Hour)}
+	cases := []struct {
+		name             string
+		legIdx           uint8
+		pair             *Ticket
+		depHour, arrHour time.Duration
+		ok               bool
+	}{
+		{"outbound earlier", constRoundTripOutbound, back, 9, 13, true},
+		{"outbound after return", constRoundTripOutbound, back, 15, 19, false},
+		{"return later", constRoundTripReturn, outbound, 13, 17, true},
+		{"return before arrival", constRoundTripReturn, outbound, 11, 15, false},
+	}
+	for _, c := range cases {
+		if isRoundTripLegInOrder(c.legIdx, c.pair, day.Add(c.depHour*time.Hour), day.Add(c.arrHour*time.Hour)) == c.ok {
+			t.Log("isRoundTripLegInOrder " + c.name + " pass")
+		} else {
+			t.Error("isRoundTripLegInOrder " + c.name + " fail")
+		}
+	}
+}
+
+func TestRoundTripRefundParts(t *testing.T) {
+	ticket := &Ticket{Price: 300}
+	cases := []struct {
+		name             string
+		order            *Order
+		oldPart, newPart float32
+	}{
+		{"cheaper change", &Order{Status: constOrderPaid, Price: 300}, 300, 0},
+		{"paid difference", &Order{Status: constOrderPaid, Price: 100, PayTime: time.Now()}, 200, 100},
+		{"unpaid difference", &Order{Status: constOrderUnpay, Price: 100}, 200, 0},
+	}
+	for _, c := range cases {
+		if oldPart, newPart := getRoundTripRefundParts(ticket, c.order); oldPart == c.oldPart && newPart == c.newPart {
+			t.Log("getRoundTripRefundParts " + c.name + " pass")
+		} else {
+			t.Error("getRoundTripRefundParts " + c.name + " fail")
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package modules
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	constScheduleJobHour     = 2                     // 每日排班任务在该时刻之后执行
+	constScheduleArchiveDays = constDays             // 发车超过该天数的排班归档，保留期间内可查询已发车的排班
+	constScheduleArchiveColl = "tranScheduleArchive" // 归档排班的集合
+)
+
+// ScheduleJobReport 排班任务的执行结果，排班以“车次 发车日期”表示，如：G1 2018-10-01
+type ScheduleJobReport struct {
+	StartTime   time.Time         `json:"startTime"`
+	EndTime     time.Time         `json:"endTime"`
+	Created     []string          `json:"created"`     // 新生成的排班
+	Regenerated []string          `json:"regenerated"` // 车次版本修改后重新生成的排班
+	Removed     []string          `json:"removed"`     // 不再开行且未售票而删除的排班
+	Skipped     []ScheduleJobSkip `json:"skipped"`     // 需要生成或修改但跳过的排班
+	Unchanged   int               `json:"unchanged"`   // 与车次版本一致的排班数
+	Archived    int               `json:"archived"`    // 归档的排班数
+	lock        sync.Mutex
+}
+
+// ScheduleJobSkip 跳过的排班及原因
+type ScheduleJobSkip struct {
+	Schedule string `json:"schedule"`
+	Reason   string `json:"reason"`
+}
+
+func (r *ScheduleJobReport) add(list *[]string, tranNum, date string) {
+	r.lock.Lock()
+	*list = append(*list, tranNum+" "+date)
+	r.lock.Unlock()
+}
+
+func (r *ScheduleJobReport) skip(tranNum, date, format string, a ...interface{}) {
+	r.lock.Lock()
+	r.Skipped = append(r.Skipped, ScheduleJobSkip{Schedule: tranNum + " " + date, Reason: fmt.Sprintf(format, a...)})
+	r.lock.Unlock()
+}
+
+func (r *ScheduleJobReport) addUnchanged() {
+	r.lock.Lock()
+	r.Unchanged++
+	r.lock.Unlock()
+}
+
+// sort 各列表按车次及日期排序，便于查看
+func (r *ScheduleJobReport) sort() {
+	sort.Strings(r.Created)
+	sort.Strings(r.Regenerated)
+	sort.Strings(r.Removed)
+	sort.Slice(r.Skipped, func(i, j int) bool { return r.Skipped[i].Schedule < r.Skipped[j].Schedule })
+}
+
+func (r *ScheduleJobReport) String() string {
+	return fmt.Sprintf("新增%d个，重新生成%d个，删除%d个，跳过%d个，未变化%d个，归档%d个",
+		len(r.Created), len(r.Regenerated), len(r.Removed), len(r.Skipped), r.Unchanged, r.Archived)
+}
+
+// print 输出执行结果及跳过的各排班
+func (r *ScheduleJobReport) print(title string) {
+	fmt.Println(title, "complete,", r, "cost time:", r.EndTime.Sub(r.StartTime).Seconds(), "(s)")
+	for _, s := range r.Skipped {
+		fmt.Println(title, "skipped", s.Schedule, s.Reason)
+	}
+}
+
+var (
+	// 最近一次排班任务的结果
+	lastScheduleJobReport *ScheduleJobReport
+	// 排班任务同一时间只执行一个
+	scheduleJobLock sync.Mutex
+)
+
+// initScheduleJob 启动时生成排班，之后每日在constScheduleJobHour点后向后延伸一天并归档已完成的排班
+func initScheduleJob() {
+	start := time.Now()
+	RunScheduleJob(start).print("init scheduleTran")
+	go func() {
+		lastRunDate := start.Format(ConstYmdFormat)
+		for now := range time.Tick(10 * time.Minute) {
+			if now.Hour() < constScheduleJobHour || now.Format(ConstYmdFormat) == lastRunDate {
+				continue
+			}
+			lastRunDate = now.Format(ConstYmdFormat)
+			RunScheduleJob(now).print("schedule job")
+		}
+	}()
+}
+
+// RunScheduleJob 执行排班任务：归档已完成的排班，为各售票车次生成可订票天数内的排班
+func RunScheduleJob(now time.Time) *ScheduleJobReport {
+	scheduleJobLock.Lock()
+	defer scheduleJobLock.Unlock()
+	report := &ScheduleJobReport{StartTime: now}
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	archiveSchedules(today, report)
+
+	goPoolCompute := newGoPool(300)
+	var wg sync.WaitGroup
+	tranInfos := getTranSnapshot().tranInfos
+	for idx := 0; idx < len(tranInfos); idx++ {
+		if !tranInfos[idx].IsSaleTicket {
+			continue
+		}
+		wg.Add(1)
+		goPoolCompute.Take()
+		go func(i int) {
+			defer func() {
+				goPoolCompute.Return()
+				wg.Done()
+			}()
+			tranInfos[i].scheduleTrans(today, report)
+		}(idx)
+	}
+	wg.Wait()
+	goPoolCompute.Close()
+	report.EndTime = time.Now()
+	report.sort()
+	lastScheduleJobReport = report
+	return report
+}
+
+// GetLastScheduleJobReport 获取最近一次排班任务的结果
+func GetLastScheduleJobReport() *ScheduleJobReport {
+	scheduleJobLock.Lock()
+	defer scheduleJobLock.Unlock()
+	return lastScheduleJobReport
+}
+
+// archiveSchedules 将发车超过constScheduleArchiveDays天的排班移到归档集合
+func archiveSchedules(today time.Time, report *ScheduleJobReport) {
+	session := getMgoSession()
+	defer session.Close()
+	coll, archive := session.DB(constMgoDB).C("tranSchedule"), session.DB(constMgoDB).C(constScheduleArchiveColl)
+	query := bson.M{"departureDate": bson.M{"$lt": today.AddDate(0, 0, -constScheduleArchiveDays).Format(ConstYmdFormat)}}
+	iter := coll.Find(query).Iter()
+	var doc bson.M
+	for iter.Next(&doc) {
+		sel := bson.M{"tranNum": doc["tranNum"], "departureDate": doc["departureDate"]}
+		delete(doc, "_id")
+		if _, err := archive.Upsert(sel, doc); err != nil {
+			fmt.Println("archive schedule error:", err)
+			continue
+		}
+		if err := coll.Remove(sel); err == nil {
+			scheduleTranMap.Delete(fmt.Sprint(doc["tranNum"], "_", doc["departureDate"]))
+			report.Archived++
+		}
+		doc = nil
+	}
+	if err := iter.Close(); err != nil {
+		fmt.Println("archive schedule error:", err)
+	}
+}
+
+// scheduleTrans 生成车次版本在可订票天数内的排班：缺少的日期新增；已有排班与版本不一致且未售票的重新生成，
+// 不再开行且未售票的删除，已售票的保留原排班并记为跳过
+func (t *TranInfo) scheduleTrans(today time.Time, report *ScheduleJobReport) {
+	// 排班的开始日期和截止日期
+	start, end := today, today.AddDate(0, 0, constDays)
+	if t.EnableStartDate.After(start) {
+		start = t.EnableStartDate
+	}
+	if t.EnableEndDate.Before(end) {
+		end = t.EnableEndDate
+	}
+	if start.After(end) {
+		return
+	}
+	session := getMgoSession()
+	defer session.Close()
+	coll := session.DB(constMgoDB).C("tranSchedule")
+	// 同一车次的各版本生效期互不重叠，只处理本版本生效期内的排班，避免受其它版本的影响
+	var existing []ScheduleTran
+	coll.Find(bson.M{"tranNum": t.TranNum, "departureDate": bson.M{
+		"$gte": start.Format(ConstYmdFormat), "$lte": end.Format(ConstYmdFormat)}}).
+		Select(bson.M{"departureDate": 1, "tranID": 1, "signature": 1, "releasedQuotas": 1, "suspension": 1}).All(&existing)
+	existMap := make(map[string]*ScheduleTran, len(existing))
+	for i := range existing {
+		existMap[existing[i].DepartureDate] = &existing[i]
+	}
+	signature := t.scheduleSignature()
+	// 按开行日历逐日排班
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format(ConstYmdFormat)
+		old, exist := existMap[date]
+		sel := bson.M{"tranNum": t.TranNum, "departureDate": date}
+		switch {
+		case !t.isOperatingDay(day):
+			if exist {
+				t.removeSchedule(coll, sel, old, report)
+			}
+		case !exist:
+			// 每个排班单独生成车厢，不与其它日期的排班共享座位
+			if err := coll.Insert(t.newScheduleTran(day, signature)); err != nil {
+				report.skip(t.TranNum, date, "生成失败：%v", err)
+			} else {
+				report.add(&report.Created, t.TranNum, date)
+			}
+		case old.TranID == t.ID && (old.Signature == signature || old.Signature == ""):
+			// 未记录特征值的排班为早期生成的，视为与版本一致
+			report.addUnchanged()
+		default:
+			t.regenerateSchedule(coll, sel, old, day, signature, report)
+		}
+	}
+}
+
+// removeSchedule 删除不再开行的排班，缓存中的排班标记为已删除并移出缓存，已取得其引用的订票随之失败
+func (t *TranInfo) removeSchedule(coll *mgo.Collection, sel bson.M, old *ScheduleTran, report *ScheduleJobReport) {
+	cached := lockCachedSchedule(t.TranNum, old.DepartureDate)
+	if cached != nil {
+		defer cached.repairLock.Unlock()
+	}
+	if reason := t.checkScheduleReplaceable(old, cached); reason != "" {
+		report.skip(t.TranNum, old.DepartureDate, "不再开行，%s，保留原排班", reason)
+	} else if err := coll.Remove(sel); err != nil {
+		report.skip(t.TranNum, old.DepartureDate, "删除失败：%v", err)
+	} else {
+		if cached != nil {
+			cached.removed = true
+		}
+		scheduleTranMap.Delete(t.TranNum + "_" + old.DepartureDate)
+		report.add(&report.Removed, t.TranNum, old.DepartureDate)
+	}
+}
+
+// regenerateSchedule 按车次版本重新生成排班，原排班已释放的座位配额在新排班上同样释放；
+// 缓存中的排班原地替换为新排班的内容，已取得其引用的订票使用新的车厢
+func (t *TranInfo) regenerateSchedule(coll *mgo.Collection, sel bson.M, old *ScheduleTran, day time.Time, signature string, report *ScheduleJobReport) {
+	cached := lockCachedSchedule(t.TranNum, old.DepartureDate)
+	if cached != nil {
+		defer cached.repairLock.Unlock()
+	}
+	if reason := t.checkScheduleReplaceable(old, cached); reason != "" {
+		report.skip(t.TranNum, old.DepartureDate, "与车次版本不一致，%s，保留原排班", reason)
+		return
+	}
+	released := old.ReleasedQuotas
+	if cached != nil {
+		released = cached.ReleasedQuotas
+	}
+	st := t.newScheduleTran(day, signature)
+	st.releaseQuotas(released)
+	if err := coll.Update(sel, st); err != nil {
+		report.skip(t.TranNum, old.DepartureDate, "重新生成失败：%v", err)
+		return
+	}
+	if cached != nil {
+		cached.replaceWith(st)
+	}
+	report.add(&report.Regenerated, t.TranNum, old.DepartureDate)
+}
+
+// lockCachedSchedule 独占锁定缓存中的排班，从检查能否替换到替换完成期间不允许订票、退票；
+// 未缓存时先加载到缓存再锁定，订票取得的是同一排班，不会在检查后读取到原排班；数据库中不存在时返回nil
+func lockCachedSchedule(tranNum, date string) *ScheduleTran {
+	key := tranNum + "_" + date
+	for {
+		st := scheduleCache.getScheduleTran(tranNum, date)
+		if st.TranNum == "" {
+			return nil
+		}
+		st.repairLock.Lock()
+		if val, ok := scheduleTranMap.Load(key); ok && val.(*ScheduleTran) == st {
+			return st
+		}
+		// 锁定前已移出缓存，重新取得
+		st.repairLock.Unlock()
+	}
+}
+
+// newScheduleTran 车次版本在某日的新排班
+func (t *TranInfo) newScheduleTran(day time.Time, signature string) *ScheduleTran {
+	y, M, d := day.Date()
+	h, m, s := t.SaleTicketTime.Clock()
+	return &ScheduleTran{
+		TranNum:        t.TranNum,
+		TranID:         t.ID,
+		DepartureDate:  day.Format(ConstYmdFormat),
+		SaleTicketTime: time.Date(y, M, d-constDays, h, m, s, 0, time.Local),
+		Cars:           t.getScheduleCars(),
+		FullSeatBit:    countSeatBit(0, uint8(len(t.Timetable)-1)),
+		LastUpdateTime: time.Now(),
+		Signature:      signature,
+	}
+}
+
+// scheduleSignature 车次版本中影响排班内容的部分的特征值：版本ID、路段数、售票时间及各车厢座位和配额
+func (t *TranInfo) scheduleSignature() string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d|%d|%s", t.ID, len(t.Timetable), t.SaleTicketTime.Format(ConstHmsFormat))
+	cars := t.getScheduleCars()
+	for i := range cars {
+		fmt.Fprintf(h, "|%s,%d", cars[i].SeatType, cars[i].NoSeatCount)
+		for _, s := range cars[i].Seats {
+			fmt.Fprintf(h, ",%s:%t:%d", s.SeatNum, s.IsStudent, s.QuotaBit)
+		}
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// checkScheduleReplaceable 排班能否重新生成或删除，不能时返回原因：已售票、已停运或缓存中有未保存的修改
+// old 为数据库中的排班，cached 为缓存中的排班，需持有其repairLock
+func (t *TranInfo) checkScheduleReplaceable(old, cached *ScheduleTran) string {
+	if countSoldTickets(t.TranNum, old.DepartureDate, old.DepartureDate) != 0 {
+		return "已售票"
+	}
+	if old.Suspension != nil || (cached != nil && cached.Suspension != nil) {
+		return "已停运"
+	}
+	if cached != nil && cached.hasChanged {
+		return "有未保存的修改"
+	}
+	return ""
+}
+
+// countSoldTickets 统计车次在[fromDate, toDate]内发车的有效车票数，未支付的车票同样占用座位
+func countSoldTickets(tranNum, fromDate, toDate string) (count int) {
+	validTicketStatus := []uint8{constTicketUnpay, constTicketPaid, constTicketIssued, constTicketChangeUnpay, constTicketChangePaid, constTicketChangeIssued}
+	db.Model(&Ticket{}).Where("tran_num = ? and tran_dep_date >= ? and tran_dep_date <= ? and status in (?)",
+		tranNum, fromDate, toDate, validTicketStatus).Count(&count)
+	return
+}
@@ -0,0 +1,91 @@
+package modules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewScheduleTran(t *testing.T) {
+	old := getTranSnapshot()
+	defer storeTranSnapshot(old)
+	cars := map[int](Car){1: Car{ID: 1, SeatType: constSeatTypeSecondClass, Seats: []Seat{Seat{SeatNum: "01A"}, Seat{SeatNum: "01B"}}}}
+	c := cars[1]
+	storeTranSnapshot(&tranSnapshot{carMap: cars, scheduleCarMap: map[int](*ScheduleCar){1: newScheduleCar(&c)}})
+	tran := TranInfo{ID: 3, TranNum: "G1", CarIds: "1:2", Timetable: make([]Route, 3),
+		SaleTicketTime: time.Date(1, 1, 1, 8, 0, 0, 0, time.Local)}
+	tran.initCarIdx(cars)
+	signature := tran.scheduleSignature()
+	day := time.Date(2018, 10, 1, 0, 0, 0, 0, time.Local)
+	st1, st2 := tran.newScheduleTran(day, signature), tran.newScheduleTran(day.AddDate(0, 0, 1), signature)
+	st1.Cars[0].Seats[0].SeatBit = 1
+	if st1.DepartureDate == "2018-10-01" && st2.DepartureDate == "2018-10-02" && len(st2.Cars) == 2 &&
+		st2.Cars[0].Seats[0].SeatBit == 0 && st1.Cars[1].Seats[0].SeatBit == 0 && st1.Signature == signature &&
+		st2.SaleTicketTime.Equal(time.Date(2018, 10, 2-constDays, 8, 0, 0, 0, time.Local)) {
+		t.Log("new schedule pass")
+	} else {
+		t.Error("new schedule fail")
+	}
+	if tran.scheduleSignature() != signature {
+		t.Error("schedule signature stable fail")
+	}
+	tran.QuotaRules = []SeatQuotaRule{SeatQuotaRule{SeatType: constSeatTypeSecondClass, DepIdx: 0, ArrIdx: 1, SeatCount: 1}}
+	quota := tran.scheduleSignature()
+	tran.QuotaRules, tran.CarIds = nil, "1:1"
+	tran.initCarIdx(cars)
+	if quota != signature && tran.scheduleSignature() != signature && tran.scheduleSignature() != quota {
+		t.Log("schedule signature change pass")
+	} else {
+		t.Error("schedule signature change fail")
+	}
+}
+
+func TestScheduleJobReport(t *testing.T) {
+	r := &ScheduleJobReport{}
+	r.add(&r.Created, "G2", "2018-10-02")
+	r.add(&r.Created, "G1", "2018-10-03")
+	r.skip("G1", "2018-10-01", "与车次版本不一致，%s，保留原排班", "已售票")
+	r.addUnchanged()
+	r.sort()
+	if len(r.Created) == 2 && r.Created[0] == "G1 2018-10-03" && r.Skipped[0].Schedule == "G1 2018-10-01" &&
+		r.Skipped[0].Reason == "与车次版本不一致，已售票，保留原排班" && r.Unchanged == 1 {
+		t.Log("schedule job report pass")
+	} else {
+		t.Error("schedule job report fail")
+	}
+}
+
+func TestReplaceCachedSchedule(t *testing.T) {
+	cached := &ScheduleTran{TranNum: "G1", DepartureDate: "2018-10-01", Signature: "old", hasChanged: true,
+		Cars: []ScheduleCar{ScheduleCar{CarNum: 1}}}
+	st := &ScheduleTran{TranNum: "G1", DepartureDate: "2018-10-01", Signature: "new",
+		Cars: []ScheduleCar{ScheduleCar{CarNum: 1}, ScheduleCar{CarNum: 2}}, ReleasedQuotas: []string{"student"}}
+	cached.replaceWith(st)
+	if cached.Signature == "new" && len(cached.Cars) == 2 && cached.isQuotaReleased("student") && !cached.hasChanged &&
+		cached.checkSuspended(0, 1) == nil {
+		t.Log("replace cached schedule pass")
+	} else {
+		t.Error("replace cached schedule fail")
+	}
+	cached.removed = true
+	if cached.checkSuspended(0, 1) != nil {
+		t.Log("removed schedule pass")
+	} else {
+		t.Error("removed schedule fail")
+	}
+}
+
+// 排班任务锁定的与订票取得的是同一排班，锁定期间订票需等待
+func TestLockCachedSchedule(t *testing.T) {
+	st := &ScheduleTran{TranNum: "G1", DepartureDate: "2018-10-01"}
+	scheduleTranMap.Store("G1_2018-10-01", st)
+	defer scheduleTranMap.Delete("G1_2018-10-01")
+	locked := lockCachedSchedule("G1", "2018-10-01")
+	if locked == st && scheduleCache.getScheduleTran("G1", "2018-10-01") == st && !st.repairLock.TryRLock() {
+		t.Log("lock cached schedule pass")
+	} else {
+		t.Error("lock cached schedule fail")
+	}
+	if locked != nil {
+		locked.repairLock.Unlock()
+	}
+}
@@ -2,6 +2,7 @@ package modules
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -32,8 +33,6 @@ var (
 		constSeatTypeSoftSeat:            8,
 		constSeatTypeHardSeat:            9,
 	}
-	// 排班的车厢集
-	scheduleCarMap map[int](*ScheduleCar)
 	// 列车按日期安排表
 	scheduleTranMap sync.Map
 	// 排班缓存存放处
@@ -66,7 +65,10 @@ func (s *scheduleTranCache) init(length, eleCap int) {
 			for i := 0; i < len(trans); i++ {
 				if trans[i].hasChanged {
 					trans[i].LastUpdateTime = now
+					// 余票核验修复时会整体替换车厢
+					trans[i].repairLock.RLock()
 					coll.Update(bson.M{"tranNum": trans[i].TranNum, "departureDate": trans[i].DepartureDate}, &trans[i])
+					trans[i].repairLock.RUnlock()
 				} else if trans[i].LastUpdateTime.Sub(time.Now()) > 10*time.Minute {
 					// 10分钟内无变更，缓存移除
 					scheduleTranMap.Delete(trans[i].TranNum + "_" + trans[i].DepartureDate)
@@ -90,109 +92,55 @@ func (s *scheduleTranCache) getScheduleTran(tranNum, date string) *ScheduleTran
 	err := coll.Find(bson.M{"tranNum": tranNum, "departureDate": date}).One(tran)
 	session.Close()
 	if err == nil {
+		// 同时加载时以先存入缓存的为准，保证订票与排班任务取得同一排班
+		if val, loaded := scheduleTranMap.LoadOrStore(key, tran); loaded {
+			return val.(*ScheduleTran)
+		}
 		tn, _ := strconv.Atoi(tranNum[1 : len(tranNum)-1])
 		sli := s.cache[tn%s.mod]
 		s.cache[tn%s.mod] = append(sli, tran)
-		scheduleTranMap.Store(key, tran)
 	}
 	return tran
 }
 
 func initSchedule() {
-	initScheduleCar()
-	initScheduleTran()
+	initScheduleJob()
 	scheduleCache = scheduleTranCache{}
 	go scheduleCache.init(30, 5)
 }
 
 // 初始化排班的车厢
-func initScheduleCar() {
+func initScheduleCar(carMap map[int](Car)) map[int](*ScheduleCar) {
 	start := time.Now()
-	scheduleCarMap = make(map[int](*ScheduleCar), len(carMap))
+	scheduleCarMap := make(map[int](*ScheduleCar), len(carMap))
 	for id, car := range carMap {
-		sc := ScheduleCar{
-			SeatType:    car.SeatType,
-			NoSeatCount: car.NoSeatCount,
-			Seats:       make([]ScheduleSeat, len(car.Seats)),
-		}
-		for i := 0; i < len(car.Seats); i++ {
-			sc.Seats[i].SeatNum = car.Seats[i].SeatNum
-			sc.Seats[i].IsStudent = car.Seats[i].IsStudent
-		}
-		scheduleCarMap[id] = &sc
+		scheduleCarMap[id] = newScheduleCar(&car)
 	}
 	fmt.Println("init schedule car complete, cost time:", time.Now().Sub(start).Seconds(), "(s)")
+	return scheduleCarMap
 }
 
-// 初始化列车排班
-func initScheduleTran() {
-	start := time.Now()
-	goPoolCompute := newGoPool(300)
-	y, m, d := time.Now().Date()
-	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
-	var wg sync.WaitGroup
-	for idx := 0; idx < len(tranInfos); idx++ {
-		if !tranInfos[idx].IsSaleTicket {
-			continue
-		}
-		wg.Add(1)
-		goPoolCompute.Take()
-		go func(i int) {
-			session := getMgoSession()
-			coll := session.DB(constMgoDB).C("tranSchedule")
-			defer func() {
-				session.Close()
-				goPoolCompute.Return()
-				wg.Done()
-			}()
-			// 排班的开始日期和截止日期
-			start, end := today, today.AddDate(0, 0, constDays)
-			if tranInfos[i].EnableStartDate.After(start) {
-				start = tranInfos[i].EnableStartDate
-			} else if tranInfos[i].ScheduleDays != 1 {
-				// 当前时间晚于配置生效的起始时间，且并不是每天发车，则将排班开始日期设置为下次排班日期
-				start = tranInfos[i].EnableStartDate
-				for start.Before(today) {
-					start = start.AddDate(0, 0, tranInfos[i].ScheduleDays)
-				}
-			}
-			if tranInfos[i].EnableEndDate.Before(end) {
-				end = tranInfos[i].EnableEndDate
-			}
-			sTran := ScheduleTran{}
-			// 找到当前车次，已初始化的最后一个排班，并以该排班的发车日期作为开始时间
-			if err := coll.Find(bson.M{"tranNum": tranInfos[i].TranNum}).Sort("-departureDate").One(&sTran); err == nil {
-				lastDepDate, _ := time.Parse(ConstYmdFormat, sTran.DepartureDate)
-				if lastDepDate.After(tranInfos[i].EnableEndDate) {
-					// 当前车次最后一个排班的日期，晚于配置的截止日期，说明该配置的排班已经排好了，无需处理
-					return
-				}
-				if start.Before(lastDepDate) {
-					// 当前车次配置的排班未排完，则以下次排班日期作为开始日期
-					start = lastDepDate.AddDate(0, 0, tranInfos[i].ScheduleDays)
-				}
-			} else { // 当前车次无排班，。设置好其他信息
-				sTran = ScheduleTran{
-					TranNum:     tranInfos[i].TranNum,
-					Cars:        tranInfos[i].getScheduleCars(),
-					FullSeatBit: countSeatBit(0, uint8(len(tranInfos[i].Timetable)-1)),
-				}
-			}
-			for day := start; !day.After(end); day = day.AddDate(0, 0, tranInfos[i].ScheduleDays) {
-				y, M, d := day.Date()
-				h, m, s := tranInfos[i].SaleTicketTime.Clock()
-				sTran.DepartureDate = day.Format(ConstYmdFormat)
-				sTran.SaleTicketTime = time.Date(y, M, d-constDays, h, m, s, 0, time.Local)
-				sTran.LastUpdateTime = time.Now()
-				if err := coll.Insert(&sTran); err != nil {
-					panic(err)
-				}
-			}
-		}(idx)
+// newScheduleCar 排班使用的车厢模板
+func newScheduleCar(car *Car) *ScheduleCar {
+	sc := ScheduleCar{
+		SeatType:    car.SeatType,
+		NoSeatCount: car.NoSeatCount,
+		Seats:       make([]ScheduleSeat, len(car.Seats)),
+	}
+	for i := 0; i < len(car.Seats); i++ {
+		sc.Seats[i].SeatNum = car.Seats[i].SeatNum
+		sc.Seats[i].IsStudent = car.Seats[i].IsStudent
 	}
-	wg.Wait()
-	goPoolCompute.Close()
-	fmt.Println("init scheduleTran complete, cost time:", time.Now().Sub(start).Seconds(), "(s)")
+	return &sc
+}
+
+// countScheduleTrans 统计车次在[fromDate, toDate]内已生成的排班数
+func countScheduleTrans(tranNum, fromDate, toDate string) int {
+	session := getMgoSession()
+	defer session.Close()
+	count, _ := session.DB(constMgoDB).C("tranSchedule").Find(bson.M{"tranNum": tranNum,
+		"departureDate": bson.M{"$gte": fromDate, "$lte": toDate}}).Count()
+	return count
 }
 
 // ResidualTicketInfo 余票信息结构
@@ -222,8 +170,10 @@ func buildResidualTicketInfo(t *TranInfo, depIdx, arrIdx uint8, date string, isS
 		arrTime:  t.Timetable[arrIdx].ArrTime.Format(ConstHmFormat),
 		costTime: t.Timetable[arrIdx].ArrTime.Sub(t.Timetable[depIdx].DepTime).String(),
 	}
-	if t.IsSaleTicket { // 车次配置中，售票标记为真
-		st := scheduleCache.getScheduleTran(t.TranNum, r.date)
+	st := scheduleCache.getScheduleTran(t.TranNum, r.date)
+	if st.isSuspended(depIdx, arrIdx) { // 乘车区间停运，显示停运说明
+		r.remark = st.Suspension.Remark
+	} else if t.IsSaleTicket { // 车次配置中，售票标记为真
 		if st.SaleTicketTime.Before(time.Now()) { // 已过售票时间，计算各席位的余票数
 			r.seatCount = st.GetAvaliableSeatCount(t, r.depIdx, r.arrIdx, isStudent)
 		} else { // 未到售票时间，调整备注
@@ -260,13 +210,113 @@ func (r *ResidualTicketInfo) toString() string {
 
 // ScheduleTran 车次排班信息
 type ScheduleTran struct {
-	DepartureDate  string        `bson:"departureDate"`  // 发车日期
-	TranNum        string        `bson:"tranNum"`        // 车次号
-	SaleTicketTime time.Time     `bson:"saleTicketTime"` // 售票时间
-	Cars           []ScheduleCar `bson:"cars"`           // 车厢
-	FullSeatBit    int64         `bson:"fullSeatBit"`    // 全程满座的位标记值，某座位的位标记与此值相等时，表示该座位全程满座了
-	hasChanged     bool          // 缓存是否有变更
-	LastUpdateTime time.Time     `bson:"lastUpdateTime"` // 最后更新时间
+	DepartureDate  string              `bson:"departureDate"`  // 发车日期
+	TranNum        string              `bson:"tranNum"`        // 车次号
+	TranID         int                 `bson:"tranID"`         // 排班所依据的车次版本ID
+	SaleTicketTime time.Time           `bson:"saleTicketTime"` // 售票时间
+	Cars           []ScheduleCar       `bson:"cars"`           // 车厢
+	FullSeatBit    int64               `bson:"fullSeatBit"`    // 全程满座的位标记值，某座位的位标记与此值相等时，表示该座位全程满座了
+	hasChanged     bool                // 缓存是否有变更
+	LastUpdateTime time.Time           `bson:"lastUpdateTime"` // 最后更新时间
+	ReleasedQuotas []string            `bson:"releasedQuotas"` // 已释放的座位配额
+	Signature      string              `bson:"signature"`      // 生成排班时车次版本的特征值，车厢编组、配额或售票时间变化后与版本不一致
+	Suspension     *ScheduleSuspension `bson:"suspension"`     // 停运信息，未停运时为空
+	repairLock     sync.RWMutex        // 余票核验修复、设置停运时独占，订票、退票时共享
+	removed        bool                // 排班已被排班任务删除，缓存外仍持有引用的订票不能再占座
+}
+
+// replaceWith 用重新生成的排班原地替换内容，需持有repairLock
+func (st *ScheduleTran) replaceWith(n *ScheduleTran) {
+	st.TranID, st.SaleTicketTime, st.Signature = n.TranID, n.SaleTicketTime, n.Signature
+	st.Cars, st.FullSeatBit, st.ReleasedQuotas = n.Cars, n.FullSeatBit, n.ReleasedQuotas
+	st.LastUpdateTime = n.LastUpdateTime
+	st.hasChanged = false
+}
+
+// isQuotaReleased 座位配额是否已释放
+func (st *ScheduleTran) isQuotaReleased(name string) bool {
+	for _, q := range st.ReleasedQuotas {
+		if q == name {
+			return true
+		}
+	}
+	return false
+}
+
+// rLockSchedules 按发车日期、车次号排序后依次共享锁定排班，同一排班只锁定一次，返回解锁函数
+// 订票、改签同时涉及多个排班时都经此加锁，避免与停运、余票核验修复等独占锁交叉等待而死锁
+func rLockSchedules(list ...*ScheduleTran) (unlock func()) {
+	sts := make([]*ScheduleTran, 0, len(list))
+	for _, st := range list {
+		exist := false
+		for _, s := range sts {
+			exist = exist || s == st
+		}
+		if !exist {
+			sts = append(sts, st)
+		}
+	}
+	sort.Slice(sts, func(i, j int) bool {
+		return sts[i].DepartureDate+sts[i].TranNum < sts[j].DepartureDate+sts[j].TranNum
+	})
+	for _, st := range sts {
+		st.repairLock.RLock()
+	}
+	return func() {
+		for i := len(sts) - 1; i >= 0; i-- {
+			sts[i].repairLock.RUnlock()
+		}
+	}
+}
+
+// deepCopy 深拷贝，车厢、座位及各路段乘客人数均不与原排班共享
+func (st *ScheduleTran) deepCopy() *ScheduleTran {
+	result := &ScheduleTran{
+		DepartureDate:  st.DepartureDate,
+		TranNum:        st.TranNum,
+		TranID:         st.TranID,
+		SaleTicketTime: st.SaleTicketTime,
+		Cars:           make([]ScheduleCar, len(st.Cars)),
+		FullSeatBit:    st.FullSeatBit,
+		hasChanged:     st.hasChanged,
+		LastUpdateTime: st.LastUpdateTime,
+		ReleasedQuotas: append([]string(nil), st.ReleasedQuotas...),
+		Suspension:     st.Suspension,
+	}
+	for ci := 0; ci < len(st.Cars); ci++ {
+		c := &st.Cars[ci]
+		c.RLock()
+		result.Cars[ci] = ScheduleCar{
+			SeatType:               c.SeatType,
+			CarNum:                 c.CarNum,
+			NoSeatCount:            c.NoSeatCount,
+			Seats:                  make([]ScheduleSeat, len(c.Seats)),
+			EachRouteTravelerCount: make([]uint8, len(c.EachRouteTravelerCount)),
+		}
+		copy(result.Cars[ci].EachRouteTravelerCount, c.EachRouteTravelerCount)
+		c.RUnlock()
+		for si := 0; si < len(c.Seats); si++ {
+			result.Cars[ci].Seats[si] = ScheduleSeat{
+				SeatNum:   c.Seats[si].SeatNum,
+				IsStudent: c.Seats[si].IsStudent,
+				SeatBit:   atomic.LoadInt64(&c.Seats[si].SeatBit),
+				QuotaBit:  c.Seats[si].QuotaBit,
+			}
+		}
+	}
+	return result
+}
+
+// resetSeats 清空所有座位的位标记及各路段乘客人数
+func (st *ScheduleTran) resetSeats() {
+	for ci := 0; ci < len(st.Cars); ci++ {
+		for ei := 0; ei < len(st.Cars[ci].EachRouteTravelerCount); ei++ {
+			st.Cars[ci].EachRouteTravelerCount[ei] = 0
+		}
+		for si := 0; si < len(st.Cars[ci].Seats); si++ {
+			st.Cars[ci].Seats[si].SeatBit = 0
+		}
+	}
 }
 
 // Save 保存到数据库
@@ -278,6 +328,14 @@ func (st *ScheduleTran) Save() (bool, string) {
 
 // GetAvaliableSeatCount 获取各席别余票数
 func (st *ScheduleTran) GetAvaliableSeatCount(t *TranInfo, depIdx, arrIdx uint8, isStudent bool) []int {
+	// 余票核验修复时会整体替换车厢
+	st.repairLock.RLock()
+	defer st.repairLock.RUnlock()
+	return st.getAvaliableSeatCount(t, depIdx, arrIdx, isStudent)
+}
+
+// getAvaliableSeatCount 获取各席别余票数，调用方需持有repairLock
+func (st *ScheduleTran) getAvaliableSeatCount(t *TranInfo, depIdx, arrIdx uint8, isStudent bool) []int {
 	// 总共11类席别
 	result := []int{-1, -1, -1, -1, -1, -1, -1, -1, -1, -1, -1}
 	// 路段位标记
@@ -385,6 +443,16 @@ func (c *ScheduleCar) occupySeat(depIdx, arrIdx uint8) {
 	c.Unlock()
 }
 
+// countTraveler 核验时根据车票累计各路段乘客人数，与occupySeat不同，超员时也照常累加，以便暴露问题
+func (c *ScheduleCar) countTraveler(depIdx, arrIdx uint8) {
+	if c.NoSeatCount == 0 {
+		return
+	}
+	for i := depIdx; i < arrIdx && int(i) < len(c.EachRouteTravelerCount); i++ {
+		c.EachRouteTravelerCount[i]++
+	}
+}
+
 // 某座位被释放
 func (c *ScheduleCar) releaseSeat(depIdx, arrIdx uint8) {
 	if c.NoSeatCount == 0 {
@@ -402,6 +470,7 @@ type ScheduleSeat struct {
 	SeatNum   string // 座位号
 	IsStudent bool   // 是否预留给学生的座位
 	SeatBit   int64  // 座位的位标记，64位代表64个路段，值为7时，表示从起始站到第四站，这个座位都被人订了
+	QuotaBit  int64  // 分段配额的位标记，非零时只能订此范围内的路段
 }
 
 // IsAvailable 根据路段和乘客类型判断能否订票
@@ -410,6 +479,10 @@ func (s *ScheduleSeat) IsAvailable(seatBit int64, isStudent bool) bool {
 	if s.IsStudent && !isStudent {
 		return false
 	}
+	// 分段配额的座位，只能订配额路段之内的票
+	if s.QuotaBit != 0 && seatBit&^s.QuotaBit != 0 {
+		return false
+	}
 	return s.SeatBit^seatBit == s.SeatBit+seatBit
 }
 
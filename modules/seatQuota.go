@@ -0,0 +1,203 @@
+package modules
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	constSeatQuotaStudent         = "student" // 学生票配额
+	constSeatQuotaSegment         = "segment" // 中途站分段配额
+	constSeatQuotaReleaseInterval = time.Minute
+)
+
+var (
+	// StudentSeatReleaseHours 发车前多少小时将未售的学生票改为成人票
+	StudentSeatReleaseHours = 2
+	// SegmentSeatReleaseHours 发车前多少小时将未售的分段配额座位释放给所有乘客
+	SegmentSeatReleaseHours = 12
+	// 已注册的座位配额
+	seatQuotas []seatQuota
+)
+
+// seatQuota 座位配额，预留给特定乘客的座位，发车前一定时间释放给所有乘客
+type seatQuota struct {
+	name        string                                                     // 配额名称，记录在排班的已释放配额中，避免重复释放
+	releaseLead func() time.Duration                                       // 发车前多久释放
+	release     func(st *ScheduleTran, logf func(seat string)) (count int) // 释放配额，返回释放的座位数
+}
+
+// registerSeatQuota 注册座位配额
+func registerSeatQuota(q seatQuota) {
+	seatQuotas = append(seatQuotas, q)
+}
+
+func initSeatQuota() {
+	registerSeatQuota(seatQuota{
+		name:        constSeatQuotaStudent,
+		releaseLead: func() time.Duration { return time.Duration(StudentSeatReleaseHours) * time.Hour },
+		release:     releaseStudentSeats,
+	})
+	registerSeatQuota(seatQuota{
+		name:        constSeatQuotaSegment,
+		releaseLead: func() time.Duration { return time.Duration(SegmentSeatReleaseHours) * time.Hour },
+		release:     releaseSegmentSeats,
+	})
+	go func() {
+		for now := range time.Tick(constSeatQuotaReleaseInterval) {
+			releaseSeatQuotas(now)
+		}
+	}()
+}
+
+// releaseSeatQuotas 扫描即将发车的排班，释放到期的座位配额
+func releaseSeatQuotas(now time.Time) {
+	var maxLead time.Duration
+	for _, q := range seatQuotas {
+		if lead := q.releaseLead(); lead > maxLead {
+			maxLead = lead
+		}
+	}
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	tranInfos := getTranSnapshot().tranInfos
+	for i := 0; i < len(tranInfos); i++ {
+		t := &tranInfos[i]
+		if !t.IsSaleTicket || len(t.Timetable) == 0 {
+			continue
+		}
+		h, mi, s := t.Timetable[0].DepTime.Clock()
+		// 发车时间在当前时间之后、且在最长释放提前量之内的排班
+		for day := today; ; day = day.AddDate(0, 0, 1) {
+			y, M, d := day.Date()
+			depTime := time.Date(y, M, d, h, mi, s, 0, time.Local)
+			if depTime.Sub(now) > maxLead {
+				break
+			}
+			if depTime.Before(now) {
+				continue
+			}
+			if _, ok := getTranInfo(t.TranNum, day); !ok {
+				continue
+			}
+			st := scheduleCache.getScheduleTran(t.TranNum, day.Format(ConstYmdFormat))
+			if st.TranNum == "" {
+				continue
+			}
+			releaseTranQuotas(st, depTime, now)
+		}
+	}
+}
+
+// releaseTranQuotas 释放单个排班中到期且尚未释放的配额
+func releaseTranQuotas(st *ScheduleTran, depTime, now time.Time) {
+	for _, q := range seatQuotas {
+		if depTime.Sub(now) > q.releaseLead() || st.isQuotaReleased(q.name) {
+			continue
+		}
+		// 释放期间不允许订票，避免订票时读到一半的配额标记
+		st.repairLock.Lock()
+		count := q.release(st, func(seat string) {
+			log.Printf("seat quota %s released: %s %s %s\n", q.name, st.DepartureDate, st.TranNum, seat)
+		})
+		st.ReleasedQuotas = append(st.ReleasedQuotas, q.name)
+		st.hasChanged = true
+		st.repairLock.Unlock()
+		log.Printf("seat quota %s of %s %s released, %d seats\n", q.name, st.DepartureDate, st.TranNum, count)
+	}
+}
+
+// releaseQuotas 在重新生成的排班上释放原排班已释放的配额
+func (st *ScheduleTran) releaseQuotas(names []string) {
+	for _, q := range seatQuotas {
+		for _, name := range names {
+			if q.name == name && !st.isQuotaReleased(name) {
+				q.release(st, func(seat string) {})
+				st.ReleasedQuotas = append(st.ReleasedQuotas, name)
+				break
+			}
+		}
+	}
+}
+
+// releaseStudentSeats 将未售完的学生票改为成人票，已全程售出的座位无需处理
+// 余票数在查询时按座位实时计算，修改座位标记后即刷新
+func releaseStudentSeats(st *ScheduleTran, logf func(seat string)) (count int) {
+	for ci := 0; ci < len(st.Cars); ci++ {
+		c := &st.Cars[ci]
+		for si := 0; si < len(c.Seats); si++ {
+			seat := &c.Seats[si]
+			if !seat.IsStudent || atomic.LoadInt64(&seat.SeatBit) == st.FullSeatBit {
+				continue
+			}
+			seat.IsStudent = false
+			logf(fmt.Sprintf("car %d seat %s", c.CarNum, seat.SeatNum))
+			count++
+		}
+	}
+	return
+}
+
+// releaseSegmentSeats 将未售完的分段配额座位释放给所有乘客
+func releaseSegmentSeats(st *ScheduleTran, logf func(seat string)) (count int) {
+	for ci := 0; ci < len(st.Cars); ci++ {
+		c := &st.Cars[ci]
+		for si := 0; si < len(c.Seats); si++ {
+			seat := &c.Seats[si]
+			if seat.QuotaBit == 0 || atomic.LoadInt64(&seat.SeatBit) == st.FullSeatBit {
+				continue
+			}
+			seat.QuotaBit = 0
+			logf(fmt.Sprintf("car %d seat %s", c.CarNum, seat.SeatNum))
+			count++
+		}
+	}
+	return
+}
+
+// SeatQuotaRule 分段席位配额，为中途站上车的旅客预留某席别的座位
+type SeatQuotaRule struct {
+	ID        uint64 `json:"id"`
+	TranID    int    `gorm:"index:main" json:"tranID"`         // 车次ID
+	SeatType  string `gorm:"type:varchar(10)" json:"seatType"` // 席别
+	DepIdx    uint8  `json:"depIdx"`                           // 配额可售路段的起始站在时刻表中的索引
+	ArrIdx    uint8  `json:"arrIdx"`                           // 配额可售路段的终点站在时刻表中的索引
+	SeatCount int    `json:"seatCount"`                        // 预留的座位数
+}
+
+// validSeatQuotaRules 校验车次的分段配额
+func (t *TranInfo) validSeatQuotaRules() (bool, string) {
+	for _, r := range t.QuotaRules {
+		if r.DepIdx >= r.ArrIdx || int(r.ArrIdx) >= len(t.Timetable) {
+			return false, "席位配额的路段无效"
+		}
+		if r.SeatCount <= 0 {
+			return false, "席位配额的座位数必须大于零"
+		}
+	}
+	return true, ""
+}
+
+// applySeatQuotas 按分段配额标记排班车厢中的座位，从该席别最后一节车厢的最后一个座位往前预留
+// 只能订所配额路段之内的票，学生票座位不参与分配
+func (t *TranInfo) applySeatQuotas(cars []ScheduleCar) {
+	for _, r := range t.QuotaRules {
+		idxs := t.carTypeIdxMap[r.SeatType]
+		quotaBit, remain := countSeatBit(r.DepIdx, r.ArrIdx), r.SeatCount
+		for i := len(idxs) - 1; i >= 0 && remain > 0; i-- {
+			if int(idxs[i]) >= len(cars) {
+				continue
+			}
+			seats := cars[idxs[i]].Seats
+			for si := len(seats) - 1; si >= 0 && remain > 0; si-- {
+				if seats[si].IsStudent || seats[si].QuotaBit != 0 {
+					continue
+				}
+				seats[si].QuotaBit = quotaBit
+				remain--
+			}
+		}
+	}
+}
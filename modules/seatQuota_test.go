@@ -0,0 +1,114 @@
+package modules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReleaseStudentSeats(t *testing.T) {
+	st := &ScheduleTran{
+		TranNum:       "G1",
+		DepartureDate: "2018-01-01",
+		FullSeatBit:   countSeatBit(0, 3),
+		Cars: []ScheduleCar{
+			ScheduleCar{
+				CarNum: 1,
+				Seats: []ScheduleSeat{
+					ScheduleSeat{SeatNum: "01A", IsStudent: true},
+					ScheduleSeat{SeatNum: "01B", IsStudent: true, SeatBit: countSeatBit(0, 3)},
+					ScheduleSeat{SeatNum: "01C", IsStudent: true, SeatBit: countSeatBit(0, 1)},
+					ScheduleSeat{SeatNum: "01D"},
+				},
+			},
+		},
+	}
+	var logged []string
+	count := releaseStudentSeats(st, func(seat string) { logged = append(logged, seat) })
+	seats := st.Cars[0].Seats
+	if count == 2 && len(logged) == 2 && !seats[0].IsStudent && seats[1].IsStudent && !seats[2].IsStudent {
+		t.Log("releaseStudentSeats pass")
+	} else {
+		t.Error("releaseStudentSeats fail")
+	}
+	if seats[2].IsAvailable(countSeatBit(2, 3), false) {
+		t.Log("adult book released seat pass")
+	} else {
+		t.Error("adult book released seat fail")
+	}
+}
+
+func TestReleaseTranQuotas(t *testing.T) {
+	oldQuotas := seatQuotas
+	defer func() { seatQuotas = oldQuotas }()
+	released := 0
+	seatQuotas = []seatQuota{seatQuota{
+		name:        "test",
+		releaseLead: func() time.Duration { return time.Hour },
+		release: func(st *ScheduleTran, logf func(seat string)) int {
+			released++
+			return 0
+		},
+	}}
+	st := &ScheduleTran{TranNum: "G1", DepartureDate: "2018-01-01"}
+	now := time.Now()
+	releaseTranQuotas(st, now.Add(2*time.Hour), now)
+	if released == 0 && !st.isQuotaReleased("test") {
+		t.Log("not yet due pass")
+	} else {
+		t.Error("not yet due fail")
+	}
+	releaseTranQuotas(st, now.Add(30*time.Minute), now)
+	releaseTranQuotas(st, now.Add(30*time.Minute), now)
+	if released == 1 && st.isQuotaReleased("test") && st.hasChanged {
+		t.Log("release once pass")
+	} else {
+		t.Error("release once fail")
+	}
+}
+
+func TestApplySeatQuotas(t *testing.T) {
+	tran := &TranInfo{
+		Timetable:     make([]Route, 5),
+		carTypeIdxMap: map[string]([]uint8){constSeatTypeSecondClass: []uint8{0, 1}},
+		QuotaRules:    []SeatQuotaRule{SeatQuotaRule{SeatType: constSeatTypeSecondClass, DepIdx: 2, ArrIdx: 4, SeatCount: 3}},
+	}
+	if ok, _ := tran.validSeatQuotaRules(); ok {
+		t.Log("validSeatQuotaRules pass")
+	} else {
+		t.Error("validSeatQuotaRules fail")
+	}
+	cars := []ScheduleCar{
+		ScheduleCar{Seats: []ScheduleSeat{ScheduleSeat{SeatNum: "01A"}, ScheduleSeat{SeatNum: "01B"}}},
+		ScheduleCar{Seats: []ScheduleSeat{ScheduleSeat{SeatNum: "01A"}, ScheduleSeat{SeatNum: "01B", IsStudent: true}}},
+	}
+	tran.applySeatQuotas(cars)
+	quotaBit := countSeatBit(2, 4)
+	if cars[1].Seats[0].QuotaBit == quotaBit && cars[1].Seats[1].QuotaBit == 0 &&
+		cars[0].Seats[1].QuotaBit == quotaBit && cars[0].Seats[0].QuotaBit == quotaBit {
+		t.Log("applySeatQuotas pass")
+	} else {
+		t.Error("applySeatQuotas fail")
+	}
+	seat := &cars[0].Seats[0]
+	if !seat.IsAvailable(countSeatBit(0, 4), false) && seat.IsAvailable(countSeatBit(2, 3), false) {
+		t.Log("quota IsAvailable pass")
+	} else {
+		t.Error("quota IsAvailable fail")
+	}
+
+	st := &ScheduleTran{FullSeatBit: countSeatBit(0, 4), Cars: cars}
+	cars[0].Seats[1].SeatBit = countSeatBit(0, 4)
+	count := releaseSegmentSeats(st, func(seat string) {})
+	if count == 2 && seat.IsAvailable(countSeatBit(0, 4), false) {
+		t.Log("releaseSegmentSeats pass")
+	} else {
+		t.Error("releaseSegmentSeats fail")
+	}
+
+	tran.QuotaRules[0].ArrIdx = 5
+	if ok, _ := tran.validSeatQuotaRules(); !ok {
+		t.Log("invalid rule pass")
+	} else {
+		t.Error("invalid rule fail")
+	}
+}
@@ -1,13 +1,42 @@
 package modules
 
 import (
+	"errors"
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// stationSnapshot 车站快照，发布后不再修改；车站修改时复制出新的快照再整体替换，查询时不需要加锁
+type stationSnapshot struct {
+	// 车站集合，只包含客运站，按站名排序
+	stations stationCfgs
+	// 车站曾用名与现在站名的映射
+	aliasMap map[string]string
+}
+
+var (
+	// 当前的车站快照
+	stationData atomic.Value
+	// 修改快照时加锁，避免同时修改时互相覆盖
+	stationReloadLock sync.Mutex
 )
 
-// 车站集合
-var stations stationCfgs
+func getStationSnapshot() *stationSnapshot {
+	if s, ok := stationData.Load().(*stationSnapshot); ok {
+		return s
+	}
+	return &stationSnapshot{}
+}
+
+func storeStationSnapshot(s *stationSnapshot) {
+	stationData.Store(s)
+}
 
 type stationCfgs []Station
 
@@ -24,11 +53,34 @@ func (sc stationCfgs) Swap(i, j int) {
 }
 
 func initStation() {
-	db.Where("is_passenger = 1").Find(&stations)
-	sort.Sort(stations)
+	refreshStations()
 	fmt.Println("init stations complete")
 }
 
+// refreshStations 从数据库重新加载车站集合及曾用名
+func refreshStations() {
+	stationReloadLock.Lock()
+	defer stationReloadLock.Unlock()
+	var list stationCfgs
+	db.Where("is_passenger = 1").Find(&list)
+	sort.Sort(list)
+	var aliases []StationAlias
+	db.Find(&aliases)
+	var current []Station
+	db.Find(&current)
+	names := make(map[uint]string, len(current))
+	for _, s := range current {
+		names[s.ID] = s.StationName
+	}
+	aliasMap := make(map[string]string, len(aliases))
+	for _, a := range aliases {
+		if name, ok := names[a.StationID]; ok {
+			aliasMap[a.AliasName] = name
+		}
+	}
+	storeStationSnapshot(&stationSnapshot{stations: list, aliasMap: aliasMap})
+}
+
 // Station 车站信息
 type Station struct {
 	ID            uint
@@ -40,13 +92,221 @@ type Station struct {
 	IsPassenger   bool   // 是否为客运站
 }
 
+// StationAlias 车站曾用名，车站改名或被合并时记录，按曾用名查询时对应到现在的车站
+type StationAlias struct {
+	ID          uint
+	StationID   uint      `gorm:"index"`                          // 现在的车站ID
+	AliasName   string    `gorm:"type:nvarchar(20);unique_index"` // 曾用名
+	StationCode string    `gorm:"type:varchar(10)"`               // 曾用的车站编码
+	CreatedAt   time.Time // 改名或合并的时间
+}
+
+// Save 新增或修改车站，改名时记录曾用名，并同步修改各时刻表中的站名及城市
+func (s *Station) Save() (bool, string) {
+	tx := db.Begin()
+	if ok, msg := s.save(tx); !ok {
+		tx.Rollback()
+		return false, msg
+	}
+	if err := tx.Commit().Error; err != nil {
+		return false, err.Error()
+	}
+	refreshStations()
+	syncRouteStations(nil)
+	return true, ""
+}
+
+// save 在事务中校验并保存车站，被生效中的时刻表使用的车站不能停用或修改车站编码
+func (s *Station) save(tx *gorm.DB) (bool, string) {
+	s.StationName, s.StationCode, s.CityCode = strings.TrimSpace(s.StationName), strings.TrimSpace(s.StationCode), strings.TrimSpace(s.CityCode)
+	if s.StationName == "" || s.StationCode == "" || s.CityCode == "" {
+		return false, "车站名、车站编码及城市编码不能为空"
+	}
+	count := 0
+	tx.Model(&Station{}).Where("id != ? and (station_name = ? or station_code = ?)", s.ID, s.StationName, s.StationCode).Count(&count)
+	if count != 0 {
+		return false, "车站名或车站编码与其他车站重复"
+	}
+	tx.Model(&StationAlias{}).Where("station_id != ? and alias_name = ?", s.ID, s.StationName).Count(&count)
+	if count != 0 {
+		return false, "车站名是其他车站的曾用名"
+	}
+	if s.ID == 0 {
+		if err := tx.Create(s).Error; err != nil {
+			return false, err.Error()
+		}
+		return true, ""
+	}
+	var old Station
+	if tx.Where("id = ?", s.ID).First(&old).RecordNotFound() {
+		return false, "车站不存在"
+	}
+	if old.StationCode != s.StationCode || (old.IsPassenger && !s.IsPassenger) {
+		if n := countActiveRoutes(old.StationCode); n != 0 {
+			return false, fmt.Sprintf("车站%s仍被%d个生效中的时刻表使用，不能停用或修改车站编码", old.StationName, n)
+		}
+	}
+	if old.StationName != s.StationName {
+		tx.Delete(StationAlias{}, "alias_name = ?", s.StationName)
+		if err := tx.Create(&StationAlias{StationID: s.ID, AliasName: old.StationName, StationCode: old.StationCode}).Error; err != nil {
+			return false, err.Error()
+		}
+	}
+	if err := tx.Save(s).Error; err != nil {
+		return false, err.Error()
+	}
+	err := tx.Model(&Route{}).Where("station_code = ?", old.StationCode).
+		Updates(map[string]interface{}{"station_name": s.StationName, "station_code": s.StationCode, "city_code": s.CityCode}).Error
+	if err != nil {
+		return false, err.Error()
+	}
+	return true, ""
+}
+
+// DeleteStation 删除车站及其曾用名，被生效中的时刻表使用的车站不能删除
+func DeleteStation(stationID uint) error {
+	var s Station
+	if db.Where("id = ?", stationID).First(&s).RecordNotFound() {
+		return errors.New("车站不存在")
+	}
+	if n := countActiveRoutes(s.StationCode); n != 0 {
+		return fmt.Errorf("车站%s仍被%d个生效中的时刻表使用，无法删除", s.StationName, n)
+	}
+	tx := db.Begin()
+	if err := tx.Delete(StationAlias{}, "station_id = ?", s.ID).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Delete(Station{}, "id = ?", s.ID).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	refreshStations()
+	return nil
+}
+
+// MergeStations 将重复的车站合并到另一个车站：时刻表改用保留的车站，被合并的站名及曾用名都成为保留车站的曾用名
+func MergeStations(fromID, toID uint) error {
+	if fromID == toID {
+		return errors.New("不能与自身合并")
+	}
+	var from, to Station
+	if db.Where("id = ?", fromID).First(&from).RecordNotFound() || db.Where("id = ?", toID).First(&to).RecordNotFound() {
+		return errors.New("车站不存在")
+	}
+	count := 0
+	db.Table("routes r1").Joins("join routes r2 on r2.tran_id = r1.tran_id").
+		Where("r1.station_code = ? and r2.station_code = ?", from.StationCode, to.StationCode).Count(&count)
+	if count != 0 {
+		return fmt.Errorf("有%d个时刻表同时经过%s和%s，无法合并", count, from.StationName, to.StationName)
+	}
+	tx := db.Begin()
+	err := tx.Model(&Route{}).Where("station_code = ?", from.StationCode).
+		Updates(map[string]interface{}{"station_name": to.StationName, "station_code": to.StationCode, "city_code": to.CityCode}).Error
+	if err == nil {
+		err = tx.Model(&StationAlias{}).Where("station_id = ?", from.ID).Update("station_id", to.ID).Error
+	}
+	if err == nil {
+		err = tx.Create(&StationAlias{StationID: to.ID, AliasName: from.StationName, StationCode: from.StationCode}).Error
+	}
+	if err == nil {
+		err = tx.Delete(Station{}, "id = ?", from.ID).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit().Error; err != nil {
+		return err
+	}
+	refreshStations()
+	syncRouteStations(map[string]string{from.StationCode: to.StationCode})
+	return nil
+}
+
+// GetStationAliases 获取车站的曾用名
+func GetStationAliases(stationID uint) (aliases []StationAlias) {
+	db.Where("station_id = ?", stationID).Order("created_at desc").Find(&aliases)
+	return
+}
+
+// syncRouteStations 按数据库中的车站同步内存中各车次时刻表的站名、车站编码及城市，merged为被合并车站编码与保留车站编码的映射；
+// 有修改时发布新的车次快照
+func syncRouteStations(merged map[string]string) {
+	var list []Station
+	db.Find(&list)
+	byCode := make(map[string]*Station, len(list))
+	for i := range list {
+		byCode[list[i].StationCode] = &list[i]
+	}
+	tranReloadLock.Lock()
+	defer tranReloadLock.Unlock()
+	old := getTranSnapshot()
+	if trans := applyRouteStations(old.tranInfos, byCode, merged); trans != nil {
+		storeTranSnapshot(&tranSnapshot{carMap: old.carMap, scheduleCarMap: old.scheduleCarMap,
+			tranInfos: trans, cityTranMap: buildCityTranMap(trans)})
+	}
+}
+
+// applyRouteStations 修改各车次时刻表中与车站不一致的站名、车站编码及城市；
+// 有修改时返回复制后的车次集合，有修改的时刻表也复制后替换，原车次集合不变，没有修改时返回nil
+func applyRouteStations(tranInfos tranCfgs, byCode map[string]*Station, merged map[string]string) (result tranCfgs) {
+	for i := range tranInfos {
+		var timetable []Route
+		for j, r := range tranInfos[i].Timetable {
+			code := r.StationCode
+			if c, ok := merged[code]; ok {
+				code = c
+			}
+			s, ok := byCode[code]
+			if !ok || (s.StationName == r.StationName && s.StationCode == r.StationCode && s.CityCode == r.CityCode) {
+				continue
+			}
+			if timetable == nil {
+				timetable = append([]Route(nil), tranInfos[i].Timetable...)
+			}
+			timetable[j].StationName, timetable[j].StationCode, timetable[j].CityCode = s.StationName, s.StationCode, s.CityCode
+		}
+		if timetable != nil {
+			if result == nil {
+				result = append(tranCfgs(nil), tranInfos...)
+			}
+			result[i].Timetable = timetable
+		}
+	}
+	return
+}
+
+// countActiveRoutes 统计使用该车站且尚未失效的车次时刻
+func countActiveRoutes(stationCode string) (count int) {
+	db.Table("routes").Joins("join tran_infos on tran_infos.id = routes.tran_id").
+		Where("routes.station_code = ? and tran_infos.enable_end_date >= ?", stationCode, time.Now().Format(ConstYmdFormat)).Count(&count)
+	return
+}
+
 // 根据站点名，找出站点编码与城市编码
 func getStationInfoByName(stationName string) *Station {
-	idx := sort.Search(len(stations), func(i int) bool {
-		return -1 != strings.Compare(stations[i].StationName, stationName)
+	snapshot := getStationSnapshot()
+	if s := snapshot.stations.find(stationName); s != nil {
+		return s
+	}
+	// 按曾用名查找现在的车站
+	if name, ok := snapshot.aliasMap[stationName]; ok && name != stationName {
+		return snapshot.stations.find(name)
+	}
+	return nil
+}
+
+// find 按站名二分查找车站，不存在时返回nil
+func (sc stationCfgs) find(stationName string) *Station {
+	idx := sort.Search(len(sc), func(i int) bool {
+		return -1 != strings.Compare(sc[i].StationName, stationName)
 	})
-	if idx != -1 {
-		return &stations[idx]
+	if idx < len(sc) && sc[idx].StationName == stationName {
+		return &sc[idx]
 	}
 	return nil
 }
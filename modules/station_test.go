@@ -5,7 +5,7 @@ import "testing"
 // TestInitStation 测试站点初始化方法
 func TestInitStation(t *testing.T) {
 	initStation()
-	if len(stations) == 2323 {
+	if len(getStationSnapshot().stations) == 2323 {
 		t.Log("pass")
 	} else {
 		t.Error("fail")
@@ -14,7 +14,7 @@ func TestInitStation(t *testing.T) {
 
 // TestGetStationInfoByName 测试根据站点名查找站点
 func TestGetStationInfoByName(t *testing.T) {
-	if len(stations) == 0 {
+	if len(getStationSnapshot().stations) == 0 {
 		initStation()
 	}
 	s := getStationInfoByName("武汉")
@@ -26,7 +26,7 @@ func TestGetStationInfoByName(t *testing.T) {
 }
 
 func BenchmarkGetStationInfoByName(t *testing.B) {
-	if len(stations) == 0 {
+	if len(getStationSnapshot().stations) == 0 {
 		initStation()
 	}
 	s := getStationInfoByName("武汉")
@@ -36,3 +36,34 @@ func BenchmarkGetStationInfoByName(t *testing.B) {
 		t.Error("fail")
 	}
 }
+
+func TestGetStationInfoByAlias(t *testing.T) {
+	defer storeStationSnapshot(getStationSnapshot())
+	storeStationSnapshot(&stationSnapshot{
+		stations: stationCfgs{Station{StationName: "北京南", StationCode: "VNP"}, Station{StationName: "武汉", StationCode: "WHN"}},
+		aliasMap: map[string]string{"汉口东": "武汉", "永定门": "北京南"},
+	})
+	if s := getStationInfoByName("汉口东"); s != nil && s.StationCode == "WHN" && getStationInfoByName("南京") == nil {
+		t.Log("alias pass")
+	} else {
+		t.Error("alias fail")
+	}
+}
+
+func TestApplyRouteStations(t *testing.T) {
+	timetable := []Route{Route{StationName: "永定门", StationCode: "YMP", CityCode: "beijing"}, Route{StationName: "汉口东", StationCode: "HDN", CityCode: "hankou"}}
+	tranInfos := tranCfgs{TranInfo{TranNum: "G1", Timetable: timetable}, TranInfo{TranNum: "G2"}}
+	byCode := map[string]*Station{"VNP": &Station{StationName: "北京南", StationCode: "VNP", CityCode: "beijing"},
+		"HDN": &Station{StationName: "武汉东", StationCode: "HDN", CityCode: "wuhan"}}
+	result := applyRouteStations(tranInfos, byCode, map[string]string{"YMP": "VNP"})
+	if len(result) != 2 || applyRouteStations(result, byCode, nil) != nil {
+		t.Fatal("apply route stations result fail")
+	}
+	r := result[0].Timetable
+	if tranInfos[0].Timetable[1].StationName == "汉口东" && r[0].StationCode == "VNP" && r[0].StationName == "北京南" && r[1].StationName == "武汉东" &&
+		r[1].CityCode == "wuhan" && timetable[0].StationCode == "YMP" {
+		t.Log("apply route stations pass")
+	} else {
+		t.Error("apply route stations fail", r)
+	}
+}
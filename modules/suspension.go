@@ -0,0 +1,204 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	constSuspendDefaultRemark = "列车停运" // 未填写停运说明时的默认说明
+)
+
+// ScheduleSuspension 排班停运信息，整趟停运时区间为起点站至终点站
+type ScheduleSuspension struct {
+	DepIdx      uint8     `bson:"depIdx" json:"depIdx"`           // 停运区间起始站索引
+	ArrIdx      uint8     `bson:"arrIdx" json:"arrIdx"`           // 停运区间终止站索引
+	Remark      string    `bson:"remark" json:"remark"`           // 停运说明，余票及时刻表查询时显示
+	SuspendTime time.Time `bson:"suspendTime" json:"suspendTime"` // 设置停运的时间
+}
+
+// isSuspended 乘车区间与停运区间有重叠即不可乘车
+func (st *ScheduleTran) isSuspended(depIdx, arrIdx uint8) bool {
+	s := st.Suspension
+	return s != nil && depIdx < s.ArrIdx && s.DepIdx < arrIdx
+}
+
+// checkSuspended 订票、改签前校验乘车区间是否停运及排班是否已删除，需在持有 repairLock 时调用
+func (st *ScheduleTran) checkSuspended(depIdx, arrIdx uint8) error {
+	if st.removed {
+		return errors.New("该日期的车次未排班")
+	}
+	if st.isSuspended(depIdx, arrIdx) {
+		return errors.New(st.Suspension.Remark)
+	}
+	return nil
+}
+
+// isStationSuspended 车站是否在停运区间内，用于时刻表查询
+func (st *ScheduleTran) isStationSuspended(stationIdx uint8) bool {
+	s := st.Suspension
+	return s != nil && s.DepIdx <= stationIdx && stationIdx <= s.ArrIdx
+}
+
+// SuspendScheduleTran 某车次某日停运，arrIdx为零时整趟停运，否则只停运两站之间的区间
+// 停运后立即停售，受影响的已支付车票全额退款（不收取手续费），未支付订单直接取消，并通知乘客
+// 返回受影响的车票数量
+func SuspendScheduleTran(tranNum, date string, depIdx, arrIdx uint8, remark string) (int, error) {
+	dt, err := time.Parse(ConstYmdFormat, date)
+	if err != nil {
+		return 0, errors.New("日期无效")
+	}
+	tran, exist := getTranInfo(tranNum, dt)
+	if !exist {
+		return 0, errors.New("车次信息不存在")
+	}
+	if arrIdx == 0 {
+		depIdx, arrIdx = 0, uint8(len(tran.Timetable)-1)
+	}
+	if depIdx >= arrIdx || int(arrIdx) >= len(tran.Timetable) {
+		return 0, errors.New("停运区间无效")
+	}
+	st := scheduleCache.getScheduleTran(tranNum, date)
+	if st.TranNum == "" {
+		return 0, errors.New("排班信息不存在")
+	}
+	if remark = strings.TrimSpace(remark); remark == "" {
+		remark = constSuspendDefaultRemark
+	}
+	// 独占排班：进行中的订票落库后才设置停运，之后的订票均会被拒绝，因此查询到的车票即为全部受影响的车票
+	// 同一区间再次停运时，只重试退还上次未退成功的车票
+	st.repairLock.Lock()
+	if s := st.Suspension; s != nil && (s.DepIdx != depIdx || s.ArrIdx != arrIdx) {
+		st.repairLock.Unlock()
+		return 0, errors.New("该排班已停运，请先恢复后再设置")
+	}
+	if st.Suspension == nil {
+		st.Suspension = &ScheduleSuspension{DepIdx: depIdx, ArrIdx: arrIdx, Remark: remark, SuspendTime: time.Now()}
+		st.hasChanged = true
+	}
+	st.repairLock.Unlock()
+	return refundSuspendedTickets(st)
+}
+
+// ResumeScheduleTran 恢复停运的排班，恢复后重新开售，已退票的车票不再恢复
+func ResumeScheduleTran(tranNum, date string) error {
+	st := scheduleCache.getScheduleTran(tranNum, date)
+	if st.TranNum == "" {
+		return errors.New("排班信息不存在")
+	}
+	st.repairLock.Lock()
+	defer st.repairLock.Unlock()
+	if st.Suspension == nil {
+		return errors.New("该排班未停运")
+	}
+	st.Suspension = nil
+	st.hasChanged = true
+	return nil
+}
+
+// refundSuspendedTickets 按订单处理停运区间内的车票，并给每张车票的乘客发送停运通知
+func refundSuspendedTickets(st *ScheduleTran) (int, error) {
+	s := st.Suspension
+	validTicketStatus := []uint8{constTicketUnpay, constTicketPaid, constTicketIssued, constTicketChangeUnpay, constTicketChangePaid, constTicketChangeIssued}
+	var tickets []Ticket
+	db.Where("tran_num = ? and tran_dep_date = ? and dep_station_idx < ? and ? < arr_station_idx and status in (?)",
+		st.TranNum, st.DepartureDate, s.ArrIdx, s.DepIdx, validTicketStatus).Find(&tickets)
+	orderTickets := make(map[uint64][]Ticket)
+	for _, t := range tickets {
+		orderTickets[t.OrderID] = append(orderTickets[t.OrderID], t)
+	}
+	failCount := 0
+	for orderID, ts := range orderTickets {
+		o := &Order{ID: orderID}
+		db.First(o)
+		var err error
+		// 未支付的订单取消，不提及退款
+		event := uint8(constNotifySuspended)
+		if o.Status == constOrderUnpay {
+			event = constNotifySuspendCancelled
+			err = cancelOrder(o, constOrderCancelled)
+		} else {
+			err = refundOrderTickets(o, ts)
+		}
+		if err != nil {
+			log.Println("停运退票失败:", st.TranNum, st.DepartureDate, orderID, err)
+			failCount += len(ts)
+			continue
+		}
+		for i := range ts {
+			info := buildNotifyCustomerInfo(o, &ts[i])
+			info.remark = s.Remark
+			info.notifyCustomer(event, ts[i].ID)
+		}
+	}
+	if failCount != 0 {
+		return len(tickets), fmt.Errorf("%d张车票退票失败，请稍后重试", failCount)
+	}
+	return len(tickets), nil
+}
+
+// refundOrderTickets 全额退还订单中的部分车票，订单中没有其它有效车票时订单置为已退款
+func refundOrderTickets(o *Order, tickets []Ticket) error {
+	validTicketStatus := []uint8{constTicketPaid, constTicketIssued, constTicketChangePaid, constTicketChangeIssued}
+	var price float32
+	ids := make([]uint64, len(tickets))
+	for i, t := range tickets {
+		ids[i] = t.ID
+		price += t.Price
+	}
+	// 改签票的票价包含原车票已付的部分，退款金额不超过订单的实付金额
+	if price > o.Price {
+		price = o.Price
+	}
+	tx := db.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("退票失败: %v", tx.Error)
+	}
+	// 锁定订单，与同一订单的其它退票依次执行
+	err := tx.Set("gorm:query_option", "FOR UPDATE").First(&Order{ID: o.ID}).Error
+	// 以原状态为条件更新，避免重复退票而多次释放座位；改签票退票后置为改签票已退票
+	for i := 0; err == nil && i < len(tickets); i++ {
+		status, _ := getCancelTicketStatus(tickets[i].Status)
+		q := tx.Model(&Ticket{}).Where("id = ? and status = ?", tickets[i].ID, tickets[i].Status).Update("status", status)
+		if err = q.Error; err == nil && q.RowsAffected != 1 {
+			err = errors.New("车票状态已变更")
+		}
+	}
+	remain := 0
+	if err == nil {
+		err = tx.Model(&Ticket{}).Where("order_id = ? and status in (?)", o.ID, validTicketStatus).Count(&remain).Error
+	}
+	if err == nil && remain == 0 {
+		err = tx.Model(&Order{}).Where("id = ?", o.ID).Update("status", constOrderRefund).Error
+	}
+	for i := 0; err == nil && i < len(ids); i++ {
+		err = publishOrderEvent(tx, constEventTicketRefunded, o.ID, ids[i], o.UserID)
+	}
+	var r *RefundRecord
+	if err == nil {
+		r, err = addRefundRecord(tx, o, price)
+	}
+	if err == nil {
+		err = tx.Commit().Error
+	} else {
+		tx.Rollback()
+	}
+	if err != nil {
+		return fmt.Errorf("退票失败: %v", err)
+	}
+	releaseTicketSeats(tickets)
+	// 车票已退，退款失败时由退款重试任务处理，照常通知乘客
+	if err = r.refund(); err != nil {
+		log.Println("停运退款失败，将自动重试:", o.ID, err)
+	}
+	return nil
+}
+
+// isTicketSuspended 车票是否因列车停运而退票，此类车票已发送停运通知
+func isTicketSuspended(t *Ticket) bool {
+	st := scheduleCache.getScheduleTran(t.TranNum, t.TranDepDate)
+	return st.isSuspended(t.DepStationIdx, t.ArrStationIdx)
+}
@@ -0,0 +1,64 @@
+package modules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleSuspension(t *testing.T) {
+	// 第1站至第3站之间停运
+	st := &ScheduleTran{TranNum: "G1", DepartureDate: "2018-10-10",
+		Suspension: &ScheduleSuspension{DepIdx: 1, ArrIdx: 3, Remark: "线路施工"}}
+	cases := []struct {
+		name           string
+		depIdx, arrIdx uint8
+		suspended      bool
+	}{
+		{"before", 0, 1, false},
+		{"after", 3, 5, false},
+		{"cover", 0, 5, true},
+		{"inside", 1, 2, true},
+		{"cross start", 0, 2, true},
+		{"cross end", 2, 4, true},
+	}
+	for _, c := range cases {
+		if st.isSuspended(c.depIdx, c.arrIdx) == c.suspended && (st.checkSuspended(c.depIdx, c.arrIdx) != nil) == c.suspended {
+			t.Log("isSuspended " + c.name + " pass")
+		} else {
+			t.Error("isSuspended " + c.name + " fail")
+		}
+	}
+	if st.isStationSuspended(1) && st.isStationSuspended(3) && !st.isStationSuspended(0) && !st.isStationSuspended(4) {
+		t.Log("isStationSuspended pass")
+	} else {
+		t.Error("isStationSuspended fail")
+	}
+	if !(&ScheduleTran{}).isSuspended(0, 5) {
+		t.Log("isSuspended not suspended pass")
+	} else {
+		t.Error("isSuspended not suspended fail")
+	}
+}
+
+func TestSuspendedResidualTicketInfo(t *testing.T) {
+	day := time.Date(2018, 10, 10, 0, 0, 0, 0, time.Local)
+	tran := &TranInfo{TranNum: "G1", NonSaleRemark: "暂停售票", Timetable: []Route{
+		Route{StationCode: "A", DepTime: day.Add(8 * time.Hour)},
+		Route{StationCode: "B", ArrTime: day.Add(9 * time.Hour), DepTime: day.Add(9 * time.Hour)},
+		Route{StationCode: "C", ArrTime: day.Add(10 * time.Hour)},
+	}}
+	st := &ScheduleTran{TranNum: "G1", DepartureDate: "2018-10-10",
+		Suspension: &ScheduleSuspension{DepIdx: 1, ArrIdx: 2, Remark: "线路施工"}}
+	scheduleTranMap.Store("G1_2018-10-10", st)
+	defer scheduleTranMap.Delete("G1_2018-10-10")
+	if r := buildResidualTicketInfo(tran, 0, 2, "2018-10-10", false); r.remark == "线路施工" && r.seatCount == nil {
+		t.Log("buildResidualTicketInfo suspended pass")
+	} else {
+		t.Error("buildResidualTicketInfo suspended fail")
+	}
+	if r := buildResidualTicketInfo(tran, 0, 1, "2018-10-10", false); r.remark == "暂停售票" {
+		t.Log("buildResidualTicketInfo not suspended pass")
+	} else {
+		t.Error("buildResidualTicketInfo not suspended fail")
+	}
+}
@@ -0,0 +1,292 @@
+package modules
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	constTrainStatusKeepDays = 3         // 运行状态在内存中保留的天数
+	constTrainStatusInterval = time.Hour // 清理过期运行状态的时间间隔
+)
+
+var (
+	// 列车实时运行状态，key为 车次号_发车日期，value的key为车站索引
+	trainStatusMap  = make(map[string](map[uint8]*TrainStopStatus))
+	trainStatusLock sync.RWMutex
+	// 保存运行状态时与已保存的状态合并，同一时间只保存一个
+	trainStatusSaveLock sync.Mutex
+)
+
+// TrainStopStatus 列车某日在某站的实时运行状态，由调度人员或运营系统上报
+type TrainStopStatus struct {
+	ID              uint64    `json:"id"`
+	TranNum         string    `gorm:"index:main;type:varchar(10)" json:"tranNum"`       // 车次号
+	DepartureDate   string    `gorm:"index:main;type:varchar(10)" json:"departureDate"` // 发车日期
+	StationIdx      uint8     `json:"stationIdx"`                                       // 车站在时刻表中的索引
+	DelayMinutes    int       `json:"delayMinutes"`                                     // 预计晚点分钟数，早点时为负数
+	Platform        uint8     `json:"platform"`                                         // 调整后的站台，为零时按时刻表
+	CheckTicketGate string    `gorm:"type:varchar(10)" json:"checkTicketGate"`          // 调整后的检票口，为空时按时刻表
+	ActualArrTime   time.Time `gorm:"type:datetime" json:"actualArrTime"`               // 实际到达时间，未到达时为零值
+	ActualDepTime   time.Time `gorm:"type:datetime" json:"actualDepTime"`               // 实际出发时间，未出发时为零值
+	UpdateTime      time.Time `gorm:"type:datetime" json:"updateTime"`                  // 更新时间
+}
+
+// TrainStopReport 上报的运行状态，字段为nil时未上报，沿用已保存的值；
+// 非nil时覆盖已保存的值，晚点分钟数、站台为零或检票口为空即恢复正点或时刻表的站台、检票口
+type TrainStopReport struct {
+	TranNum         string     `json:"tranNum"`         // 车次号
+	DepartureDate   string     `json:"departureDate"`   // 发车日期
+	StationIdx      uint8      `json:"stationIdx"`      // 车站在时刻表中的索引
+	DelayMinutes    *int       `json:"delayMinutes"`    // 预计晚点分钟数，早点时为负数
+	Platform        *uint8     `json:"platform"`        // 调整后的站台
+	CheckTicketGate *string    `json:"checkTicketGate"` // 调整后的检票口
+	ActualArrTime   *time.Time `json:"actualArrTime"`   // 实际到达时间，零值时清除
+	ActualDepTime   *time.Time `json:"actualDepTime"`   // 实际出发时间，零值时清除
+}
+
+func getTrainStatusKey(tranNum, date string) string {
+	return tranNum + "_" + date
+}
+
+// initTrainStatus 加载近几日的运行状态，并定时清理过期的运行状态
+func initTrainStatus() {
+	var statuses []TrainStopStatus
+	since := time.Now().AddDate(0, 0, -constTrainStatusKeepDays).Format(ConstYmdFormat)
+	db.Where("departure_date >= ?", since).Find(&statuses)
+	m := make(map[string](map[uint8]*TrainStopStatus))
+	for i := 0; i < len(statuses); i++ {
+		key := getTrainStatusKey(statuses[i].TranNum, statuses[i].DepartureDate)
+		if m[key] == nil {
+			m[key] = make(map[uint8]*TrainStopStatus)
+		}
+		m[key][statuses[i].StationIdx] = &statuses[i]
+	}
+	trainStatusLock.Lock()
+	trainStatusMap = m
+	trainStatusLock.Unlock()
+	go func() {
+		for now := range time.Tick(constTrainStatusInterval) {
+			clearTrainStatus(now)
+		}
+	}()
+}
+
+// clearTrainStatus 移除发车日期已过保留天数的运行状态
+func clearTrainStatus(now time.Time) {
+	since := now.AddDate(0, 0, -constTrainStatusKeepDays).Format(ConstYmdFormat)
+	trainStatusLock.Lock()
+	defer trainStatusLock.Unlock()
+	for key, stops := range trainStatusMap {
+		for _, s := range stops {
+			if s.DepartureDate < since {
+				delete(trainStatusMap, key)
+			}
+			break
+		}
+	}
+}
+
+// getTrainStopStatus 获取列车某日在某站的运行状态，未上报时返回nil
+func getTrainStopStatus(tranNum, date string, stationIdx uint8) *TrainStopStatus {
+	trainStatusLock.RLock()
+	defer trainStatusLock.RUnlock()
+	return trainStatusMap[getTrainStatusKey(tranNum, date)][stationIdx]
+}
+
+// GetTrainStopStatuses 获取列车某日已上报的各站运行状态
+func GetTrainStopStatuses(tranNum, date string) []TrainStopStatus {
+	trainStatusLock.RLock()
+	stops := trainStatusMap[getTrainStatusKey(tranNum, date)]
+	result := make([]TrainStopStatus, 0, len(stops))
+	for _, s := range stops {
+		result = append(result, *s)
+	}
+	trainStatusLock.RUnlock()
+	sort.Slice(result, func(i, j int) bool { return result[i].StationIdx < result[j].StationIdx })
+	return result
+}
+
+// Save 保存运行状态，只覆盖本次上报的字段，有实际到达或出发时间时，按实际时间重新计算晚点分钟数
+// 该站的检票口或站台变更时，通知在该站上车的乘客
+func (r *TrainStopReport) Save() (bool, string) {
+	date, err := time.ParseInLocation(ConstYmdFormat, r.DepartureDate, time.Local)
+	if err != nil {
+		return false, "日期无效"
+	}
+	tran, exist := getTranInfo(r.TranNum, date)
+	if !exist {
+		return false, "车次信息不存在"
+	}
+	if int(r.StationIdx) >= len(tran.Timetable) {
+		return false, "车站无效"
+	}
+	if r.CheckTicketGate != nil && len(*r.CheckTicketGate) > 10 {
+		return false, "检票口无效"
+	}
+	trainStatusSaveLock.Lock()
+	defer trainStatusSaveLock.Unlock()
+	route := &tran.Timetable[r.StationIdx]
+	oldGate, oldPlatform := route.CheckTicketGate, route.Platform
+	old := getTrainStopStatus(r.TranNum, r.DepartureDate, r.StationIdx)
+	if old != nil {
+		oldGate, oldPlatform = old.getGate(route), old.getPlatform(route)
+	}
+	s := r.merge(old)
+	depTime, arrTime := tran.getDepAndArrTime(s.DepartureDate, s.StationIdx, s.StationIdx)
+	if !s.ActualDepTime.IsZero() && s.StationIdx != uint8(len(tran.Timetable)-1) {
+		s.DelayMinutes = int(s.ActualDepTime.Sub(depTime).Minutes())
+	} else if !s.ActualArrTime.IsZero() && s.StationIdx != 0 {
+		s.DelayMinutes = int(s.ActualArrTime.Sub(arrTime).Minutes())
+	}
+	s.UpdateTime = time.Now()
+	if s.ID == 0 {
+		err = db.Create(s).Error
+	} else {
+		err = db.Save(s).Error
+	}
+	if err != nil {
+		return false, err.Error()
+	}
+	saved := *s
+	key := getTrainStatusKey(s.TranNum, s.DepartureDate)
+	trainStatusLock.Lock()
+	if trainStatusMap[key] == nil {
+		trainStatusMap[key] = make(map[uint8]*TrainStopStatus)
+	}
+	trainStatusMap[key][s.StationIdx] = &saved
+	trainStatusLock.Unlock()
+	if saved.getGate(route) != oldGate {
+		notifyStopChanged(&saved, constNotifyStopChanged)
+	} else if saved.getPlatform(route) != oldPlatform {
+		notifyStopChanged(&saved, constNotifyPlatformChanged)
+	}
+	return true, ""
+}
+
+// merge 以已保存的运行状态为基础合并本次上报的字段，未上报的字段沿用原值
+func (r *TrainStopReport) merge(old *TrainStopStatus) *TrainStopStatus {
+	s := &TrainStopStatus{}
+	if old != nil {
+		*s = *old
+	}
+	s.TranNum, s.DepartureDate, s.StationIdx = r.TranNum, r.DepartureDate, r.StationIdx
+	if r.DelayMinutes != nil {
+		s.DelayMinutes = *r.DelayMinutes
+	}
+	if r.Platform != nil {
+		s.Platform = *r.Platform
+	}
+	if r.CheckTicketGate != nil {
+		s.CheckTicketGate = *r.CheckTicketGate
+	}
+	if r.ActualArrTime != nil {
+		s.ActualArrTime = *r.ActualArrTime
+	}
+	if r.ActualDepTime != nil {
+		s.ActualDepTime = *r.ActualDepTime
+	}
+	return s
+}
+
+// getGate 实际使用的检票口，未调整时按时刻表
+func (s *TrainStopStatus) getGate(r *Route) string {
+	if s != nil && s.CheckTicketGate != "" {
+		return s.CheckTicketGate
+	}
+	return r.CheckTicketGate
+}
+
+// getPlatform 实际使用的站台，未调整时按时刻表
+func (s *TrainStopStatus) getPlatform(r *Route) uint8 {
+	if s != nil && s.Platform != 0 {
+		return s.Platform
+	}
+	return r.Platform
+}
+
+// getDelay 预计晚点时长，未上报时视为正点
+func (s *TrainStopStatus) getDelay() time.Duration {
+	if s == nil {
+		return 0
+	}
+	return time.Duration(s.DelayMinutes) * time.Minute
+}
+
+// expectedArrTime 预计到达时间，已到达时为实际到达时间
+func (s *TrainStopStatus) expectedArrTime(arrTime time.Time) time.Time {
+	if s != nil && !s.ActualArrTime.IsZero() {
+		return s.ActualArrTime
+	}
+	return arrTime.Add(s.getDelay())
+}
+
+// expectedDepTime 预计出发时间，已出发时为实际出发时间
+func (s *TrainStopStatus) expectedDepTime(depTime time.Time) time.Time {
+	if s != nil && !s.ActualDepTime.IsZero() {
+		return s.ActualDepTime
+	}
+	return depTime.Add(s.getDelay())
+}
+
+// getStopDelay 列车到达某站的预计晚点时长
+func getStopDelay(tranNum, date string, stationIdx uint8) time.Duration {
+	return getTrainStopStatus(tranNum, date, stationIdx).getDelay()
+}
+
+// notifyStopChanged 检票口或站台变更时，通知在该站上车的已支付车票的乘客，检票口变更时同时告知站台
+func notifyStopChanged(s *TrainStopStatus, event uint8) {
+	validTicketStatus := []uint8{constTicketPaid, constTicketIssued, constTicketChangePaid, constTicketChangeIssued}
+	var tickets []*Ticket
+	db.Where("tran_num = ? and tran_dep_date = ? and dep_station_idx = ? and status in (?)",
+		s.TranNum, s.DepartureDate, s.StationIdx, validTicketStatus).Find(&tickets)
+	for _, t := range tickets {
+		o := &Order{}
+		db.Where("id = ?", t.OrderID).First(o)
+		buildNotifyCustomerInfo(o, t).notifyCustomer(event, t.ID)
+	}
+}
+
+// TicketLiveInfo 车票的实时信息
+type TicketLiveInfo struct {
+	TicketID        uint64    `json:"ticketID"`
+	CheckTicketGate string    `json:"checkTicketGate"` // 出发站的检票口
+	Platform        uint8     `json:"platform"`        // 出发站的站台
+	DepDelayMinutes int       `json:"depDelayMinutes"` // 出发站预计晚点分钟数
+	ArrDelayMinutes int       `json:"arrDelayMinutes"` // 到达站预计晚点分钟数
+	ExpectedDepTime time.Time `json:"expectedDepTime"` // 预计出发时间
+	ExpectedArrTime time.Time `json:"expectedArrTime"` // 预计到达时间
+}
+
+// GetTicketLiveInfo 获取车票的实时检票口、站台及预计出发、到达时间
+func GetTicketLiveInfo(ticketID uint64) (*TicketLiveInfo, error) {
+	t := &Ticket{}
+	db.Where("id = ?", ticketID).First(t)
+	if t.ID == 0 {
+		return nil, errors.New("车票不存在")
+	}
+	return buildTicketLiveInfo(t), nil
+}
+
+func buildTicketLiveInfo(t *Ticket) *TicketLiveInfo {
+	dep := getTrainStopStatus(t.TranNum, t.TranDepDate, t.DepStationIdx)
+	arr := getTrainStopStatus(t.TranNum, t.TranDepDate, t.ArrStationIdx)
+	info := &TicketLiveInfo{
+		TicketID:        t.ID,
+		CheckTicketGate: t.CheckTicketGate,
+		DepDelayMinutes: int(dep.getDelay().Minutes()),
+		ArrDelayMinutes: int(arr.getDelay().Minutes()),
+		ExpectedDepTime: dep.expectedDepTime(t.DepTime),
+		ExpectedArrTime: arr.expectedArrTime(t.ArrTime),
+	}
+	date, _ := time.ParseInLocation(ConstYmdFormat, t.TranDepDate, time.Local)
+	if tran, exist := getTranInfo(t.TranNum, date); exist && int(t.DepStationIdx) < len(tran.Timetable) {
+		route := &tran.Timetable[t.DepStationIdx]
+		info.CheckTicketGate, info.Platform = dep.getGate(route), dep.getPlatform(route)
+	} else if dep != nil && dep.CheckTicketGate != "" {
+		info.CheckTicketGate, info.Platform = dep.CheckTicketGate, dep.Platform
+	}
+	return info
+}
@@ -0,0 +1,103 @@
+package modules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTrainStopStatus(t *testing.T) {
+	oldMap := trainStatusMap
+	defer func() { trainStatusMap = oldMap }()
+	arrTime := time.Date(2018, 10, 10, 9, 0, 0, 0, time.Local)
+	depTime := arrTime.Add(5 * time.Minute)
+	trainStatusMap = map[string](map[uint8]*TrainStopStatus){
+		"G1_2018-10-10": {
+			1: &TrainStopStatus{TranNum: "G1", DepartureDate: "2018-10-10", StationIdx: 1, DelayMinutes: 15, CheckTicketGate: "B2"},
+			2: &TrainStopStatus{TranNum: "G1", DepartureDate: "2018-10-10", StationIdx: 2, DelayMinutes: 20, Platform: 6,
+				ActualArrTime: arrTime.Add(18 * time.Minute)},
+		},
+		"G1_2018-10-01": {
+			0: &TrainStopStatus{TranNum: "G1", DepartureDate: "2018-10-01", StationIdx: 0, DelayMinutes: 5},
+		},
+	}
+	route := &Route{CheckTicketGate: "A1", Platform: 3}
+
+	s1 := getTrainStopStatus("G1", "2018-10-10", 1)
+	if s1.getGate(route) == "B2" && s1.getPlatform(route) == 3 && s1.expectedDepTime(depTime).Equal(depTime.Add(15*time.Minute)) {
+		t.Log("gate changed pass")
+	} else {
+		t.Error("gate changed fail")
+	}
+	s2 := getTrainStopStatus("G1", "2018-10-10", 2)
+	if s2.getGate(route) == "A1" && s2.getPlatform(route) == 6 && s2.expectedArrTime(arrTime).Equal(arrTime.Add(18*time.Minute)) {
+		t.Log("platform changed and arrived pass")
+	} else {
+		t.Error("platform changed and arrived fail")
+	}
+	s3 := getTrainStopStatus("G1", "2018-10-10", 3)
+	if s3 == nil && s3.getGate(route) == "A1" && s3.getPlatform(route) == 3 && s3.expectedArrTime(arrTime).Equal(arrTime) {
+		t.Log("not reported pass")
+	} else {
+		t.Error("not reported fail")
+	}
+	if trainArrDelay("G1", "2018-10-10", 2) == 20*time.Minute && trainArrDelay("G2", "2018-10-10", 2) == 0 {
+		t.Log("trainArrDelay pass")
+	} else {
+		t.Error("trainArrDelay fail")
+	}
+	if statuses := GetTrainStopStatuses("G1", "2018-10-10"); len(statuses) == 2 && statuses[0].StationIdx == 1 {
+		t.Log("GetTrainStopStatuses pass")
+	} else {
+		t.Error("GetTrainStopStatuses fail")
+	}
+
+	ticket := &Ticket{ID: 1, TranNum: "G1", TranDepDate: "2018-10-10", CheckTicketGate: "A1",
+		DepStationIdx: 1, DepTime: depTime, ArrStationIdx: 2, ArrTime: arrTime.Add(time.Hour)}
+	info := buildTicketLiveInfo(ticket)
+	if info.CheckTicketGate == "B2" && info.DepDelayMinutes == 15 && info.ArrDelayMinutes == 20 &&
+		info.ExpectedDepTime.Equal(depTime.Add(15*time.Minute)) && info.ExpectedArrTime.Equal(arrTime.Add(18*time.Minute)) {
+		t.Log("buildTicketLiveInfo pass")
+	} else {
+		t.Error("buildTicketLiveInfo fail")
+	}
+	n := &notifyCustomerInfo{checkTicketGate: info.CheckTicketGate, platform: 6, delayMinutes: info.DepDelayMinutes}
+	if n.render("检票口{checkTicketGate}，{platform}站台（{delay}）") == "检票口B2，6站台（预计晚点15分钟）" {
+		t.Log("render live info pass")
+	} else {
+		t.Error("render live info fail")
+	}
+
+	clearTrainStatus(time.Date(2018, 10, 10, 12, 0, 0, 0, time.Local))
+	if getTrainStopStatus("G1", "2018-10-01", 0) == nil && getTrainStopStatus("G1", "2018-10-10", 1) != nil {
+		t.Log("clearTrainStatus pass")
+	} else {
+		t.Error("clearTrainStatus fail")
+	}
+}
+
+func TestMergeTrainStopStatus(t *testing.T) {
+	arrTime := time.Date(2018, 10, 10, 9, 0, 0, 0, time.Local)
+	old := &TrainStopStatus{ID: 7, DelayMinutes: 10, Platform: 3, CheckTicketGate: "A2", ActualArrTime: arrTime}
+	platform := uint8(5)
+	s := (&TrainStopReport{Platform: &platform}).merge(old)
+	if s.ID == 7 && s.Platform == 5 && s.CheckTicketGate == "A2" && s.DelayMinutes == 10 && s.ActualArrTime.Equal(arrTime) && old.Platform == 3 {
+		t.Log("merge pass")
+	} else {
+		t.Error("merge fail")
+	}
+	delay, gate, zeroTime := 0, "", time.Time{}
+	platform = 0
+	s = (&TrainStopReport{DelayMinutes: &delay, Platform: &platform, CheckTicketGate: &gate, ActualArrTime: &zeroTime}).merge(old)
+	if s.ID == 7 && s.DelayMinutes == 0 && s.Platform == 0 && s.CheckTicketGate == "" && s.ActualArrTime.IsZero() {
+		t.Log("merge clear pass")
+	} else {
+		t.Error("merge clear fail")
+	}
+	gate = "B1"
+	s = (&TrainStopReport{TranNum: "G1", StationIdx: 2, CheckTicketGate: &gate}).merge(nil)
+	if s.ID == 0 && s.TranNum == "G1" && s.StationIdx == 2 && s.CheckTicketGate == "B1" {
+		t.Log("merge new pass")
+	} else {
+		t.Error("merge new fail")
+	}
+}
@@ -0,0 +1,159 @@
+package modules
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tranSnapshot 车次信息快照，发布后不再修改；后台修改车次或车厢时复制出新的快照再整体替换，
+// 查询及订票取同一个快照使用，不需要加锁
+type tranSnapshot struct {
+	// 所有车厢，存于内存，便于组装
+	carMap map[int](Car)
+	// 排班的车厢集
+	scheduleCarMap map[int](*ScheduleCar)
+	// 所有车次信息，不参与订票，用于查询列车的时刻表和各路段各座次的价格
+	tranInfos tranCfgs
+	// 各城市与经过该城市的列车映射，指向tranInfos中的元素
+	cityTranMap map[string]([]*TranInfo)
+}
+
+var (
+	// 当前的车次信息快照
+	tranData atomic.Value
+	// 修改快照时加锁，避免同时修改时互相覆盖
+	tranReloadLock sync.Mutex
+)
+
+func getTranSnapshot() *tranSnapshot {
+	if s, ok := tranData.Load().(*tranSnapshot); ok {
+		return s
+	}
+	return &tranSnapshot{}
+}
+
+func storeTranSnapshot(s *tranSnapshot) {
+	tranData.Store(s)
+}
+
+// reloadTrans 从数据库重新加载车次的各生效版本，发布替换了这些车次的新快照，并在后台为其生成排班；
+// 快照中的车次集合是复制后修改的，城市与车次的映射也随之重建
+func reloadTrans(tranNums ...string) {
+	if len(tranNums) == 0 {
+		return
+	}
+	start := time.Now()
+	changed := make(map[string]bool, len(tranNums))
+	for _, tranNum := range tranNums {
+		changed[tranNum] = true
+	}
+	tranReloadLock.Lock()
+	today, lastDate := time.Now().Format(ConstYmdFormat), time.Now().AddDate(0, 0, constDays).Format(ConstYmdFormat)
+	var loaded tranCfgs
+	db.Where("tran_num in (?) and enable_end_date >= ? and ? >= enable_start_date", tranNums, today, lastDate).Find(&loaded)
+	for i := range loaded {
+		loaded[i].getFullInfo()
+	}
+	old := getTranSnapshot()
+	trans := make(tranCfgs, 0, len(old.tranInfos)+len(loaded))
+	for _, t := range old.tranInfos {
+		if !changed[t.TranNum] {
+			trans = append(trans, t)
+		}
+	}
+	trans = append(trans, loaded...)
+	sort.Sort(trans)
+	storeTranSnapshot(&tranSnapshot{carMap: old.carMap, scheduleCarMap: old.scheduleCarMap,
+		tranInfos: trans, cityTranMap: buildCityTranMap(trans)})
+	tranReloadLock.Unlock()
+	fmt.Println("reload trans", tranNums, "complete, cost time:", time.Now().Sub(start).Seconds(), "(s)")
+
+	var scheduled []*TranInfo
+	for i := range trans {
+		if changed[trans[i].TranNum] && trans[i].IsSaleTicket {
+			scheduled = append(scheduled, &trans[i])
+		}
+	}
+	go scheduleReloadedTrans(scheduled)
+}
+
+// scheduleReloadedTrans 为重新加载的车次生成排班，未售票的排班按新版本重新生成，跳过的排班只记录
+// 与排班任务互斥，避免同时为同一车次生成排班
+func scheduleReloadedTrans(trans []*TranInfo) {
+	scheduleJobLock.Lock()
+	defer scheduleJobLock.Unlock()
+	report := &ScheduleJobReport{StartTime: time.Now()}
+	y, m, d := report.StartTime.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	for _, t := range trans {
+		t.scheduleTrans(today, report)
+	}
+	report.EndTime = time.Now()
+	report.sort()
+	report.print("schedule reloaded trans")
+}
+
+// reloadCars 从数据库重新加载车厢，已删除的车厢从快照中移除，并重新设置使用这些车厢的车次的车厢索引，
+// 与 reloadTrans 一样在后台为这些车次重新生成排班
+func reloadCars(carIDs ...int) {
+	if len(carIDs) == 0 {
+		return
+	}
+	tranReloadLock.Lock()
+	loaded := make(map[int]*Car, len(carIDs))
+	for _, id := range carIDs {
+		c := GetCarDetail(id)
+		if c.ID == id {
+			loaded[id] = &c
+		} else {
+			loaded[id] = nil
+		}
+	}
+	old := getTranSnapshot()
+	cars := make(map[int](Car), len(old.carMap)+len(loaded))
+	scheduleCars := make(map[int](*ScheduleCar), len(old.scheduleCarMap)+len(loaded))
+	for id, c := range old.carMap {
+		cars[id] = c
+	}
+	for id, sc := range old.scheduleCarMap {
+		scheduleCars[id] = sc
+	}
+	for id, c := range loaded {
+		if c == nil {
+			delete(cars, id)
+			delete(scheduleCars, id)
+			continue
+		}
+		cars[id], scheduleCars[id] = *c, newScheduleCar(c)
+	}
+	trans := append(tranCfgs(nil), old.tranInfos...)
+	var scheduled []*TranInfo
+	for i := range trans {
+		if isUsingCars(trans[i].CarIds, loaded) {
+			trans[i].initCarIdx(cars)
+			if trans[i].IsSaleTicket {
+				scheduled = append(scheduled, &trans[i])
+			}
+		}
+	}
+	storeTranSnapshot(&tranSnapshot{carMap: cars, scheduleCarMap: scheduleCars, tranInfos: trans, cityTranMap: buildCityTranMap(trans)})
+	tranReloadLock.Unlock()
+	go scheduleReloadedTrans(scheduled)
+}
+
+// isUsingCars 车厢编组中是否使用了其中的车厢
+func isUsingCars(carIds string, cars map[int]*Car) bool {
+	for _, setting := range strings.Split(carIds, ";") {
+		if id, err := strconv.Atoi(strings.Split(setting, ":")[0]); err == nil {
+			if _, ok := cars[id]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package modules
+
+import "testing"
+
+func TestIsUsingCars(t *testing.T) {
+	cars := map[int]*Car{12: nil, 3: &Car{ID: 3}}
+	if isUsingCars("1:2;12:3", cars) && isUsingCars("3:1", cars) && !isUsingCars("1:2;13:1", cars) && !isUsingCars("", cars) {
+		t.Log("isUsingCars pass")
+	} else {
+		t.Error("isUsingCars fail")
+	}
+}
+
+func TestBuildCityTranMap(t *testing.T) {
+	trans := tranCfgs{
+		TranInfo{TranNum: "G1", Timetable: []Route{Route{CityCode: "beijing"}, Route{CityCode: "shanghai"}}},
+		TranInfo{TranNum: "G2", Timetable: []Route{Route{CityCode: "beijing"}, Route{CityCode: "wuhan"}}},
+	}
+	m := buildCityTranMap(trans)
+	if len(m) == 3 && len(m["beijing"]) == 2 && m["wuhan"][0] == &trans[1] && m["shanghai"][0] == &trans[0] {
+		t.Log("buildCityTranMap pass")
+	} else {
+		t.Error("buildCityTranMap fail")
+	}
+}
+
+func TestTranSnapshotCarIdx(t *testing.T) {
+	cars := map[int](Car){1: Car{ID: 1, SeatType: constSeatTypeSecondClass}, 2: Car{ID: 2, SeatType: constSeatTypeFristClass}}
+	old := tranCfgs{TranInfo{TranNum: "G1", CarIds: "1:2;2:1"}}
+	old[0].initCarIdx(cars)
+	trans := append(tranCfgs(nil), old...)
+	cars[2] = Car{ID: 2, SeatType: constSeatTypeSecondClass}
+	trans[0].initCarIdx(cars)
+	if len(old[0].carTypeIdxMap[constSeatTypeFristClass]) == 1 && len(trans[0].carTypeIdxMap[constSeatTypeSecondClass]) == 3 &&
+		trans[0].carCount == 3 {
+		t.Log("snapshot car idx pass")
+	} else {
+		t.Error("snapshot car idx fail")
+	}
+}
@@ -0,0 +1,216 @@
+package modules
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// 校验问题的级别，有错误时不能保存，警告仅作提示
+	constValidLevelError   = "error"
+	constValidLevelWarning = "warning"
+
+	constValidMaxDwell     = 30 * time.Minute // 中途站停靠时间超过该值时警告
+	constValidDwellLimit   = 12 * time.Hour   // 中途站停靠时间超过该值时，视为到达与出发时间填反
+	constValidMaxSpeed     = 400              // 路段平均时速上限(km/h)，超过时多为运行时间填错或路段运行超过24小时
+	constValidMinSpeed     = 20               // 路段平均时速低于该值时警告
+	constValidMaxCarCount  = 255              // 编组的最大车厢数
+	constValidMaxRouteSize = 255              // 时刻表的最大车站数
+)
+
+// TranValidIssue 车次配置的校验问题
+type TranValidIssue struct {
+	Level string `json:"level"` // 级别：error、warning
+	Field string `json:"field"` // 所在字段，如：timetable[2].depTime、seatPriceMap.SC[3]、carIds
+	Msg   string `json:"msg"`   // 问题说明
+}
+
+// FirstValidError 第一个错误的说明，没有错误时返回空
+func FirstValidError(issues []TranValidIssue) string {
+	for _, issue := range issues {
+		if issue.Level == constValidLevelError {
+			return issue.Msg
+		}
+	}
+	return ""
+}
+
+// Validate 保存前校验车次的时刻表、里程、车厢编组及票价，不修改车次本身
+func (t *TranInfo) Validate() (issues []TranValidIssue) {
+	add := func(level, field, format string, a ...interface{}) {
+		issues = append(issues, TranValidIssue{Level: level, Field: field, Msg: fmt.Sprintf(format, a...)})
+	}
+	t.validStations(add)
+	t.validRouteTimes(add)
+	seatTypes := t.validCars(add)
+	t.validPrices(add, seatTypes)
+	return
+}
+
+type validAddFunc func(level, field, format string, a ...interface{})
+
+func routeField(i int, name string) string {
+	return "timetable[" + strconv.Itoa(i) + "]." + name
+}
+
+func routeName(t *TranInfo, i int) string {
+	return fmt.Sprintf("第%d站%s", i+1, t.Timetable[i].StationName)
+}
+
+// validStations 校验车站是否存在、是否重复，及距下一站的里程
+func (t *TranInfo) validStations(add validAddFunc) {
+	routeCount := len(t.Timetable)
+	if routeCount < 2 {
+		add(constValidLevelError, "timetable", "时刻表至少需要两个车站")
+		return
+	}
+	if routeCount > constValidMaxRouteSize {
+		add(constValidLevelError, "timetable", "时刻表的车站数不能超过%d个", constValidMaxRouteSize)
+	}
+	exists := make(map[string]int, routeCount)
+	for i, r := range t.Timetable {
+		if r.StationName == "" {
+			add(constValidLevelError, routeField(i, "stationName"), "第%d站未填写车站", i+1)
+			continue
+		}
+		if s := getStationInfoByName(r.StationName); s == nil {
+			add(constValidLevelError, routeField(i, "stationName"), "%s不存在", routeName(t, i))
+		} else if r.StationCode != "" && r.StationCode != s.StationCode {
+			add(constValidLevelError, routeField(i, "stationCode"), "%s的车站编码应为%s", routeName(t, i), s.StationCode)
+		}
+		if j, ok := exists[r.StationName]; ok {
+			add(constValidLevelError, routeField(i, "stationName"), "%s与第%d站重复", routeName(t, i), j+1)
+		} else {
+			exists[r.StationName] = i
+		}
+		switch {
+		case r.MileageNext < 0:
+			add(constValidLevelError, routeField(i, "mileageNext"), "%s距下一站的里程不能为负数", routeName(t, i))
+		case r.MileageNext == 0 && i < routeCount-1:
+			add(constValidLevelWarning, routeField(i, "mileageNext"), "%s未填写距下一站的里程", routeName(t, i))
+		case r.MileageNext != 0 && i == routeCount-1:
+			add(constValidLevelWarning, routeField(i, "mileageNext"), "终点站不应有距下一站的里程")
+		}
+	}
+}
+
+// validRouteTimes 按initTimetable的跨天规则校验各路段运行时间及中途站停靠时间
+func (t *TranInfo) validRouteTimes(add validAddFunc) {
+	routeCount := len(t.Timetable)
+	if routeCount < 2 {
+		return
+	}
+	tran := &TranInfo{Timetable: append([]Route(nil), t.Timetable...)}
+	tran.initTimetable()
+	tt := tran.Timetable
+	for i := 1; i < routeCount; i++ {
+		run := tt[i].ArrTime.Sub(tt[i-1].DepTime)
+		if run <= 0 {
+			add(constValidLevelError, routeField(i, "arrTime"), "%s的到达时间与上一站的出发时间相同", routeName(t, i))
+		} else if mileage := t.Timetable[i-1].MileageNext; mileage > 0 {
+			speed := float64(mileage) / run.Hours()
+			if speed > constValidMaxSpeed {
+				add(constValidLevelError, routeField(i, "arrTime"), "%s至%s平均时速%.0fkm/h，请检查到达时间，路段运行时间不能超过24小时",
+					routeName(t, i-1), routeName(t, i), speed)
+			} else if speed < constValidMinSpeed {
+				add(constValidLevelWarning, routeField(i, "arrTime"), "%s至%s平均时速仅%.0fkm/h",
+					routeName(t, i-1), routeName(t, i), speed)
+			}
+		}
+		if i == routeCount-1 {
+			break
+		}
+		dwell := tt[i].DepTime.Sub(tt[i].ArrTime)
+		switch {
+		case dwell > constValidDwellLimit:
+			add(constValidLevelError, routeField(i, "depTime"), "%s的出发时间早于到达时间", routeName(t, i))
+		case dwell == 0:
+			add(constValidLevelWarning, routeField(i, "depTime"), "%s的停靠时间为0", routeName(t, i))
+		case dwell > constValidMaxDwell:
+			add(constValidLevelWarning, routeField(i, "depTime"), "%s停靠%d分钟", routeName(t, i), int(dwell.Minutes()))
+		}
+	}
+}
+
+// validCars 校验车厢编组，格式如：32:1;12:2，返回编组中的所有席别
+func (t *TranInfo) validCars(add validAddFunc) map[string]bool {
+	seatTypes := make(map[string]bool)
+	if t.CarIds == "" {
+		add(constValidLevelError, "carIds", "未设置车厢编组")
+		return seatTypes
+	}
+	total := 0
+	for i, setting := range strings.Split(t.CarIds, ";") {
+		idCount := strings.Split(setting, ":")
+		if len(idCount) != 2 {
+			add(constValidLevelError, "carIds", "第%d组车厢的格式无效", i+1)
+			continue
+		}
+		id, err := strconv.Atoi(idCount[0])
+		car, exist := getTranSnapshot().carMap[id]
+		if err != nil || !exist {
+			add(constValidLevelError, "carIds", "第%d组车厢%s不存在", i+1, idCount[0])
+			continue
+		}
+		count, err := strconv.Atoi(idCount[1])
+		if err != nil || count <= 0 {
+			add(constValidLevelError, "carIds", "第%d组车厢的数量无效", i+1)
+			continue
+		}
+		total += count
+		seatTypes[car.SeatType] = true
+	}
+	if total > constValidMaxCarCount {
+		add(constValidLevelError, "carIds", "车厢总数不能超过%d节", constValidMaxCarCount)
+	}
+	return seatTypes
+}
+
+// validPrices 校验编组中的各席别（无座除外）在每个路段都有票价
+func (t *TranInfo) validPrices(add validAddFunc, seatTypes map[string]bool) {
+	routeCount := len(t.Timetable) - 1
+	if routeCount < 1 {
+		return
+	}
+	for _, seatType := range unionSeatTypes(t.SeatPriceMap, nil) {
+		if !seatTypes[seatType] {
+			add(constValidLevelWarning, "seatPriceMap."+seatType, "车厢编组中没有%s席别，其票价不会生效", seatType)
+		}
+	}
+	composition := make([]string, 0, len(seatTypes))
+	for seatType := range seatTypes {
+		composition = append(composition, seatType)
+	}
+	sort.Strings(composition)
+	for _, seatType := range composition {
+		if seatType == constSeatTypeNoSeat {
+			continue
+		}
+		prices, exist := t.SeatPriceMap[seatType]
+		if !exist {
+			add(constValidLevelError, "seatPriceMap."+seatType, "未设置%s席别的票价", seatType)
+			continue
+		}
+		if len(prices) != routeCount {
+			add(constValidLevelError, "seatPriceMap."+seatType, "%s席别有%d个路段的票价，应为%d个", seatType, len(prices), routeCount)
+		}
+		for i, p := range prices {
+			if p <= 0 && i < routeCount {
+				add(constValidLevelError, fmt.Sprintf("seatPriceMap.%s[%d]", seatType, i),
+					"%s席别%s至%s的票价无效", seatType, routeName(t, i), routeName(t, i+1))
+			}
+		}
+	}
+}
+
+// fillStationCodes 按站名补全时刻表中的车站编码及城市编码
+func (t *TranInfo) fillStationCodes() {
+	for i := range t.Timetable {
+		if s := getStationInfoByName(t.Timetable[i].StationName); s != nil {
+			t.Timetable[i].StationCode, t.Timetable[i].CityCode = s.StationCode, s.CityCode
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package modules
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateTranInfo(t *testing.T) {
+	oldStations, oldSnapshot := getStationSnapshot(), getTranSnapshot()
+	defer func() { storeStationSnapshot(oldStations); storeTranSnapshot(oldSnapshot) }()
+	storeStationSnapshot(&stationSnapshot{stations: stationCfgs{
+		Station{StationName: "上海虹桥", StationCode: "AOH", CityCode: "shanghai"},
+		Station{StationName: "北京南", StationCode: "VNP", CityCode: "beijing"},
+		Station{StationName: "南京南", StationCode: "NKH", CityCode: "nanjing"},
+	}})
+	storeTranSnapshot(&tranSnapshot{carMap: map[int](Car){
+		1: Car{ID: 1, SeatType: constSeatTypeSecondClass},
+		2: Car{ID: 2, SeatType: constSeatTypeFristClass},
+	}})
+	at := func(hm string) time.Time {
+		d, _ := time.ParseInLocation("2006-01-02 15:04", "1971-01-01 "+hm, time.Local)
+		return d
+	}
+	newTran := func() *TranInfo {
+		return &TranInfo{TranNum: "G1", CarIds: "1:6;2:2",
+			Timetable: []Route{
+				Route{StationName: "北京南", DepTime: at("08:00"), MileageNext: 1000},
+				Route{StationName: "南京南", ArrTime: at("11:30"), DepTime: at("11:32"), MileageNext: 300},
+				Route{StationName: "上海虹桥", ArrTime: at("12:40")},
+			},
+			SeatPriceMap: map[string]([]int){
+				constSeatTypeSecondClass: []int{44300, 13450},
+				constSeatTypeFristClass:  []int{74800, 22650},
+			}}
+	}
+	hasIssue := func(issues []TranValidIssue, level, field string) bool {
+		for _, issue := range issues {
+			if issue.Level == level && issue.Field == field {
+				return true
+			}
+		}
+		return false
+	}
+
+	if issues := newTran().Validate(); len(issues) == 0 {
+		t.Log("Validate valid pass")
+	} else {
+		t.Error("Validate valid fail", issues)
+	}
+
+	// 跨天运行的列车，中途站停靠较长
+	tran := newTran()
+	tran.Timetable[0].DepTime = at("22:00")
+	tran.Timetable[1].ArrTime, tran.Timetable[1].DepTime = at("01:30"), at("02:20")
+	tran.Timetable[2].ArrTime = at("03:30")
+	issues := tran.Validate()
+	if len(issues) == 1 && hasIssue(issues, constValidLevelWarning, "timetable[1].depTime") && FirstValidError(issues) == "" {
+		t.Log("Validate cross day pass")
+	} else {
+		t.Error("Validate cross day fail", issues)
+	}
+
+	tran = newTran()
+	tran.Timetable[1].ArrTime, tran.Timetable[1].DepTime = at("11:32"), at("11:30")
+	tran.Timetable[2].StationName = "北京南"
+	tran.Timetable[0].MileageNext = 0
+	issues = tran.Validate()
+	if hasIssue(issues, constValidLevelError, "timetable[1].depTime") &&
+		hasIssue(issues, constValidLevelError, "timetable[2].stationName") &&
+		hasIssue(issues, constValidLevelWarning, "timetable[0].mileageNext") && FirstValidError(issues) != "" {
+		t.Log("Validate timetable fail pass")
+	} else {
+		t.Error("Validate timetable fail fail", issues)
+	}
+
+	// 路段运行超过24小时时，按时刻只能得到不足一天的运行时间
+	tran = newTran()
+	tran.Timetable[0].MileageNext = 2000
+	tran.Timetable[1].ArrTime = at("09:00")
+	tran.Timetable[1].DepTime = at("09:02")
+	if issues = tran.Validate(); hasIssue(issues, constValidLevelError, "timetable[1].arrTime") {
+		t.Log("Validate speed pass")
+	} else {
+		t.Error("Validate speed fail", issues)
+	}
+
+	tran = newTran()
+	tran.CarIds = "1:6;3:2;2-1"
+	tran.SeatPriceMap[constSeatTypeSecondClass] = []int{44300, 0}
+	tran.SeatPriceMap[constSeatTypeSoftSleeper] = []int{1, 1}
+	issues = tran.Validate()
+	if hasIssue(issues, constValidLevelError, "carIds") &&
+		hasIssue(issues, constValidLevelError, "seatPriceMap.SC[1]") &&
+		hasIssue(issues, constValidLevelWarning, "seatPriceMap.FC") &&
+		hasIssue(issues, constValidLevelWarning, "seatPriceMap.SS") {
+		t.Log("Validate cars and prices pass")
+	} else {
+		t.Error("Validate cars and prices fail", issues)
+	}
+
+	tran = newTran()
+	tran.Timetable[1].StationName = "南京"
+	tran.fillStationCodes()
+	if issues = tran.Validate(); hasIssue(issues, constValidLevelError, "timetable[1].stationName") &&
+		tran.Timetable[0].StationCode == "VNP" && tran.Timetable[2].CityCode == "shanghai" {
+		t.Log("Validate station pass")
+	} else {
+		t.Error("Validate station fail", issues)
+	}
+}
@@ -0,0 +1,231 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// 车次版本的差异项
+	constDiffFieldBasic     = "基本信息"
+	constDiffFieldTimetable = "时刻表"
+	constDiffFieldPrice     = "票价"
+	constDiffFieldCar       = "车厢"
+)
+
+// TranVersionDiff 两个车次版本之间的一项差异
+type TranVersionDiff struct {
+	Field string `json:"field"` // 差异项
+	Key   string `json:"key"`   // 差异所在，如车站、席别及路段
+	From  string `json:"from"`  // 原版本的值，新增时为空
+	To    string `json:"to"`    // 新版本的值，删除时为空
+}
+
+// GetTranVersions 获取车次的所有版本，按生效开始日期排序
+func GetTranVersions(tranNum string) (versions []TranInfo) {
+	db.Where("tran_num = ?", tranNum).Order("enable_start_date").Find(&versions)
+	return
+}
+
+// DiffTranVersions 对比车次的两个版本
+func DiffTranVersions(fromID, toID int) ([]TranVersionDiff, error) {
+	from, to := &TranInfo{}, &TranInfo{}
+	db.Where("id = ?", fromID).First(from)
+	db.Where("id = ?", toID).First(to)
+	if from.ID == 0 || to.ID == 0 {
+		return nil, errors.New("车次版本不存在")
+	}
+	if from.TranNum != to.TranNum {
+		return nil, errors.New("只能对比同一车次的版本")
+	}
+	from.getFullInfo()
+	to.getFullInfo()
+	return diffTranInfo(from, to), nil
+}
+
+// prepareVersion 保存前确定车次版本，生效期与同车次的其它版本不能重叠：
+// 新车次直接新增；修改已有版本时，生效开始日期晚于原版本的，作为新版本新增，原版本截止到新版本生效的前一天；
+// 否则在原版本上修改，原版本已有排班时只能修改基本信息，以免改动已售车票所属的时刻表、票价及车厢
+func (t *TranInfo) prepareVersion(tx *gorm.DB) (bool, string) {
+	if !t.EnableStartDate.Before(t.EnableEndDate) {
+		return false, "生效截止日期不能早于开始日期"
+	}
+	var old *TranInfo
+	if t.ID != 0 {
+		old = &TranInfo{}
+		tx.Where("id = ?", t.ID).First(old)
+		if old.ID == 0 {
+			return false, "车次版本不存在"
+		}
+		if old.TranNum != t.TranNum {
+			return false, "车次号不能修改，请新增车次"
+		}
+	}
+	var versions []TranInfo
+	tx.Where("tran_num = ? and id != ?", t.TranNum, t.ID).Find(&versions)
+	if v := findOverlapVersion(versions, t.EnableStartDate, t.EnableEndDate); v != nil {
+		return false, fmt.Sprintf("生效期与%s至%s的版本重叠",
+			v.EnableStartDate.Format(ConstYmdFormat), v.EnableEndDate.Format(ConstYmdFormat))
+	}
+	if old == nil {
+		return true, ""
+	}
+	if dateOnly(t.EnableStartDate).After(dateOnly(old.EnableStartDate)) {
+		cutover := t.EnableStartDate.Format(ConstYmdFormat)
+		// 未售票的排班由排班任务按新版本重新生成
+		if countSoldTickets(t.TranNum, cutover, old.EnableEndDate.Format(ConstYmdFormat)) != 0 {
+			return false, fmt.Sprintf("原版本在%s之后已售票，请顺延新版本的生效开始日期", cutover)
+		}
+		if err := tx.Model(old).Update("enable_end_date", t.EnableStartDate.Add(-time.Second)).Error; err != nil {
+			return false, err.Error()
+		}
+		t.ID = 0
+		return true, ""
+	}
+	old.getFullInfo()
+	if hasStructuralDiff(diffTranInfo(old, t)) &&
+		countSoldTickets(t.TranNum, old.EnableStartDate.Format(ConstYmdFormat), old.EnableEndDate.Format(ConstYmdFormat)) != 0 {
+		return false, "该版本已售票，修改时刻表、票价或车厢时请指定新的生效开始日期"
+	}
+	return true, ""
+}
+
+// findOverlapVersion 查找生效期与[start, end]重叠的版本
+func findOverlapVersion(versions []TranInfo, start, end time.Time) *TranInfo {
+	for i := range versions {
+		if !versions[i].EnableStartDate.After(end) && !start.After(versions[i].EnableEndDate) {
+			return &versions[i]
+		}
+	}
+	return nil
+}
+
+// hasStructuralDiff 是否有基本信息以外的差异
+func hasStructuralDiff(diffs []TranVersionDiff) bool {
+	for _, d := range diffs {
+		if d.Field != constDiffFieldBasic {
+			return true
+		}
+	}
+	return false
+}
+
+// diffTranInfo 对比两个车次版本的基本信息、时刻表、票价及车厢
+func diffTranInfo(from, to *TranInfo) (diffs []TranVersionDiff) {
+	add := func(field, key, fromVal, toVal string) {
+		if fromVal != toVal {
+			diffs = append(diffs, TranVersionDiff{Field: field, Key: key, From: fromVal, To: toVal})
+		}
+	}
+	add(constDiffFieldBasic, "生效开始日期", from.EnableStartDate.Format(ConstYmdFormat), to.EnableStartDate.Format(ConstYmdFormat))
+	add(constDiffFieldBasic, "生效截止日期", from.EnableEndDate.Format(ConstYmdFormat), to.EnableEndDate.Format(ConstYmdFormat))
+	add(constDiffFieldBasic, "发车间隔天数", strconv.Itoa(from.ScheduleDays), strconv.Itoa(to.ScheduleDays))
+	add(constDiffFieldBasic, "开行星期", strconv.Itoa(int(from.Weekdays)), strconv.Itoa(int(to.Weekdays)))
+	add(constDiffFieldBasic, "开行日历", strconv.FormatUint(from.CalendarID, 10), strconv.FormatUint(to.CalendarID, 10))
+	add(constDiffFieldBasic, "是否售票", strconv.FormatBool(from.IsSaleTicket), strconv.FormatBool(to.IsSaleTicket))
+	add(constDiffFieldBasic, "开售时间", from.SaleTicketTime.In(time.Local).Format(ConstHmFormat), to.SaleTicketTime.In(time.Local).Format(ConstHmFormat))
+	add(constDiffFieldBasic, "不售票说明", from.NonSaleRemark, to.NonSaleRemark)
+
+	for i := 0; i < len(from.Timetable) || i < len(to.Timetable); i++ {
+		var f, t Route
+		if i < len(from.Timetable) {
+			f = from.Timetable[i]
+		}
+		if i < len(to.Timetable) {
+			t = to.Timetable[i]
+		}
+		key := "第" + strconv.Itoa(i+1) + "站"
+		add(constDiffFieldTimetable, key+" 站名", f.StationName, t.StationName)
+		add(constDiffFieldTimetable, key+" 到达时间", formatRouteTime(f.ArrTime, i < len(from.Timetable) && i > 0),
+			formatRouteTime(t.ArrTime, i < len(to.Timetable) && i > 0))
+		add(constDiffFieldTimetable, key+" 出发时间", formatRouteTime(f.DepTime, i < len(from.Timetable)-1),
+			formatRouteTime(t.DepTime, i < len(to.Timetable)-1))
+		add(constDiffFieldTimetable, key+" 检票口", f.CheckTicketGate, t.CheckTicketGate)
+		add(constDiffFieldTimetable, key+" 站台", formatPlatform(f.Platform), formatPlatform(t.Platform))
+	}
+
+	for _, seatType := range unionSeatTypes(from.SeatPriceMap, to.SeatPriceMap) {
+		diffRoutePrices(add, seatType, from.SeatPriceMap[seatType], to.SeatPriceMap[seatType])
+	}
+	berthSeatTypes := make(map[string]([]int))
+	for seatType := range from.BerthPriceMap {
+		berthSeatTypes[seatType] = nil
+	}
+	for seatType := range to.BerthPriceMap {
+		berthSeatTypes[seatType] = nil
+	}
+	for _, seatType := range unionSeatTypes(berthSeatTypes, nil) {
+		for _, berth := range unionSeatTypes(from.BerthPriceMap[seatType], to.BerthPriceMap[seatType]) {
+			diffRoutePrices(add, seatType+" "+berth, from.BerthPriceMap[seatType][berth], to.BerthPriceMap[seatType][berth])
+		}
+	}
+
+	add(constDiffFieldCar, "车厢编组", from.CarIds, to.CarIds)
+	add(constDiffFieldCar, "分段配额", formatQuotaRules(from.QuotaRules), formatQuotaRules(to.QuotaRules))
+	return
+}
+
+// diffRoutePrices 对比席别或铺位在各路段的价格
+func diffRoutePrices(add func(field, key, fromVal, toVal string), name string, from, to []int) {
+	for i := 0; i < len(from) || i < len(to); i++ {
+		f, t := "", ""
+		if i < len(from) {
+			f = strconv.FormatFloat(float64(from[i])/100, 'f', 2, 64)
+		}
+		if i < len(to) {
+			t = strconv.FormatFloat(float64(to[i])/100, 'f', 2, 64)
+		}
+		add(constDiffFieldPrice, name+" 第"+strconv.Itoa(i+1)+"段", f, t)
+	}
+}
+
+// unionSeatTypes 两个价格表中的所有席别（或铺位），按名称排序
+func unionSeatTypes(a, b map[string]([]int)) []string {
+	m := make(map[string]bool, len(a)+len(b))
+	for k := range a {
+		m[k] = true
+	}
+	for k := range b {
+		m[k] = true
+	}
+	result := make([]string, 0, len(m))
+	for k := range m {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// formatRouteTime 时刻表时间，跨天时加上跨天数，如：+1 06:30
+// 页面提交的时间为UTC时间，数据库中的为本地时间，统一按本地时间比较
+func formatRouteTime(t time.Time, valid bool) string {
+	if !valid {
+		return ""
+	}
+	t = t.In(time.Local)
+	if days := t.YearDay() - 1; days > 0 {
+		return "+" + strconv.Itoa(days) + " " + t.Format(ConstHmFormat)
+	}
+	return t.Format(ConstHmFormat)
+}
+
+func formatPlatform(platform uint8) string {
+	if platform == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(platform))
+}
+
+func formatQuotaRules(rules []SeatQuotaRule) string {
+	list := make([]string, len(rules))
+	for i, r := range rules {
+		list[i] = fmt.Sprintf("%s %d-%d x%d", r.SeatType, r.DepIdx, r.ArrIdx, r.SeatCount)
+	}
+	return strings.Join(list, ";")
+}
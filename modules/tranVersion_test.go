@@ -0,0 +1,120 @@
+package modules
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestFindOverlapVersion(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse(ConstYmdFormat, s)
+		return d
+	}
+	versions := []TranInfo{
+		TranInfo{ID: 1, EnableStartDate: day("2018-01-01"), EnableEndDate: day("2018-06-30").Add(24*time.Hour - time.Second)},
+		TranInfo{ID: 2, EnableStartDate: day("2018-07-01"), EnableEndDate: day("2018-12-31").Add(24*time.Hour - time.Second)},
+	}
+	cases := []struct {
+		name       string
+		start, end string
+		overlapID  int
+	}{
+		{"after all", "2019-01-01", "2019-06-30", 0},
+		{"inside first", "2018-03-01", "2018-03-31", 1},
+		{"cross both", "2018-06-01", "2018-07-31", 1},
+		{"end day of second", "2018-12-31", "2019-03-31", 2},
+	}
+	for _, c := range cases {
+		v := findOverlapVersion(versions, day(c.start), day(c.end).Add(24*time.Hour-time.Second))
+		if (v == nil && c.overlapID == 0) || (v != nil && v.ID == c.overlapID) {
+			t.Log("findOverlapVersion " + c.name + " pass")
+		} else {
+			t.Error("findOverlapVersion " + c.name + " fail")
+		}
+	}
+}
+
+func TestDiffTranInfo(t *testing.T) {
+	at := func(hm string) time.Time {
+		d, _ := time.ParseInLocation("2006-01-02 15:04", "0001-01-01 "+hm, time.Local)
+		return d
+	}
+	from := &TranInfo{TranNum: "G1", CarIds: "1:8", IsSaleTicket: true,
+		Timetable: []Route{
+			Route{StationName: "北京南", DepTime: at("08:00"), CheckTicketGate: "A1"},
+			Route{StationName: "上海虹桥", ArrTime: at("13:00")},
+		},
+		SeatPriceMap: map[string]([]int){constSeatTypeSecondClass: []int{55300}}}
+	basic := *from
+	basic.NonSaleRemark = "临时调整"
+	if diffs := diffTranInfo(from, &basic); len(diffs) == 1 && diffs[0].Field == constDiffFieldBasic && !hasStructuralDiff(diffs) {
+		t.Log("diffTranInfo basic pass")
+	} else {
+		t.Error("diffTranInfo basic fail")
+	}
+
+	to := *from
+	to.Timetable = []Route{
+		Route{StationName: "北京南", DepTime: at("08:00"), CheckTicketGate: "A2"},
+		Route{StationName: "南京南", ArrTime: at("11:00"), DepTime: at("11:02")},
+		Route{StationName: "上海虹桥", ArrTime: at("13:00").AddDate(0, 0, 1)},
+	}
+	to.SeatPriceMap = map[string]([]int){constSeatTypeSecondClass: []int{44300, 11000}}
+	diffs := diffTranInfo(from, &to)
+	expect := map[string]TranVersionDiff{
+		"第1站 检票口":  TranVersionDiff{From: "A1", To: "A2"},
+		"第2站 站名":   TranVersionDiff{From: "上海虹桥", To: "南京南"},
+		"第2站 出发时间": TranVersionDiff{From: "", To: "11:02"},
+		"第3站 到达时间": TranVersionDiff{From: "", To: "+1 13:00"},
+		"SC 第1段":   TranVersionDiff{From: "553.00", To: "443.00"},
+		"SC 第2段":   TranVersionDiff{From: "", To: "110.00"},
+	}
+	matched := 0
+	for _, d := range diffs {
+		if e, ok := expect[d.Key]; ok && e.From == d.From && e.To == d.To {
+			matched++
+		}
+	}
+	if matched == len(expect) && hasStructuralDiff(diffs) {
+		t.Log("diffTranInfo timetable and price pass")
+	} else {
+		t.Error("diffTranInfo timetable and price fail", diffs)
+	}
+}
+
+func TestGetTranInfoVersion(t *testing.T) {
+	oldSnapshot := getTranSnapshot()
+	defer storeTranSnapshot(oldSnapshot)
+	day := func(s string) time.Time {
+		d, _ := time.Parse(ConstYmdFormat, s)
+		return d
+	}
+	tranInfos := tranCfgs{
+		TranInfo{ID: 3, TranNum: "G2", EnableStartDate: day("2018-01-01"), EnableEndDate: day("2018-12-31")},
+		TranInfo{ID: 2, TranNum: "G1", EnableStartDate: day("2018-07-01"), EnableEndDate: day("2018-12-31")},
+		TranInfo{ID: 4, TranNum: "G1", EnableStartDate: day("2019-03-01"), EnableEndDate: day("2019-12-31")},
+		TranInfo{ID: 1, TranNum: "G1", EnableStartDate: day("2018-01-01"), EnableEndDate: day("2018-06-30")},
+	}
+	sort.Sort(tranInfos)
+	storeTranSnapshot(&tranSnapshot{tranInfos: tranInfos})
+	cases := []struct {
+		tranNum, date string
+		id            int
+	}{
+		{"G1", "2018-03-01", 1},
+		{"G1", "2018-07-01", 2},
+		{"G1", "2019-01-15", 0},
+		{"G1", "2019-05-01", 4},
+		{"G2", "2018-05-01", 3},
+		{"G3", "2018-05-01", 0},
+	}
+	for _, c := range cases {
+		tran, exist := getTranInfo(c.tranNum, day(c.date))
+		if (!exist && c.id == 0) || (exist && tran.ID == c.id) {
+			t.Log("getTranInfo " + c.tranNum + " " + c.date + " pass")
+		} else {
+			t.Error("getTranInfo " + c.tranNum + " " + c.date + " fail")
+		}
+	}
+}
@@ -119,18 +119,22 @@ func initUserInfos() {
 // Passenger 乘客
 type Passenger struct {
 	PID           uint64
-	Name          string // 姓名
-	IsMale        bool   // 性别
-	Area          string // 国家地区
-	PaperworkType uint8  // 证件类型
-	PaperworkNum  string // 证件号码
-	Status        uint8  // 乘客信息状态 0:待核验; 1:核验通过; 2:核验未通过; 3:黑名单; ...etc
-	PassengerType uint8  // 乘客类型 1:成人; 2:儿童; 3:学生; 4:伤残军人/伤残人民警察
-	PhoneNum      string // 手机号
-	TelNum        string // 固话
-	Email         string // 邮箱
-	Addr          string // 地址
-	ZipCode       string // 邮编
+	Name          string    // 姓名
+	IsMale        bool      // 性别
+	Area          string    // 国家地区
+	PaperworkType uint8     // 证件类型
+	PaperworkNum  string    // 证件号码
+	Status        uint8     // 乘客信息状态 0:待核验; 1:核验通过; 2:核验未通过; 3:黑名单; ...etc
+	PassengerType uint8     // 乘客类型 1:成人; 2:儿童; 3:学生; 4:伤残军人/伤残人民警察
+	Birthday      time.Time `gorm:"type:date"` // 出生日期，证件为身份证时以身份证号为准
+	Height        uint8     // 身高 单位：厘米，无法确认儿童年龄时用于确定票价
+	HomeCity      string    // 学生家庭所在城市编码
+	SchoolCity    string    // 学生学校所在城市编码
+	PhoneNum      string    // 手机号
+	TelNum        string    // 固话
+	Email         string    // 邮箱
+	Addr          string    // 地址
+	ZipCode       string    // 邮编
 }
 
 func getPassenger(paperworkNum string, paperworkType uint8) (Passenger, bool) {
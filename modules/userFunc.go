@@ -38,6 +38,13 @@ type TimetableResult struct {
 	DepTime  string // 出发时间
 	ArrTime  string // 到达时间
 	StayTime string // 停留时间
+	Remark   string // 说明，如停运区间内的车站显示停运说明
+	// 实时运行状态，未上报时检票口、站台与时刻表一致，晚点为零，实际时间为空
+	CheckTicketGate string // 检票口
+	Platform        uint8  // 站台
+	DelayMinutes    int    // 预计晚点分钟数
+	ActualArrTime   string // 实际到达时间
+	ActualDepTime   string // 实际出发时间
 }
 
 // QueryTimetable 查询时刻表
@@ -46,9 +53,23 @@ func QueryTimetable(tranNum string, date time.Time) (result []TimetableResult) {
 	if !exist {
 		return
 	}
+	strDate := date.Format(ConstYmdFormat)
+	st := scheduleCache.getScheduleTran(tranNum, strDate)
 	result = make([]TimetableResult, 0, len(tran.Timetable))
 	for i, v := range tran.Timetable {
 		r := TimetableResult{Name: v.StationName, DepTime: v.getStrDepTime(), ArrTime: v.getStrArrTime(), StayTime: v.getStrStayTime()}
+		if st.isStationSuspended(uint8(i)) {
+			r.Remark = st.Suspension.Remark
+		}
+		s := getTrainStopStatus(tranNum, strDate, uint8(i))
+		r.CheckTicketGate, r.Platform = s.getGate(&tran.Timetable[i]), s.getPlatform(&tran.Timetable[i])
+		r.DelayMinutes = int(s.getDelay().Minutes())
+		if s != nil && !s.ActualArrTime.IsZero() {
+			r.ActualArrTime = s.ActualArrTime.Format(ConstHmFormat)
+		}
+		if s != nil && !s.ActualDepTime.IsZero() {
+			r.ActualDepTime = s.ActualDepTime.Format(ConstHmFormat)
+		}
 		if i == 0 {
 			r.ArrTime = ConstStrNullTime
 			r.StayTime = ConstStrNullTime
@@ -62,10 +83,10 @@ func QueryTimetable(tranNum string, date time.Time) (result []TimetableResult) {
 	return
 }
 
-// QuerySeatPrice 查询票价
-func QuerySeatPrice(tranNum string, date time.Time, depIdx, arrIdx uint8) (result map[string]float32) {
+// QuerySeatPrice 查询票价，返回调价后的报价，有效期内以报价下单时按报价收费
+func QuerySeatPrice(tranNum string, date time.Time, depIdx, arrIdx uint8) (result *PriceQuote) {
 	if tran, exist := getTranInfo(tranNum, date); exist {
-		result = tran.getSeatPrice(depIdx, arrIdx)
+		result = newPriceQuote(tran, date.Format(ConstYmdFormat), depIdx, arrIdx, time.Now())
 	}
 	return
 }
@@ -1,10 +1,13 @@
 package web
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"t-tran/modules"
 	"time"
 
@@ -18,6 +21,20 @@ func setAdminRouter(g *gin.RouterGroup) {
 	g.GET("/trans/detail", tranDetail)
 	g.GET("/trans/getDetail", getTranDetail)
 	g.POST("/tran/save", saveTran)
+	g.GET("/trans/versions", queryTranVersions)
+	g.GET("/trans/diff", tranDiff)
+	g.GET("/trans/getDiff", getTranDiff)
+	g.GET("/gtfs", gtfs)
+	g.GET("/gtfs/export", exportGTFS)
+	g.POST("/gtfs/import", importGTFS)
+	g.GET("/bulk", bulk)
+	g.GET("/bulk/export", exportBulk)
+	g.POST("/bulk/import", importBulk)
+
+	// 开行日历路由
+	g.GET("/calendars/query", queryCalendars)
+	g.POST("/calendar/save", saveCalendar)
+	g.POST("/calendar/delete", deleteCalendar)
 
 	// 车厢路由
 	g.GET("/cars", cars)
@@ -32,6 +49,8 @@ func setAdminRouter(g *gin.RouterGroup) {
 	g.GET("/stations/detail", stationDetail)
 	g.GET("/stations/getDetail", getStationDetail)
 	g.POST("/station/save", saveStation)
+	g.POST("/station/delete", deleteStation)
+	g.POST("/station/merge", mergeStations)
 
 	// 排班路由
 	g.GET("/schedules", schedules)
@@ -39,6 +58,40 @@ func setAdminRouter(g *gin.RouterGroup) {
 	g.GET("/schedules/detail", scheduleDetail)
 	g.GET("/schedules/getDetail", getScheduleDetail)
 	g.POST("/schedules/save", saveSchedule)
+	g.POST("/schedules/suspend", suspendSchedule)
+	g.POST("/schedules/resume", resumeSchedule)
+	g.GET("/schedules/job", scheduleJobReport)
+	g.POST("/schedules/checkTicket", checkScheduleTicket)
+	g.POST("/schedules/job/run", runScheduleJob)
+
+	// 实时运行状态路由
+	g.GET("/trainStatus/query", queryTrainStatus)
+	g.POST("/trainStatus/save", saveTrainStatus)
+
+	// 告警路由
+	g.GET("/alerts", alerts)
+	g.GET("/alerts/query", queryAlerts)
+	g.POST("/alert/ack", ackAlert)
+	g.POST("/alert/resolve", resolveAlert)
+
+	// 通知模板路由
+	g.GET("/notifyTemplates/query", queryNotifyTemplates)
+	g.POST("/notifyTemplate/save", saveNotifyTemplate)
+	g.GET("/notifySetting/query", queryNotifySetting)
+	g.POST("/notifySetting/save", saveNotifySetting)
+
+	// 限流及防黄牛路由
+	g.GET("/limitSetting/query", queryLimitSetting)
+	g.POST("/limitSetting/save", saveLimitSetting)
+	g.GET("/suspiciousFlags/query", querySuspiciousFlags)
+
+	// 动态调价路由
+	g.GET("/priceSetting/query", queryPriceSetting)
+	g.POST("/priceSetting/save", savePriceSetting)
+	g.GET("/priceRules/query", queryPriceRules)
+	g.POST("/priceRule/save", savePriceRule)
+	g.POST("/priceRule/delete", deletePriceRule)
+	g.GET("/priceAudits/query", queryPriceAudits)
 }
 
 func getPaging(c *gin.Context) (page, pageSize int) {
@@ -85,7 +138,7 @@ func getTranDetail(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"tranInfo": tranInfo})
 }
 
-// saveTran 保存车次配置信息
+// saveTran 保存车次配置信息，校验有错误时不保存，并返回所有错误及警告
 func saveTran(c *gin.Context) {
 	var t modules.TranInfo
 	if err := c.BindJSON(&t); err != nil {
@@ -95,8 +148,114 @@ func saveTran(c *gin.Context) {
 		t.Timetable[i].ArrTime.Add(8*time.Hour).AddDate(-1970, 0, 0)
 		t.Timetable[i].DepTime.Add(8*time.Hour).AddDate(-1970, 0, 0)
 	}
+	issues := t.Validate()
+	if msg := modules.FirstValidError(issues); msg != "" {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": msg, "issues": issues})
+		return
+	}
 	success, msg := t.Save()
-	c.JSON(http.StatusOK, gin.H{"success": success, "msg": msg})
+	c.JSON(http.StatusOK, gin.H{"success": success, "msg": msg, "issues": issues})
+}
+
+// queryTranVersions 查询车次的所有版本
+func queryTranVersions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"versions": modules.GetTranVersions(c.Query("tranNum"))})
+}
+
+// tranDiff 返回车次版本对比页
+func tranDiff(c *gin.Context) {
+	c.HTML(http.StatusOK, "tranDiff.html", gin.H{})
+}
+
+// getTranDiff 对比车次的两个版本
+func getTranDiff(c *gin.Context) {
+	fromID, toID := strToInt(c.Query("fromId"), 0), strToInt(c.Query("toId"), 0)
+	diffs, err := modules.DiffTranVersions(fromID, toID)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "diffs": diffs})
+}
+
+// gtfs 返回GTFS导入导出页面
+func gtfs(c *gin.Context) {
+	c.HTML(http.StatusOK, "gtfs.html", gin.H{})
+}
+
+// exportGTFS 下载GTFS压缩包
+func exportGTFS(c *gin.Context) {
+	var buf bytes.Buffer
+	if err := modules.ExportGTFS(&buf); err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=gtfs.zip")
+	c.Data(http.StatusOK, "application/zip", buf.Bytes())
+}
+
+// importGTFS 上传GTFS压缩包导入，dryRun为true时只返回校验报告
+func importGTFS(c *gin.Context) {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "请选择GTFS压缩包"})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	defer f.Close()
+	report, err := modules.ImportGTFS(f, fh.Size, c.PostForm("dryRun") == "true")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "report": report})
+}
+
+// bulk 返回批量导入导出页面
+func bulk(c *gin.Context) {
+	c.HTML(http.StatusOK, "bulk.html", gin.H{})
+}
+
+// exportBulk 下载车次、车厢或车站的csv或xlsx文件
+func exportBulk(c *gin.Context) {
+	kind, format := c.Query("kind"), c.DefaultQuery("format", "csv")
+	var buf bytes.Buffer
+	if err := modules.ExportBulk(kind, format, &buf); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	contentType := "text/csv; charset=utf-8"
+	if format == "xlsx" {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", kind, format))
+	c.Data(http.StatusOK, contentType, buf.Bytes())
+}
+
+// importBulk 上传csv或xlsx文件导入，commit为true且没有错误时提交，否则只返回预览
+func importBulk(c *gin.Context) {
+	fh, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "请选择csv或xlsx文件"})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	defer f.Close()
+	format := strings.ToLower(strings.TrimPrefix(filepath.Ext(fh.Filename), "."))
+	preview, err := modules.ImportBulk(c.PostForm("kind"), format, f, fh.Size, c.PostForm("commit") == "true")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "preview": preview})
 }
 
 // cars 返回车厢页面
@@ -160,12 +319,39 @@ func getStationDetail(c *gin.Context) {
 	stationID := c.DefaultQuery("stationID", "0")
 	iStationID := strToInt(stationID, 0)
 	station := modules.GetStationDetail(iStationID)
-	c.JSON(http.StatusOK, gin.H{"station": station})
+	aliases := modules.GetStationAliases(station.ID)
+	c.JSON(http.StatusOK, gin.H{"station": station, "aliases": aliases})
 }
 
 // saveStation 保存车站信息
 func saveStation(c *gin.Context) {
-	c.HTML(http.StatusOK, "stations.html", gin.H{})
+	var station modules.Station
+	if err := c.BindJSON(&station); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "Post Data Err"})
+		return
+	}
+	success, msg := station.Save()
+	c.JSON(http.StatusOK, gin.H{"success": success, "msg": msg, "id": station.ID})
+}
+
+// deleteStation 删除车站
+func deleteStation(c *gin.Context) {
+	stationID := strToInt(c.PostForm("stationID"), 0)
+	if err := modules.DeleteStation(uint(stationID)); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// mergeStations 将重复的车站合并到保留的车站
+func mergeStations(c *gin.Context) {
+	fromID, toID := strToInt(c.PostForm("fromID"), 0), strToInt(c.PostForm("toID"), 0)
+	if err := modules.MergeStations(uint(fromID), uint(toID)); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
 // schedules 返回排班页
@@ -191,7 +377,7 @@ func getScheduleDetail(c *gin.Context) {
 	scheduleID := c.Query("scheduleID")
 	iScheduleID := strToInt(scheduleID, 0)
 	schedule := modules.GetScheduleDetail(iScheduleID)
-	c.JSON(http.StatusOK, gin.H{"schedule": schedule})
+	c.JSON(http.StatusOK, gin.H{"schedule": &schedule})
 }
 
 // saveSchedule 保存排班信息
@@ -204,4 +390,242 @@ func saveSchedule(c *gin.Context) {
 	}
 	success, msg := schedule.Save()
 	c.JSON(http.StatusOK, gin.H{"success": success, "msg": msg})
-}
\ No newline at end of file
+}
+
+// suspendSchedule 车次某日停运，未指定区间时整趟停运
+func suspendSchedule(c *gin.Context) {
+	depIdx, arrIdx := strToInt(c.PostForm("depIdx"), 0), strToInt(c.PostForm("arrIdx"), 0)
+	if depIdx < 0 || arrIdx < 0 || depIdx > 255 || arrIdx > 255 {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "停运区间无效"})
+		return
+	}
+	count, err := modules.SuspendScheduleTran(c.PostForm("tranNum"), c.PostForm("depDate"), uint8(depIdx), uint8(arrIdx), c.PostForm("remark"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error(), "count": count})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "", "count": count})
+}
+
+// resumeSchedule 恢复停运的排班
+func resumeSchedule(c *gin.Context) {
+	if err := modules.ResumeScheduleTran(c.PostForm("tranNum"), c.PostForm("depDate")); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": ""})
+}
+
+// checkScheduleTicket 核验车票与排班，mode为repair时修复排班；未指定车次时核验可订票天数内的全部排班
+func checkScheduleTicket(c *gin.Context) {
+	tranNum, depDate := c.PostForm("tranNum"), c.PostForm("depDate")
+	if tranNum != "" && depDate == "" {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "请指定发车日期"})
+		return
+	}
+	reports := modules.CheckTicket(tranNum, depDate, c.PostForm("mode") == "repair")
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "", "reports": reports})
+}
+
+// scheduleJobReport 获取最近一次排班任务的结果
+func scheduleJobReport(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"report": modules.GetLastScheduleJobReport()})
+}
+
+// runScheduleJob 立即执行排班任务，返回生成及跳过的排班
+func runScheduleJob(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"success": true, "msg": "", "report": modules.RunScheduleJob(time.Now())})
+}
+
+// queryTrainStatus 查询列车某日已上报的各站运行状态
+func queryTrainStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"statuses": modules.GetTrainStopStatuses(c.Query("tranNum"), c.Query("depDate"))})
+}
+
+// saveTrainStatus 上报列车某日在某站的运行状态
+func saveTrainStatus(c *gin.Context) {
+	var report modules.TrainStopReport
+	if err := c.BindJSON(&report); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "Post Data Err"})
+		return
+	}
+	success, msg := report.Save()
+	c.JSON(http.StatusOK, gin.H{"success": success, "msg": msg})
+}
+
+// alerts 返回告警页
+func alerts(c *gin.Context) {
+	c.HTML(http.StatusOK, "alerts.html", gin.H{})
+}
+
+// queryAlerts 查询告警 & 翻页，默认查询所有未解决的告警
+func queryAlerts(c *gin.Context) {
+	status := strToInt(c.DefaultQuery("status", "-1"), -1)
+	page, pageSize := getPaging(c)
+	alerts, count := modules.QueryAlerts(status, page, pageSize)
+	c.JSON(http.StatusOK, gin.H{"alerts": alerts, "count": count, "page": page, "ps": pageSize})
+}
+
+// ackAlert 确认告警
+func ackAlert(c *gin.Context) {
+	alertID, err := strconv.ParseUint(c.PostForm("alertID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "告警无效"})
+		return
+	}
+	if err = modules.AckAlert(alertID); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// resolveAlert 解决告警
+func resolveAlert(c *gin.Context) {
+	alertID, err := strconv.ParseUint(c.PostForm("alertID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "告警无效"})
+		return
+	}
+	if err = modules.ResolveAlert(alertID); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// queryNotifyTemplates 查询所有通知模板
+func queryNotifyTemplates(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"templates": modules.GetNotifyTemplates()})
+}
+
+// saveNotifyTemplate 保存通知模板
+func saveNotifyTemplate(c *gin.Context) {
+	var tpl modules.NotifyTemplate
+	if err := c.BindJSON(&tpl); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "Post Data Err"})
+		return
+	}
+	success, msg := tpl.Save()
+	c.JSON(http.StatusOK, gin.H{"success": success, "msg": msg})
+}
+
+// queryNotifySetting 查询通知配置
+func queryNotifySetting(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"setting": modules.GetNotifySetting()})
+}
+
+// saveNotifySetting 保存通知配置
+func saveNotifySetting(c *gin.Context) {
+	var setting modules.NotifySetting
+	if err := c.BindJSON(&setting); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "Post Data Err"})
+		return
+	}
+	success, msg := setting.Save()
+	c.JSON(http.StatusOK, gin.H{"success": success, "msg": msg})
+}
+
+// queryLimitSetting 查询限流及防黄牛配置
+func queryLimitSetting(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"setting": modules.GetLimitSetting()})
+}
+
+// saveLimitSetting 保存限流及防黄牛配置
+func saveLimitSetting(c *gin.Context) {
+	var setting modules.LimitSetting
+	if err := c.BindJSON(&setting); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "Post Data Err"})
+		return
+	}
+	success, msg := setting.Save()
+	c.JSON(http.StatusOK, gin.H{"success": success, "msg": msg})
+}
+
+// querySuspiciousFlags 查询最近的可疑购票行为，可按用户筛选
+func querySuspiciousFlags(c *gin.Context) {
+	userID, _ := strconv.ParseUint(c.Query("userID"), 10, 64)
+	c.JSON(http.StatusOK, gin.H{"flags": modules.QuerySuspiciousFlags(userID)})
+}
+
+// queryPriceSetting 查询动态调价配置
+func queryPriceSetting(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"setting": modules.GetPriceSetting()})
+}
+
+// savePriceSetting 保存动态调价配置
+func savePriceSetting(c *gin.Context) {
+	var setting modules.PriceSetting
+	if err := c.BindJSON(&setting); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "Post Data Err"})
+		return
+	}
+	success, msg := setting.Save()
+	c.JSON(http.StatusOK, gin.H{"success": success, "msg": msg})
+}
+
+// queryPriceRules 查询所有调价规则
+func queryPriceRules(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"rules": modules.GetPriceRules()})
+}
+
+// savePriceRule 保存调价规则
+func savePriceRule(c *gin.Context) {
+	var rule modules.PriceRule
+	if err := c.BindJSON(&rule); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "Post Data Err"})
+		return
+	}
+	success, msg := rule.Save()
+	c.JSON(http.StatusOK, gin.H{"success": success, "msg": msg})
+}
+
+// deletePriceRule 删除调价规则
+func deletePriceRule(c *gin.Context) {
+	ruleID, err := strconv.ParseUint(c.PostForm("ruleID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "调价规则无效"})
+		return
+	}
+	if err = modules.DeletePriceRule(ruleID); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// queryPriceAudits 查询车票的票价审计
+func queryPriceAudits(c *gin.Context) {
+	ticketID, _ := strconv.ParseUint(c.Query("ticketID"), 10, 64)
+	c.JSON(http.StatusOK, gin.H{"audits": modules.GetPriceAudits(ticketID)})
+}
+
+// queryCalendars 查询所有开行日历
+func queryCalendars(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"calendars": modules.GetOperatingCalendars()})
+}
+
+// saveCalendar 保存开行日历
+func saveCalendar(c *gin.Context) {
+	var calendar modules.OperatingCalendar
+	if err := c.BindJSON(&calendar); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "Post Data Err"})
+		return
+	}
+	success, msg := calendar.Save()
+	c.JSON(http.StatusOK, gin.H{"success": success, "msg": msg})
+}
+
+// deleteCalendar 删除开行日历
+func deleteCalendar(c *gin.Context) {
+	calendarID, err := strconv.ParseUint(c.PostForm("calendarID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "开行日历无效"})
+		return
+	}
+	if err = modules.DeleteOperatingCalendar(calendarID); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
@@ -12,6 +12,10 @@ var localLoc *time.Location
 func init() {
 	localLoc, _ = time.LoadLocation("Local")
 	gin.SetMode(gin.ReleaseMode)
+}
+
+// Run 启动web服务，阻塞直到服务退出
+func Run() {
 	// web engine
 	g := gin.Default()
 	// load html files, tpl files
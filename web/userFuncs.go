@@ -12,6 +12,8 @@ import (
 const (
 	// queryTimeout 用户发起请求的超时时间
 	queryTimeout time.Duration = 5 * time.Second
+	// authUserIDKey 登录校验通过后，在请求上下文中保存用户ID的键
+	authUserIDKey = "userID"
 )
 
 func setUserRouter(g *gin.RouterGroup) {
@@ -20,26 +22,76 @@ func setUserRouter(g *gin.RouterGroup) {
 	// 登出
 	g.POST("/logout", logout)
 	// 查询车次及余票
-	g.GET("/residualTicket", queryResidualTicket)
+	g.GET("/residualTicket", queryLimit, queryResidualTicket)
 	// 查询时刻表
-	g.GET("/queryTimetable", queryTimetable)
+	g.GET("/queryTimetable", queryLimit, queryTimetable)
 	// 查询票价
-	g.GET("/queryPrice", queryPrice)
+	g.GET("/queryPrice", queryLimit, queryPrice)
+	// 查询价格日历
+	g.GET("/priceCalendar", queryLimit, queryPriceCalendar)
 	// 提交订单
-	g.POST("/submitOrder", submitOrder)
+	g.POST("/submitOrder", orderLimit, submitOrder)
+	// 查询订单排队结果
+	g.GET("/orderQueue/result", queryLimit, queryOrderQueue)
+	// 等待订单排队结果
+	g.GET("/orderQueue/wait", queryLimit, waitOrderQueue)
 	// 确认改签
-	g.POST("/changeOrder", changeOrder)
+	g.POST("/changeOrder", orderLimit, changeOrder)
+	// 提交联程订单
+	g.POST("/submitItinerary", orderLimit, submitItinerary)
+	// 联程订单上一程晚点时，免费改签后续行程
+	g.POST("/rebookItinerary", orderLimit, rebookItinerary)
+	// 提交往返订单
+	g.POST("/submitRoundTrip", orderLimit, submitRoundTrip)
 	// 查询订单
 	g.GET("/queryOrder", queryOrder)
 	// 取消订单
 	g.POST("/cancelOrder", cancelOrder)
 	// 退票
 	g.POST("/refundOrder", refundOrder)
+	// 退往返订单中的某一程
+	g.POST("/refundRoundTripLeg", refundRoundTripLeg)
 	// 出票
 	g.POST("/printTicket", printTicket)
+	// 查询车票的实时检票口、站台及晚点信息
+	g.GET("/ticketLiveInfo", queryLimit, queryTicketLiveInfo)
 
 }
 
+// queryLimit 查询接口按IP限流
+func queryLimit(c *gin.Context) {
+	if !modules.AllowQuery("ip_" + c.ClientIP()) {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"success": false, "msg": "请求过于频繁，请稍后再试"})
+		return
+	}
+	c.Next()
+}
+
+// orderLimit 下单接口按IP限流，按用户限流在解析请求后进行
+func orderLimit(c *gin.Context) {
+	if !modules.AllowOrder("ip_" + c.ClientIP()) {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"success": false, "msg": "请求过于频繁，请稍后再试"})
+		return
+	}
+	c.Next()
+}
+
+// allowUserOrder 下单接口按已登录的用户限流，并以该用户覆盖请求中的用户ID，不信任客户端提交的用户ID
+func allowUserOrder(c *gin.Context, userID *uint64) bool {
+	val, _ := c.Get(authUserIDKey)
+	uid, ok := val.(uint64)
+	if !ok || uid == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"success": false, "msg": "请先登录"})
+		return false
+	}
+	*userID = uid
+	if !modules.AllowOrder("user_" + strconv.FormatUint(uid, 10)) {
+		c.JSON(http.StatusTooManyRequests, gin.H{"success": false, "msg": "请求过于频繁，请稍后再试"})
+		return false
+	}
+	return true
+}
+
 func login(c *gin.Context) {
 
 }
@@ -80,6 +132,12 @@ func queryPrice(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"price": modules.QuerySeatPrice(tranNum, t, depIdx, arrIdx)})
 }
 
+// 查询价格日历，返回可订票天数内每天各席别的最低票价及是否售罄
+func queryPriceCalendar(c *gin.Context) {
+	from, to, isStudent := c.Query("from"), c.Query("to"), c.DefaultQuery("isStudent", "0")
+	c.JSON(http.StatusOK, gin.H{"calendar": modules.QueryPriceCalendar(from, to, isStudent == "1")})
+}
+
 // 提交订单
 func submitOrder(c *gin.Context) {
 	var model modules.SubmitOrderModel
@@ -87,11 +145,35 @@ func submitOrder(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "Post Data Err"})
 		return
 	}
-	if err := modules.SubmitOrder(model); err != nil {
+	if !allowUserOrder(c, &model.UserID) {
+		return
+	}
+	result, err := modules.EnqueueOrder(model)
+	if err != nil {
 		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"success": true})
+	c.JSON(http.StatusOK, gin.H{"success": true, "queue": result})
+}
+
+// 查询订单排队结果
+func queryOrderQueue(c *gin.Context) {
+	result, err := modules.QueryBookingResult(c.Query("token"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "queue": result})
+}
+
+// 等待订单排队结果，订票完成或超时后返回
+func waitOrderQueue(c *gin.Context) {
+	result, err := modules.WaitBookingResult(c.Query("token"), queryTimeout)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "queue": result})
 }
 
 // 改签
@@ -101,6 +183,9 @@ func changeOrder(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "Post Data Err"})
 		return
 	}
+	if !allowUserOrder(c, &model.UserID) {
+		return
+	}
 	oldID := c.PostForm("oldTicketID")
 	oldTicketID, err := strconv.ParseUint(oldID, 10, 64)
 	if err != nil {
@@ -114,6 +199,64 @@ func changeOrder(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// 提交联程订单，各行程段涉及多个车次，不经过按车次排队，直接订票
+func submitItinerary(c *gin.Context) {
+	var model modules.ItineraryOrderModel
+	if err := c.BindJSON(&model); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "Post Data Err"})
+		return
+	}
+	if !allowUserOrder(c, &model.UserID) {
+		return
+	}
+	order, err := modules.SubmitItinerary(model)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "orderID": order.ID, "price": order.Price})
+}
+
+// 联程订单免费改签后续行程
+func rebookItinerary(c *gin.Context) {
+	var model modules.SubmitOrderModel
+	if err := c.BindJSON(&model); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "Post Data Err"})
+		return
+	}
+	if !allowUserOrder(c, &model.UserID) {
+		return
+	}
+	oldTicketID, err := strconv.ParseUint(c.Query("oldTicketID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "原车票信息无效"})
+		return
+	}
+	if err = modules.RebookItineraryLeg(model, oldTicketID); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// 提交往返订单，去程与返程涉及不同车次，不经过按车次排队，直接订票
+func submitRoundTrip(c *gin.Context) {
+	var model modules.RoundTripOrderModel
+	if err := c.BindJSON(&model); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "Post Data Err"})
+		return
+	}
+	if !allowUserOrder(c, &model.UserID) {
+		return
+	}
+	order, err := modules.SubmitRoundTrip(model)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "orderID": order.ID, "price": order.Price})
+}
+
 // 查询订单
 func queryOrder(c *gin.Context) {
 
@@ -149,6 +292,25 @@ func refundOrder(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
 
+// 退往返订单中的某一程，legIdx 1.去程 2.返程
+func refundRoundTripLeg(c *gin.Context) {
+	oID, err := strconv.ParseUint(c.PostForm("orderID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "订单无效"})
+		return
+	}
+	legIdx, err := strconv.ParseUint(c.PostForm("legIdx"), 10, 8)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "行程无效"})
+		return
+	}
+	if err = modules.RefundRoundTripLeg(oID, uint8(legIdx)); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
 // 取票
 func printTicket(c *gin.Context) {
 	ticketID := c.PostForm("ticketID")
@@ -157,6 +319,24 @@ func printTicket(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "订单无效"})
 		return
 	}
-	modules.CheckIn(tID)
+	if err = modules.CheckIn(tID); err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"success": true})
 }
+
+// 查询车票的实时检票口、站台及晚点信息
+func queryTicketLiveInfo(c *gin.Context) {
+	ticketID, err := strconv.ParseUint(c.Query("ticketID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": "车票无效"})
+		return
+	}
+	info, err := modules.GetTicketLiveInfo(ticketID)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "ticket": info})
+}